@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/bifrost/common/clientconfig"
 	"github.com/bifrost/common/dsnkeys"
@@ -20,10 +21,39 @@ type Config struct {
 	AgentMode string
 	insecure  bool
 	tlsCA     string
+
+	tlsClientCert string
+	tlsClientKey  string
+
+	// sessions without packets for this time are closed, disabled if it's zero
+	SessionIdleTimeout time.Duration
+	// sessions are closed after this time even if they're active, disabled if it's zero
+	SessionMaxDuration time.Duration
 }
 
+const (
+	defaultSessionIdleTimeout = time.Hour
+	defaultSessionMaxDuration = 24 * time.Hour
+)
+
 // Load the configuration based on environment variable BIFROST_KEY or BIFROST_DSN (legacy).
 func Load() (*Config, error) {
+	conf, err := load()
+	if err != nil {
+		return nil, err
+	}
+	conf.SessionIdleTimeout, err = durationEnv("BIFROST_SESSION_IDLE_TIMEOUT", defaultSessionIdleTimeout)
+	if err != nil {
+		return nil, err
+	}
+	conf.SessionMaxDuration, err = durationEnv("BIFROST_SESSION_MAX_DURATION", defaultSessionMaxDuration)
+	if err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
+func load() (*Config, error) {
 	isLegacy, key := getEnvCredentials()
 	dsn, err := dsnkeys.Parse(key)
 	if err != nil && err != dsnkeys.ErrEmpty {
@@ -40,6 +70,14 @@ func Load() (*Config, error) {
 		if err != nil {
 			return nil, err
 		}
+		tlsClientCert, err := envloader.GetEnv("BIFROST_TLSCERT")
+		if err != nil {
+			return nil, err
+		}
+		tlsClientKey, err := envloader.GetEnv("BIFROST_TLSKEY")
+		if err != nil {
+			return nil, err
+		}
 		isInsecure := dsn.Scheme == "http" || dsn.Scheme == "grpc"
 		return &Config{
 			Name:      dsn.Name,
@@ -49,6 +87,9 @@ func Load() (*Config, error) {
 			URL:       dsn.Address,
 			insecure:  isInsecure,
 			tlsCA:     tlsCA,
+
+			tlsClientCert: tlsClientCert,
+			tlsClientKey:  tlsClientKey,
 		}, nil
 	}
 	legacyToken := getLegacyHoopTokenCredentials()
@@ -73,9 +114,23 @@ func (c *Config) GrpcClientConfig() (grpc.ClientConfig, error) {
 		Insecure:      c.IsInsecure(),
 		TLSServerName: os.Getenv("BIFROST_TLSSERVERNAME"),
 		TLSCA:         c.tlsCA,
+		TLSClientCert: c.tlsClientCert,
+		TLSClientKey:  c.tlsClientKey,
 	}, err
 }
 
+func durationEnv(name string, defaultVal time.Duration) (time.Duration, error) {
+	val := os.Getenv(name)
+	if val == "" {
+		return defaultVal, nil
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("%s is in wrong format, expected a duration (e.g.: 30m), got=%q", name, val)
+	}
+	return d, nil
+}
+
 func (c *Config) HasTlsCA() bool   { return c.tlsCA != "" }
 func (c *Config) IsInsecure() bool { return c.insecure }
 func (c *Config) IsValid() bool    { return c.Token != "" && c.URL != "" }
@@ -9,8 +9,9 @@ import (
 	"net"
 	"net/url"
 	"os"
-	"os/exec"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/getsentry/sentry-go"
@@ -18,7 +19,7 @@ import (
 	"github.com/bifrost/poc/controller/system/dbprovisioner"
 	"github.com/bifrost/poc/controller/system/runbookhook"
 	"github.com/bifrost/poc/secretsmanager"
-	term "github.com/bifrost/poc/terminal"
+	term "github.com/bifrost/poc/libbifrost/terminal"
 	"github.com/bifrost/common/log"
 	"github.com/bifrost/common/memory"
 	pb "github.com/bifrost/common/proto"
@@ -30,12 +31,19 @@ import (
 
 type (
 	Agent struct {
-		client           pb.ClientTransport
+		client           *resumableTransport
 		connStore        memory.Store
 		config           *config.Config
 		runtimeEnvs      map[string]string
 		shutdownCtx      context.Context
 		shutdownCancelFn context.CancelCauseFunc
+
+		mu          sync.Mutex
+		resumeTimer *time.Timer
+		// the gateway is shutting down and it will close the sessions
+		gatewayDraining atomic.Bool
+		// how long the sessions are kept while the agent reconnects, it's set by the gateway
+		resumeGrace atomic.Int64
 	}
 	connEnv struct {
 		scheme             string
@@ -45,6 +53,7 @@ type (
 		pass               string
 		port               string
 		authorizedSSHKeys  string
+		sshHostKey         string
 		dbname             string
 		insecure           bool
 		options            string
@@ -73,14 +82,17 @@ func (e *connEnv) Address() string {
 
 func New(client pb.ClientTransport, cfg *config.Config, runtimeEnvs map[string]string) *Agent {
 	shutdownCtx, cancelFn := context.WithCancelCause(context.Background())
-	return &Agent{
-		client:           client,
+	a := &Agent{
+		client:           newResumableTransport(client),
 		connStore:        memory.New(),
 		config:           cfg,
 		runtimeEnvs:      runtimeEnvs,
 		shutdownCtx:      shutdownCtx,
 		shutdownCancelFn: cancelFn,
 	}
+	a.client.onSend = a.touchSession
+	go a.expireSessions(shutdownCtx)
+	return a
 }
 
 func (a *Agent) Close(cause error) {
@@ -94,6 +106,68 @@ func (a *Agent) Close(cause error) {
 	_, _ = a.client.Close()
 }
 
+// Suspend keeps the sessions of the agent open while it reconnects with the gateway,
+// the agent is closed if it isn't resumed within the grace period. It returns false
+// if the agent is shutting down.
+func (a *Agent) Suspend(grace time.Duration) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	conns := len(a.connStore.List())
+	// a draining gateway closes the sessions before disconnecting
+	if a.shutdownCtx.Err() != nil || conns == 0 || a.gatewayDraining.Load() {
+		return false
+	}
+	client, _ := a.client.current()
+	a.client.disconnect(client)
+	a.resumeTimer = time.AfterFunc(grace, func() {
+		a.Close(fmt.Errorf("agent did not reconnect within %v", grace))
+	})
+	log.Infof("suspending %v connection(s), waiting %v to reconnect", conns, grace)
+	return true
+}
+
+// ResumeGrace returns how long the sessions are kept while the agent reconnects,
+// it's the session_resume_grace of the gateway.
+func (a *Agent) ResumeGrace() time.Duration {
+	return time.Duration(a.resumeGrace.Load())
+}
+
+// processGatewayConnectOK resumes the sessions suspended by the gateway, the sessions
+// that the gateway didn't keep are closed. It returns an error if the connection fails
+// while the sessions are resumed.
+func (a *Agent) processGatewayConnectOK(pkt *pb.Packet) error {
+	grace, err := time.ParseDuration(string(pkt.Spec[pb.SpecSessionResumeGrace]))
+	if err != nil {
+		grace = 0
+	}
+	a.resumeGrace.Store(int64(grace))
+	gatewaySessions, err := pb.DecodeResumeSessions(pkt.Spec[pb.SpecResumeSessions])
+	if err != nil {
+		log.Warnf("the sessions will not be resumed: %v", err)
+	}
+	closed, err := a.client.resumeSessions(gatewaySessions)
+	for _, sessionID := range closed {
+		a.sessionCleanup(sessionID)
+	}
+	return err
+}
+
+// Resume continues a suspended agent with a new connection with the gateway,
+// it returns false if the agent was closed while it was suspended.
+func (a *Agent) Resume(client pb.ClientTransport) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.resumeTimer != nil && !a.resumeTimer.Stop() {
+		return false
+	}
+	a.resumeTimer = nil
+	if a.shutdownCtx.Err() != nil {
+		return false
+	}
+	a.client.resume(client)
+	return true
+}
+
 func (a *Agent) Run() error {
 	a.client.StartKeepAlive()
 	for {
@@ -109,9 +183,17 @@ func (a *Agent) Run() error {
 		}
 		sid := string(pkt.Spec[pb.SpecGatewaySessionID])
 		log.With("sid", sid).Debugf("received client packet [%v]", pkt.Type)
+		a.touchSession(sid)
 		switch pkt.Type {
 		case pbagent.GatewayConnectOK:
+			a.gatewayDraining.Store(false)
 			log.Infof("connected with success to %v", a.config.URL)
+			if err := a.processGatewayConnectOK(pkt); err != nil {
+				return err
+			}
+		case pbagent.GatewayDraining:
+			a.gatewayDraining.Store(true)
+			log.Infof("gateway is shutting down, new sessions will be rejected until it reconnects")
 		case pbagent.SessionOpen:
 			a.processSessionOpen(pkt)
 
@@ -159,6 +241,10 @@ func (a *Agent) Run() error {
 		case pbagent.TCPConnectionClose:
 			a.processTCPCloseConnection(pkt)
 
+		// flow control
+		case pbagent.ConnectionCredit:
+			a.processConnectionCredit(pkt)
+
 		// system
 		case pbsystem.ProvisionDBRolesRequest:
 			dbprovisioner.ProcessDBProvisionerRequest(a.client, pkt)
@@ -166,6 +252,7 @@ func (a *Agent) Run() error {
 		case pbsystem.RunbookHookRequestType:
 			runbookhook.ProcessRequest(a.client, pkt)
 		}
+		a.consumeConnectionData(pkt)
 	}
 }
 
@@ -173,10 +260,20 @@ func (a *Agent) processSessionOpen(pkt *pb.Packet) {
 	sessionID := pkt.Spec[pb.SpecGatewaySessionID]
 	sessionIDKey := string(sessionID)
 	log.With("sid", sessionIDKey).Infof("received connect request")
+	if a.gatewayDraining.Load() {
+		log.With("sid", sessionIDKey).Infof("rejecting session, the gateway is shutting down")
+		a.sendClientSessionClose(sessionIDKey, "the gateway is shutting down, try again")
+		return
+	}
 
+	ctx, sessionSpan := a.startSessionSpan(pkt)
+	_, span := tracer.Start(ctx, "agent.processSessionOpen")
 	connParams, err := a.buildConnectionParams(pkt)
 	if err != nil {
 		log.Warnf("failed building connection params, err=%v", err)
+		endSpan(span, err)
+		endSpan(sessionSpan, err)
+		a.connStore.Del(sessionSpanKey(sessionIDKey))
 		_ = a.client.Send(&pb.Packet{
 			Type:    pbclient.SessionClose,
 			Payload: []byte(err.Error()),
@@ -213,9 +310,13 @@ func (a *Agent) processSessionOpen(pkt *pb.Packet) {
 	// Store connection params BEFORE sending SessionOpenOK to ensure they're available
 	// when subsequent packets (like MySQLConnectionWrite) arrive
 	a.connStore.Set(string(sessionID), connParams)
+	a.startSessionActivity(sessionIDKey)
+	flowControlWindow := a.startSessionFlowControl(pkt)
 
 	go func() {
-		if err := a.checkTCPLiveness(pkt, connParams.EnvVars); err != nil {
+		err := a.checkTCPLiveness(pkt, connParams.EnvVars)
+		defer endSpan(span, err)
+		if err != nil {
 			_ = a.client.Send(&pb.Packet{
 				Type:    pbclient.SessionClose,
 				Payload: []byte(err.Error()),
@@ -235,35 +336,12 @@ func (a *Agent) processSessionOpen(pkt *pb.Packet) {
 				pb.SpecConnectionType:       pkt.Spec[pb.SpecConnectionType],
 				pb.SpecClientRequestPort:    pkt.Spec[pb.SpecClientRequestPort],
 				pb.SpecClientExecCommandKey: []byte(strings.Join(requestCommand, " ")),
+				pb.SpecFlowControlWindow:    flowControlWindow,
 			}})
 		log.With("sid", sessionIDKey).Infof("sent gateway connect ok")
 	}()
 }
 
-func (a *Agent) executeMySQLCommand(mysqlCmd string) ([]byte, int) {
-	cmd := exec.Command("sh", "-c", mysqlCmd)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			return output, exitErr.ExitCode()
-		}
-		return output, 1
-	}
-	return output, 0
-}
-
-func (a *Agent) executeMongoDBCommand(mongoCmd string) ([]byte, int) {
-	cmd := exec.Command("sh", "-c", mongoCmd)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			return output, exitErr.ExitCode()
-		}
-		return output, 1
-	}
-	return output, 0
-}
-
 func (a *Agent) processTCPCloseConnection(pkt *pb.Packet) {
 	sessionID := pkt.Spec[pb.SpecGatewaySessionID]
 	clientConnID := pkt.Spec[pb.SpecClientConnectionID]
@@ -288,6 +366,9 @@ func (a *Agent) processSessionClose(pkt *pb.Packet) {
 		log.Warnf("received packet %v without a session", pkt.Type)
 		return
 	}
+	if reason := string(pkt.Payload); reason != "" {
+		log.With("sid", sessionID).Infof("session closed by the gateway, reason=%v", reason)
+	}
 	a.sessionCleanup(sessionID)
 }
 
@@ -304,6 +385,8 @@ func (a *Agent) sessionCleanup(sessionID string) {
 			a.connStore.Del(key)
 		}
 	}
+	// the connection params are kept until the session is closed
+	a.connStore.Del(sessionID)
 }
 
 func (a *Agent) sendClientSessionClose(sessionID string, errMsg string) {
@@ -512,6 +595,7 @@ func parseConnectionEnvVars(envVars map[string]any, connType pb.ConnectionType)
 		pass:              envVarS.Getenv("PASS"),
 		port:              envVarS.Getenv("PORT"),
 		authorizedSSHKeys: envVarS.Getenv("AUTHORIZED_SERVER_KEYS"),
+		sshHostKey:        envVarS.Getenv("HOST_KEY"),
 		dbname:            envVarS.Getenv("DB"),
 		insecure:          envVarS.Getenv("INSECURE") == "true",
 		postgresSSLMode:   envVarS.Getenv("SSLMODE"),
@@ -533,9 +617,11 @@ func parseConnectionEnvVars(envVars map[string]any, connType pb.ConnectionType)
 		if mode == "" {
 			mode = "prefer"
 		}
-		if mode != "require" && mode != "verify-full" && mode != "prefer" && mode != "disable" {
+		switch mode {
+		case "disable", "allow", "prefer", "require", "verify-ca", "verify-full":
+		default:
 			return nil, fmt.Errorf("wrong option (%q) for SSLMODE, accept only: %v", mode,
-				[]string{"disable", "prefer", "require", "verify-full"})
+				[]string{"disable", "allow", "prefer", "require", "verify-ca", "verify-full"})
 		}
 	case pb.ConnectionTypeMySQL:
 		if env.port == "" {
@@ -583,6 +669,10 @@ func parseConnectionEnvVars(envVars map[string]any, connType pb.ConnectionType)
 		if env.host == "" || (env.pass == "" && env.authorizedSSHKeys == "") || env.user == "" {
 			return nil, errors.New("missing required secrets for ssh connection [HOST, USER, PASS or AUTHORIZED_SERVER_KEYS]")
 		}
+		// the host key is only skipped when the connection opts out explicitly
+		if env.sshHostKey == "" && !env.insecure {
+			return nil, errors.New("missing required secrets for ssh connection [HOST_KEY or INSECURE=true]")
+		}
 	case pb.ConnectionTypeTCP:
 		if env.host == "" || env.port == "" {
 			return nil, errors.New("missing required environment for connection [HOST, PORT]")
@@ -5,4 +5,6 @@ const (
 	cmdStoreKey      string = "cmd:%s"
 	connEnvKey       string = "connenv"
 	internalExitCode string = "254"
+	// sessions that are idle or exceed the maximum duration, as timeout(1)
+	expiredExitCode string = "124"
 )
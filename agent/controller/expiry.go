@@ -0,0 +1,91 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/bifrost/common/log"
+)
+
+// sessionExpiryInterval is how often the sessions are checked for the idle and maximum duration timeouts
+const sessionExpiryInterval = 10 * time.Second
+
+// sessionActivity tracks when a session was opened and its last packet, it's
+// removed from the connection store with the other entries of the session.
+type sessionActivity struct {
+	openedAt     time.Time
+	lastActivity atomic.Int64
+}
+
+func (s *sessionActivity) touch() { s.lastActivity.Store(time.Now().UnixNano()) }
+
+// Close allows the session cleanup to remove the entry
+func (s *sessionActivity) Close() error { return nil }
+
+// expiredReason returns why the session has expired, it's empty if the session
+// is within the timeouts. A zero timeout disables the check.
+func (s *sessionActivity) expiredReason(now time.Time, idleTimeout, maxDuration time.Duration) string {
+	switch {
+	case maxDuration > 0 && now.Sub(s.openedAt) > maxDuration:
+		return fmt.Sprintf("session exceeded the maximum duration of %v", maxDuration)
+	case idleTimeout > 0 && now.Sub(time.Unix(0, s.lastActivity.Load())) > idleTimeout:
+		return fmt.Sprintf("session idle for more than %v", idleTimeout)
+	}
+	return ""
+}
+
+func sessionActivityKey(sessionID string) string { return sessionID + ":activity" }
+
+func (a *Agent) startSessionActivity(sessionID string) {
+	activity := &sessionActivity{openedAt: time.Now()}
+	activity.touch()
+	a.connStore.Set(sessionActivityKey(sessionID), activity)
+}
+
+// touchSession records a packet of the session
+func (a *Agent) touchSession(sessionID string) {
+	if sessionID == "" {
+		return
+	}
+	if activity, ok := a.connStore.Get(sessionActivityKey(sessionID)).(*sessionActivity); ok {
+		activity.touch()
+	}
+}
+
+// expireSessions closes the sessions that are idle or have exceeded the
+// maximum duration until the agent shuts down.
+func (a *Agent) expireSessions(ctx context.Context) {
+	idleTimeout, maxDuration := a.config.SessionIdleTimeout, a.config.SessionMaxDuration
+	if idleTimeout == 0 && maxDuration == 0 {
+		return
+	}
+	ticker := time.NewTicker(sessionExpiryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			filterFn := func(k string) bool { return strings.HasSuffix(k, ":activity") }
+			for key, obj := range a.connStore.Filter(filterFn) {
+				activity, ok := obj.(*sessionActivity)
+				if !ok {
+					continue
+				}
+				if reason := activity.expiredReason(now, idleTimeout, maxDuration); reason != "" {
+					a.expireSession(strings.TrimSuffix(key, ":activity"), reason)
+				}
+			}
+		}
+	}
+}
+
+// expireSession closes the session in the client and releases its connections
+func (a *Agent) expireSession(sessionID, reason string) {
+	log.With("sid", sessionID).Infof("session expired, reason=%v", reason)
+	a.sendClientSessionCloseWithExitCode(sessionID, reason, expiredExitCode)
+	a.sessionCleanup(sessionID)
+}
@@ -0,0 +1,175 @@
+package controller
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/bifrost/common/log"
+	pb "github.com/bifrost/common/proto"
+	pbagent "github.com/bifrost/common/proto/agent"
+	pbclient "github.com/bifrost/common/proto/client"
+	"github.com/bifrost/poc/libbifrost"
+)
+
+// flowControlledTypes are the packets with data of client connections, the agent
+// returns credits to the client when the data is written to the connection of the target.
+// The proxies that buffer the data of the client (libbifrost.ConsumeNotifier) return the
+// credits as they read the data to write it to the target.
+var flowControlledTypes = map[string]bool{
+	pbagent.TCPConnectionWrite:       true,
+	pbagent.PGConnectionWrite:        true,
+	pbagent.MySQLConnectionWrite:     true,
+	pbagent.MSSQLConnectionWrite:     true,
+	pbagent.MongoDBConnectionWrite:   true,
+	pbagent.SSHConnectionWrite:       true,
+	pbagent.HttpProxyConnectionWrite: true,
+}
+
+// messageFramedTypes are the packets with a message of the protocol in each packet,
+// the writes are never split by the flow control.
+var messageFramedTypes = map[pb.PacketType]bool{
+	pbclient.SSHConnectionWrite: true,
+}
+
+// sessionFlowControl is the receive window of the client connections of a session,
+// the session is flow controlled only when the client sends it in SessionOpen.
+type sessionFlowControl struct{ window int }
+
+// Close allows the session cleanup to remove the entry
+func (s *sessionFlowControl) Close() error { return nil }
+
+// connectionFlowControl holds the credits of a client connection in both directions,
+// it's closed with the other entries of the connection releasing the blocked writers.
+type connectionFlowControl struct {
+	// the data sent to the client
+	send *pb.FlowWindow
+	// the data of the client written to the target
+	received *pb.FlowCredits
+}
+
+func (c *connectionFlowControl) Close() error { return c.send.Close() }
+
+func sessionFlowControlKey(sessionID string) string { return sessionID + ":flow" }
+
+func connectionFlowControlKey(sessionID, connectionID string) string {
+	return fmt.Sprintf("%s:%s:flow", sessionID, connectionID)
+}
+
+// startSessionFlowControl enables the flow control of the session when the client supports
+// it, it returns the window of the agent to be sent to the client in SessionOpenOK.
+func (a *Agent) startSessionFlowControl(pkt *pb.Packet) []byte {
+	window := pb.ParseFlowControlWindow(pkt.Spec)
+	if window == 0 {
+		return nil
+	}
+	sessionID := string(pkt.Spec[pb.SpecGatewaySessionID])
+	a.connStore.Set(sessionFlowControlKey(sessionID), &sessionFlowControl{window: window})
+	return []byte(strconv.Itoa(pb.DefaultFlowControlWindow))
+}
+
+// connectionFlowControl returns the credits of a client connection, it's nil when the
+// session is not flow controlled or the connection is closed. The packets of closed
+// connections (e.g. credits sent before the client received the close) are ignored.
+func (a *Agent) connectionFlowControl(sessionID, connectionID string) *connectionFlowControl {
+	if connectionID == "" {
+		return nil
+	}
+	fc, _ := a.connStore.Get(connectionFlowControlKey(sessionID, connectionID)).(*connectionFlowControl)
+	return fc
+}
+
+// startConnectionFlowControl creates the credits of a client connection when the session
+// is flow controlled, it's called when the proxy of the connection is created.
+func (a *Agent) startConnectionFlowControl(sessionID, connectionID string) *connectionFlowControl {
+	if connectionID == "" {
+		return nil
+	}
+	key := connectionFlowControlKey(sessionID, connectionID)
+	if fc, ok := a.connStore.Get(key).(*connectionFlowControl); ok {
+		return fc
+	}
+	session, ok := a.connStore.Get(sessionFlowControlKey(sessionID)).(*sessionFlowControl)
+	if !ok {
+		return nil
+	}
+	fc := &connectionFlowControl{
+		send:     pb.NewFlowWindow(session.window),
+		received: pb.NewFlowCredits(pb.DefaultFlowControlWindow),
+	}
+	a.connStore.Set(key, fc)
+	return fc
+}
+
+// newStreamWriter returns the writer of the data sent to a client connection, it must be
+// called when the proxy of the connection is created. The writer waits for the credits
+// of the client when the session is flow controlled.
+func (a *Agent) newStreamWriter(pktType pb.PacketType, spec map[string][]byte) io.WriteCloser {
+	sessionID := string(spec[pb.SpecGatewaySessionID])
+	connectionID := string(spec[pb.SpecClientConnectionID])
+	if fc := a.startConnectionFlowControl(sessionID, connectionID); fc != nil {
+		if messageFramedTypes[pktType] {
+			return pb.NewFlowControlledMessageWriter(a.client, pktType, spec, fc.send)
+		}
+		return pb.NewFlowControlledStreamWriter(a.client, pktType, spec, fc.send)
+	}
+	return pb.NewStreamWriter(a.client, pktType, spec)
+}
+
+// processConnectionCredit releases the data of a connection waiting for the client
+func (a *Agent) processConnectionCredit(pkt *pb.Packet) {
+	sessionID := string(pkt.Spec[pb.SpecGatewaySessionID])
+	credits, err := pb.DecodeFlowCredits(pkt.Payload)
+	if err != nil {
+		log.With("sid", sessionID).Warnf("%v", err)
+		return
+	}
+	if fc := a.connectionFlowControl(sessionID, string(pkt.Spec[pb.SpecClientConnectionID])); fc != nil {
+		fc.send.Grant(credits)
+	}
+}
+
+// consumeConnectionData returns credits to the client for the data written to the target,
+// the proxies that buffer the data return the credits when they consume it.
+func (a *Agent) consumeConnectionData(pkt *pb.Packet) {
+	if !flowControlledTypes[pkt.Type] {
+		return
+	}
+	sessionID := string(pkt.Spec[pb.SpecGatewaySessionID])
+	connectionID := string(pkt.Spec[pb.SpecClientConnectionID])
+	if _, ok := a.connStore.Get(fmt.Sprintf("%s:%s", sessionID, connectionID)).(libbifrost.ConsumeNotifier); ok {
+		return
+	}
+	a.returnConnectionCredits(sessionID, connectionID, len(pkt.Payload))
+}
+
+// returnCreditsOnConsume returns credits to the client as the proxy consumes the data
+// of the connection, it must be called before writing the first packet to the proxy.
+func (a *Agent) returnCreditsOnConsume(proxy libbifrost.Proxy, spec map[string][]byte) {
+	notifier, ok := proxy.(libbifrost.ConsumeNotifier)
+	if !ok {
+		return
+	}
+	sessionID := string(spec[pb.SpecGatewaySessionID])
+	connectionID := string(spec[pb.SpecClientConnectionID])
+	notifier.OnConsume(func(n int) { a.returnConnectionCredits(sessionID, connectionID, n) })
+}
+
+// returnConnectionCredits counts n bytes of the client written to the target, the credits are
+// sent to the client in batches.
+func (a *Agent) returnConnectionCredits(sessionID, connectionID string, n int) {
+	fc := a.connectionFlowControl(sessionID, connectionID)
+	if fc == nil {
+		return
+	}
+	if credits := fc.received.Consume(n); credits > 0 {
+		_ = a.client.Send(&pb.Packet{
+			Type:    pbclient.ConnectionCredit,
+			Payload: pb.EncodeFlowCredits(credits),
+			Spec: map[string][]byte{
+				pb.SpecGatewaySessionID:   []byte(sessionID),
+				pb.SpecClientConnectionID: []byte(connectionID),
+			},
+		})
+	}
+}
@@ -1,7 +1,6 @@
 package controller
 
 import (
-	"context"
 	"fmt"
 	"io"
 	"github.com/bifrost/poc/libbifrost"
@@ -35,7 +34,7 @@ func (a *Agent) processHttpProxyWriteServer(pkt *pb.Packet) {
 		}
 		return
 	}
-	httpStreamClient := pb.NewStreamWriter(a.client, pbclient.HttpProxyConnectionWrite, pkt.Spec)
+	httpStreamClient := a.newStreamWriter(pbclient.HttpProxyConnectionWrite, pkt.Spec)
 	connenv, err := parseConnectionEnvVars(connParams.EnvVars, pb.ConnectionTypeHttpProxy)
 	if err != nil {
 		log.Infof("missing connection credentials in memory, err=%v", err)
@@ -49,13 +48,25 @@ func (a *Agent) processHttpProxyWriteServer(pkt *pb.Packet) {
 	connenv.httpProxyHeaders["connection_id"] = clientConnectionID
 	connenv.httpProxyHeaders["sid"] = sessionID
 	connenv.httpProxyHeaders["insecure"] = fmt.Sprintf("%v", connenv.insecure)
-	httpProxy, err := libbifrost.NewHttpProxy(context.Background(), httpStreamClient, connenv.httpProxyHeaders)
+	ctx, span := a.startConnectionSpan(sessionID, pb.ConnectionTypeHttpProxy, clientConnectionID)
+	httpProxy, err := libbifrost.NewHttpProxy(ctx, httpStreamClient, connenv.httpProxyHeaders)
+	endSpan(span, err)
 	if err != nil {
 		log.Infof("failed connecting to %v, err=%v", sessionID, connenv.host, err)
 		a.sendClientSessionClose(sessionID, fmt.Sprintf("failed connecting to internal service, reason=%v", err))
 		return
 	}
+	a.returnCreditsOnConsume(httpProxy, pkt.Spec)
+	a.connStore.Set(clientConnectionIDKey, httpProxy)
+	httpProxy.Run(func(exitCode int, errMsg string) {
+		a.connStore.Del(clientConnectionIDKey)
+		// the client may keep other connections of the session open
+		if exitCode == 0 {
+			a.sendClientTCPConnectionClose(sessionID, clientConnectionID)
+			return
+		}
+		a.sendClientSessionClose(sessionID, errMsg)
+	})
 	// write the first packet when establishing the connection
 	_, _ = httpProxy.Write(pkt.Payload)
-	a.connStore.Set(clientConnectionIDKey, httpProxy)
 }
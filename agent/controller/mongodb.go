@@ -3,6 +3,10 @@ package controller
 import (
 	"fmt"
 	"io"
+	"net"
+	"net/url"
+
+	"github.com/bifrost/poc/libbifrost"
 
 	"github.com/bifrost/common/log"
 	pb "github.com/bifrost/common/proto"
@@ -11,7 +15,6 @@ import (
 
 func (a *Agent) processMongoDBProtocol(pkt *pb.Packet) {
 	sessionID := string(pkt.Spec[pb.SpecGatewaySessionID])
-	streamClient := pb.NewStreamWriter(a.client, pbclient.MongoDBConnectionWrite, pkt.Spec)
 	connParams := a.connectionParams(sessionID)
 	if connParams == nil {
 		log.With("sid", sessionID).Errorf("connection params not found")
@@ -36,6 +39,7 @@ func (a *Agent) processMongoDBProtocol(pkt *pb.Packet) {
 		return
 	}
 
+	streamClient := a.newStreamWriter(pbclient.MongoDBConnectionWrite, pkt.Spec)
 	connenv, err := parseConnectionEnvVars(connParams.EnvVars, pb.ConnectionTypeMongoDB)
 	if err != nil {
 		log.With("sid", sessionID).Error("mongodb credentials not found in memory, err=%v", err)
@@ -46,37 +50,48 @@ func (a *Agent) processMongoDBProtocol(pkt *pb.Packet) {
 	log.With("sid", sessionID, "conn", clientConnectionID, "legacy", connenv.connectionString == "").
 		Infof("starting mongodb connection at %v", connenv.Address())
 
-	// POC: Execute MongoDB query directly using mongosh CLI (libbifrost is stub in POC)
-	// In production, this would use libbifrost's MongoDB protocol handler
-	query := string(pkt.Payload)
-	log.Infof("session=%v - executing query: %s", sessionID, query)
-
-	// Build MongoDB connection string
-	var connString string
-	if connenv.connectionString != "" {
-		connString = connenv.connectionString
-	} else {
-		// Build connection string from individual parameters
-		connString = fmt.Sprintf("mongodb://%s:%s@%s:%s/%s?authSource=admin",
-			connenv.user, connenv.pass, connenv.host, connenv.port, connenv.dbname)
+	opts := map[string]string{
+		"sid":               sessionID,
+		"connection_string": mongoConnectionString(connenv),
+		"connection_id":     clientConnectionID,
 	}
-
-	// Execute mongosh command (try mongosh first, fallback to mongo)
-	// Use --quiet to suppress warnings and --eval to execute the query
-	mongoCmd := fmt.Sprintf("mongosh \"%s\" --quiet --eval '%s' 2>&1 || mongo \"%s\" --quiet --eval '%s' 2>&1",
-		connString, query, connString, query)
-
-	output, exitCode := a.executeMongoDBCommand(mongoCmd)
-
-	// Send output back to client
-	if len(output) > 0 {
-		_, _ = streamClient.Write(output)
+	ctx, span := a.startConnectionSpan(sessionID, pb.ConnectionTypeMongoDB, clientConnectionID)
+	serverWriter, err := libbifrost.NewDBCore(ctx, streamClient, opts).MongoDB()
+	endSpan(span, err)
+	if err != nil {
+		errMsg := fmt.Sprintf("failed connecting with mongodb server, err=%v", err)
+		log.Errorf(errMsg)
+		a.sendClientSessionClose(sessionID, errMsg)
+		return
 	}
+	a.returnCreditsOnConsume(serverWriter, pkt.Spec)
+	serverWriter.Run(func(_ int, errMsg string) {
+		a.sendClientSessionClose(sessionID, errMsg)
+	})
+	// write the first packet when establishing the connection
+	_, _ = serverWriter.Write(pkt.Payload)
+	a.connStore.Set(clientConnectionIDKey, serverWriter)
+}
 
-	// Close the session with exit code
-	if exitCode == 0 {
-		a.sendClientSessionCloseWithExitCode(sessionID, "", "0")
-	} else {
-		a.sendClientSessionCloseWithExitCode(sessionID, "query execution failed", fmt.Sprintf("%d", exitCode))
+// mongoConnectionString returns the connection string of the environment,
+// legacy connections are built from the HOST, PORT, USER, PASS and DB secrets
+func mongoConnectionString(env *connEnv) string {
+	if env.connectionString != "" {
+		return env.connectionString
+	}
+	host := net.JoinHostPort(env.host, env.port)
+	if env.scheme == "mongodb+srv" {
+		host = env.host
+	}
+	options := env.options
+	if options == "" {
+		options = "authSource=admin"
 	}
+	return (&url.URL{
+		Scheme:   env.scheme,
+		User:     url.UserPassword(env.user, env.pass),
+		Host:     host,
+		Path:     "/" + env.dbname,
+		RawQuery: options,
+	}).String()
 }
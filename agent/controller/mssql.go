@@ -1,7 +1,6 @@
 package controller
 
 import (
-	"context"
 	"fmt"
 	"io"
 	"github.com/bifrost/poc/libbifrost"
@@ -13,7 +12,6 @@ import (
 
 func (a *Agent) processMSSQLProtocol(pkt *pb.Packet) {
 	sessionID := string(pkt.Spec[pb.SpecGatewaySessionID])
-	streamClient := pb.NewStreamWriter(a.client, pbclient.MSSQLConnectionWrite, pkt.Spec)
 	connParams := a.connectionParams(sessionID)
 	if connParams == nil {
 		log.Errorf("session=%s - connection params not found", sessionID)
@@ -38,6 +36,7 @@ func (a *Agent) processMSSQLProtocol(pkt *pb.Packet) {
 		return
 	}
 
+	streamClient := a.newStreamWriter(pbclient.MSSQLConnectionWrite, pkt.Spec)
 	connenv, err := parseConnectionEnvVars(connParams.EnvVars, pb.ConnectionTypeMSSQL)
 	if err != nil {
 		log.Error("mssql credentials not found in memory, err=%v", err)
@@ -47,20 +46,25 @@ func (a *Agent) processMSSQLProtocol(pkt *pb.Packet) {
 
 	log.Infof("session=%v - starting mssql connection at %v:%v", sessionID, connenv.host, connenv.port)
 	opts := map[string]string{
-		"sid":      sessionID,
-		"hostname": connenv.host,
-		"port":     connenv.port,
-		"username": connenv.user,
-		"password": connenv.pass,
-		"insecure": fmt.Sprintf("%v", connenv.insecure),
+		"sid":           sessionID,
+		"hostname":      connenv.host,
+		"port":          connenv.port,
+		"username":      connenv.user,
+		"password":      connenv.pass,
+		"database":      connenv.dbname,
+		"insecure":      fmt.Sprintf("%v", connenv.insecure),
+		"connection_id": clientConnectionID,
 	}
-	serverWriter, err := libbifrost.NewDBCore(context.Background(), streamClient, opts).MSSQL()
+	ctx, span := a.startConnectionSpan(sessionID, pb.ConnectionTypeMSSQL, clientConnectionID)
+	serverWriter, err := libbifrost.NewDBCore(ctx, streamClient, opts).MSSQL()
+	endSpan(span, err)
 	if err != nil {
 		errMsg := fmt.Sprintf("failed connecting with mssql server, err=%v", err)
 		log.Errorf(errMsg)
 		a.sendClientSessionClose(sessionID, errMsg)
 		return
 	}
+	a.returnCreditsOnConsume(serverWriter, pkt.Spec)
 	serverWriter.Run(func(_ int, errMsg string) {
 		a.sendClientSessionClose(sessionID, errMsg)
 	})
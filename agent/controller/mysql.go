@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"io"
 
+	"github.com/bifrost/poc/libbifrost"
+
 	"github.com/bifrost/common/log"
 	pb "github.com/bifrost/common/proto"
 	pbclient "github.com/bifrost/common/proto/client"
@@ -11,7 +13,6 @@ import (
 
 func (a *Agent) processMySQLProtocol(pkt *pb.Packet) {
 	sessionID := string(pkt.Spec[pb.SpecGatewaySessionID])
-	streamClient := pb.NewStreamWriter(a.client, pbclient.MySQLConnectionWrite, pkt.Spec)
 	connParams := a.connectionParams(sessionID)
 	if connParams == nil {
 		log.Errorf("session=%s - connection params not found", sessionID)
@@ -36,6 +37,7 @@ func (a *Agent) processMySQLProtocol(pkt *pb.Packet) {
 		return
 	}
 
+	streamClient := a.newStreamWriter(pbclient.MySQLConnectionWrite, pkt.Spec)
 	connenv, err := parseConnectionEnvVars(connParams.EnvVars, pb.ConnectionTypeMySQL)
 	if err != nil {
 		log.Error("mysql credentials not found in memory, err=%v", err)
@@ -44,28 +46,27 @@ func (a *Agent) processMySQLProtocol(pkt *pb.Packet) {
 	}
 
 	log.Infof("session=%v - starting mysql connection at %v:%v", sessionID, connenv.host, connenv.port)
-
-	// POC: Execute MySQL query directly using mysql CLI (libbifrost is stub in POC)
-	// In production, this would use libbifrost's MySQL protocol handler
-	query := string(pkt.Payload)
-	log.Infof("session=%v - executing query: %s", sessionID, query)
-
-	// Execute mysql command (disable SSL for POC demo, force TCP protocol)
-	// Using --skip-ssl for compatibility with both MySQL and MariaDB clients
-	mysqlCmd := fmt.Sprintf("mysql --protocol=TCP -h%s -P%s -u%s -p%s --skip-ssl -D%s -e \"%s\" 2>&1",
-		connenv.host, connenv.port, connenv.user, connenv.pass, connenv.dbname, query)
-
-	output, exitCode := a.executeMySQLCommand(mysqlCmd)
-
-	// Send output back to client
-	if len(output) > 0 {
-		_, _ = streamClient.Write(output)
+	opts := map[string]string{
+		"sid":           sessionID,
+		"hostname":      connenv.host,
+		"port":          connenv.port,
+		"username":      connenv.user,
+		"password":      connenv.pass,
+		"database":      connenv.dbname,
+		"connection_id": clientConnectionID,
 	}
-
-	// Close the session with exit code
-	if exitCode == 0 {
-		a.sendClientSessionCloseWithExitCode(sessionID, "", "0")
-	} else {
-		a.sendClientSessionCloseWithExitCode(sessionID, "query execution failed", fmt.Sprintf("%d", exitCode))
+	ctx, span := a.startConnectionSpan(sessionID, pb.ConnectionTypeMySQL, clientConnectionID)
+	serverWriter, err := libbifrost.NewDBCore(ctx, streamClient, opts).MySQL()
+	endSpan(span, err)
+	if err != nil {
+		errMsg := fmt.Sprintf("failed connecting with mysql server, err=%v", err)
+		log.Errorf(errMsg)
+		a.sendClientSessionClose(sessionID, errMsg)
+		return
 	}
+	a.returnCreditsOnConsume(serverWriter, pkt.Spec)
+	serverWriter.Run(func(_ int, errMsg string) {
+		a.sendClientSessionClose(sessionID, errMsg)
+	})
+	a.connStore.Set(clientConnectionIDKey, serverWriter)
 }
@@ -1,7 +1,6 @@
 package controller
 
 import (
-	"context"
 	"fmt"
 	"io"
 	"github.com/bifrost/poc/libbifrost"
@@ -14,7 +13,6 @@ import (
 
 func (a *Agent) processPGProtocol(pkt *pb.Packet) {
 	sessionID := string(pkt.Spec[pb.SpecGatewaySessionID])
-	streamClient := pb.NewStreamWriter(a.client, pbclient.PGConnectionWrite, pkt.Spec)
 	connParams := a.connectionParams(sessionID)
 	if connParams == nil {
 		log.Errorf("session=%s - connection params not found", sessionID)
@@ -40,6 +38,7 @@ func (a *Agent) processPGProtocol(pkt *pb.Packet) {
 		return
 	}
 
+	streamClient := a.newStreamWriter(pbclient.PGConnectionWrite, pkt.Spec)
 	connenv, err := parseConnectionEnvVars(connParams.EnvVars, pb.ConnectionTypePostgres)
 	if err != nil {
 		log.Error("postgres credentials not found in memory, err=%v", err)
@@ -64,6 +63,7 @@ func (a *Agent) processPGProtocol(pkt *pb.Packet) {
 		"port":                      connenv.port,
 		"username":                  connenv.user,
 		"password":                  connenv.pass,
+		"database":                  connenv.dbname,
 		"sslmode":                   connenv.postgresSSLMode,
 		"dlp_provider":              connParams.DlpProvider,
 		"dlp_mode":                  connParams.DlpMode,
@@ -74,14 +74,18 @@ func (a *Agent) processPGProtocol(pkt *pb.Packet) {
 		"dlp_masking_character":     "#",
 		"data_masking_entity_data":  dataMaskingEntityTypesData,
 		"guard_rail_rules":          guardRailRules,
+		"connection_id":             clientConnectionID,
 	}
-	serverWriter, err := libbifrost.NewDBCore(context.Background(), streamClient, opts).Postgres()
+	ctx, span := a.startConnectionSpan(sessionID, pb.ConnectionTypePostgres, clientConnectionID)
+	serverWriter, err := libbifrost.NewDBCore(ctx, streamClient, opts).Postgres()
+	endSpan(span, err)
 	if err != nil {
 		errMsg := fmt.Sprintf("failed connecting with postgres server, err=%v", err)
 		log.Errorf(errMsg)
 		a.sendClientSessionClose(sessionID, errMsg)
 		return
 	}
+	a.returnCreditsOnConsume(serverWriter, pkt.Spec)
 	serverWriter.Run(func(_ int, errMsg string) {
 		a.sendClientSessionClose(sessionID, errMsg)
 	})
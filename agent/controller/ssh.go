@@ -1,7 +1,6 @@
 package controller
 
 import (
-	"context"
 	"fmt"
 	"io"
 	"github.com/bifrost/poc/libbifrost"
@@ -13,7 +12,6 @@ import (
 
 func (a *Agent) processSSHProtocol(pkt *pb.Packet) {
 	sid := string(pkt.Spec[pb.SpecGatewaySessionID])
-	streamClient := pb.NewStreamWriter(a.client, pbclient.SSHConnectionWrite, pkt.Spec)
 	connParams := a.connectionParams(sid)
 	if connParams == nil {
 		log.With("sid", sid).Errorf("connection params not found")
@@ -38,6 +36,7 @@ func (a *Agent) processSSHProtocol(pkt *pb.Packet) {
 		return
 	}
 
+	streamClient := a.newStreamWriter(pbclient.SSHConnectionWrite, pkt.Spec)
 	connenv, err := parseConnectionEnvVars(connParams.EnvVars, pb.ConnectionTypeSSH)
 	if err != nil {
 		log.With("sid", sid).Error("SSH credentials not found in memory, err=%v", err)
@@ -55,9 +54,13 @@ func (a *Agent) processSSHProtocol(pkt *pb.Packet) {
 		"username":               connenv.user,
 		"password":               connenv.pass,
 		"authorized_server_keys": connenv.authorizedSSHKeys,
+		"host_key":               connenv.sshHostKey,
+		"insecure":               fmt.Sprintf("%v", connenv.insecure),
 		"connection_id":          clientConnectionID,
 	}
-	serverWriter, err := libbifrost.NewSSHProxy(context.Background(), streamClient, opts)
+	ctx, span := a.startConnectionSpan(sid, pb.ConnectionTypeSSH, clientConnectionID)
+	serverWriter, err := libbifrost.NewSSHProxy(ctx, streamClient, opts)
+	endSpan(span, err)
 	if err != nil {
 		errMsg := fmt.Sprintf("failed initializing SSH proxy connection, err=%v", err)
 		log.With("sid", sid, "conn", clientConnectionID).Errorf(errMsg)
@@ -65,6 +68,7 @@ func (a *Agent) processSSHProtocol(pkt *pb.Packet) {
 		return
 	}
 
+	a.returnCreditsOnConsume(serverWriter, pkt.Spec)
 	serverWriter.Run(func(_ int, errMsg string) {
 		a.connStore.Del(clientConnectionIDKey)
 		a.sendClientSessionClose(sid, errMsg)
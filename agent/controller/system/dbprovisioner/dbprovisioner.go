@@ -11,6 +11,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/bifrost/poc/metrics"
 	"github.com/bifrost/poc/secretsmanager"
 	"github.com/bifrost/common/log"
 	"github.com/bifrost/common/memory"
@@ -167,6 +168,7 @@ func processDBProvisionerRequest(client pb.ClientTransport, pkt *pb.Packet) {
 }
 
 func sendResponse(client pb.ClientTransport, response *pbsystem.DBProvisionerResponse) {
+	metrics.IncProvisionerRequest(response.Status)
 	payload, pbtype, _ := response.Encode()
 	_ = client.Send(&pb.Packet{
 		Type:    pbtype,
@@ -32,14 +32,16 @@ func (a *Agent) processTCPWriteServer(pkt *pb.Packet) {
 		}
 		return
 	}
-	tcpClient := pb.NewStreamWriter(a.client, pbclient.TCPConnectionWrite, pkt.Spec)
+	tcpClient := a.newStreamWriter(pbclient.TCPConnectionWrite, pkt.Spec)
 	connenv, err := parseConnectionEnvVars(connParams.EnvVars, pb.ConnectionTypeTCP)
 	if err != nil {
 		log.Printf("session=%s - missing connection credentials in memory, err=%v", sessionID, err)
 		a.sendClientSessionClose(sessionID, "credentials are empty, contact the administrator")
 		return
 	}
+	_, span := a.startConnectionSpan(sessionID, pb.ConnectionTypeTCP, clientConnectionID)
 	tcpServer, err := newTCPConn(connenv)
+	endSpan(span, err)
 	if err != nil {
 		log.Printf("session=%s - failed connecting to %v, err=%v", sessionID, connenv.host, err)
 		a.sendClientSessionClose(sessionID, fmt.Sprintf("failed connecting to internal service, reason=%v", err))
@@ -43,7 +43,9 @@ func (a *Agent) doExec(pkt *pb.Packet) {
 	}
 
 	args := append(connParams.CmdList, connParams.ClientArgs...)
+	_, span := a.startConnectionSpan(sid, pb.ConnectionTypeCommandLine, "")
 	cmd, err := libbifrost.NewAdHocExec(connParams.EnvVars, args, pkt.Payload, stdoutw, stderrw, opts)
+	endSpan(span, err)
 	if err != nil {
 		errMsg := fmt.Sprintf("failed configuring command, reason=%v", err)
 		log.With("sid", sid).Infof(errMsg)
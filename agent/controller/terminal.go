@@ -52,7 +52,9 @@ func (a *Agent) doTerminalWriteAgentStdin(pkt *pb.Packet) {
 		"data_masking_entity_data":  dataMaskingEntityTypesData,
 	}
 	args := append(connParams.CmdList, connParams.ClientArgs...)
+	_, span := a.startConnectionSpan(sid, pb.ConnectionTypeCommandLine, "")
 	cmd, err := libbifrost.NewConsole(connParams.EnvVars, args, stdoutWriter, opts)
+	endSpan(span, err)
 	if err != nil {
 		// should we propagate the error back to the client?
 		// In case of sensitive contents in the error this could be propagate back
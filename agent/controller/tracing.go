@@ -0,0 +1,66 @@
+package controller
+
+import (
+	"context"
+
+	"github.com/bifrost/common/monitoring"
+	pb "github.com/bifrost/common/proto"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/bifrost/poc/controller")
+
+// sessionSpan ends the span of a session when the session is cleaned up
+type sessionSpan struct{ trace.Span }
+
+func (s sessionSpan) Close() error {
+	s.End()
+	return nil
+}
+
+func sessionSpanKey(sessionID string) string { return sessionID + ":trace" }
+
+// startSessionSpan starts the span of a session as a child of the trace
+// propagated by the gateway in the spec of the SessionOpen packet.
+func (a *Agent) startSessionSpan(pkt *pb.Packet) (context.Context, trace.Span) {
+	sessionID := string(pkt.Spec[pb.SpecGatewaySessionID])
+	ctx := monitoring.ExtractTraceContext(context.Background(), pkt.Spec)
+	ctx, span := tracer.Start(ctx, "agent.session",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("bifrost.session_id", sessionID),
+			attribute.String("bifrost.connection_type", string(pkt.Spec[pb.SpecConnectionType])),
+		))
+	a.connStore.Set(sessionSpanKey(sessionID), sessionSpan{span})
+	return ctx, span
+}
+
+// sessionContext returns a context with the span of the session
+func (a *Agent) sessionContext(sessionID string) context.Context {
+	ctx := context.Background()
+	if span, ok := a.connStore.Get(sessionSpanKey(sessionID)).(sessionSpan); ok {
+		ctx = trace.ContextWithSpan(ctx, span)
+	}
+	return ctx
+}
+
+// startConnectionSpan starts the span of a new connection to the target of a session
+func (a *Agent) startConnectionSpan(sessionID string, connectionType pb.ConnectionType, connectionID string) (context.Context, trace.Span) {
+	return tracer.Start(a.sessionContext(sessionID), "agent."+connectionType.String()+".connect",
+		trace.WithAttributes(
+			attribute.String("bifrost.session_id", sessionID),
+			attribute.String("bifrost.connection_id", connectionID),
+		))
+}
+
+// endSpan ends a span recording the error of the operation
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
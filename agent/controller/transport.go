@@ -0,0 +1,300 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+
+	"github.com/bifrost/common/log"
+	pb "github.com/bifrost/common/proto"
+	pbagent "github.com/bifrost/common/proto/agent"
+	pbclient "github.com/bifrost/common/proto/client"
+)
+
+// maxReplayBufferSize is the amount of data of a session not acknowledged by the gateway
+// that is kept to be sent again when the session resumes
+const maxReplayBufferSize = 8 * 1024 * 1024 // 8 MiB
+
+// resumableTransport sends the packets through the current connection with the gateway.
+// The packets are held while the agent is disconnected, allowing the sessions to
+// continue when the agent reconnects. The packets of the sessions are numbered and kept
+// until the gateway acknowledges them, the ones lost when the connection dropped are
+// sent again when the sessions resume (see resumeSessions).
+type resumableTransport struct {
+	mu        sync.Mutex
+	client    pb.ClientTransport
+	connected bool
+	// it's closed when the transport is connected
+	readyCh   chan struct{}
+	closedCh  chan struct{}
+	closeOnce sync.Once
+	// the packets of each session, they're removed when the session is closed
+	sessions map[string]*pb.ReplayBuffer
+	// serializes the sends, the packets are sent in the order they're numbered
+	sendMu sync.Mutex
+	// it's called with the session of the packets sent
+	onSend func(sessionID string)
+}
+
+func newResumableTransport(client pb.ClientTransport) *resumableTransport {
+	readyCh := make(chan struct{})
+	close(readyCh)
+	return &resumableTransport{
+		client:    client,
+		connected: true,
+		readyCh:   readyCh,
+		closedCh:  make(chan struct{}),
+		sessions:  map[string]*pb.ReplayBuffer{},
+	}
+}
+
+func (t *resumableTransport) current() (pb.ClientTransport, <-chan struct{}) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.client, t.readyCh
+}
+
+// replayBuffer returns the packets of a session, the buffer is created when the session
+// is opened. It's nil for the packets without a session or of sessions already closed,
+// they're not numbered.
+func (t *resumableTransport) replayBuffer(sessionID string, open bool) *pb.ReplayBuffer {
+	if sessionID == "" {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	replay, ok := t.sessions[sessionID]
+	if !ok && open {
+		replay = pb.NewReplayBuffer(maxReplayBufferSize)
+		t.sessions[sessionID] = replay
+	}
+	return replay
+}
+
+// forget removes the packets of a closed session
+func (t *resumableTransport) forget(sessionID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.sessions, sessionID)
+}
+
+// Send blocks while the transport is disconnected, it fails if the transport is closed.
+// The packets of a session that fail to be sent are sent again when the session resumes.
+func (t *resumableTransport) Send(pkt *pb.Packet) error {
+	sessionID := string(pkt.Spec[pb.SpecGatewaySessionID])
+	replay := t.replayBuffer(sessionID, false)
+	for {
+		client, err := t.lockSend()
+		if err != nil {
+			return err
+		}
+		if replay != nil {
+			replay.Stamp(pkt)
+		}
+		err = client.Send(pkt)
+		t.sendMu.Unlock()
+		if err == nil {
+			if pkt.Type == pbclient.SessionClose {
+				t.forget(sessionID)
+			}
+			if t.onSend != nil {
+				t.onSend(sessionID)
+			}
+			return nil
+		}
+		t.disconnect(client)
+		if replay != nil {
+			return nil
+		}
+		// retry when the agent reconnects
+	}
+}
+
+// lockSend waits until the transport is connected, it returns the current
+// connection with the sends locked.
+func (t *resumableTransport) lockSend() (pb.ClientTransport, error) {
+	for {
+		_, readyCh := t.current()
+		select {
+		case <-readyCh:
+		case <-t.closedCh:
+			return nil, io.ErrClosedPipe
+		}
+		select {
+		case <-t.closedCh:
+			return nil, io.ErrClosedPipe
+		default:
+		}
+		t.sendMu.Lock()
+		t.mu.Lock()
+		client, connected := t.client, t.connected
+		t.mu.Unlock()
+		if connected {
+			return client, nil
+		}
+		// the connection dropped while waiting for the lock
+		t.sendMu.Unlock()
+	}
+}
+
+// disconnect holds the packets sent through the client until the sessions are resumed
+func (t *resumableTransport) disconnect(client pb.ClientTransport) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.client != client || !t.connected {
+		return
+	}
+	t.connected = false
+	t.readyCh = make(chan struct{})
+}
+
+// resume switches to a new connection, the packets are held until the
+// gateway sends its sessions and they're resumed with resumeSessions.
+func (t *resumableTransport) resume(client pb.ClientTransport) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.client = client
+}
+
+// resumeSessions resumes the sessions kept by the gateway and releases the packets on hold.
+// gatewaySessions has the last sequence received by the gateway of each session: the agent
+// replies with SessionResume and the last sequence it has received, then it sends again
+// the packets that the gateway is missing. The sessions that the gateway doesn't have or
+// that can't be resumed are closed, they're returned to be cleaned up.
+func (t *resumableTransport) resumeSessions(gatewaySessions map[string]uint64) ([]string, error) {
+	t.sendMu.Lock()
+	defer t.sendMu.Unlock()
+	t.mu.Lock()
+	client := t.client
+	sessions := make(map[string]*pb.ReplayBuffer, len(t.sessions))
+	for sessionID, replay := range t.sessions {
+		sessions[sessionID] = replay
+	}
+	t.mu.Unlock()
+
+	var closed []string
+	for sessionID, replay := range sessions {
+		ack, ok := gatewaySessions[sessionID]
+		if !ok {
+			log.With("sid", sessionID).Infof("closing session, it was not kept by the gateway")
+			closed = append(closed, sessionID)
+			t.forget(sessionID)
+			continue
+		}
+		packets, err := replay.Replay(ack)
+		if err != nil {
+			log.With("sid", sessionID).Warnf("closing session, it can't be resumed: %v", err)
+			packets = []*pb.Packet{{
+				Type:    pbclient.SessionClose,
+				Payload: []byte(fmt.Sprintf("failed resuming session: %v", err)),
+				Spec: map[string][]byte{
+					pb.SpecGatewaySessionID:  []byte(sessionID),
+					pb.SpecClientExitCodeKey: []byte(internalExitCode),
+				},
+			}}
+			closed = append(closed, sessionID)
+			t.forget(sessionID)
+		} else {
+			packets = append([]*pb.Packet{{
+				Type: pbclient.SessionResume,
+				Spec: map[string][]byte{
+					pb.SpecGatewaySessionID: []byte(sessionID),
+					pb.SpecSessionAck:       []byte(strconv.FormatUint(replay.Received(), 10)),
+				},
+			}}, packets...)
+			log.With("sid", sessionID).Infof("resuming session, replayed packets=%v", len(packets)-1)
+		}
+		for _, pkt := range packets {
+			if err := client.Send(pkt); err != nil {
+				// the sessions are resumed when the agent reconnects again
+				return closed, err
+			}
+		}
+	}
+	for sessionID := range gatewaySessions {
+		if _, ok := sessions[sessionID]; ok {
+			continue
+		}
+		if err := client.Send(&pb.Packet{
+			Type:    pbclient.SessionClose,
+			Payload: []byte("the session was not found in the agent"),
+			Spec: map[string][]byte{
+				pb.SpecGatewaySessionID:  []byte(sessionID),
+				pb.SpecClientExitCodeKey: []byte(internalExitCode),
+			},
+		}); err != nil {
+			return closed, err
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.client == client && !t.connected {
+		t.connected = true
+		close(t.readyCh)
+	}
+	return closed, nil
+}
+
+// Recv returns the packets of the gateway, the packets received again after a reconnection
+// are skipped. A session missing packets of the gateway is closed.
+func (t *resumableTransport) Recv() (*pb.Packet, error) {
+	for {
+		client, _ := t.current()
+		pkt, err := client.Recv()
+		if err != nil {
+			return nil, err
+		}
+		sessionID := string(pkt.Spec[pb.SpecGatewaySessionID])
+		if pkt.Type == pbagent.SessionClose {
+			t.forget(sessionID)
+			return pkt, nil
+		}
+		replay := t.replayBuffer(sessionID, pkt.Type == pbagent.SessionOpen)
+		if replay == nil {
+			return pkt, nil
+		}
+		ok, err := replay.Receive(pkt)
+		if err != nil {
+			reason := fmt.Sprintf("failed receiving the data of the session: %v", err)
+			go func() {
+				_ = t.Send(&pb.Packet{
+					Type:    pbclient.SessionClose,
+					Payload: []byte(reason),
+					Spec: map[string][]byte{
+						pb.SpecGatewaySessionID:  []byte(sessionID),
+						pb.SpecClientExitCodeKey: []byte(internalExitCode),
+					},
+				})
+			}()
+			// the session is cleaned up as if the gateway had closed it
+			return &pb.Packet{
+				Type:    pbagent.SessionClose,
+				Payload: []byte(reason),
+				Spec:    map[string][]byte{pb.SpecGatewaySessionID: []byte(sessionID)},
+			}, nil
+		}
+		if ok {
+			return pkt, nil
+		}
+	}
+}
+
+func (t *resumableTransport) StreamContext() context.Context {
+	client, _ := t.current()
+	return client.StreamContext()
+}
+
+func (t *resumableTransport) StartKeepAlive() {
+	client, _ := t.current()
+	client.StartKeepAlive()
+}
+
+// Close closes the current connection and fails the packets on hold
+func (t *resumableTransport) Close() (error, error) {
+	t.closeOnce.Do(func() { close(t.closedCh) })
+	client, _ := t.current()
+	return client.Close()
+}
@@ -0,0 +1,188 @@
+package libbifrost
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/bifrost/common/log"
+	"github.com/bifrost/poc/libbifrost/terminal"
+	"github.com/creack/pty"
+)
+
+// outputDrainTimeout is the time to wait for the remaining output after the
+// command exits, background processes may keep the output open.
+const outputDrainTimeout = 2 * time.Second
+
+// baseEnvironKeys are the environment variables of the agent inherited by the
+// commands, the remaining variables may contain credentials of the agent.
+var baseEnvironKeys = []string{"PATH", "HOME", "USER", "SHELL", "LANG", "LC_ALL", "TZ", "TMPDIR"}
+
+// console executes a command attached to a pseudo terminal. The input
+// of the client is written to the terminal and the output is streamed
+// to the stdout writer.
+type console struct {
+	sid      string
+	cmd      *exec.Cmd
+	envStore *terminal.EnvVarStore
+	stdout   io.Writer
+
+	mu         sync.Mutex
+	preExec    bool
+	ptmx       *os.File
+	winSize    *pty.Winsize
+	doneCh     chan struct{}
+	closeOnce  sync.Once
+	userClosed atomic.Bool
+}
+
+// NewConsole prepares the command to run in a pseudo terminal, the environment
+// variables are decoded from the connection (envvar:KEY or filesystem:KEY).
+func NewConsole(rawEnvVarList map[string]any, args []string, stdout io.WriteCloser, opts map[string]string) (Proxy, error) {
+	cmd, envStore, err := newCommand(rawEnvVarList, args)
+	if err != nil {
+		return nil, err
+	}
+	cmd.Env = append(cmd.Env, "TERM=xterm-256color")
+	return &console{
+		sid:      opts["sid"],
+		cmd:      cmd,
+		envStore: envStore,
+		stdout:   stdout,
+		doneCh:   make(chan struct{}),
+	}, nil
+}
+
+// newCommand builds the command of the connection with the environment variables
+func newCommand(rawEnvVarList map[string]any, args []string) (*exec.Cmd, *terminal.EnvVarStore, error) {
+	if len(args) == 0 {
+		return nil, nil, fmt.Errorf("missing command to execute")
+	}
+	envStore, err := terminal.NewEnvVarStore(rawEnvVarList)
+	if err != nil {
+		return nil, nil, err
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	for _, key := range baseEnvironKeys {
+		if val, ok := os.LookupEnv(key); ok {
+			cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", key, val))
+		}
+	}
+	// the variables of the connection have precedence
+	cmd.Env = append(cmd.Env, envStore.Environ()...)
+	return cmd, envStore, nil
+}
+
+func (c *console) Run(onErr func(exitCode int, errMsg string)) {
+	if err := c.start(); err != nil {
+		c.close()
+		onErr(1, err.Error())
+		return
+	}
+	go func() {
+		copyDoneCh := make(chan struct{})
+		go func() {
+			// the read fails (EIO) when the terminal is closed
+			_, _ = io.Copy(c.stdout, c.ptmx)
+			close(copyDoneCh)
+		}()
+		err := c.cmd.Wait()
+		select {
+		case <-copyDoneCh:
+		case <-time.After(outputDrainTimeout):
+		}
+		userClosed := c.userClosed.Load()
+		c.close()
+		if userClosed {
+			return
+		}
+		exitCode := commandExitCode(c.cmd)
+		log.With("sid", c.sid).Infof("console command exited, exitcode=%v, err=%v", exitCode, err)
+		if exitCode == -1 {
+			onErr(1, err.Error())
+			return
+		}
+		onErr(exitCode, "")
+	}()
+}
+
+func (c *console) start() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.preExec = true
+	if err := c.envStore.OnPreExec(); err != nil {
+		return err
+	}
+	ptmx, err := pty.StartWithSize(c.cmd, c.winSize)
+	if err != nil {
+		return fmt.Errorf("failed starting command, err=%v", err)
+	}
+	c.ptmx = ptmx
+	return nil
+}
+
+// commandExitCode returns the exit code of a finished command, processes killed
+// by a signal are reported as 128+signal. It returns -1 if the command didn't exit.
+func commandExitCode(cmd *exec.Cmd) int {
+	if cmd.ProcessState == nil {
+		return -1
+	}
+	if status, ok := cmd.ProcessState.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return cmd.ProcessState.ExitCode()
+}
+
+// Write writes the input of the client to the terminal
+func (c *console) Write(data []byte) (int, error) {
+	c.mu.Lock()
+	ptmx := c.ptmx
+	c.mu.Unlock()
+	if ptmx == nil {
+		return 0, fmt.Errorf("console is not running")
+	}
+	return ptmx.Write(data)
+}
+
+// ResizeTTY resizes the terminal, the size is applied when the command
+// starts if the console is not running yet.
+func (c *console) ResizeTTY(size *pty.Winsize) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ptmx == nil {
+		c.winSize = size
+		return nil
+	}
+	return pty.Setsize(c.ptmx, size)
+}
+
+func (c *console) Done() <-chan struct{} { return c.doneCh }
+func (c *console) Close() error {
+	c.userClosed.Store(true)
+	c.close()
+	return nil
+}
+
+func (c *console) close() {
+	c.closeOnce.Do(func() {
+		c.mu.Lock()
+		if c.ptmx != nil {
+			// the command is the leader of its session, the whole group is terminated
+			_ = syscall.Kill(-c.cmd.Process.Pid, syscall.SIGKILL)
+			_ = c.ptmx.Close()
+		}
+		preExec := c.preExec
+		c.mu.Unlock()
+		if preExec {
+			if err := c.envStore.OnPostExec(); err != nil {
+				log.With("sid", c.sid).Infof("failed running post exec hooks, err=%v", err)
+			}
+		}
+		close(c.doneCh)
+	})
+}
@@ -0,0 +1,97 @@
+package libbifrost
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/creack/pty"
+	"github.com/stretchr/testify/assert"
+)
+
+// testWriter keeps the output written by the commands
+type testWriter struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (w *testWriter) Write(data []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.Write(data)
+}
+
+func (w *testWriter) Close() error { return nil }
+
+func (w *testWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.String()
+}
+
+// waitOutput waits until the output contains want
+func waitOutput(t *testing.T, w *testWriter, want string) bool {
+	deadline := time.Now().Add(testTimeout)
+	for !strings.Contains(w.String(), want) {
+		if time.Now().After(deadline) {
+			return assert.Contains(t, w.String(), want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return true
+}
+
+func TestConsole(t *testing.T) {
+	t.Run("it must relay the input and report the exit code", func(t *testing.T) {
+		stdout := &testWriter{}
+		p, err := NewConsole(map[string]any{"envvar:FOO": base64.StdEncoding.EncodeToString([]byte("bar"))},
+			[]string{"sh", "-c", `read line; echo "got $line $FOO"; exit 3`}, stdout, nil)
+		assert.Nil(t, err)
+		resultCh := runProxy(t, p)
+		_, err = p.Write([]byte("hello\n"))
+		assert.Nil(t, err)
+		assert.Equal(t, &proxyResult{3, ""}, waitResult(t, p, resultCh))
+		assert.Contains(t, stdout.String(), "got hello bar")
+	})
+	t.Run("it must start the command with the size of the terminal", func(t *testing.T) {
+		stdout := &testWriter{}
+		p, err := NewConsole(nil, []string{"stty", "size"}, stdout, nil)
+		assert.Nil(t, err)
+		assert.Nil(t, p.(*console).ResizeTTY(&pty.Winsize{Rows: 30, Cols: 100}))
+		resultCh := runProxy(t, p)
+		assert.Equal(t, &proxyResult{0, ""}, waitResult(t, p, resultCh))
+		assert.Contains(t, stdout.String(), "30 100")
+	})
+	t.Run("it must kill the command and remove the files of the environment when it's closed", func(t *testing.T) {
+		stdout := &testWriter{}
+		p, err := NewConsole(map[string]any{"filesystem:CERT": base64.StdEncoding.EncodeToString([]byte("cert-data"))},
+			[]string{"sh", "-c", `echo "$CERT"; cat "$CERT"; sleep 30`}, stdout, nil)
+		assert.Nil(t, err)
+		resultCh := runProxy(t, p)
+		if !waitOutput(t, stdout, "cert-data") {
+			return
+		}
+		filePath := strings.Fields(stdout.String())[0]
+		assert.FileExists(t, filePath)
+
+		// the client closing the console is not reported
+		assert.Nil(t, p.Close())
+		assert.Nil(t, waitResult(t, p, resultCh))
+		assert.NoFileExists(t, filePath)
+		pid := p.(*console).cmd.Process.Pid
+		assert.Eventually(t, func() bool { return syscall.Kill(pid, 0) != nil }, testTimeout, 10*time.Millisecond)
+	})
+	t.Run("it must fail when the command doesn't start", func(t *testing.T) {
+		p, err := NewConsole(nil, []string{"/bifrost/not-found"}, &testWriter{}, nil)
+		assert.Nil(t, err)
+		result := waitResult(t, p, runProxy(t, p))
+		if assert.NotNil(t, result) {
+			assert.Equal(t, 1, result.exitCode)
+			assert.Contains(t, result.errMsg, "failed starting command")
+		}
+	})
+}
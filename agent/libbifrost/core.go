@@ -9,6 +9,13 @@ type Proxy interface {
 	Close() error
 }
 
+// ConsumeNotifier is implemented by the proxies that buffer the data written by the
+// client, fn is called with the size of the data as the proxy reads it to write it to
+// the server. The data of the other proxies is written to the server by Write.
+type ConsumeNotifier interface {
+	OnConsume(fn func(n int))
+}
+
 type Terminal interface {
 	ResizeTTY(size *pty.Winsize) error
 }
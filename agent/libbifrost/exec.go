@@ -0,0 +1,116 @@
+package libbifrost
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/bifrost/common/log"
+	"github.com/bifrost/poc/libbifrost/terminal"
+)
+
+// adHocExec executes a command once with the payload as its input.
+// The stdout and stderr of the command are streamed to their own writers.
+type adHocExec struct {
+	sid      string
+	cmd      *exec.Cmd
+	envStore *terminal.EnvVarStore
+
+	mu         sync.Mutex
+	preExec    bool
+	started    bool
+	exited     atomic.Bool
+	doneCh     chan struct{}
+	closeOnce  sync.Once
+	userClosed atomic.Bool
+}
+
+// NewAdHocExec prepares the command to run with the payload as stdin, the environment
+// variables are decoded from the connection (envvar:KEY or filesystem:KEY).
+func NewAdHocExec(rawEnvVarList map[string]any, args []string, payload []byte, stdout, stderr io.WriteCloser, opts map[string]string) (Proxy, error) {
+	cmd, envStore, err := newCommand(rawEnvVarList, args)
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	// the command is terminated with its child processes when the session is closed
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.WaitDelay = outputDrainTimeout
+	return &adHocExec{
+		sid:      opts["sid"],
+		cmd:      cmd,
+		envStore: envStore,
+		doneCh:   make(chan struct{}),
+	}, nil
+}
+
+func (e *adHocExec) Run(onErr func(exitCode int, errMsg string)) {
+	if err := e.start(); err != nil {
+		e.close()
+		onErr(1, err.Error())
+		return
+	}
+	go func() {
+		// it waits the output to be copied to the writers
+		err := e.cmd.Wait()
+		e.exited.Store(true)
+		userClosed := e.userClosed.Load()
+		e.close()
+		if userClosed {
+			return
+		}
+		exitCode := commandExitCode(e.cmd)
+		log.With("sid", e.sid).Infof("command exited, exitcode=%v, err=%v", exitCode, err)
+		if exitCode == -1 {
+			onErr(1, err.Error())
+			return
+		}
+		onErr(exitCode, "")
+	}()
+}
+
+func (e *adHocExec) start() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.preExec = true
+	if err := e.envStore.OnPreExec(); err != nil {
+		return err
+	}
+	if err := e.cmd.Start(); err != nil {
+		return fmt.Errorf("failed starting command, err=%v", err)
+	}
+	e.started = true
+	return nil
+}
+
+// Write is not supported, the input of the command is the payload
+func (e *adHocExec) Write(data []byte) (int, error) { return 0, io.ErrClosedPipe }
+func (e *adHocExec) Done() <-chan struct{}          { return e.doneCh }
+func (e *adHocExec) Close() error {
+	e.userClosed.Store(true)
+	e.close()
+	return nil
+}
+
+func (e *adHocExec) close() {
+	e.closeOnce.Do(func() {
+		e.mu.Lock()
+		if e.started && !e.exited.Load() {
+			_ = syscall.Kill(-e.cmd.Process.Pid, syscall.SIGKILL)
+		}
+		preExec := e.preExec
+		e.mu.Unlock()
+		if preExec {
+			if err := e.envStore.OnPostExec(); err != nil {
+				log.With("sid", e.sid).Infof("failed running post exec hooks, err=%v", err)
+			}
+		}
+		close(e.doneCh)
+	})
+}
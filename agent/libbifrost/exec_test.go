@@ -0,0 +1,82 @@
+package libbifrost
+
+import (
+	"encoding/base64"
+	"io"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdHocExec(t *testing.T) {
+	for _, tt := range []struct {
+		msg        string
+		envs       map[string]any
+		args       []string
+		payload    string
+		wantStdout string
+		wantStderr string
+		wantResult proxyResult
+	}{
+		{
+			msg:        "it must write the payload to the stdin and report the exit code",
+			envs:       map[string]any{"envvar:FOO": base64.StdEncoding.EncodeToString([]byte("bar"))},
+			args:       []string{"sh", "-c", `cat; echo "$FOO" >&2; exit 4`},
+			payload:    "select 1",
+			wantStdout: "select 1",
+			wantStderr: "bar\n",
+			wantResult: proxyResult{4, ""},
+		},
+		{
+			msg:        "it must write the file of a filesystem environment variable",
+			envs:       map[string]any{"filesystem:CERT": base64.StdEncoding.EncodeToString([]byte("cert-data"))},
+			args:       []string{"sh", "-c", `cat "$CERT"`},
+			wantStdout: "cert-data",
+			wantResult: proxyResult{0, ""},
+		},
+		{
+			msg:        "it must report the signal of a killed command",
+			args:       []string{"sh", "-c", `kill -TERM $$`},
+			wantResult: proxyResult{128 + int(syscall.SIGTERM), ""},
+		},
+	} {
+		t.Run(tt.msg, func(t *testing.T) {
+			stdout, stderr := &testWriter{}, &testWriter{}
+			p, err := NewAdHocExec(tt.envs, tt.args, []byte(tt.payload), stdout, stderr, nil)
+			assert.Nil(t, err)
+			assert.Equal(t, &tt.wantResult, waitResult(t, p, runProxy(t, p)))
+			assert.Equal(t, tt.wantStdout, stdout.String())
+			assert.Equal(t, tt.wantStderr, stderr.String())
+		})
+	}
+}
+
+func TestAdHocExecClose(t *testing.T) {
+	stdout := &testWriter{}
+	p, err := NewAdHocExec(nil, []string{"sh", "-c", "echo started; sleep 30"}, nil, stdout, &testWriter{}, nil)
+	assert.Nil(t, err)
+	resultCh := runProxy(t, p)
+	if !waitOutput(t, stdout, "started") {
+		return
+	}
+	_, err = p.Write([]byte("input"))
+	assert.Equal(t, io.ErrClosedPipe, err)
+
+	// the client closing the command is not reported
+	assert.Nil(t, p.Close())
+	assert.Nil(t, waitResult(t, p, resultCh))
+	pid := p.(*adHocExec).cmd.Process.Pid
+	assert.Eventually(t, func() bool { return syscall.Kill(pid, 0) != nil }, testTimeout, 10*time.Millisecond)
+}
+
+func TestAdHocExecStartError(t *testing.T) {
+	p, err := NewAdHocExec(nil, []string{"/bifrost/not-found"}, nil, &testWriter{}, &testWriter{}, nil)
+	assert.Nil(t, err)
+	result := waitResult(t, p, runProxy(t, p))
+	if assert.NotNil(t, result) {
+		assert.Equal(t, 1, result.exitCode)
+		assert.Contains(t, result.errMsg, "failed starting command")
+	}
+}
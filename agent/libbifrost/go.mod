@@ -1,5 +1,28 @@
-module libbifrost
+module github.com/bifrost/poc/libbifrost
 
-go 1.22.4
+go 1.23.8
 
-require github.com/creack/pty v1.1.21
+replace github.com/bifrost/common => ../../common
+
+require (
+	github.com/bifrost/common v0.0.0-00010101000000-000000000000
+	github.com/creack/pty v1.1.21
+	github.com/google/uuid v1.6.0
+	github.com/stretchr/testify v1.10.0
+	github.com/xdg-go/scram v1.1.2
+	go.mongodb.org/mongo-driver v1.15.1
+	golang.org/x/crypto v0.37.0
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.1 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	github.com/xdg-go/pbkdf2 v1.0.0 // indirect
+	github.com/xdg-go/stringprep v1.0.4 // indirect
+	go.uber.org/multierr v1.11.0 // indirect
+	go.uber.org/zap v1.27.0 // indirect
+	golang.org/x/sys v0.32.0 // indirect
+	golang.org/x/text v0.24.0 // indirect
+	google.golang.org/grpc v1.71.1 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
+)
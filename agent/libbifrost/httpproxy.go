@@ -0,0 +1,245 @@
+package libbifrost
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/bifrost/common/log"
+)
+
+const httpProxyHeaderPrefix = "header_"
+
+// httpProxy parses the HTTP/1.1 requests written by the client and forwards
+// them to the remote url with the headers of the connection. The responses
+// are streamed back to the client as they are read from the remote server.
+type httpProxy struct {
+	ctx      context.Context
+	cancelFn context.CancelFunc
+
+	sid          string
+	connectionID string
+	remoteURL    *url.URL
+	headers      http.Header
+	transport    *http.Transport
+
+	clientW    io.Writer
+	clientR    *clientReader
+	doneCh     chan struct{}
+	closeOnce  sync.Once
+	userClosed atomic.Bool
+}
+
+// NewHttpProxy returns a proxy for a client connection. The options are the
+// connection headers prefixed with HEADER_ and the keys remote_url, insecure,
+// sid and connection_id.
+func NewHttpProxy(ctx context.Context, clientW io.Writer, opts map[string]string) (Proxy, error) {
+	remoteURL, err := url.Parse(opts["remote_url"])
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing remote url, reason=%v", err)
+	}
+	if remoteURL.Scheme != "http" && remoteURL.Scheme != "https" {
+		return nil, fmt.Errorf("remote url scheme must be http or https, got=%q", remoteURL.Scheme)
+	}
+	headers := http.Header{}
+	for key, val := range opts {
+		if !strings.HasPrefix(strings.ToLower(key), httpProxyHeaderPrefix) {
+			continue
+		}
+		name := strings.ReplaceAll(key[len(httpProxyHeaderPrefix):], "_", "-")
+		if name != "" {
+			headers.Set(name, val)
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{InsecureSkipVerify: opts["insecure"] == "true"}
+	transport.DialContext = func(ctx context.Context, _, address string) (net.Conn, error) {
+		return dialServer(ctx, address)
+	}
+	// the encoding requested by the client is sent as it is
+	transport.DisableCompression = true
+	ctx, cancelFn := context.WithCancel(ctx)
+	return &httpProxy{
+		ctx:          ctx,
+		cancelFn:     cancelFn,
+		sid:          opts["sid"],
+		connectionID: opts["connection_id"],
+		remoteURL:    remoteURL,
+		headers:      headers,
+		transport:    transport,
+		clientW:      clientW,
+		clientR:      newClientReader(),
+		doneCh:       make(chan struct{}),
+	}, nil
+}
+
+// Run processes the requests of the client until the connection is closed, onErr
+// is called when the connection ends without being closed by Close.
+func (p *httpProxy) Run(onErr func(exitCode int, errMsg string)) {
+	go func() {
+		err := p.serve()
+		userClosed := p.userClosed.Load()
+		p.close()
+		if userClosed {
+			return
+		}
+		if err != nil {
+			log.With("sid", p.sid, "conn", p.connectionID).Infof("http proxy connection closed, reason=%v", err)
+			onErr(1, err.Error())
+			return
+		}
+		onErr(0, "")
+	}()
+}
+
+func (p *httpProxy) serve() error {
+	br := bufio.NewReader(p.clientR)
+	bw := bufio.NewWriterSize(p.clientW, 32*1024)
+	for {
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			_ = writeHttpError(bw, http.StatusBadRequest, fmt.Sprintf("failed parsing request: %v", err))
+			return err
+		}
+		resp, err := p.transport.RoundTrip(p.newRemoteRequest(req))
+		if err != nil {
+			_, _ = io.Copy(io.Discard, req.Body)
+			log.With("sid", p.sid, "conn", p.connectionID).Infof("failed forwarding request to %v, reason=%v",
+				p.remoteURL.Host, err)
+			if err := writeHttpError(bw, http.StatusBadGateway, fmt.Sprintf("failed connecting to remote url: %v", err)); err != nil {
+				return err
+			}
+			continue
+		}
+		err = writeHttpResponse(bw, req, resp)
+		_ = resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		if req.Close || resp.Close {
+			return nil
+		}
+	}
+}
+
+// newRemoteRequest rewrites the request to the remote url injecting the headers of the connection
+func (p *httpProxy) newRemoteRequest(req *http.Request) *http.Request {
+	outReq := req.Clone(p.ctx)
+	outReq.RequestURI = ""
+	outReq.URL.Scheme = p.remoteURL.Scheme
+	outReq.URL.Host = p.remoteURL.Host
+	outReq.URL.Path = joinURLPath(p.remoteURL.Path, req.URL.Path)
+	outReq.URL.RawPath = ""
+	switch {
+	case p.remoteURL.RawQuery == "":
+	case outReq.URL.RawQuery == "":
+		outReq.URL.RawQuery = p.remoteURL.RawQuery
+	default:
+		outReq.URL.RawQuery = p.remoteURL.RawQuery + "&" + outReq.URL.RawQuery
+	}
+	outReq.Host = p.remoteURL.Host
+	outReq.Header.Del("Proxy-Connection")
+	outReq.Header.Del("Proxy-Authorization")
+	for name, values := range p.headers {
+		outReq.Header[name] = values
+	}
+	return outReq
+}
+
+// writeHttpResponse writes the response flushing the buffered data
+// every time the body is read, it allows streaming responses.
+func writeHttpResponse(bw *bufio.Writer, req *http.Request, resp *http.Response) error {
+	// responses without length are chunked, the client connection is kept open
+	if resp.ContentLength == -1 && len(resp.TransferEncoding) == 0 &&
+		req.Method != http.MethodHead && bodyAllowedForStatus(resp.StatusCode) {
+		resp.TransferEncoding = []string{"chunked"}
+	}
+	resp.Body = &flushReader{ReadCloser: resp.Body, w: bw}
+	// hides the io.ReaderFrom of the buffered writer, it reads into
+	// the buffer that is flushed by the body reader
+	if err := resp.Write(struct{ io.Writer }{bw}); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func writeHttpError(bw *bufio.Writer, statusCode int, errMsg string) error {
+	resp := &http.Response{
+		StatusCode:    statusCode,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{"text/plain; charset=utf-8"}},
+		ContentLength: int64(len(errMsg)),
+		Body:          io.NopCloser(strings.NewReader(errMsg)),
+	}
+	if err := resp.Write(bw); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func bodyAllowedForStatus(status int) bool {
+	switch {
+	case status >= 100 && status <= 199:
+		return false
+	case status == http.StatusNoContent, status == http.StatusNotModified:
+		return false
+	}
+	return true
+}
+
+func joinURLPath(a, b string) string {
+	switch {
+	case a == "":
+		return b
+	case strings.HasSuffix(a, "/") && strings.HasPrefix(b, "/"):
+		return a + b[1:]
+	case !strings.HasSuffix(a, "/") && !strings.HasPrefix(b, "/"):
+		return a + "/" + b
+	}
+	return a + b
+}
+
+// flushReader flushes the writer before blocking on the next read
+type flushReader struct {
+	io.ReadCloser
+	w *bufio.Writer
+}
+
+func (f *flushReader) Read(p []byte) (int, error) {
+	if err := f.w.Flush(); err != nil {
+		return 0, err
+	}
+	return f.ReadCloser.Read(p)
+}
+
+// Write writes the data sent by the client
+func (p *httpProxy) Write(data []byte) (int, error) { return p.clientR.Write(data) }
+func (p *httpProxy) OnConsume(fn func(n int))       { p.clientR.OnConsume(fn) }
+func (p *httpProxy) Done() <-chan struct{}          { return p.doneCh }
+func (p *httpProxy) Close() error {
+	p.userClosed.Store(true)
+	p.close()
+	return nil
+}
+
+func (p *httpProxy) close() {
+	p.closeOnce.Do(func() {
+		p.cancelFn()
+		_ = p.clientR.Close()
+		p.transport.CloseIdleConnections()
+		close(p.doneCh)
+	})
+}
@@ -0,0 +1,131 @@
+package libbifrost
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readHttpResponse(t *testing.T, br *bufio.Reader) (*http.Response, string) {
+	resp, err := http.ReadResponse(br, nil)
+	if !assert.Nil(t, err) {
+		return nil, ""
+	}
+	body, err := io.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	return resp, string(body)
+}
+
+func TestHttpProxyRequests(t *testing.T) {
+	// the server writes the end of the streamed response when the client reads its first chunk
+	chunkReadCh := make(chan struct{})
+	fakeServer(t, func(t *testing.T, conn net.Conn) {
+		br := bufio.NewReader(conn)
+		req, err := http.ReadRequest(br)
+		if !assert.Nil(t, err) {
+			return
+		}
+		assert.Equal(t, "/v1/users", req.URL.Path)
+		assert.Equal(t, "token=abc&page=2", req.URL.RawQuery)
+		assert.Equal(t, "api.local", req.Host)
+		assert.Equal(t, "secret", req.Header.Get("X-Api-Key"))
+		assert.Empty(t, req.Header.Get("Proxy-Authorization"))
+		_, _ = io.WriteString(conn, "HTTP/1.1 200 OK\r\nContent-Type: text/plain\r\n"+
+			"Transfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n")
+		<-chunkReadCh
+		_, _ = io.WriteString(conn, "6\r\n world\r\n0\r\n\r\n")
+
+		req, err = http.ReadRequest(br)
+		if !assert.Nil(t, err) {
+			return
+		}
+		body, _ := io.ReadAll(req.Body)
+		assert.Equal(t, "ping", string(body))
+		assert.True(t, req.Close)
+		_, _ = io.WriteString(conn, "HTTP/1.1 201 Created\r\nContent-Length: 4\r\n\r\npong")
+	})
+	client := newTestClient(t)
+	p, err := NewHttpProxy(context.Background(), client.proxyConn, map[string]string{
+		"remote_url": "http://api.local/v1?token=abc", "header_X_Api_Key": "secret"})
+	assert.Nil(t, err)
+	resultCh := runProxy(t, p)
+	br := bufio.NewReader(client)
+
+	_, err = p.Write([]byte("GET /users?page=2 HTTP/1.1\r\nHost: bifrost\r\nProxy-Authorization: Basic Zm9v\r\n\r\n"))
+	assert.Nil(t, err)
+	resp, err := http.ReadResponse(br, nil)
+	if !assert.Nil(t, err) {
+		return
+	}
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	chunk := make([]byte, 5)
+	_, err = io.ReadFull(resp.Body, chunk)
+	assert.Nil(t, err)
+	assert.Equal(t, "hello", string(chunk))
+	close(chunkReadCh)
+	rest, err := io.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	assert.Equal(t, " world", string(rest))
+
+	_, err = p.Write([]byte("POST /users HTTP/1.1\r\nHost: bifrost\r\nContent-Length: 4\r\nConnection: close\r\n\r\nping"))
+	assert.Nil(t, err)
+	resp, body := readHttpResponse(t, br)
+	if assert.NotNil(t, resp) {
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+		assert.Equal(t, "pong", body)
+	}
+	// the client closing the connection ends the proxy without errors
+	assert.Equal(t, &proxyResult{0, ""}, waitResult(t, p, resultCh))
+}
+
+func TestHttpProxyErrors(t *testing.T) {
+	dial := dialServer
+	t.Cleanup(func() { dialServer = dial })
+	dialServer = func(ctx context.Context, address string) (net.Conn, error) {
+		return nil, fmt.Errorf("dial tcp %v: connection refused", address)
+	}
+
+	t.Run("it must reply bad gateway when the remote url is unreachable", func(t *testing.T) {
+		client := newTestClient(t)
+		p, err := NewHttpProxy(context.Background(), client.proxyConn, map[string]string{"remote_url": "http://api.local"})
+		assert.Nil(t, err)
+		resultCh := runProxy(t, p)
+		br := bufio.NewReader(client)
+		for i := 0; i < 2; i++ {
+			_, err = p.Write([]byte("GET / HTTP/1.1\r\nHost: bifrost\r\n\r\n"))
+			assert.Nil(t, err)
+			resp, body := readHttpResponse(t, br)
+			if assert.NotNil(t, resp) {
+				assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+				assert.Contains(t, body, "connection refused")
+			}
+		}
+		// the client closing the proxy is not reported
+		assert.Nil(t, p.Close())
+		assert.Nil(t, waitResult(t, p, resultCh))
+	})
+	t.Run("it must reply bad request when the request is malformed", func(t *testing.T) {
+		client := newTestClient(t)
+		p, err := NewHttpProxy(context.Background(), client.proxyConn, map[string]string{"remote_url": "http://api.local"})
+		assert.Nil(t, err)
+		resultCh := runProxy(t, p)
+		_, err = p.Write([]byte("NOT HTTP\r\n\r\n"))
+		assert.Nil(t, err)
+		resp, body := readHttpResponse(t, bufio.NewReader(client))
+		if assert.NotNil(t, resp) {
+			assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+			assert.Contains(t, body, "failed parsing request")
+		}
+		result := waitResult(t, p, resultCh)
+		if assert.NotNil(t, result) {
+			assert.Equal(t, 1, result.exitCode)
+			assert.Contains(t, result.errMsg, "malformed HTTP")
+		}
+	})
+}
@@ -0,0 +1,420 @@
+package libbifrost
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/bifrost/common/log"
+	"github.com/bifrost/common/mongotypes"
+	"github.com/xdg-go/scram"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/connstring"
+)
+
+const (
+	mongoScramSha1   = "SCRAM-SHA-1"
+	mongoScramSha256 = "SCRAM-SHA-256"
+
+	mongoErrUnauthorized = 13
+)
+
+// commands that are handled by the agent, the client is not allowed to authenticate
+var mongoAuthCommands = []string{"saslstart", "saslcontinue", "authenticate", "logout"}
+
+// options of the handshake that depends on the authentication or the compression
+// of the connection with the server, they are removed from the hello command of clients
+var mongoHelloRemoveKeys = []string{"speculativeAuthenticate", "saslSupportedMechs", "compression"}
+
+// mongoProxy relays OP_MSG frames between the client and the server.
+// The agent authenticates in the server using SCRAM with the credentials
+// of the connection string, the authentication commands of the client are rejected.
+type mongoProxy struct {
+	ctx      context.Context
+	cancelFn context.CancelFunc
+
+	sid          string
+	connectionID string
+	connStr      *connstring.ConnString
+
+	clientW    io.Writer
+	clientR    *clientReader
+	serverConn net.Conn
+	requestID  atomic.Uint32
+
+	mu         sync.Mutex
+	writeMu    sync.Mutex
+	doneCh     chan struct{}
+	closeOnce  sync.Once
+	userClosed atomic.Bool
+}
+
+func newMongoDBProxy(ctx context.Context, clientW io.Writer, opts map[string]string) (*mongoProxy, error) {
+	connStr, err := connstring.ParseAndValidate(opts["connection_string"])
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing connection string, reason=%v", err)
+	}
+	if len(connStr.Hosts) == 0 {
+		return nil, fmt.Errorf("connection string does not contain any host")
+	}
+	ctx, cancelFn := context.WithCancel(ctx)
+	return &mongoProxy{
+		ctx:          ctx,
+		cancelFn:     cancelFn,
+		sid:          opts["sid"],
+		connectionID: opts["connection_id"],
+		connStr:      connStr,
+		clientW:      clientW,
+		clientR:      newClientReader(),
+		doneCh:       make(chan struct{}),
+	}, nil
+}
+
+func (p *mongoProxy) Run(onErr func(exitCode int, errMsg string)) {
+	go func() {
+		err := p.run()
+		userClosed := p.userClosed.Load()
+		p.close()
+		if userClosed {
+			return
+		}
+		if err != nil {
+			log.With("sid", p.sid, "conn", p.connectionID).Infof("mongodb connection closed, reason=%v", err)
+			onErr(1, err.Error())
+			return
+		}
+		onErr(0, "")
+	}()
+}
+
+func (p *mongoProxy) run() error {
+	serverConn, err := p.connect()
+	if err != nil {
+		return err
+	}
+	p.mu.Lock()
+	p.serverConn = serverConn
+	p.mu.Unlock()
+	if p.userClosed.Load() {
+		_ = serverConn.Close()
+		return net.ErrClosed
+	}
+	log.With("sid", p.sid, "conn", p.connectionID).Infof("mongodb connection established with %v",
+		serverConn.RemoteAddr())
+
+	errCh := make(chan error, 2)
+	go func() { errCh <- p.relayServer() }()
+	go func() { errCh <- p.relayClient() }()
+	return <-errCh
+}
+
+// connect opens an authenticated connection with the primary node,
+// if none of the nodes is the primary the first reachable node is used.
+func (p *mongoProxy) connect() (net.Conn, error) {
+	authSource := p.authSource()
+	helloCmd := bson.D{{Key: "hello", Value: 1}, {Key: "$db", Value: "admin"}}
+	if p.connStr.Username != "" {
+		helloCmd = append(helloCmd, bson.E{Key: "saslSupportedMechs", Value: authSource + "." + p.connStr.Username})
+	}
+	var selected net.Conn
+	var selectedReply bson.Raw
+	var lastErr error
+	for _, host := range p.connStr.Hosts {
+		conn, err := p.dial(host)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		reply, err := p.roundTrip(conn, helloCmd)
+		if err != nil {
+			_ = conn.Close()
+			lastErr = fmt.Errorf("failed sending hello to %v, reason=%v", host, err)
+			continue
+		}
+		isPrimary, _ := reply.Lookup("isWritablePrimary").BooleanOK()
+		if selected == nil || isPrimary {
+			if selected != nil {
+				_ = selected.Close()
+			}
+			selected, selectedReply = conn, reply
+		} else {
+			_ = conn.Close()
+		}
+		if isPrimary || p.connStr.DirectConnection {
+			break
+		}
+	}
+	if selected == nil {
+		return nil, lastErr
+	}
+	if p.connStr.Username == "" {
+		return selected, nil
+	}
+
+	var mechs []string
+	if values, ok := selectedReply.Lookup("saslSupportedMechs").ArrayOK(); ok {
+		elems, _ := values.Values()
+		for _, v := range elems {
+			mechs = append(mechs, v.StringValue())
+		}
+	}
+	if err := p.authenticate(selected, authSource, mechs); err != nil {
+		_ = selected.Close()
+		return nil, err
+	}
+	return selected, nil
+}
+
+func (p *mongoProxy) dial(host string) (net.Conn, error) {
+	if !strings.Contains(host, ":") {
+		host = net.JoinHostPort(host, "27017")
+	}
+	conn, err := dialServer(p.ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("failed connecting with mongodb server %v, reason=%v", host, err)
+	}
+	if !p.connStr.SSL {
+		return conn, nil
+	}
+	serverName, _, _ := net.SplitHostPort(host)
+	tlsConfig := &tls.Config{ServerName: serverName, InsecureSkipVerify: p.connStr.SSLInsecure}
+	if p.connStr.SSLCaFileSet {
+		pem, err := os.ReadFile(p.connStr.SSLCaFile)
+		if err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("failed reading tls ca file, reason=%v", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		tlsConfig.RootCAs.AppendCertsFromPEM(pem)
+	}
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err := tlsConn.HandshakeContext(p.ctx); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed tls handshake with %v, reason=%v", host, err)
+	}
+	return tlsConn, nil
+}
+
+// authSource returns the database used to authenticate
+// https://www.mongodb.com/docs/manual/reference/connection-string/#mongodb-urioption-urioption.authSource
+func (p *mongoProxy) authSource() string {
+	switch {
+	case p.connStr.AuthSourceSet && p.connStr.AuthSource != "":
+		return p.connStr.AuthSource
+	case p.connStr.Database != "":
+		return p.connStr.Database
+	}
+	return "admin"
+}
+
+// authenticate performs the SCRAM conversation with the server
+// https://github.com/mongodb/specifications/blob/master/source/auth/auth.md#scram-sha-1
+func (p *mongoProxy) authenticate(conn net.Conn, authSource string, supportedMechs []string) error {
+	mech := strings.ToUpper(p.connStr.AuthMechanism)
+	if mech == "" {
+		mech = mongoScramSha256
+		if len(supportedMechs) > 0 && !containsString(supportedMechs, mongoScramSha256) {
+			mech = mongoScramSha1
+		}
+	}
+	var client *scram.Client
+	var err error
+	switch mech {
+	case mongoScramSha256:
+		client, err = scram.SHA256.NewClient(p.connStr.Username, p.connStr.Password, "")
+	case mongoScramSha1:
+		h := md5.New()
+		_, _ = io.WriteString(h, p.connStr.Username+":mongo:"+p.connStr.Password)
+		client, err = scram.SHA1.NewClientUnprepped(p.connStr.Username, hex.EncodeToString(h.Sum(nil)), "")
+	default:
+		return fmt.Errorf("authentication mechanism %q is not supported", mech)
+	}
+	if err != nil {
+		return fmt.Errorf("failed creating %v client, reason=%v", mech, err)
+	}
+	conv := client.NewConversation()
+	payload, err := conv.Step("")
+	if err != nil {
+		return fmt.Errorf("failed starting %v conversation, reason=%v", mech, err)
+	}
+	reply, err := p.roundTrip(conn, bson.D{
+		{Key: "saslStart", Value: 1},
+		{Key: "mechanism", Value: mech},
+		{Key: "payload", Value: []byte(payload)},
+		{Key: "options", Value: bson.D{{Key: "skipEmptyExchange", Value: true}}},
+		{Key: "$db", Value: authSource},
+	})
+	for {
+		if err != nil {
+			return fmt.Errorf("failed authenticating with %v, reason=%v", mech, err)
+		}
+		done, _ := reply.Lookup("done").BooleanOK()
+		if done && conv.Done() {
+			return nil
+		}
+		_, serverPayload, _ := reply.Lookup("payload").BinaryOK()
+		payload, err = conv.Step(string(serverPayload))
+		if err != nil {
+			return fmt.Errorf("failed authenticating with %v, reason=%v", mech, err)
+		}
+		if done && conv.Done() {
+			return nil
+		}
+		reply, err = p.roundTrip(conn, bson.D{
+			{Key: "saslContinue", Value: 1},
+			{Key: "conversationId", Value: reply.Lookup("conversationId")},
+			{Key: "payload", Value: []byte(payload)},
+			{Key: "$db", Value: authSource},
+		})
+	}
+}
+
+// roundTrip sends a command to the server and returns the reply document
+func (p *mongoProxy) roundTrip(conn net.Conn, cmd bson.D) (bson.Raw, error) {
+	doc, err := bson.Marshal(cmd)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(mongotypes.NewOpMsg(p.requestID.Add(1), doc).Encode()); err != nil {
+		return nil, err
+	}
+	pkt, err := mongotypes.Decode(conn)
+	if err != nil {
+		return nil, err
+	}
+	replyDoc, err := pkt.CommandDocument()
+	if err != nil {
+		return nil, err
+	}
+	reply := bson.Raw(replyDoc)
+	if !isMongoReplyOK(reply) {
+		errMsg, _ := reply.Lookup("errmsg").StringValueOK()
+		return reply, fmt.Errorf("%v", errMsg)
+	}
+	return reply, nil
+}
+
+// relayServer forwards complete messages of the server to the client
+func (p *mongoProxy) relayServer() error {
+	for {
+		pkt, err := mongotypes.Decode(p.serverConn)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		if err := p.writeClient(pkt); err != nil {
+			return err
+		}
+	}
+}
+
+// relayClient forwards the messages of the client to the server
+// intercepting the authentication commands.
+func (p *mongoProxy) relayClient() error {
+	for {
+		pkt, err := mongotypes.Decode(p.clientR)
+		if err != nil {
+			return err
+		}
+		switch pkt.OpCode {
+		case mongotypes.OpCompressed:
+			return fmt.Errorf("compressed messages are not supported")
+		case mongotypes.OpMsgType, mongotypes.OpQueryType:
+			cmdName := strings.ToLower(pkt.CommandName())
+			log.With("sid", p.sid, "conn", p.connectionID).Debugf("command=%v, opcode=%v", cmdName, pkt.OpCode)
+			if containsString(mongoAuthCommands, cmdName) {
+				reply := mongotypes.NewErrorReply(pkt, p.requestID.Add(1), mongoErrUnauthorized, "Unauthorized",
+					"authentication is managed by the agent")
+				if err := p.writeClient(reply); err != nil {
+					return err
+				}
+				continue
+			}
+			if cmdName == "hello" || cmdName == "ismaster" {
+				if err := removeHelloKeys(pkt); err != nil {
+					return err
+				}
+			}
+		}
+		if _, err := p.serverConn.Write(pkt.Encode()); err != nil {
+			return err
+		}
+	}
+}
+
+func (p *mongoProxy) writeClient(pkt *mongotypes.Packet) error {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+	_, err := p.clientW.Write(pkt.Encode())
+	return err
+}
+
+// Write writes the data sent by the client
+func (p *mongoProxy) Write(data []byte) (int, error) { return p.clientR.Write(data) }
+func (p *mongoProxy) OnConsume(fn func(n int))       { p.clientR.OnConsume(fn) }
+func (p *mongoProxy) Done() <-chan struct{}          { return p.doneCh }
+func (p *mongoProxy) Close() error {
+	p.userClosed.Store(true)
+	p.close()
+	return nil
+}
+
+func (p *mongoProxy) close() {
+	p.closeOnce.Do(func() {
+		p.cancelFn()
+		_ = p.clientR.Close()
+		p.mu.Lock()
+		if p.serverConn != nil {
+			_ = p.serverConn.Close()
+		}
+		p.mu.Unlock()
+		close(p.doneCh)
+	})
+}
+
+func removeHelloKeys(pkt *mongotypes.Packet) error {
+	doc, err := pkt.CommandDocument()
+	if err != nil {
+		return err
+	}
+	newDoc, removed, err := mongotypes.RemoveKeys(doc, mongoHelloRemoveKeys...)
+	if err != nil || !removed {
+		return err
+	}
+	return pkt.ReplaceCommandDocument(newDoc)
+}
+
+func isMongoReplyOK(reply bson.Raw) bool {
+	v := reply.Lookup("ok")
+	if f, ok := v.DoubleOK(); ok {
+		return f == 1
+	}
+	if i, ok := v.Int32OK(); ok {
+		return i == 1
+	}
+	if i, ok := v.Int64OK(); ok {
+		return i == 1
+	}
+	b, _ := v.BooleanOK()
+	return b
+}
+
+func containsString(list []string, v string) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,210 @@
+package libbifrost
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"net"
+	"testing"
+
+	"github.com/bifrost/common/mongotypes"
+	"github.com/stretchr/testify/assert"
+	"github.com/xdg-go/scram"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// newScramServer returns a server that authenticates the user and password of client
+func newScramServer(t *testing.T, hash scram.HashGeneratorFcn, client *scram.Client) *scram.ServerConversation {
+	creds := client.GetStoredCredentials(scram.KeyFactors{Salt: "bifrost-salt", Iters: 4096})
+	server, err := hash.NewServer(func(string) (scram.StoredCredentials, error) { return creds, nil })
+	assert.Nil(t, err)
+	return server.NewConversation()
+}
+
+func readMongoCommand(t *testing.T, conn net.Conn) (*mongotypes.Packet, bson.Raw) {
+	pkt, err := mongotypes.Decode(conn)
+	if !assert.Nil(t, err) {
+		return nil, nil
+	}
+	doc, err := pkt.CommandDocument()
+	assert.Nil(t, err)
+	return pkt, bson.Raw(doc)
+}
+
+func writeMongoReply(t *testing.T, conn net.Conn, req *mongotypes.Packet, reply bson.D) bool {
+	doc, err := bson.Marshal(reply)
+	if !assert.Nil(t, err) {
+		return false
+	}
+	pkt := mongotypes.NewOpMsg(req.RequestID+1000, doc)
+	pkt.ResponseTo = req.RequestID
+	_, err = conn.Write(pkt.Encode())
+	return assert.Nil(t, err)
+}
+
+// serveMongo replies the hello of the agent with the mechanisms of the user, then it
+// authenticates the agent with mech and relays a hello of the client.
+func serveMongo(mechs []string, mech string, client *scram.Client) func(*testing.T, net.Conn) {
+	return func(t *testing.T, conn net.Conn) {
+		req, cmd := readMongoCommand(t, conn)
+		if req == nil {
+			return
+		}
+		assert.Equal(t, "hello", req.CommandName())
+		assert.Equal(t, "admin.bifrost", cmd.Lookup("saslSupportedMechs").StringValue())
+		writeMongoReply(t, conn, req, bson.D{
+			{Key: "ok", Value: 1.0},
+			{Key: "isWritablePrimary", Value: true},
+			{Key: "saslSupportedMechs", Value: mechs},
+		})
+
+		req, cmd = readMongoCommand(t, conn)
+		if req == nil {
+			return
+		}
+		assert.Equal(t, "saslStart", req.CommandName())
+		assert.Equal(t, mech, cmd.Lookup("mechanism").StringValue())
+		assert.Equal(t, "admin", cmd.Lookup("$db").StringValue())
+		hash := scram.SHA256
+		if mech == mongoScramSha1 {
+			hash = scram.SHA1
+		}
+		conv := newScramServer(t, hash, client)
+		_, payload := cmd.Lookup("payload").Binary()
+		serverFirst, err := conv.Step(string(payload))
+		if !assert.Nil(t, err) {
+			return
+		}
+		writeMongoReply(t, conn, req, bson.D{
+			{Key: "ok", Value: 1.0},
+			{Key: "conversationId", Value: int32(1)},
+			{Key: "done", Value: false},
+			{Key: "payload", Value: []byte(serverFirst)},
+		})
+
+		req, cmd = readMongoCommand(t, conn)
+		if req == nil {
+			return
+		}
+		assert.Equal(t, "saslContinue", req.CommandName())
+		assert.Equal(t, int32(1), cmd.Lookup("conversationId").Int32())
+		_, payload = cmd.Lookup("payload").Binary()
+		serverFinal, err := conv.Step(string(payload))
+		if err != nil {
+			writeMongoReply(t, conn, req, bson.D{
+				{Key: "ok", Value: 0.0},
+				{Key: "errmsg", Value: "Authentication failed."},
+				{Key: "code", Value: int32(18)},
+			})
+			return
+		}
+		writeMongoReply(t, conn, req, bson.D{
+			{Key: "ok", Value: 1.0},
+			{Key: "conversationId", Value: int32(1)},
+			{Key: "done", Value: true},
+			{Key: "payload", Value: []byte(serverFinal)},
+		})
+
+		// the hello of the client doesn't negotiate the authentication or the compression
+		req, cmd = readMongoCommand(t, conn)
+		if req == nil {
+			return
+		}
+		assert.Equal(t, "hello", req.CommandName())
+		for _, key := range mongoHelloRemoveKeys {
+			_, err := cmd.LookupErr(key)
+			assert.NotNil(t, err, key)
+		}
+		assert.Equal(t, "mongosh", cmd.Lookup("appName").StringValue())
+		writeMongoReply(t, conn, req, bson.D{{Key: "ok", Value: 1.0}, {Key: "isWritablePrimary", Value: true}})
+	}
+}
+
+func writeMongoCommand(t *testing.T, p Proxy, requestID uint32, cmd bson.D) {
+	doc, err := bson.Marshal(cmd)
+	assert.Nil(t, err)
+	_, err = p.Write(mongotypes.NewOpMsg(requestID, doc).Encode())
+	assert.Nil(t, err)
+}
+
+func TestMongoDBHandshake(t *testing.T) {
+	h := md5.New()
+	_, _ = h.Write([]byte("bifrost:mongo:secret"))
+	sha1Client, err := scram.SHA1.NewClientUnprepped("bifrost", hex.EncodeToString(h.Sum(nil)), "")
+	assert.Nil(t, err)
+	sha256Client, err := scram.SHA256.NewClient("bifrost", "secret", "")
+	assert.Nil(t, err)
+
+	for _, tt := range []struct {
+		msg      string
+		password string
+		mechs    []string
+		mech     string
+		client   *scram.Client
+		wantErr  string
+	}{
+		{
+			msg:      "it must authenticate with SCRAM-SHA-256",
+			password: "secret",
+			mechs:    []string{mongoScramSha1, mongoScramSha256},
+			mech:     mongoScramSha256,
+			client:   sha256Client,
+		},
+		{
+			msg:      "it must authenticate with SCRAM-SHA-1 when the user does not support SCRAM-SHA-256",
+			password: "secret",
+			mechs:    []string{mongoScramSha1},
+			mech:     mongoScramSha1,
+			client:   sha1Client,
+		},
+		{
+			msg:      "it must fail when the server rejects the authentication",
+			password: "wrong",
+			mechs:    []string{mongoScramSha256},
+			mech:     mongoScramSha256,
+			client:   sha256Client,
+			wantErr:  "failed authenticating with SCRAM-SHA-256, reason=Authentication failed.",
+		},
+	} {
+		t.Run(tt.msg, func(t *testing.T) {
+			fakeServer(t, serveMongo(tt.mechs, tt.mech, tt.client))
+			client := newTestClient(t)
+			p, err := newMongoDBProxy(context.Background(), client.proxyConn, map[string]string{
+				"connection_string": "mongodb://bifrost:" + tt.password + "@mongo.local:27017/"})
+			assert.Nil(t, err)
+			resultCh := runProxy(t, p)
+			if tt.wantErr != "" {
+				assert.Equal(t, &proxyResult{1, tt.wantErr}, waitResult(t, p, resultCh))
+				return
+			}
+
+			// the client is not allowed to authenticate
+			writeMongoCommand(t, p, 1, bson.D{
+				{Key: "saslStart", Value: 1},
+				{Key: "mechanism", Value: mongoScramSha256},
+				{Key: "$db", Value: "admin"},
+			})
+			pkt, err := mongotypes.Decode(client)
+			assert.Nil(t, err)
+			assert.Equal(t, uint32(1), pkt.ResponseTo)
+			doc, err := pkt.CommandDocument()
+			assert.Nil(t, err)
+			assert.Equal(t, int32(mongoErrUnauthorized), bson.Raw(doc).Lookup("code").Int32())
+
+			writeMongoCommand(t, p, 2, bson.D{
+				{Key: "hello", Value: 1},
+				{Key: "appName", Value: "mongosh"},
+				{Key: "speculativeAuthenticate", Value: bson.D{{Key: "saslStart", Value: 1}}},
+				{Key: "saslSupportedMechs", Value: "admin.root"},
+				{Key: "compression", Value: bson.A{"zstd"}},
+				{Key: "$db", Value: "admin"},
+			})
+			pkt, err = mongotypes.Decode(client)
+			assert.Nil(t, err)
+			assert.Equal(t, uint32(2), pkt.ResponseTo)
+			doc, err = pkt.CommandDocument()
+			assert.Nil(t, err)
+			assert.True(t, isMongoReplyOK(bson.Raw(doc)))
+		})
+	}
+}
@@ -0,0 +1,313 @@
+package libbifrost
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/bifrost/common/log"
+	"github.com/bifrost/common/mssqltypes"
+)
+
+// mssqlProxy speaks the TDS protocol with the client and the server.
+// The credentials of the LOGIN7 message sent by the client are replaced
+// by the credentials of the connection. The connection with the client
+// is never encrypted, it's already protected by the gateway.
+type mssqlProxy struct {
+	ctx      context.Context
+	cancelFn context.CancelFunc
+
+	sid          string
+	connectionID string
+	host         string
+	address      string
+	username     string
+	password     string
+	database     string
+	insecure     bool
+
+	clientW    io.Writer
+	clientR    *clientReader
+	serverConn net.Conn
+
+	mu         sync.Mutex
+	doneCh     chan struct{}
+	closeOnce  sync.Once
+	userClosed atomic.Bool
+}
+
+func newMSSQLProxy(ctx context.Context, clientW io.Writer, opts map[string]string) (*mssqlProxy, error) {
+	if opts["hostname"] == "" || opts["username"] == "" {
+		return nil, fmt.Errorf("missing required options: hostname and username")
+	}
+	port := opts["port"]
+	if port == "" {
+		port = "1433"
+	}
+	ctx, cancelFn := context.WithCancel(ctx)
+	return &mssqlProxy{
+		ctx:          ctx,
+		cancelFn:     cancelFn,
+		sid:          opts["sid"],
+		connectionID: opts["connection_id"],
+		host:         opts["hostname"],
+		address:      net.JoinHostPort(opts["hostname"], port),
+		username:     opts["username"],
+		password:     opts["password"],
+		database:     opts["database"],
+		insecure:     opts["insecure"] == "true",
+		clientW:      clientW,
+		clientR:      newClientReader(),
+		doneCh:       make(chan struct{}),
+	}, nil
+}
+
+func (p *mssqlProxy) Run(onErr func(exitCode int, errMsg string)) {
+	go func() {
+		err := p.run()
+		userClosed := p.userClosed.Load()
+		p.close()
+		if userClosed {
+			return
+		}
+		if err != nil {
+			log.With("sid", p.sid, "conn", p.connectionID).Infof("mssql connection closed, reason=%v", err)
+			onErr(1, err.Error())
+			return
+		}
+		onErr(0, "")
+	}()
+}
+
+func (p *mssqlProxy) run() error {
+	typ, data, err := mssqltypes.DecodeMessage(p.clientR)
+	if err != nil {
+		return fmt.Errorf("failed reading prelogin from client, err=%v", err)
+	}
+	if typ != mssqltypes.PacketPreloginType {
+		return fmt.Errorf("expected prelogin packet from client, found=%X", byte(typ))
+	}
+	clientPrelogin, err := mssqltypes.DecodePrelogin(data)
+	if err != nil {
+		return fmt.Errorf("failed decoding client prelogin, err=%v", err)
+	}
+
+	serverConn, err := dialServer(p.ctx, p.address)
+	if err != nil {
+		return fmt.Errorf("failed connecting with mssql server, err=%v", err)
+	}
+	p.mu.Lock()
+	p.serverConn = serverConn
+	p.mu.Unlock()
+	if p.userClosed.Load() {
+		_ = serverConn.Close()
+		return net.ErrClosed
+	}
+
+	loginConn, err := p.prelogin(clientPrelogin)
+	if err != nil {
+		return err
+	}
+	if err := p.login(loginConn); err != nil {
+		return err
+	}
+	log.With("sid", p.sid, "conn", p.connectionID).Infof("mssql connection established with %v", p.address)
+
+	errCh := make(chan error, 2)
+	go func() {
+		_, err := io.Copy(p.clientW, p.serverConn)
+		errCh <- err
+	}()
+	go func() { errCh <- p.relayClient() }()
+	return <-errCh
+}
+
+// prelogin negotiates the encryption with the server and answers the client
+// with encryption not supported. It returns the connection used to send the login.
+func (p *mssqlProxy) prelogin(clientPrelogin mssqltypes.Prelogin) (net.Conn, error) {
+	serverPrelogin := mssqltypes.Prelogin{}
+	for token, val := range clientPrelogin {
+		serverPrelogin[token] = val
+	}
+	serverPrelogin[mssqltypes.PreloginEncryption] = []byte{mssqltypes.EncryptOn}
+	// multiple active result sets requires multiplexing the connection (SMP)
+	serverPrelogin[mssqltypes.PreloginMars] = []byte{0x00}
+	delete(serverPrelogin, mssqltypes.PreloginFedAuthRequired)
+	delete(serverPrelogin, mssqltypes.PreloginNonceOpt)
+	preloginMsg := mssqltypes.EncodeMessage(mssqltypes.PacketPreloginType, serverPrelogin.Encode(), mssqltypes.DefaultPacketSize)
+	if _, err := p.serverConn.Write(preloginMsg); err != nil {
+		return nil, fmt.Errorf("failed writing prelogin to server, err=%v", err)
+	}
+	_, data, err := mssqltypes.DecodeMessage(p.serverConn)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading prelogin response from server, err=%v", err)
+	}
+	serverResp, err := mssqltypes.DecodePrelogin(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed decoding server prelogin, err=%v", err)
+	}
+
+	clientResp := mssqltypes.Prelogin{}
+	for token, val := range serverResp {
+		clientResp[token] = val
+	}
+	clientResp[mssqltypes.PreloginEncryption] = []byte{mssqltypes.EncryptNotSup}
+	clientResp[mssqltypes.PreloginMars] = []byte{0x00}
+	delete(clientResp, mssqltypes.PreloginFedAuthRequired)
+	delete(clientResp, mssqltypes.PreloginNonceOpt)
+
+	encryption := serverResp.Encryption()
+	if encryption == mssqltypes.EncryptNotSup && !p.insecure {
+		return nil, fmt.Errorf("mssql server does not support encryption, enable the insecure option to allow it")
+	}
+	if clientPrelogin.Encryption() == mssqltypes.EncryptReq {
+		return nil, fmt.Errorf("client requires encryption, disable it, the connection is already protected by the gateway")
+	}
+	respMsg := mssqltypes.EncodeMessage(mssqltypes.PacketReplyType, clientResp.Encode(), mssqltypes.DefaultPacketSize)
+	if _, err := p.clientW.Write(respMsg); err != nil {
+		return nil, fmt.Errorf("failed writing prelogin response to client, err=%v", err)
+	}
+	if encryption == mssqltypes.EncryptNotSup {
+		return p.serverConn, nil
+	}
+
+	handshakeConn := &tdsHandshakeConn{Conn: p.serverConn}
+	tlsConfig := &tls.Config{
+		ServerName:         p.host,
+		InsecureSkipVerify: p.insecure,
+		// sql server implementations may not support tls 1.3 on top of tds
+		MaxVersion: tls.VersionTLS12,
+	}
+	tlsConn := tls.Client(handshakeConn, tlsConfig)
+	if err := tlsConn.HandshakeContext(p.ctx); err != nil {
+		return nil, fmt.Errorf("failed tls handshake with mssql server, err=%v", err)
+	}
+	handshakeConn.handshakeComplete.Store(true)
+	// encrypt on: the whole connection is encrypted, encrypt off: only the login
+	if encryption != mssqltypes.EncryptOff {
+		p.mu.Lock()
+		p.serverConn = tlsConn
+		p.mu.Unlock()
+	}
+	return tlsConn, nil
+}
+
+// login replaces the credentials of the client login and sends it to the server
+func (p *mssqlProxy) login(loginConn net.Conn) error {
+	typ, data, err := mssqltypes.DecodeMessage(p.clientR)
+	if err != nil {
+		return fmt.Errorf("failed reading login from client, err=%v", err)
+	}
+	if typ != mssqltypes.PacketLogin7Type {
+		return fmt.Errorf("expected login7 packet from client, found=%X", byte(typ))
+	}
+	login := mssqltypes.DecodeLogin(data)
+	login.UserName = p.username
+	login.Password = p.password
+	login.ChangePassword = ""
+	login.DisablePasswordChange()
+	login.DisableIntegratedSecurity()
+	if login.Database == "" {
+		login.Database = p.database
+	}
+	pkt, err := mssqltypes.EncodeLogin(*login)
+	if err != nil {
+		return fmt.Errorf("failed encoding login7 packet, err=%v", err)
+	}
+	loginMsg := mssqltypes.EncodeMessage(mssqltypes.PacketLogin7Type, pkt.Frame, mssqltypes.DefaultPacketSize)
+	if _, err := loginConn.Write(loginMsg); err != nil {
+		return fmt.Errorf("failed writing login7 to server, err=%v", err)
+	}
+	return nil
+}
+
+// relayClient forwards the packets of the client to the server,
+// it includes sql batches, rpc requests and attention signals.
+func (p *mssqlProxy) relayClient() error {
+	for {
+		pkt, err := mssqltypes.Decode(p.clientR)
+		if err != nil {
+			return err
+		}
+		if pkt.Type() == mssqltypes.PacketSQLBatchType && log.IsDebugLevel {
+			if query, err := mssqltypes.DecodeSQLBatchToRawQuery(pkt.Encode()); err == nil {
+				log.With("sid", p.sid, "conn", p.connectionID).Debugf("query=%s", query)
+			}
+		}
+		if _, err := p.serverConn.Write(pkt.Encode()); err != nil {
+			return err
+		}
+	}
+}
+
+// Write writes the data sent by the client
+func (p *mssqlProxy) Write(data []byte) (int, error) { return p.clientR.Write(data) }
+func (p *mssqlProxy) OnConsume(fn func(n int))       { p.clientR.OnConsume(fn) }
+func (p *mssqlProxy) Done() <-chan struct{}          { return p.doneCh }
+func (p *mssqlProxy) Close() error {
+	p.userClosed.Store(true)
+	p.close()
+	return nil
+}
+
+func (p *mssqlProxy) close() {
+	p.closeOnce.Do(func() {
+		p.cancelFn()
+		_ = p.clientR.Close()
+		p.mu.Lock()
+		if p.serverConn != nil {
+			_ = p.serverConn.Close()
+		}
+		p.mu.Unlock()
+		close(p.doneCh)
+	})
+}
+
+// tdsHandshakeConn wraps the tls handshake records in prelogin packets,
+// after the handshake the records are sent directly in the connection.
+type tdsHandshakeConn struct {
+	net.Conn
+	handshakeComplete atomic.Bool
+
+	readBuf  []byte
+	writeBuf []byte
+}
+
+func (c *tdsHandshakeConn) Read(b []byte) (int, error) {
+	if c.handshakeComplete.Load() {
+		return c.Conn.Read(b)
+	}
+	// the client flight is sent when the response of the server is expected
+	if len(c.writeBuf) > 0 {
+		msg := mssqltypes.EncodeMessage(mssqltypes.PacketPreloginType, c.writeBuf, mssqltypes.DefaultPacketSize)
+		c.writeBuf = nil
+		if _, err := c.Conn.Write(msg); err != nil {
+			return 0, err
+		}
+	}
+	if len(c.readBuf) == 0 {
+		pkt, err := mssqltypes.Decode(c.Conn)
+		if err != nil {
+			return 0, err
+		}
+		if pkt.Type() != mssqltypes.PacketPreloginType {
+			return 0, fmt.Errorf("expected prelogin packet during tls handshake, found=%X", byte(pkt.Type()))
+		}
+		c.readBuf = pkt.Frame
+	}
+	n := copy(b, c.readBuf)
+	c.readBuf = c.readBuf[n:]
+	return n, nil
+}
+
+func (c *tdsHandshakeConn) Write(b []byte) (int, error) {
+	if c.handshakeComplete.Load() {
+		return c.Conn.Write(b)
+	}
+	c.writeBuf = append(c.writeBuf, b...)
+	return len(b), nil
+}
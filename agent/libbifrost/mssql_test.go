@@ -0,0 +1,225 @@
+package libbifrost
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/hex"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/bifrost/common/mssqltypes"
+	"github.com/stretchr/testify/assert"
+)
+
+// mssqlTestLogin is the login7 message sent by sqlcmd
+const mssqlTestLogin = "f40000000400007400100000000006010000000000000000a002000000000000000000005e0019009000030096000800a6000600b200090000000000c4000a00d8000000d8000e00000000000000f4000000f4000000f400000000000000530061006e00640072006f0073002d004d006100630042006f006f006b002d00500072006f002e006c006f00630061006c00730061006e00b6a5b3a586a583a596a593a5e6a5e3a5730071006c0063006d0064003100320037002e0030002e0030002e00310067006f002d006d007300730071006c006400620061006400760065006e00740075007200650077006f0072006b007300"
+
+// newTestLogin returns the login7 message of sqlcmd with the password and without the database
+func newTestLogin(t *testing.T, password string) []byte {
+	data, err := hex.DecodeString(mssqlTestLogin)
+	assert.Nil(t, err)
+	login := mssqltypes.DecodeLogin(data)
+	login.Password = password
+	login.Database = ""
+	pkt, err := mssqltypes.EncodeLogin(*login)
+	assert.Nil(t, err)
+	return pkt.Frame
+}
+
+// mssqlTestServer negotiates the encryption of the prelogin and checks the login of the agent
+type mssqlTestServer struct {
+	encryption byte
+	cert       tls.Certificate
+	// the reply of the login, the server stops after the prelogin when it's nil
+	loginReply []byte
+	// the server closes the connection after the reply of the login
+	rejectLogin bool
+}
+
+func (s mssqlTestServer) serve(t *testing.T, conn net.Conn) {
+	typ, data, err := mssqltypes.DecodeMessage(conn)
+	if !assert.Nil(t, err) || !assert.Equal(t, mssqltypes.PacketPreloginType, typ) {
+		return
+	}
+	prelogin, err := mssqltypes.DecodePrelogin(data)
+	if !assert.Nil(t, err) {
+		return
+	}
+	// the agent requests encryption and doesn't negotiate features that it can't relay
+	assert.Equal(t, mssqltypes.EncryptOn, prelogin.Encryption())
+	assert.Equal(t, []byte{0x00}, prelogin[mssqltypes.PreloginMars])
+	assert.NotContains(t, prelogin, mssqltypes.PreloginFedAuthRequired)
+	resp := mssqltypes.Prelogin{
+		mssqltypes.PreloginVersion:    {0x10, 0x00, 0x10, 0x7a, 0x00, 0x00},
+		mssqltypes.PreloginEncryption: {s.encryption},
+		mssqltypes.PreloginMars:       {0x00},
+	}
+	respMsg := mssqltypes.EncodeMessage(mssqltypes.PacketReplyType, resp.Encode(), mssqltypes.DefaultPacketSize)
+	if _, err := conn.Write(respMsg); !assert.Nil(t, err) {
+		return
+	}
+
+	loginConn, dataConn := conn, conn
+	if s.encryption != mssqltypes.EncryptNotSup {
+		// the tls handshake is sent in prelogin packets
+		handshakeConn := &tdsHandshakeConn{Conn: conn}
+		tlsConn := tls.Server(handshakeConn, &tls.Config{Certificates: []tls.Certificate{s.cert}})
+		// the proxy aborts the handshake of a certificate that is not trusted
+		if tlsConn.Handshake() != nil {
+			return
+		}
+		finished := mssqltypes.EncodeMessage(mssqltypes.PacketPreloginType, handshakeConn.writeBuf, mssqltypes.DefaultPacketSize)
+		if _, err := conn.Write(finished); !assert.Nil(t, err) {
+			return
+		}
+		handshakeConn.handshakeComplete.Store(true)
+		loginConn = tlsConn
+		if s.encryption == mssqltypes.EncryptOn {
+			dataConn = tlsConn
+		}
+	}
+	if s.loginReply == nil {
+		return
+	}
+
+	typ, data, err = mssqltypes.DecodeMessage(loginConn)
+	if !assert.Nil(t, err) || !assert.Equal(t, mssqltypes.PacketLogin7Type, typ) {
+		return
+	}
+	login := mssqltypes.DecodeLogin(data)
+	wantLogin := mssqltypes.DecodeLogin(newTestLogin(t, "secret"))
+	assert.Equal(t, "bifrost", login.UserName)
+	assert.Equal(t, wantLogin.Password, login.Password)
+	assert.Equal(t, "testdb", login.Database)
+	assert.Equal(t, "sqlcmd", login.AppName)
+	if _, err := dataConn.Write(s.loginReply); !assert.Nil(t, err) || s.rejectLogin {
+		return
+	}
+
+	// the messages of the client are relayed after the login
+	typ, _, err = mssqltypes.DecodeMessage(dataConn)
+	if assert.Nil(t, err) {
+		assert.Equal(t, mssqltypes.PacketSQLBatchType, typ)
+	}
+}
+
+func TestMSSQLHandshake(t *testing.T) {
+	cert, _ := newTestCertificate(t, "mssql.local")
+	// login ack and done tokens
+	loginAck := mssqltypes.EncodeMessage(mssqltypes.PacketReplyType, []byte{0xad, 0x00, 0x00, 0xfd, 0x00}, mssqltypes.DefaultPacketSize)
+	loginErr := mssqltypes.EncodeMessage(mssqltypes.PacketReplyType, []byte{0xaa, 0x00, 0x00, 0xfd, 0x02}, mssqltypes.DefaultPacketSize)
+
+	for _, tt := range []struct {
+		msg              string
+		insecure         bool
+		clientEncryption byte
+		server           mssqlTestServer
+		wantReply        []byte
+		wantErr          string
+	}{
+		{
+			msg:              "it must login without encryption when it's allowed",
+			insecure:         true,
+			clientEncryption: mssqltypes.EncryptOff,
+			server:           mssqlTestServer{encryption: mssqltypes.EncryptNotSup, loginReply: loginAck},
+			wantReply:        loginAck,
+		},
+		{
+			msg:              "it must encrypt only the login when the server offers encryption",
+			insecure:         true,
+			clientEncryption: mssqltypes.EncryptOff,
+			server:           mssqlTestServer{encryption: mssqltypes.EncryptOff, cert: cert, loginReply: loginAck},
+			wantReply:        loginAck,
+		},
+		{
+			msg:              "it must encrypt the connection when the server requires encryption",
+			insecure:         true,
+			clientEncryption: mssqltypes.EncryptOff,
+			server:           mssqlTestServer{encryption: mssqltypes.EncryptOn, cert: cert, loginReply: loginAck},
+			wantReply:        loginAck,
+		},
+		{
+			msg:              "it must relay the login error of the server",
+			insecure:         true,
+			clientEncryption: mssqltypes.EncryptOff,
+			server:           mssqlTestServer{encryption: mssqltypes.EncryptOn, cert: cert, loginReply: loginErr, rejectLogin: true},
+			wantReply:        loginErr,
+		},
+		{
+			msg:              "it must fail when the server does not support encryption",
+			clientEncryption: mssqltypes.EncryptOff,
+			server:           mssqlTestServer{encryption: mssqltypes.EncryptNotSup},
+			wantErr:          "mssql server does not support encryption, enable the insecure option to allow it",
+		},
+		{
+			msg:              "it must fail when the certificate of the server is not trusted",
+			clientEncryption: mssqltypes.EncryptOff,
+			server:           mssqlTestServer{encryption: mssqltypes.EncryptOn, cert: cert},
+			wantErr:          "x509: certificate signed by unknown authority",
+		},
+		{
+			msg:              "it must fail when the client requires encryption",
+			insecure:         true,
+			clientEncryption: mssqltypes.EncryptReq,
+			server:           mssqlTestServer{encryption: mssqltypes.EncryptOn, cert: cert},
+			wantErr:          "client requires encryption, disable it, the connection is already protected by the gateway",
+		},
+	} {
+		t.Run(tt.msg, func(t *testing.T) {
+			fakeServer(t, tt.server.serve)
+			client := newTestClient(t)
+			opts := map[string]string{"hostname": "mssql.local", "username": "bifrost", "password": "secret", "database": "testdb"}
+			if tt.insecure {
+				opts["insecure"] = "true"
+			}
+			p, err := newMSSQLProxy(context.Background(), client.proxyConn, opts)
+			assert.Nil(t, err)
+			resultCh := runProxy(t, p)
+
+			prelogin := mssqltypes.Prelogin{
+				mssqltypes.PreloginVersion:         {0x0f, 0x00, 0x07, 0xd0, 0x00, 0x00},
+				mssqltypes.PreloginEncryption:      {tt.clientEncryption},
+				mssqltypes.PreloginMars:            {0x01},
+				mssqltypes.PreloginFedAuthRequired: {0x01},
+			}
+			_, err = p.Write(mssqltypes.EncodeMessage(mssqltypes.PacketPreloginType, prelogin.Encode(), mssqltypes.DefaultPacketSize))
+			assert.Nil(t, err)
+			_, err = p.Write(mssqltypes.EncodeMessage(mssqltypes.PacketLogin7Type, newTestLogin(t, "client-password"), mssqltypes.DefaultPacketSize))
+			assert.Nil(t, err)
+			if tt.wantErr != "" {
+				if tt.server.encryption != mssqltypes.EncryptNotSup && tt.clientEncryption != mssqltypes.EncryptReq {
+					// the client receives the prelogin response before the tls handshake
+					_, _, err := mssqltypes.DecodeMessage(client)
+					assert.Nil(t, err)
+				}
+				result := waitResult(t, p, resultCh)
+				if assert.NotNil(t, result) {
+					assert.Equal(t, 1, result.exitCode)
+					assert.Contains(t, result.errMsg, tt.wantErr)
+				}
+				return
+			}
+
+			// the client is informed that the connection is not encrypted
+			typ, data, err := mssqltypes.DecodeMessage(client)
+			assert.Nil(t, err)
+			assert.Equal(t, mssqltypes.PacketReplyType, typ)
+			resp, err := mssqltypes.DecodePrelogin(data)
+			assert.Nil(t, err)
+			assert.Equal(t, mssqltypes.EncryptNotSup, resp.Encryption())
+			assert.Equal(t, []byte{0x00}, resp[mssqltypes.PreloginMars])
+
+			reply := make([]byte, len(tt.wantReply))
+			_, err = io.ReadFull(client, reply)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.wantReply, reply)
+			if !tt.server.rejectLogin {
+				_, err = p.Write(mssqltypes.EncodeMessage(mssqltypes.PacketSQLBatchType, []byte("select 1"), mssqltypes.DefaultPacketSize))
+				assert.Nil(t, err)
+			}
+			// the server closes the connection
+			assert.Equal(t, &proxyResult{0, ""}, waitResult(t, p, resultCh))
+		})
+	}
+}
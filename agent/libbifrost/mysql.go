@@ -0,0 +1,387 @@
+package libbifrost
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bifrost/common/log"
+	"github.com/bifrost/common/mysqltypes"
+)
+
+const defaultDialTimeout = 10 * time.Second
+
+// dialServer opens the connections of the proxies with the servers
+var dialServer = func(ctx context.Context, address string) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: defaultDialTimeout}
+	return dialer.DialContext(ctx, "tcp", address)
+}
+
+// capabilities that are never negotiated with the client, the connection
+// between the client and the agent is already protected by the gateway
+const mysqlUnsupportedCapabilities = mysqltypes.ClientSSL |
+	mysqltypes.ClientCompress |
+	mysqltypes.ClientZstdCompressionAlgorithm
+
+var errMySQLClientQuit = errors.New("client sent quit command")
+
+// mysqlProxy speaks the MySQL wire protocol with the client and the server.
+// The client authentication is ignored, the agent authenticates in the
+// server with the credentials of the connection and relays the command phase.
+type mysqlProxy struct {
+	ctx      context.Context
+	cancelFn context.CancelFunc
+
+	sid          string
+	connectionID string
+	address      string
+	username     string
+	password     string
+	database     string
+
+	clientW      io.Writer
+	clientR      *clientReader
+	serverConn   net.Conn
+	capabilities mysqltypes.CapabilityFlag
+
+	mu         sync.Mutex
+	doneCh     chan struct{}
+	closeOnce  sync.Once
+	userClosed atomic.Bool
+	clientQuit atomic.Bool
+}
+
+func newMySQLProxy(ctx context.Context, clientW io.Writer, opts map[string]string) (*mysqlProxy, error) {
+	if opts["hostname"] == "" || opts["username"] == "" {
+		return nil, fmt.Errorf("missing required options: hostname and username")
+	}
+	port := opts["port"]
+	if port == "" {
+		port = "3306"
+	}
+	ctx, cancelFn := context.WithCancel(ctx)
+	return &mysqlProxy{
+		ctx:          ctx,
+		cancelFn:     cancelFn,
+		sid:          opts["sid"],
+		connectionID: opts["connection_id"],
+		address:      net.JoinHostPort(opts["hostname"], port),
+		username:     opts["username"],
+		password:     opts["password"],
+		database:     opts["database"],
+		clientW:      clientW,
+		clientR:      newClientReader(),
+		doneCh:       make(chan struct{}),
+	}, nil
+}
+
+func (p *mysqlProxy) Run(onErr func(exitCode int, errMsg string)) {
+	go func() {
+		err := p.run()
+		userClosed := p.userClosed.Load()
+		p.close()
+		if userClosed || p.clientQuit.Load() {
+			return
+		}
+		if err != nil {
+			log.With("sid", p.sid, "conn", p.connectionID).Infof("mysql connection closed, reason=%v", err)
+			onErr(1, err.Error())
+			return
+		}
+		onErr(0, "")
+	}()
+}
+
+func (p *mysqlProxy) run() error {
+	serverConn, err := dialServer(p.ctx, p.address)
+	if err != nil {
+		_ = p.writeClient(mysqltypes.NewErrPacket(0, mysqltypes.ErrConnectionFailure,
+			mysqltypes.SQLStateConnectionError, "failed connecting to mysql server: %v", err))
+		return fmt.Errorf("failed connecting with mysql server, err=%v", err)
+	}
+	p.mu.Lock()
+	p.serverConn = serverConn
+	p.mu.Unlock()
+	if p.userClosed.Load() {
+		_ = serverConn.Close()
+		return net.ErrClosed
+	}
+
+	if err := p.handshake(); err != nil {
+		return err
+	}
+	log.With("sid", p.sid, "conn", p.connectionID).Infof("mysql connection established with %v", p.address)
+
+	errCh := make(chan error, 2)
+	go func() {
+		_, err := io.Copy(p.clientW, serverConn)
+		errCh <- err
+	}()
+	go func() { errCh <- p.relayClient() }()
+	err = <-errCh
+	if errors.Is(err, errMySQLClientQuit) {
+		return nil
+	}
+	return err
+}
+
+// handshake authenticates the client with a random scramble and
+// the server with the credentials of the connection.
+func (p *mysqlProxy) handshake() error {
+	pkt, err := mysqltypes.Decode(p.serverConn)
+	if err != nil {
+		return fmt.Errorf("failed reading initial handshake from server, err=%v", err)
+	}
+	if pkt.IsErr() {
+		_ = p.writeClient(pkt)
+		return mysqltypes.DecodeErr(pkt.Frame)
+	}
+	serverHandshake, err := mysqltypes.DecodeHandshake(pkt.Frame)
+	if err != nil {
+		return fmt.Errorf("failed decoding initial handshake, err=%v", err)
+	}
+
+	clientCapabilities := serverHandshake.Capabilities&^mysqlUnsupportedCapabilities |
+		mysqltypes.ClientProtocol41 | mysqltypes.ClientSecureConnection | mysqltypes.ClientPluginAuth
+	clientHandshake := &mysqltypes.Handshake{
+		ProtocolVersion: mysqltypes.DefaultProtocolVersion,
+		ServerVersion:   serverHandshake.ServerVersion,
+		ConnectionID:    serverHandshake.ConnectionID,
+		AuthPluginData:  newScramble(),
+		Capabilities:    clientCapabilities,
+		CharacterSet:    serverHandshake.CharacterSet,
+		StatusFlags:     serverHandshake.StatusFlags,
+		AuthPluginName:  mysqltypes.NativePasswordPlugin,
+	}
+	if err := p.writeClient(mysqltypes.New(0, clientHandshake.Encode())); err != nil {
+		return fmt.Errorf("failed writing handshake to client, err=%v", err)
+	}
+
+	pkt, err = mysqltypes.Decode(p.clientR)
+	if err != nil {
+		return fmt.Errorf("failed reading handshake response from client, err=%v", err)
+	}
+	if mysqltypes.IsSSLRequest(pkt.Frame) {
+		_ = p.writeClient(mysqltypes.NewErrPacket(pkt.SequenceID+1, mysqltypes.ErrHandshake,
+			mysqltypes.SQLStateConnectionError, "ssl is not supported, the connection is already secured by the gateway"))
+		return fmt.Errorf("client requested ssl connection")
+	}
+	clientResp, err := mysqltypes.DecodeHandshakeResponse(pkt.Frame)
+	if err != nil {
+		_ = p.writeClient(mysqltypes.NewErrPacket(pkt.SequenceID+1, mysqltypes.ErrMalformedPacket,
+			mysqltypes.SQLStateGeneral, "%v", err))
+		return fmt.Errorf("failed decoding handshake response, err=%v", err)
+	}
+	clientSeq := pkt.SequenceID
+
+	capabilities := clientResp.Capabilities&serverHandshake.Capabilities&^mysqlUnsupportedCapabilities |
+		mysqltypes.ClientProtocol41 | mysqltypes.ClientSecureConnection | mysqltypes.ClientPluginAuth
+	database := clientResp.Database
+	if database == "" {
+		database = p.database
+	}
+	capabilities &^= mysqltypes.ClientConnectWithDB
+	if database != "" {
+		capabilities |= mysqltypes.ClientConnectWithDB
+	}
+	pluginName := serverHandshake.AuthPluginName
+	if pluginName == "" {
+		pluginName = mysqltypes.NativePasswordPlugin
+	}
+	authResp, err := p.authResponse(pluginName, serverHandshake.AuthPluginData)
+	if err != nil {
+		_ = p.writeClient(mysqltypes.NewErrPacket(clientSeq+1, mysqltypes.ErrNotSupportedAuth,
+			mysqltypes.SQLStateAccessDenied, "%v", err))
+		return err
+	}
+	serverResp := &mysqltypes.HandshakeResponse{
+		Capabilities:   capabilities,
+		MaxPacketSize:  clientResp.MaxPacketSize,
+		CharacterSet:   clientResp.CharacterSet,
+		Username:       p.username,
+		AuthResponse:   authResp,
+		Database:       database,
+		AuthPluginName: pluginName,
+		ConnectAttrs:   clientResp.ConnectAttrs,
+	}
+	if _, err := p.serverConn.Write(mysqltypes.New(pkt.SequenceID, serverResp.Encode()).Encode()); err != nil {
+		return fmt.Errorf("failed writing handshake response to server, err=%v", err)
+	}
+
+	result, err := p.serverAuth(pluginName, serverHandshake.AuthPluginData)
+	if result != nil {
+		// the client expects the result right after its handshake response
+		result.SequenceID = clientSeq + 1
+		if writeErr := p.writeClient(result); writeErr != nil && err == nil {
+			err = fmt.Errorf("failed writing auth result to client, err=%v", writeErr)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	p.capabilities = capabilities
+	return nil
+}
+
+// serverAuth handles the authentication exchange with the server
+// returning the final OK or ERR packet.
+func (p *mysqlProxy) serverAuth(pluginName string, scramble []byte) (*mysqltypes.Packet, error) {
+	for {
+		pkt, err := mysqltypes.Decode(p.serverConn)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading auth response from server, err=%v", err)
+		}
+		var authData []byte
+		switch pkt.Header() {
+		case mysqltypes.HeaderOK:
+			return pkt, nil
+		case mysqltypes.HeaderErr:
+			return pkt, mysqltypes.DecodeErr(pkt.Frame)
+		case mysqltypes.HeaderAuthSwitchRequest:
+			pluginName, scramble, err = mysqltypes.DecodeAuthSwitchRequest(pkt.Frame)
+			if err != nil {
+				return nil, err
+			}
+			authData, err = p.authResponse(pluginName, scramble)
+			if err != nil {
+				return mysqltypes.NewErrPacket(0, mysqltypes.ErrNotSupportedAuth,
+					mysqltypes.SQLStateAccessDenied, "%v", err), err
+			}
+		case mysqltypes.HeaderAuthMoreData:
+			authData, err = p.authMoreData(pluginName, scramble, pkt.Frame[1:])
+			if err != nil {
+				return mysqltypes.NewErrPacket(0, mysqltypes.ErrAccessDenied,
+					mysqltypes.SQLStateAccessDenied, "%v", err), err
+			}
+			// fast authentication succeeded, the next packet is the result
+			if authData == nil {
+				continue
+			}
+		default:
+			return nil, fmt.Errorf("unknown auth packet (%X) from server", pkt.Header())
+		}
+		if _, err := p.serverConn.Write(mysqltypes.New(pkt.SequenceID+1, authData).Encode()); err != nil {
+			return nil, fmt.Errorf("failed writing auth data to server, err=%v", err)
+		}
+	}
+}
+
+func (p *mysqlProxy) authResponse(pluginName string, scramble []byte) ([]byte, error) {
+	switch pluginName {
+	case mysqltypes.NativePasswordPlugin:
+		return mysqltypes.ScrambleNativePassword(scramble, p.password), nil
+	case mysqltypes.CachingSha2PasswordPlugin:
+		return mysqltypes.ScrambleCachingSha2Password(scramble, p.password), nil
+	case mysqltypes.Sha256PasswordPlugin:
+		if p.password == "" {
+			return []byte{0x00}, nil
+		}
+		// request the public key of the server
+		return []byte{0x01}, nil
+	}
+	return nil, fmt.Errorf("authentication plugin %q is not supported", pluginName)
+}
+
+// authMoreData handles the extra authentication data sent by the server,
+// a nil response means there's nothing to send.
+func (p *mysqlProxy) authMoreData(pluginName string, scramble, data []byte) ([]byte, error) {
+	switch pluginName {
+	case mysqltypes.CachingSha2PasswordPlugin:
+		if len(data) == 1 {
+			switch data[0] {
+			case mysqltypes.AuthCachingSha2FastSuccess:
+				return nil, nil
+			case mysqltypes.AuthCachingSha2FullAuth:
+				return []byte{mysqltypes.AuthRequestPublicKey}, nil
+			}
+			return nil, fmt.Errorf("unknown caching_sha2_password state (%X)", data[0])
+		}
+	case mysqltypes.Sha256PasswordPlugin:
+	default:
+		return nil, fmt.Errorf("unexpected auth data for plugin %q", pluginName)
+	}
+	pubKey, err := mysqltypes.ParsePublicKey(data)
+	if err != nil {
+		return nil, err
+	}
+	return mysqltypes.EncryptPassword(p.password, scramble, pubKey)
+}
+
+// relayClient forwards the packets of the client to the server
+// intercepting commands that are not allowed.
+func (p *mysqlProxy) relayClient() error {
+	for {
+		pkt, err := mysqltypes.Decode(p.clientR)
+		if err != nil {
+			return err
+		}
+		// a sequence greater than zero are continuation packets or local infile data
+		if pkt.SequenceID == 0 {
+			switch pkt.Command() {
+			case mysqltypes.ComChangeUser:
+				err := p.writeClient(mysqltypes.NewErrPacket(1, mysqltypes.ErrNotSupportedAuth,
+					mysqltypes.SQLStateAccessDenied, "change user is not allowed"))
+				if err != nil {
+					return err
+				}
+				continue
+			case mysqltypes.ComQuit:
+				p.clientQuit.Store(true)
+				_, _ = p.serverConn.Write(pkt.Encode())
+				return errMySQLClientQuit
+			case mysqltypes.ComQuery:
+				if log.IsDebugLevel {
+					log.With("sid", p.sid, "conn", p.connectionID).Debugf("query=%s",
+						mysqltypes.ParseQuery(pkt.Frame, p.capabilities))
+				}
+			}
+		}
+		if _, err := p.serverConn.Write(pkt.Encode()); err != nil {
+			return err
+		}
+	}
+}
+
+func (p *mysqlProxy) writeClient(pkt *mysqltypes.Packet) error {
+	_, err := p.clientW.Write(pkt.Encode())
+	return err
+}
+
+// Write writes the data sent by the client
+func (p *mysqlProxy) Write(data []byte) (int, error) { return p.clientR.Write(data) }
+func (p *mysqlProxy) OnConsume(fn func(n int))       { p.clientR.OnConsume(fn) }
+func (p *mysqlProxy) Done() <-chan struct{}          { return p.doneCh }
+func (p *mysqlProxy) Close() error {
+	p.userClosed.Store(true)
+	p.close()
+	return nil
+}
+
+func (p *mysqlProxy) close() {
+	p.closeOnce.Do(func() {
+		p.cancelFn()
+		_ = p.clientR.Close()
+		p.mu.Lock()
+		if p.serverConn != nil {
+			_ = p.serverConn.Close()
+		}
+		p.mu.Unlock()
+		close(p.doneCh)
+	})
+}
+
+// newScramble generates a random scramble of printable characters
+func newScramble() []byte {
+	scramble := make([]byte, 20)
+	_, _ = rand.Read(scramble)
+	for i := range scramble {
+		scramble[i] = scramble[i]%94 + 33
+	}
+	return scramble
+}
@@ -0,0 +1,233 @@
+package libbifrost
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"testing"
+
+	"github.com/bifrost/common/mysqltypes"
+	"github.com/stretchr/testify/assert"
+)
+
+var mysqlTestScramble = []byte("abcdefghijklmnopqrst")
+
+// serveMySQL sends the initial handshake of the server and checks the
+// handshake response of the agent, auth completes the authentication.
+func serveMySQL(plugin string, auth func(t *testing.T, conn net.Conn, resp *mysqltypes.HandshakeResponse) bool) func(*testing.T, net.Conn) {
+	return func(t *testing.T, conn net.Conn) {
+		handshake := &mysqltypes.Handshake{
+			ProtocolVersion: mysqltypes.DefaultProtocolVersion,
+			ServerVersion:   "8.0.36",
+			ConnectionID:    7,
+			AuthPluginData:  mysqlTestScramble,
+			Capabilities: mysqltypes.ClientProtocol41 | mysqltypes.ClientSecureConnection |
+				mysqltypes.ClientPluginAuth | mysqltypes.ClientConnectWithDB | mysqltypes.ClientLongPassword,
+			CharacterSet:   mysqltypes.DefaultCharset,
+			StatusFlags:    mysqltypes.StatusAutocommit,
+			AuthPluginName: plugin,
+		}
+		if _, err := conn.Write(mysqltypes.New(0, handshake.Encode()).Encode()); !assert.Nil(t, err) {
+			return
+		}
+		pkt, err := mysqltypes.Decode(conn)
+		if !assert.Nil(t, err) {
+			return
+		}
+		resp, err := mysqltypes.DecodeHandshakeResponse(pkt.Frame)
+		if !assert.Nil(t, err) {
+			return
+		}
+		assert.Equal(t, uint8(1), pkt.SequenceID)
+		assert.Equal(t, "bifrost", resp.Username)
+		assert.Equal(t, "testdb", resp.Database)
+		assert.Equal(t, plugin, resp.AuthPluginName)
+		if !auth(t, conn, resp) {
+			return
+		}
+		// the commands of the client are relayed after the authentication
+		pkt, err = mysqltypes.Decode(conn)
+		if assert.Nil(t, err) {
+			assert.Equal(t, mysqltypes.ComQuit, pkt.Command())
+		}
+	}
+}
+
+// readMySQLAuth reads the auth data sent by the agent
+func readMySQLAuth(t *testing.T, conn net.Conn, seq uint8) []byte {
+	pkt, err := mysqltypes.Decode(conn)
+	if !assert.Nil(t, err) {
+		return nil
+	}
+	assert.Equal(t, seq, pkt.SequenceID)
+	return pkt.Frame
+}
+
+func writeMySQL(t *testing.T, conn net.Conn, pkt *mysqltypes.Packet) bool {
+	_, err := conn.Write(pkt.Encode())
+	return assert.Nil(t, err)
+}
+
+func TestMySQLHandshake(t *testing.T) {
+	serverKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	pubKey, err := x509.MarshalPKIXPublicKey(&serverKey.PublicKey)
+	assert.Nil(t, err)
+	pubKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubKey})
+	switchScramble := []byte("ABCDEFGHIJKLMNOPQRST")
+
+	for _, tt := range []struct {
+		msg     string
+		plugin  string
+		auth    func(t *testing.T, conn net.Conn, resp *mysqltypes.HandshakeResponse) bool
+		wantErr string
+	}{
+		{
+			msg:    "it must authenticate with mysql_native_password",
+			plugin: mysqltypes.NativePasswordPlugin,
+			auth: func(t *testing.T, conn net.Conn, resp *mysqltypes.HandshakeResponse) bool {
+				assert.Equal(t, mysqltypes.ScrambleNativePassword(mysqlTestScramble, "secret"), resp.AuthResponse)
+				return writeMySQL(t, conn, mysqltypes.NewOKPacket(2, mysqltypes.StatusAutocommit))
+			},
+		},
+		{
+			msg:    "it must authenticate with the plugin of an auth switch request",
+			plugin: mysqltypes.CachingSha2PasswordPlugin,
+			auth: func(t *testing.T, conn net.Conn, resp *mysqltypes.HandshakeResponse) bool {
+				frame := append([]byte{mysqltypes.HeaderAuthSwitchRequest}, mysqltypes.NativePasswordPlugin+"\x00"...)
+				frame = append(append(frame, switchScramble...), 0x00)
+				if !writeMySQL(t, conn, mysqltypes.New(2, frame)) {
+					return false
+				}
+				authData := readMySQLAuth(t, conn, 3)
+				assert.Equal(t, mysqltypes.ScrambleNativePassword(switchScramble, "secret"), authData)
+				return writeMySQL(t, conn, mysqltypes.NewOKPacket(4, mysqltypes.StatusAutocommit))
+			},
+		},
+		{
+			msg:    "it must authenticate with caching_sha2_password fast authentication",
+			plugin: mysqltypes.CachingSha2PasswordPlugin,
+			auth: func(t *testing.T, conn net.Conn, resp *mysqltypes.HandshakeResponse) bool {
+				assert.Equal(t, mysqltypes.ScrambleCachingSha2Password(mysqlTestScramble, "secret"), resp.AuthResponse)
+				frame := []byte{mysqltypes.HeaderAuthMoreData, mysqltypes.AuthCachingSha2FastSuccess}
+				return writeMySQL(t, conn, mysqltypes.New(2, frame)) &&
+					writeMySQL(t, conn, mysqltypes.NewOKPacket(3, mysqltypes.StatusAutocommit))
+			},
+		},
+		{
+			msg:    "it must authenticate with caching_sha2_password full authentication",
+			plugin: mysqltypes.CachingSha2PasswordPlugin,
+			auth: func(t *testing.T, conn net.Conn, resp *mysqltypes.HandshakeResponse) bool {
+				frame := []byte{mysqltypes.HeaderAuthMoreData, mysqltypes.AuthCachingSha2FullAuth}
+				if !writeMySQL(t, conn, mysqltypes.New(2, frame)) {
+					return false
+				}
+				assert.Equal(t, []byte{mysqltypes.AuthRequestPublicKey}, readMySQLAuth(t, conn, 3))
+				if !writeMySQL(t, conn, mysqltypes.New(4, append([]byte{mysqltypes.HeaderAuthMoreData}, pubKeyPEM...))) {
+					return false
+				}
+				plain, err := rsa.DecryptOAEP(sha1.New(), rand.Reader, serverKey, readMySQLAuth(t, conn, 5), nil)
+				if !assert.Nil(t, err) {
+					return false
+				}
+				for i := range plain {
+					plain[i] ^= mysqlTestScramble[i%len(mysqlTestScramble)]
+				}
+				assert.Equal(t, "secret\x00", string(plain))
+				return writeMySQL(t, conn, mysqltypes.NewOKPacket(6, mysqltypes.StatusAutocommit))
+			},
+		},
+		{
+			msg:    "it must send the error of the server when the authentication is rejected",
+			plugin: mysqltypes.NativePasswordPlugin,
+			auth: func(t *testing.T, conn net.Conn, resp *mysqltypes.HandshakeResponse) bool {
+				writeMySQL(t, conn, mysqltypes.NewErrPacket(2, mysqltypes.ErrAccessDenied,
+					mysqltypes.SQLStateAccessDenied, "Access denied for user 'bifrost'"))
+				return false
+			},
+			wantErr: "ERROR 1045 (28000): Access denied for user 'bifrost'",
+		},
+	} {
+		t.Run(tt.msg, func(t *testing.T) {
+			fakeServer(t, serveMySQL(tt.plugin, tt.auth))
+			client := newTestClient(t)
+			p, err := newMySQLProxy(context.Background(), client.proxyConn, map[string]string{
+				"hostname": "mysql.local", "username": "bifrost", "password": "secret", "database": "testdb"})
+			assert.Nil(t, err)
+			resultCh := runProxy(t, p)
+
+			pkt, err := mysqltypes.Decode(client)
+			assert.Nil(t, err)
+			handshake, err := mysqltypes.DecodeHandshake(pkt.Frame)
+			assert.Nil(t, err)
+			// the client authenticates with the scramble of the agent
+			assert.NotEqual(t, mysqlTestScramble, handshake.AuthPluginData)
+			assert.Equal(t, mysqltypes.NativePasswordPlugin, handshake.AuthPluginName)
+			clientResp := &mysqltypes.HandshakeResponse{
+				Capabilities: mysqltypes.ClientProtocol41 | mysqltypes.ClientSecureConnection |
+					mysqltypes.ClientPluginAuth,
+				MaxPacketSize:  mysqltypes.MaxPacketSize,
+				CharacterSet:   mysqltypes.DefaultCharset,
+				Username:       "client",
+				AuthResponse:   []byte("ignored"),
+				AuthPluginName: mysqltypes.NativePasswordPlugin,
+			}
+			_, err = p.Write(mysqltypes.New(1, clientResp.Encode()).Encode())
+			assert.Nil(t, err)
+
+			pkt, err = mysqltypes.Decode(client)
+			assert.Nil(t, err)
+			assert.Equal(t, uint8(2), pkt.SequenceID)
+			if tt.wantErr != "" {
+				assert.Equal(t, tt.wantErr, mysqltypes.DecodeErr(pkt.Frame).Error())
+				assert.Equal(t, &proxyResult{1, tt.wantErr}, waitResult(t, p, resultCh))
+				return
+			}
+			assert.True(t, pkt.IsOK())
+			_, err = p.Write(mysqltypes.New(0, []byte{mysqltypes.ComQuit.Byte()}).Encode())
+			assert.Nil(t, err)
+			// the client quitting is not reported
+			assert.Nil(t, waitResult(t, p, resultCh))
+		})
+	}
+}
+
+func TestMySQLClientSSLRequest(t *testing.T) {
+	fakeServer(t, func(t *testing.T, conn net.Conn) {
+		handshake := &mysqltypes.Handshake{
+			ProtocolVersion: mysqltypes.DefaultProtocolVersion,
+			ServerVersion:   "8.0.36",
+			AuthPluginData:  mysqlTestScramble,
+			Capabilities:    mysqltypes.ClientProtocol41 | mysqltypes.ClientSecureConnection | mysqltypes.ClientPluginAuth,
+			AuthPluginName:  mysqltypes.NativePasswordPlugin,
+		}
+		writeMySQL(t, conn, mysqltypes.New(0, handshake.Encode()))
+	})
+	client := newTestClient(t)
+	p, err := newMySQLProxy(context.Background(), client.proxyConn, map[string]string{
+		"hostname": "mysql.local", "username": "bifrost"})
+	assert.Nil(t, err)
+	resultCh := runProxy(t, p)
+
+	pkt, err := mysqltypes.Decode(client)
+	assert.Nil(t, err)
+	handshake, err := mysqltypes.DecodeHandshake(pkt.Frame)
+	assert.Nil(t, err)
+	assert.False(t, handshake.Capabilities.Has(mysqltypes.ClientSSL))
+	sslRequest := &mysqltypes.HandshakeResponse{
+		Capabilities:  mysqltypes.ClientProtocol41 | mysqltypes.ClientSSL,
+		MaxPacketSize: mysqltypes.MaxPacketSize,
+		CharacterSet:  mysqltypes.DefaultCharset,
+	}
+	_, err = p.Write(mysqltypes.New(1, sslRequest.Encode()[:32]).Encode())
+	assert.Nil(t, err)
+
+	pkt, err = mysqltypes.Decode(client)
+	assert.Nil(t, err)
+	assert.Equal(t, mysqltypes.ErrHandshake, mysqltypes.DecodeErr(pkt.Frame).Code)
+	assert.Equal(t, &proxyResult{1, "client requested ssl connection"}, waitResult(t, p, resultCh))
+}
@@ -0,0 +1,462 @@
+package libbifrost
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/bifrost/common/log"
+	"github.com/bifrost/common/pgtypes"
+	"github.com/xdg-go/scram"
+)
+
+var (
+	errPGClientTerminate = errors.New("client sent terminate message")
+	errPGCancelRequest   = errors.New("client sent cancel request")
+)
+
+// policyOptions are the options of the policies applied to the queries and the
+// results of a connection: guard rails and data masking. The proxy doesn't inspect
+// them, a connection with these policies is refused instead of running without them.
+var policyOptions = []string{"guard_rail_rules", "data_masking_entity_data"}
+
+// dlpOptions configure the provider of the data masking, they're ignored without it
+var dlpOptions = []string{"dlp_provider", "dlp_info_types"}
+
+// setOption returns the first option of keys that is set, the empty json values
+// (null, [], {}) are sent when the connection has no policy configured.
+func setOption(opts map[string]string, keys []string) string {
+	for _, key := range keys {
+		switch strings.TrimSpace(opts[key]) {
+		case "", "null", "[]", "{}":
+		default:
+			return key
+		}
+	}
+	return ""
+}
+
+// pgProxy speaks the PostgreSQL protocol with the client and the server.
+// The client authentication is ignored, the agent authenticates in the
+// server with the credentials of the connection and relays the simple
+// and extended query messages.
+type pgProxy struct {
+	ctx      context.Context
+	cancelFn context.CancelFunc
+
+	sid          string
+	connectionID string
+	host         string
+	address      string
+	username     string
+	password     string
+	database     string
+	sslMode      string
+	// the trusted authorities of sslmode=verify-ca and verify-full, the system ones when it's nil
+	rootCAs *x509.CertPool
+	// the server refusing the connection is not sent to the client while trying without ssl (sslmode=allow)
+	sslRetry bool
+
+	clientW    io.Writer
+	clientR    *clientReader
+	serverConn net.Conn
+
+	mu         sync.Mutex
+	doneCh     chan struct{}
+	closeOnce  sync.Once
+	userClosed atomic.Bool
+	clientQuit atomic.Bool
+}
+
+func newPostgresProxy(ctx context.Context, clientW io.Writer, opts map[string]string) (*pgProxy, error) {
+	if opts["hostname"] == "" || opts["username"] == "" {
+		return nil, fmt.Errorf("missing required options: hostname and username")
+	}
+	if opt := setOption(opts, policyOptions); opt != "" {
+		return nil, fmt.Errorf("option %v is not supported by the postgres proxy, "+
+			"remove it from the connection or contact the administrator", opt)
+	}
+	if opt := setOption(opts, dlpOptions); opt != "" {
+		log.With("sid", opts["sid"], "conn", opts["connection_id"]).
+			Warnf("ignoring option %v, data masking is not supported by the postgres proxy", opt)
+	}
+	port := opts["port"]
+	if port == "" {
+		port = "5432"
+	}
+	sslMode := opts["sslmode"]
+	if sslMode == "" {
+		sslMode = "prefer"
+	}
+	switch sslMode {
+	case "disable", "allow", "prefer", "require", "verify-ca", "verify-full":
+	default:
+		return nil, fmt.Errorf("sslmode %q is not supported", sslMode)
+	}
+	ctx, cancelFn := context.WithCancel(ctx)
+	return &pgProxy{
+		ctx:          ctx,
+		cancelFn:     cancelFn,
+		sid:          opts["sid"],
+		connectionID: opts["connection_id"],
+		host:         opts["hostname"],
+		address:      net.JoinHostPort(opts["hostname"], port),
+		username:     opts["username"],
+		password:     opts["password"],
+		database:     opts["database"],
+		sslMode:      sslMode,
+		clientW:      clientW,
+		clientR:      newClientReader(),
+		doneCh:       make(chan struct{}),
+	}, nil
+}
+
+func (p *pgProxy) Run(onErr func(exitCode int, errMsg string)) {
+	go func() {
+		err := p.run()
+		userClosed := p.userClosed.Load()
+		p.close()
+		if userClosed || p.clientQuit.Load() {
+			return
+		}
+		if err != nil {
+			log.With("sid", p.sid, "conn", p.connectionID).Infof("postgres connection closed, reason=%v", err)
+			onErr(1, err.Error())
+			return
+		}
+		onErr(0, "")
+	}()
+}
+
+func (p *pgProxy) run() error {
+	startup, err := p.readStartupMessage()
+	if err != nil {
+		if errors.Is(err, errPGCancelRequest) {
+			return nil
+		}
+		return err
+	}
+
+	p.sslRetry = p.sslMode == "allow"
+	serverConn, err := p.connectServer(p.sslMode)
+	if err != nil {
+		return err
+	}
+	err = p.authenticate(startup)
+	var errResp *pgtypes.ErrorResponse
+	if p.sslRetry && errors.As(err, &errResp) && errResp.Code == string(pgtypes.InvalidAuthorizationSpecification) {
+		log.With("sid", p.sid, "conn", p.connectionID).Infof("server refused the connection without ssl, trying with ssl")
+		_ = serverConn.Close()
+		p.sslRetry = false
+		if serverConn, err = p.connectServer("require"); err != nil {
+			return err
+		}
+		err = p.authenticate(startup)
+	}
+	if err != nil {
+		return err
+	}
+	log.With("sid", p.sid, "conn", p.connectionID).Infof("postgres connection established with %v", p.address)
+
+	// the server sends the parameter status, the backend key data and
+	// the ready for query messages right after the authentication
+	if err := p.writeClient(pgtypes.NewAuthenticationOK()); err != nil {
+		return fmt.Errorf("failed writing authentication ok to client, err=%v", err)
+	}
+	errCh := make(chan error, 2)
+	go func() {
+		_, err := io.Copy(p.clientW, serverConn)
+		errCh <- err
+	}()
+	go func() { errCh <- p.relayClient() }()
+	err = <-errCh
+	if errors.Is(err, errPGClientTerminate) {
+		return nil
+	}
+	return err
+}
+
+// connectServer opens the connection with the server used by the session
+func (p *pgProxy) connectServer(sslMode string) (net.Conn, error) {
+	serverConn, err := p.connect(sslMode)
+	if err != nil {
+		_ = p.writeClient(pgtypes.NewFatalError("failed connecting to postgres server: %v", err))
+		return nil, fmt.Errorf("failed connecting with postgres server, err=%v", err)
+	}
+	p.mu.Lock()
+	p.serverConn = serverConn
+	p.mu.Unlock()
+	if p.userClosed.Load() {
+		_ = serverConn.Close()
+		return nil, net.ErrClosed
+	}
+	return serverConn, nil
+}
+
+// readStartupMessage handles the messages sent by the client before
+// the startup message. SSL is refused because the connection between
+// the client and the agent is already protected by the gateway.
+func (p *pgProxy) readStartupMessage() (*pgtypes.StartupMessage, error) {
+	for {
+		pkt, err := pgtypes.Decode(p.clientR)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading startup message from client, err=%v", err)
+		}
+		switch {
+		case pkt.IsFrontendSSLRequest():
+			if _, err := p.clientW.Write([]byte{pgtypes.ServerSSLRejected}); err != nil {
+				return nil, err
+			}
+			continue
+		case pkt.IsCancelRequest():
+			p.clientQuit.Store(true)
+			p.cancelRequest(pkt)
+			return nil, errPGCancelRequest
+		}
+		startup, err := pgtypes.DecodeStartupMessage(pkt.Frame())
+		if err != nil {
+			_ = p.writeClient(pgtypes.NewFatalError("%v", err))
+			return nil, fmt.Errorf("failed decoding startup message, err=%v", err)
+		}
+		if startup.ProtocolVersion != pgtypes.ProtocolVersion {
+			_ = p.writeClient(pgtypes.NewFatalError("unsupported frontend protocol %v.%v",
+				startup.ProtocolVersion>>16, startup.ProtocolVersion&0xffff))
+			return nil, fmt.Errorf("unsupported protocol version %v", startup.ProtocolVersion)
+		}
+		return startup, nil
+	}
+}
+
+// cancelRequest forwards the cancel request to the server. The client
+// has the backend key data of the server, it's relayed as it is.
+func (p *pgProxy) cancelRequest(pkt *pgtypes.Packet) {
+	conn, err := p.connect(p.sslMode)
+	if err != nil {
+		log.With("sid", p.sid, "conn", p.connectionID).Warnf("failed sending cancel request, reason=%v", err)
+		return
+	}
+	defer conn.Close()
+	if _, err := conn.Write(pkt.Encode()); err != nil {
+		log.With("sid", p.sid, "conn", p.connectionID).Warnf("failed sending cancel request, reason=%v", err)
+		return
+	}
+	// the server closes the connection after processing the request
+	_, _ = io.Copy(io.Discard, conn)
+}
+
+// connect opens a connection with the server negotiating SSL based on the sslmode,
+// sslmode=allow connects without SSL, the caller tries again if the server refuses it.
+func (p *pgProxy) connect(sslMode string) (net.Conn, error) {
+	conn, err := dialServer(p.ctx, p.address)
+	if err != nil {
+		return nil, err
+	}
+	if sslMode == "disable" || sslMode == "allow" {
+		return conn, nil
+	}
+	if _, err := conn.Write(pgtypes.NewSSLRequest().Encode()); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed sending ssl request, reason=%v", err)
+	}
+	var resp [1]byte
+	if _, err := io.ReadFull(conn, resp[:]); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed reading ssl response, reason=%v", err)
+	}
+	switch resp[0] {
+	case pgtypes.ServerSSLAccepted:
+	case pgtypes.ServerSSLRejected:
+		if sslMode == "prefer" {
+			return conn, nil
+		}
+		_ = conn.Close()
+		return nil, fmt.Errorf("server does not support ssl, sslmode=%v", sslMode)
+	default:
+		_ = conn.Close()
+		return nil, fmt.Errorf("unknown ssl response (%X) from server", resp[0])
+	}
+	tlsConfig := &tls.Config{ServerName: p.host, RootCAs: p.rootCAs, InsecureSkipVerify: sslMode != "verify-full"}
+	if sslMode == "verify-ca" {
+		tlsConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+			return verifyCertificateChain(cs, p.rootCAs)
+		}
+	}
+	tlsConn := tls.Client(conn, tlsConfig)
+	if err := tlsConn.HandshakeContext(p.ctx); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed tls handshake, reason=%v", err)
+	}
+	return tlsConn, nil
+}
+
+// verifyCertificateChain verifies that the certificate of the server is signed by a
+// trusted authority, its name is not matched with the host (sslmode=verify-ca).
+func verifyCertificateChain(cs tls.ConnectionState, rootCAs *x509.CertPool) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("server did not send a certificate")
+	}
+	opts := x509.VerifyOptions{Roots: rootCAs, Intermediates: x509.NewCertPool()}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	_, err := cs.PeerCertificates[0].Verify(opts)
+	return err
+}
+
+// authenticate sends the startup message of the client with the user of the
+// connection and performs the authentication requested by the server.
+func (p *pgProxy) authenticate(startup *pgtypes.StartupMessage) error {
+	params := map[string]string{}
+	for key, val := range startup.Parameters {
+		params[key] = val
+	}
+	params["user"] = p.username
+	if params["database"] == "" && p.database != "" {
+		params["database"] = p.database
+	}
+	serverStartup := &pgtypes.StartupMessage{ProtocolVersion: pgtypes.ProtocolVersion, Parameters: params}
+	if _, err := p.serverConn.Write(serverStartup.Encode().Encode()); err != nil {
+		return fmt.Errorf("failed writing startup message to server, err=%v", err)
+	}
+
+	var conv *scram.ClientConversation
+	for {
+		pkt, err := pgtypes.DecodeTyped(p.serverConn)
+		if err != nil {
+			return fmt.Errorf("failed reading auth response from server, err=%v", err)
+		}
+		switch pkt.Type() {
+		case pgtypes.ServerAuth:
+		case pgtypes.ServerErrorResponse:
+			errResp := pgtypes.DecodeErrorResponse(pkt.Frame())
+			if !p.sslRetry || errResp.Code != string(pgtypes.InvalidAuthorizationSpecification) {
+				_ = p.writeClient(pkt)
+			}
+			return errResp
+		case pgtypes.ServerNegotiateProtocolVersion:
+			// the server doesn't recognize some options of the client, it's informative
+			continue
+		default:
+			return fmt.Errorf("unexpected packet (%v) from server during authentication", pkt.Type().Byte())
+		}
+
+		authType, _ := pkt.AuthType()
+		var resp *pgtypes.Packet
+		switch authType {
+		case pgtypes.AuthenticationOk:
+			return nil
+		case pgtypes.AuthenticationCleartextPassword:
+			resp = pgtypes.NewPasswordMessage(append([]byte(p.password), 0x00))
+		case pgtypes.AuthenticationMD5Password:
+			salt := pkt.AuthData()
+			if len(salt) != 4 {
+				return p.authError("invalid md5 salt from server")
+			}
+			passwd := pgtypes.EncryptMD5Password(p.username, p.password, salt)
+			resp = pgtypes.NewPasswordMessage(append([]byte(passwd), 0x00))
+		case pgtypes.AuthenticationSASL:
+			if !containsString(pgtypes.ParseSASLMechanisms(pkt.AuthData()), pgtypes.ScramSha256Mechanism) {
+				return p.authError("server does not support the %v mechanism", pgtypes.ScramSha256Mechanism)
+			}
+			client, err := scram.SHA256.NewClient(p.username, p.password, "")
+			if err != nil {
+				return p.authError("failed creating scram client, reason=%v", err)
+			}
+			conv = client.NewConversation()
+			clientFirst, err := conv.Step("")
+			if err != nil {
+				return p.authError("failed starting scram conversation, reason=%v", err)
+			}
+			resp = pgtypes.NewSASLInitialResponse(pgtypes.ScramSha256Mechanism, []byte(clientFirst))
+		case pgtypes.AuthenticationSASLContinue:
+			if conv == nil {
+				return p.authError("sasl conversation not started")
+			}
+			clientFinal, err := conv.Step(string(pkt.AuthData()))
+			if err != nil {
+				return p.authError("failed on scram conversation, reason=%v", err)
+			}
+			resp = pgtypes.NewPasswordMessage([]byte(clientFinal))
+		case pgtypes.AuthenticationSASLFinal:
+			if conv == nil {
+				return p.authError("sasl conversation not started")
+			}
+			// validates the server signature
+			if _, err := conv.Step(string(pkt.AuthData())); err != nil {
+				return p.authError("failed validating scram server signature, reason=%v", err)
+			}
+			continue
+		default:
+			return p.authError("authentication method (%v) is not supported", authType)
+		}
+		if _, err := p.serverConn.Write(resp.Encode()); err != nil {
+			return fmt.Errorf("failed writing auth data to server, err=%v", err)
+		}
+	}
+}
+
+// authError informs the client about the authentication failure
+func (p *pgProxy) authError(format string, v ...any) error {
+	err := fmt.Errorf(format, v...)
+	_ = p.writeClient(pgtypes.NewFatalError("%v", err))
+	return err
+}
+
+// relayClient forwards the messages of the client to the server
+func (p *pgProxy) relayClient() error {
+	for {
+		pkt, err := pgtypes.DecodeTyped(p.clientR)
+		if err != nil {
+			return err
+		}
+		switch pkt.Type() {
+		case pgtypes.ClientTerminate:
+			p.clientQuit.Store(true)
+			_, _ = p.serverConn.Write(pkt.Encode())
+			return errPGClientTerminate
+		case pgtypes.ClientSimpleQuery, pgtypes.ClientParse:
+			if log.IsDebugLevel {
+				log.With("sid", p.sid, "conn", p.connectionID).Debugf("query=%s",
+					pgtypes.ParseQuery(pkt.Encode()))
+			}
+		}
+		if _, err := p.serverConn.Write(pkt.Encode()); err != nil {
+			return err
+		}
+	}
+}
+
+func (p *pgProxy) writeClient(pkt *pgtypes.Packet) error {
+	_, err := p.clientW.Write(pkt.Encode())
+	return err
+}
+
+// Write writes the data sent by the client
+func (p *pgProxy) Write(data []byte) (int, error) { return p.clientR.Write(data) }
+func (p *pgProxy) OnConsume(fn func(n int))       { p.clientR.OnConsume(fn) }
+func (p *pgProxy) Done() <-chan struct{}          { return p.doneCh }
+func (p *pgProxy) Close() error {
+	p.userClosed.Store(true)
+	p.close()
+	return nil
+}
+
+func (p *pgProxy) close() {
+	p.closeOnce.Do(func() {
+		p.cancelFn()
+		_ = p.clientR.Close()
+		p.mu.Lock()
+		if p.serverConn != nil {
+			_ = p.serverConn.Close()
+		}
+		p.mu.Unlock()
+		close(p.doneCh)
+	})
+}
@@ -0,0 +1,361 @@
+package libbifrost
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"math/big"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bifrost/common/pgtypes"
+	"github.com/stretchr/testify/assert"
+	"github.com/xdg-go/scram"
+)
+
+var pgTestSalt = []byte{0x01, 0x02, 0x03, 0x04}
+
+// newTestCertificate returns a certificate for name signed by the returned authority
+func newTestCertificate(t *testing.T, name string) (tls.Certificate, *x509.CertPool) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	assert.Nil(t, err)
+	ca, err := x509.ParseCertificate(caDER)
+	assert.Nil(t, err)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	assert.Nil(t, err)
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+// serveEach serves each connection opened by the proxy with the next function
+func serveEach(serve ...func(*testing.T, net.Conn)) func(*testing.T, net.Conn) {
+	var conns atomic.Int32
+	return func(t *testing.T, conn net.Conn) {
+		i := int(conns.Add(1)) - 1
+		if !assert.Less(t, i, len(serve), "unexpected connection") {
+			return
+		}
+		serve[i](t, conn)
+	}
+}
+
+// asyncWriteConn doesn't wait for the proxy to read the data written, the proxy
+// sends an alert when it refuses the certificate while the server writes the handshake.
+type asyncWriteConn struct {
+	net.Conn
+	writeCh chan []byte
+}
+
+func newAsyncWriteConn(conn net.Conn) *asyncWriteConn {
+	c := &asyncWriteConn{Conn: conn, writeCh: make(chan []byte, 16)}
+	go func() {
+		for data := range c.writeCh {
+			_, _ = conn.Write(data)
+		}
+	}()
+	return c
+}
+
+func (c *asyncWriteConn) Write(data []byte) (int, error) {
+	c.writeCh <- append([]byte{}, data...)
+	return len(data), nil
+}
+
+// close stops writing, it must be called by the goroutine that writes
+func (c *asyncWriteConn) close() { close(c.writeCh) }
+
+func newPgError(code pgtypes.Code, msg string) *pgtypes.Packet {
+	frame := []byte("SFATAL\x00C" + string(code) + "\x00M" + msg + "\x00\x00")
+	return pgtypes.NewPacket(pgtypes.ServerErrorResponse, frame)
+}
+
+func newPgAuth(authType pgtypes.AuthType, data []byte) *pgtypes.Packet {
+	return pgtypes.NewPacket(pgtypes.ServerAuth, append(binary.BigEndian.AppendUint32(nil, uint32(authType)), data...))
+}
+
+func writePg(t *testing.T, conn net.Conn, pkt *pgtypes.Packet) bool {
+	_, err := conn.Write(pkt.Encode())
+	return assert.Nil(t, err)
+}
+
+// readPgPassword reads the password message sent by the agent
+func readPgPassword(t *testing.T, conn net.Conn) []byte {
+	pkt, err := pgtypes.DecodeTyped(conn)
+	if !assert.Nil(t, err) {
+		return nil
+	}
+	assert.Equal(t, pgtypes.ClientPassword, pkt.Type())
+	return pkt.Frame()
+}
+
+// pgServe returns a server that replies the ssl request with sslResponse (0 when the proxy
+// doesn't request ssl), reads the startup message of the agent and authenticates it.
+func pgServe(sslResponse byte, cert tls.Certificate, auth func(*testing.T, net.Conn) bool) func(*testing.T, net.Conn) {
+	return func(t *testing.T, conn net.Conn) {
+		if sslResponse != 0 {
+			pkt, err := pgtypes.Decode(conn)
+			if !assert.Nil(t, err) || !assert.True(t, pkt.IsFrontendSSLRequest()) {
+				return
+			}
+			if _, err := conn.Write([]byte{sslResponse}); !assert.Nil(t, err) {
+				return
+			}
+			if sslResponse == pgtypes.ServerSSLAccepted {
+				asyncConn := newAsyncWriteConn(conn)
+				defer asyncConn.close()
+				tlsConn := tls.Server(asyncConn, &tls.Config{Certificates: []tls.Certificate{cert}})
+				// the proxy aborts the handshake of a certificate that is not trusted
+				if tlsConn.Handshake() != nil {
+					return
+				}
+				conn = tlsConn
+			}
+		}
+		pkt, err := pgtypes.Decode(conn)
+		if !assert.Nil(t, err) {
+			return
+		}
+		startup, err := pgtypes.DecodeStartupMessage(pkt.Frame())
+		if !assert.Nil(t, err) {
+			return
+		}
+		assert.Equal(t, map[string]string{"user": "bifrost", "database": "testdb", "application_name": "psql"},
+			startup.Parameters)
+		if !auth(t, conn) {
+			return
+		}
+		if !writePg(t, conn, pgtypes.NewAuthenticationOK()) ||
+			!writePg(t, conn, pgtypes.NewPacket(pgtypes.ServerReadyForQuery, []byte{'I'})) {
+			return
+		}
+		// the messages of the client are relayed after the authentication
+		pkt, err = pgtypes.DecodeTyped(conn)
+		if assert.Nil(t, err) {
+			assert.Equal(t, pgtypes.ClientTerminate, pkt.Type())
+		}
+	}
+}
+
+func pgAuthMD5(t *testing.T, conn net.Conn) bool {
+	if !writePg(t, conn, newPgAuth(pgtypes.AuthenticationMD5Password, pgTestSalt)) {
+		return false
+	}
+	want := pgtypes.EncryptMD5Password("bifrost", "secret", pgTestSalt) + "\x00"
+	return assert.Equal(t, want, string(readPgPassword(t, conn)))
+}
+
+func pgAuthSCRAM(t *testing.T, conn net.Conn) bool {
+	client, err := scram.SHA256.NewClient("bifrost", "secret", "")
+	if !assert.Nil(t, err) {
+		return false
+	}
+	conv := newScramServer(t, scram.SHA256, client)
+	mechanisms := []byte(pgtypes.ScramSha256Mechanism + "\x00\x00")
+	if !writePg(t, conn, newPgAuth(pgtypes.AuthenticationSASL, mechanisms)) {
+		return false
+	}
+	// mechanism, length of the data and the client first message
+	frame := readPgPassword(t, conn)
+	if !assert.Greater(t, len(frame), len(pgtypes.ScramSha256Mechanism)+5) {
+		return false
+	}
+	assert.Equal(t, pgtypes.ScramSha256Mechanism+"\x00", string(frame[:len(pgtypes.ScramSha256Mechanism)+1]))
+	serverFirst, err := conv.Step(string(frame[len(pgtypes.ScramSha256Mechanism)+5:]))
+	if !assert.Nil(t, err) || !writePg(t, conn, newPgAuth(pgtypes.AuthenticationSASLContinue, []byte(serverFirst))) {
+		return false
+	}
+	serverFinal, err := conv.Step(string(readPgPassword(t, conn)))
+	if !assert.Nil(t, err) {
+		return false
+	}
+	return writePg(t, conn, newPgAuth(pgtypes.AuthenticationSASLFinal, []byte(serverFinal)))
+}
+
+// pgRefuse rejects the connection without ssl as a pg_hba.conf with hostssl entries
+func pgRefuse(t *testing.T, conn net.Conn) bool {
+	writePg(t, conn, newPgError(pgtypes.InvalidAuthorizationSpecification, "no pg_hba.conf entry for host, no encryption"))
+	return false
+}
+
+func newTestPostgresProxy(t *testing.T, sslMode string, rootCAs *x509.CertPool) (*pgProxy, *testClient) {
+	client := newTestClient(t)
+	p, err := newPostgresProxy(context.Background(), client.proxyConn, map[string]string{
+		"hostname": "pg.local", "username": "bifrost", "password": "secret", "database": "testdb", "sslmode": sslMode})
+	assert.Nil(t, err)
+	p.rootCAs = rootCAs
+	return p, client
+}
+
+// startPgClient sends the ssl request and the startup message of psql
+func startPgClient(t *testing.T, p *pgProxy, client *testClient) {
+	_, err := p.Write(pgtypes.NewSSLRequest().Encode())
+	assert.Nil(t, err)
+	var sslResponse [1]byte
+	_, err = client.Read(sslResponse[:])
+	assert.Nil(t, err)
+	assert.Equal(t, pgtypes.ServerSSLRejected, sslResponse[0])
+	startup := &pgtypes.StartupMessage{
+		ProtocolVersion: pgtypes.ProtocolVersion,
+		Parameters:      map[string]string{"user": "postgres", "application_name": "psql"},
+	}
+	_, err = p.Write(startup.Encode().Encode())
+	assert.Nil(t, err)
+}
+
+func TestPostgresHandshake(t *testing.T) {
+	cert, rootCAs := newTestCertificate(t, "pg.local")
+	otherCert, otherRootCAs := newTestCertificate(t, "other.local")
+
+	for _, tt := range []struct {
+		msg     string
+		sslMode string
+		rootCAs *x509.CertPool
+		serve   func(*testing.T, net.Conn)
+		wantErr string
+	}{
+		{
+			msg:     "it must authenticate with md5 without ssl",
+			sslMode: "disable",
+			serve:   pgServe(0, cert, pgAuthMD5),
+		},
+		{
+			msg:     "it must authenticate with scram-sha-256 over ssl",
+			sslMode: "require",
+			serve:   pgServe(pgtypes.ServerSSLAccepted, cert, pgAuthSCRAM),
+		},
+		{
+			msg:     "it must connect without ssl when the server does not support it with sslmode prefer",
+			sslMode: "prefer",
+			serve:   pgServe(pgtypes.ServerSSLRejected, cert, pgAuthMD5),
+		},
+		{
+			msg:     "it must try again with ssl when the server refuses the connection with sslmode allow",
+			sslMode: "allow",
+			serve:   serveEach(pgServe(0, cert, pgRefuse), pgServe(pgtypes.ServerSSLAccepted, cert, pgAuthMD5)),
+		},
+		{
+			msg:     "it must verify the authority but not the name of the certificate with sslmode verify-ca",
+			sslMode: "verify-ca",
+			rootCAs: otherRootCAs,
+			serve:   pgServe(pgtypes.ServerSSLAccepted, otherCert, pgAuthSCRAM),
+		},
+		{
+			msg:     "it must verify the name of the certificate with sslmode verify-full",
+			sslMode: "verify-full",
+			rootCAs: rootCAs,
+			serve:   pgServe(pgtypes.ServerSSLAccepted, cert, pgAuthMD5),
+		},
+		{
+			msg:     "it must fail when the certificate is not from a trusted authority with sslmode verify-ca",
+			sslMode: "verify-ca",
+			rootCAs: rootCAs,
+			serve:   pgServe(pgtypes.ServerSSLAccepted, otherCert, pgAuthMD5),
+			wantErr: "failed connecting with postgres server, err=failed tls handshake, " +
+				"reason=x509: certificate signed by unknown authority",
+		},
+		{
+			msg:     "it must fail when the server does not support ssl with sslmode require",
+			sslMode: "require",
+			serve: func(t *testing.T, conn net.Conn) {
+				pkt, err := pgtypes.Decode(conn)
+				if assert.Nil(t, err) && assert.True(t, pkt.IsFrontendSSLRequest()) {
+					_, _ = conn.Write([]byte{pgtypes.ServerSSLRejected})
+				}
+			},
+			wantErr: "failed connecting with postgres server, err=server does not support ssl, sslmode=require",
+		},
+		{
+			msg:     "it must send the error of the server when the authentication is rejected",
+			sslMode: "disable",
+			serve: pgServe(0, cert, func(t *testing.T, conn net.Conn) bool {
+				pgAuthMD5(t, conn)
+				writePg(t, conn, newPgError(pgtypes.InvalidPassword, `password authentication failed for user "bifrost"`))
+				return false
+			}),
+			wantErr: `FATAL: password authentication failed for user "bifrost" (SQLSTATE 28P01)`,
+		},
+	} {
+		t.Run(tt.msg, func(t *testing.T) {
+			fakeServer(t, tt.serve)
+			p, client := newTestPostgresProxy(t, tt.sslMode, tt.rootCAs)
+			resultCh := runProxy(t, p)
+			startPgClient(t, p, client)
+
+			pkt, err := pgtypes.DecodeTyped(client)
+			if !assert.Nil(t, err) {
+				return
+			}
+			if tt.wantErr != "" {
+				assert.Equal(t, pgtypes.ServerErrorResponse, pkt.Type())
+				result := waitResult(t, p, resultCh)
+				if assert.NotNil(t, result) {
+					assert.Equal(t, 1, result.exitCode)
+					assert.Contains(t, result.errMsg, tt.wantErr)
+				}
+				return
+			}
+			assert.Equal(t, pgtypes.NewAuthenticationOK().Encode(), pkt.Encode())
+			pkt, err = pgtypes.DecodeTyped(client)
+			assert.Nil(t, err)
+			assert.Equal(t, pgtypes.ServerReadyForQuery, pkt.Type())
+			_, err = p.Write(pgtypes.NewPacket(pgtypes.ClientTerminate, nil).Encode())
+			assert.Nil(t, err)
+			// the client terminating is not reported
+			assert.Nil(t, waitResult(t, p, resultCh))
+		})
+	}
+}
+
+func TestPostgresCancelRequest(t *testing.T) {
+	cancelRequest := binary.BigEndian.AppendUint32(nil, 16)
+	cancelRequest = binary.BigEndian.AppendUint32(cancelRequest, pgtypes.ClientCancelRequestMessage)
+	cancelRequest = binary.BigEndian.AppendUint32(cancelRequest, 1234)
+	cancelRequest = binary.BigEndian.AppendUint32(cancelRequest, 5678)
+	received := make(chan []byte, 1)
+	fakeServer(t, func(t *testing.T, conn net.Conn) {
+		pkt, err := pgtypes.Decode(conn)
+		if assert.Nil(t, err) {
+			received <- pkt.Encode()
+		}
+	})
+	p, _ := newTestPostgresProxy(t, "disable", nil)
+	resultCh := runProxy(t, p)
+	_, err := p.Write(cancelRequest)
+	assert.Nil(t, err)
+
+	// the cancel request is relayed as it is and the proxy ends without reporting it
+	assert.Nil(t, waitResult(t, p, resultCh))
+	select {
+	case got := <-received:
+		assert.Equal(t, cancelRequest, got)
+	case <-time.After(testTimeout):
+		t.Fatal("timeout waiting for the cancel request")
+	}
+}
@@ -1,16 +1,19 @@
 package sshtypes
 
 import (
+	"encoding/binary"
 	"fmt"
 	"io"
 )
 
+// OpenChannel requests to open a channel (session, direct-tcpip) in the server
 type OpenChannel struct {
 	ChannelID        uint16
 	ChannelType      string
 	ChannelExtraData []byte
 }
 
+// SSHRequest is a channel request (pty-req, shell, exec, exit-status, etc)
 type SSHRequest struct {
 	ChannelID   uint16
 	RequestType string
@@ -18,34 +21,85 @@ type SSHRequest struct {
 	Payload     []byte
 }
 
+// Data is the data of a channel
 type Data struct {
 	ChannelID uint16
 	Payload   []byte
 }
 
+// ExtendedData is the extended data of a channel, e.g.: the stderr of a session
+type ExtendedData struct {
+	ChannelID    uint16
+	DataTypeCode uint32
+	Payload      []byte
+}
+
+// SSHRequestReply is the reply of a channel request sent with WantReply, the replies
+// of a channel are sent in the order of its requests.
+type SSHRequestReply struct {
+	ChannelID uint16
+	OK        bool
+	Payload   []byte
+}
+
+// CloseChannel informs that a channel was closed
 type CloseChannel struct {
 	ID   uint16
 	Type string
 }
 
+// ExtendedDataStderr is the data type code of the stderr of a session (RFC 4254, 5.2)
+const ExtendedDataStderr uint32 = 1
+
 type Encoder interface {
 	Encode() []byte
 }
 
+// The messages are encoded as: type (1) + channel id (2) + fields.
+// Variable length fields are prefixed with their length (4), except the
+// last field of the message which is the remaining of the packet.
+
 func (o OpenChannel) Encode() []byte {
-	return []byte(``)
+	data := newHeader(OpenChannelType, o.ChannelID)
+	data = appendString(data, o.ChannelType)
+	return append(data, o.ChannelExtraData...)
 }
 
 func (o SSHRequest) Encode() []byte {
-	return []byte(``)
+	data := newHeader(SSHRequestType, o.ChannelID)
+	wantReply := byte(0x00)
+	if o.WantReply {
+		wantReply = 0x01
+	}
+	data = append(data, wantReply)
+	data = appendString(data, o.RequestType)
+	return append(data, o.Payload...)
 }
 
 func (o Data) Encode() []byte {
-	return []byte(``)
+	data := newHeader(DataType, o.ChannelID)
+	return append(data, o.Payload...)
+}
+
+func (o ExtendedData) Encode() []byte {
+	data := newHeader(ExtendedDataType, o.ChannelID)
+	data = binary.BigEndian.AppendUint32(data, o.DataTypeCode)
+	return append(data, o.Payload...)
+}
+
+func (o SSHRequestReply) Encode() []byte {
+	data := newHeader(SSHRequestReplyType, o.ChannelID)
+	ok := byte(0x00)
+	if o.OK {
+		ok = 0x01
+	}
+	data = append(data, ok)
+	return append(data, o.Payload...)
 }
 
 func (o CloseChannel) Encode() []byte {
-	return []byte(``)
+	data := newHeader(CloseChannelType, o.ID)
+	return append(data, o.Type...)
 }
 
 type PacketType byte
@@ -55,24 +109,158 @@ const (
 	SSHRequestType
 	DataType
 	CloseChannelType
+	ExtendedDataType
+	SSHRequestReplyType
 )
 
 func (p PacketType) Byte() byte { return byte(p) }
 
+func (p PacketType) String() string {
+	switch p {
+	case OpenChannelType:
+		return "OpenChannel"
+	case SSHRequestType:
+		return "SSHRequest"
+	case DataType:
+		return "Data"
+	case CloseChannelType:
+		return "CloseChannel"
+	case ExtendedDataType:
+		return "ExtendedData"
+	case SSHRequestReplyType:
+		return "SSHRequestReply"
+	}
+	return "unknown"
+}
+
+// DecodeType returns the type of the message, it returns 0x00 for empty messages
 func DecodeType(data []byte) PacketType {
-	return 0x00
+	if len(data) == 0 {
+		return 0x00
+	}
+	return PacketType(data[0])
 }
 
+// Decode decodes the message into a pointer of OpenChannel, SSHRequest, Data, ExtendedData,
+// SSHRequestReply or CloseChannel
 func Decode(data []byte, into any) error {
-	return fmt.Errorf("libbifrost: not implemented")
+	if len(data) < 3 {
+		return fmt.Errorf("message is too short (%v)", len(data))
+	}
+	typ := DecodeType(data)
+	channelID := binary.BigEndian.Uint16(data[1:3])
+	data = data[3:]
+	var err error
+	switch v := into.(type) {
+	case *OpenChannel:
+		if typ != OpenChannelType {
+			return fmt.Errorf("unable to decode %v into OpenChannel", typ)
+		}
+		v.ChannelID = channelID
+		v.ChannelType, data, err = readString(data)
+		v.ChannelExtraData = data
+	case *SSHRequest:
+		if typ != SSHRequestType {
+			return fmt.Errorf("unable to decode %v into SSHRequest", typ)
+		}
+		if len(data) < 1 {
+			return fmt.Errorf("ssh request is too short")
+		}
+		v.ChannelID = channelID
+		v.WantReply = data[0] == 0x01
+		v.RequestType, data, err = readString(data[1:])
+		v.Payload = data
+	case *Data:
+		if typ != DataType {
+			return fmt.Errorf("unable to decode %v into Data", typ)
+		}
+		v.ChannelID = channelID
+		v.Payload = data
+	case *ExtendedData:
+		if typ != ExtendedDataType {
+			return fmt.Errorf("unable to decode %v into ExtendedData", typ)
+		}
+		if len(data) < 4 {
+			return fmt.Errorf("extended data is too short")
+		}
+		v.ChannelID = channelID
+		v.DataTypeCode = binary.BigEndian.Uint32(data[:4])
+		v.Payload = data[4:]
+	case *SSHRequestReply:
+		if typ != SSHRequestReplyType {
+			return fmt.Errorf("unable to decode %v into SSHRequestReply", typ)
+		}
+		if len(data) < 1 {
+			return fmt.Errorf("ssh request reply is too short")
+		}
+		v.ChannelID = channelID
+		v.OK = data[0] == 0x01
+		v.Payload = data[1:]
+	case *CloseChannel:
+		if typ != CloseChannelType {
+			return fmt.Errorf("unable to decode %v into CloseChannel", typ)
+		}
+		v.ID = channelID
+		v.Type = string(data)
+	default:
+		return fmt.Errorf("unknown type %T to decode", into)
+	}
+	return err
 }
 
+// NewDataWriter returns a writer that encodes the data as Data messages of the channel
 func NewDataWriter(w io.Writer, channelID uint16) io.Writer {
-	return &DataWriter{}
+	return &DataWriter{w: w, channelID: channelID}
 }
 
-type DataWriter struct{}
+type DataWriter struct {
+	w         io.Writer
+	channelID uint16
+}
 
 func (w *DataWriter) Write(b []byte) (n int, err error) {
-	return 0, fmt.Errorf("libbifrost: not implemented")
+	if _, err := w.w.Write(Data{ChannelID: w.channelID, Payload: b}.Encode()); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// NewExtendedDataWriter returns a writer that encodes the data as ExtendedData messages
+// of the channel, e.g.: the stderr of a session with ExtendedDataStderr
+func NewExtendedDataWriter(w io.Writer, channelID uint16, dataTypeCode uint32) io.Writer {
+	return &ExtendedDataWriter{w: w, channelID: channelID, dataTypeCode: dataTypeCode}
+}
+
+type ExtendedDataWriter struct {
+	w            io.Writer
+	channelID    uint16
+	dataTypeCode uint32
+}
+
+func (w *ExtendedDataWriter) Write(b []byte) (n int, err error) {
+	msg := ExtendedData{ChannelID: w.channelID, DataTypeCode: w.dataTypeCode, Payload: b}
+	if _, err := w.w.Write(msg.Encode()); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func newHeader(typ PacketType, channelID uint16) []byte {
+	return binary.BigEndian.AppendUint16([]byte{typ.Byte()}, channelID)
+}
+
+func appendString(data []byte, s string) []byte {
+	data = binary.BigEndian.AppendUint32(data, uint32(len(s)))
+	return append(data, s...)
+}
+
+func readString(data []byte) (string, []byte, error) {
+	if len(data) < 4 {
+		return "", nil, fmt.Errorf("unable to read string length")
+	}
+	size := binary.BigEndian.Uint32(data[:4])
+	if uint32(len(data)-4) < size {
+		return "", nil, fmt.Errorf("string length (%v) is greater than the message", size)
+	}
+	return string(data[4 : 4+size]), data[4+size:], nil
 }
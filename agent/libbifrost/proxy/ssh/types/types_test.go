@@ -0,0 +1,96 @@
+package sshtypes
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	for _, tt := range []struct {
+		msg     string
+		encoder Encoder
+		into    any
+		typ     PacketType
+	}{
+		{
+			msg:     "it must decode open channel messages",
+			encoder: OpenChannel{ChannelID: 1, ChannelType: "session", ChannelExtraData: []byte{0x00, 0x01}},
+			into:    &OpenChannel{},
+			typ:     OpenChannelType,
+		},
+		{
+			msg:     "it must decode ssh request messages",
+			encoder: SSHRequest{ChannelID: 2, RequestType: "pty-req", WantReply: true, Payload: []byte("xterm")},
+			into:    &SSHRequest{},
+			typ:     SSHRequestType,
+		},
+		{
+			msg:     "it must decode ssh request reply messages",
+			encoder: SSHRequestReply{ChannelID: 3, OK: true, Payload: []byte{}},
+			into:    &SSHRequestReply{},
+			typ:     SSHRequestReplyType,
+		},
+		{
+			msg:     "it must decode data messages",
+			encoder: Data{ChannelID: 65535, Payload: []byte("ls -l\n")},
+			into:    &Data{},
+			typ:     DataType,
+		},
+		{
+			msg:     "it must decode extended data messages",
+			encoder: ExtendedData{ChannelID: 4, DataTypeCode: ExtendedDataStderr, Payload: []byte("command not found")},
+			into:    &ExtendedData{},
+			typ:     ExtendedDataType,
+		},
+		{
+			msg:     "it must decode close channel messages",
+			encoder: CloseChannel{ID: 5, Type: "direct-tcpip"},
+			into:    &CloseChannel{},
+			typ:     CloseChannelType,
+		},
+	} {
+		t.Run(tt.msg, func(t *testing.T) {
+			data := tt.encoder.Encode()
+			assert.Equal(t, tt.typ, DecodeType(data))
+			assert.Nil(t, Decode(data, tt.into))
+			assert.Equal(t, tt.encoder, reflect.ValueOf(tt.into).Elem().Interface())
+		})
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	for _, tt := range []struct {
+		msg     string
+		data    []byte
+		into    any
+		wantErr string
+	}{
+		{msg: "it must fail with short messages", data: []byte{0x03, 0x00}, into: &Data{}, wantErr: "message is too short (2)"},
+		{msg: "it must fail with a different type", data: Data{ChannelID: 1}.Encode(), into: &CloseChannel{}, wantErr: "unable to decode Data into CloseChannel"},
+		{msg: "it must fail when the string is greater than the message", data: []byte{0x01, 0x00, 0x01, 0x00, 0x00, 0x00, 0x10, 'a'}, into: &OpenChannel{}, wantErr: "string length (16) is greater than the message"},
+		{msg: "it must fail with extended data without the type code", data: []byte{0x05, 0x00, 0x01, 0x00}, into: &ExtendedData{}, wantErr: "extended data is too short"},
+		{msg: "it must fail with unknown types", data: Data{ChannelID: 1}.Encode(), into: &struct{}{}, wantErr: "unknown type *struct {} to decode"},
+	} {
+		t.Run(tt.msg, func(t *testing.T) {
+			assert.EqualError(t, Decode(tt.data, tt.into), tt.wantErr)
+		})
+	}
+}
+
+func TestDataWriters(t *testing.T) {
+	var buf bytes.Buffer
+	n, err := NewExtendedDataWriter(&buf, 7, ExtendedDataStderr).Write([]byte("error"))
+	assert.Nil(t, err)
+	assert.Equal(t, 5, n)
+	var msg ExtendedData
+	assert.Nil(t, Decode(buf.Bytes(), &msg))
+	assert.Equal(t, ExtendedData{ChannelID: 7, DataTypeCode: ExtendedDataStderr, Payload: []byte("error")}, msg)
+
+	buf.Reset()
+	_, err = NewDataWriter(&buf, 7).Write([]byte("output"))
+	assert.Nil(t, err)
+	assert.Equal(t, Data{ChannelID: 7, Payload: []byte("output")}.Encode(), buf.Bytes())
+}
@@ -0,0 +1,90 @@
+package libbifrost
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testTimeout limits the time the tests wait for the proxies
+const testTimeout = 5 * time.Second
+
+// fakeServer replaces the connections of the proxies with the servers by pipes,
+// serve runs with the server side of each connection opened by the proxy.
+func fakeServer(t *testing.T, serve func(t *testing.T, conn net.Conn)) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var conns []net.Conn
+	dial := dialServer
+	t.Cleanup(func() {
+		dialServer = dial
+		mu.Lock()
+		for _, conn := range conns {
+			_ = conn.Close()
+		}
+		mu.Unlock()
+		wg.Wait()
+	})
+	dialServer = func(ctx context.Context, address string) (net.Conn, error) {
+		proxyConn, serverConn := net.Pipe()
+		_ = serverConn.SetDeadline(time.Now().Add(testTimeout))
+		mu.Lock()
+		conns = append(conns, serverConn)
+		mu.Unlock()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer serverConn.Close()
+			serve(t, serverConn)
+		}()
+		return proxyConn, nil
+	}
+}
+
+// testClient reads what a proxy writes to the client, the proxy writes to proxyConn
+type testClient struct {
+	net.Conn
+	proxyConn net.Conn
+}
+
+func newTestClient(t *testing.T) *testClient {
+	conn, proxyConn := net.Pipe()
+	_ = conn.SetDeadline(time.Now().Add(testTimeout))
+	t.Cleanup(func() {
+		_ = conn.Close()
+		_ = proxyConn.Close()
+	})
+	return &testClient{Conn: conn, proxyConn: proxyConn}
+}
+
+type proxyResult struct {
+	exitCode int
+	errMsg   string
+}
+
+// runProxy runs the proxy until the test ends, the result is sent when
+// the proxy reports the end of the connection.
+func runProxy(t *testing.T, p Proxy) <-chan proxyResult {
+	resultCh := make(chan proxyResult, 1)
+	p.Run(func(exitCode int, errMsg string) { resultCh <- proxyResult{exitCode, errMsg} })
+	t.Cleanup(func() { _ = p.Close() })
+	return resultCh
+}
+
+// waitResult waits for the result of a proxy, a proxy closed without
+// reporting the end of the connection returns nil.
+func waitResult(t *testing.T, p Proxy, resultCh <-chan proxyResult) *proxyResult {
+	select {
+	case <-p.Done():
+	case <-time.After(testTimeout):
+		t.Fatal("timeout waiting for the proxy to finish")
+	}
+	select {
+	case result := <-resultCh:
+		return &result
+	case <-time.After(50 * time.Millisecond):
+		return nil
+	}
+}
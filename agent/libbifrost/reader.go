@@ -0,0 +1,78 @@
+package libbifrost
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// clientReaderBufferSize is how many packets are buffered before blocking the writer
+const clientReaderBufferSize = 1024
+
+// clientReader buffers the packets written by the client allowing the agent
+// to keep processing packets while the proxy connects to the server.
+type clientReader struct {
+	dataCh    chan []byte
+	buf       []byte
+	doneCh    chan struct{}
+	closeOnce sync.Once
+	consumeNotifier
+}
+
+func newClientReader() *clientReader {
+	return &clientReader{
+		dataCh: make(chan []byte, clientReaderBufferSize),
+		doneCh: make(chan struct{}),
+	}
+}
+
+func (r *clientReader) Write(data []byte) (int, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+	select {
+	case <-r.doneCh:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+	b := make([]byte, len(data))
+	_ = copy(b, data)
+	select {
+	case r.dataCh <- b:
+		return len(data), nil
+	case <-r.doneCh:
+		return 0, io.ErrClosedPipe
+	}
+}
+
+func (r *clientReader) Read(p []byte) (int, error) {
+	if len(r.buf) == 0 {
+		select {
+		case r.buf = <-r.dataCh:
+		case <-r.doneCh:
+			return 0, io.EOF
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	r.consumed(n)
+	return n, nil
+}
+
+func (r *clientReader) Close() error {
+	r.closeOnce.Do(func() { close(r.doneCh) })
+	return nil
+}
+
+// consumeNotifier calls the function set with OnConsume, it may be set while the proxy is reading
+type consumeNotifier struct {
+	fn atomic.Pointer[func(n int)]
+}
+
+func (c *consumeNotifier) OnConsume(fn func(n int)) { c.fn.Store(&fn) }
+
+func (c *consumeNotifier) consumed(n int) {
+	if fn := c.fn.Load(); fn != nil && n > 0 {
+		(*fn)(n)
+	}
+}
@@ -0,0 +1,403 @@
+package libbifrost
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/bifrost/common/log"
+	sshtypes "github.com/bifrost/poc/libbifrost/proxy/ssh/types"
+	"golang.org/x/crypto/ssh"
+)
+
+// sshProxy terminates the connection with the SSH server and multiplexes
+// the channels opened by the client. Each packet written by the client is
+// a message of sshtypes (OpenChannel, SSHRequest, SSHRequestReply, Data,
+// ExtendedData or CloseChannel).
+type sshProxy struct {
+	ctx      context.Context
+	cancelFn context.CancelFunc
+
+	sid          string
+	connectionID string
+	address      string
+	config       *ssh.ClientConfig
+
+	clientW  io.Writer
+	clientCh chan []byte
+	client   *ssh.Client
+
+	mu         sync.Mutex
+	channels   map[uint16]*sshChannel
+	doneCh     chan struct{}
+	closeOnce  sync.Once
+	userClosed atomic.Bool
+	// the messages are consumed when they're written to the server
+	consume consumeNotifier
+}
+
+// sshChannel is a channel opened in the server, the requests of the server that
+// want a reply wait for the replies of the client in order.
+type sshChannel struct {
+	ssh.Channel
+	pendingReplies []*ssh.Request
+}
+
+func NewSSHProxy(ctx context.Context, clientW io.Writer, opts map[string]string) (Proxy, error) {
+	return newSSHProxy(ctx, clientW, opts)
+}
+
+func newSSHProxy(ctx context.Context, clientW io.Writer, opts map[string]string) (*sshProxy, error) {
+	if opts["hostname"] == "" || opts["username"] == "" {
+		return nil, fmt.Errorf("missing required options: hostname and username")
+	}
+	var authMethods []ssh.AuthMethod
+	if keys := opts["authorized_server_keys"]; keys != "" {
+		signer, err := ssh.ParsePrivateKey([]byte(keys))
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing authorized server keys, reason=%v", err)
+		}
+		authMethods = append(authMethods, ssh.PublicKeys(signer))
+	}
+	if opts["password"] != "" {
+		authMethods = append(authMethods, ssh.Password(opts["password"]))
+	}
+	if len(authMethods) == 0 {
+		return nil, fmt.Errorf("missing required options: password or authorized_server_keys")
+	}
+	hostKeyCallback, hostKeyAlgorithms, err := sshHostKeyCallback(opts)
+	if err != nil {
+		return nil, err
+	}
+	port := opts["port"]
+	if port == "" {
+		port = "22"
+	}
+	ctx, cancelFn := context.WithCancel(ctx)
+	return &sshProxy{
+		ctx:          ctx,
+		cancelFn:     cancelFn,
+		sid:          opts["sid"],
+		connectionID: opts["connection_id"],
+		address:      net.JoinHostPort(opts["hostname"], port),
+		config: &ssh.ClientConfig{
+			User:              opts["username"],
+			Auth:              authMethods,
+			HostKeyCallback:   hostKeyCallback,
+			HostKeyAlgorithms: hostKeyAlgorithms,
+			Timeout:           defaultDialTimeout,
+		},
+		clientW:  clientW,
+		clientCh: make(chan []byte, clientReaderBufferSize),
+		channels: map[uint16]*sshChannel{},
+		doneCh:   make(chan struct{}),
+	}, nil
+}
+
+// sshHostKeyCallback verifies the key of the server with the host_key option, the public
+// keys in the authorized_keys or known_hosts format (one per line). The verification is
+// only skipped when the connection is insecure and has no host key.
+func sshHostKeyCallback(opts map[string]string) (ssh.HostKeyCallback, []string, error) {
+	if opts["host_key"] == "" {
+		if opts["insecure"] != "true" {
+			return nil, nil, fmt.Errorf("missing required options: host_key or insecure")
+		}
+		log.With("sid", opts["sid"], "conn", opts["connection_id"]).
+			Warnf("the host key of the ssh server %v is not verified", opts["hostname"])
+		return ssh.InsecureIgnoreHostKey(), nil, nil
+	}
+	var keys []ssh.PublicKey
+	var algorithms []string
+	// the hosts of known_hosts lines are parsed as the options of authorized keys
+	rest := []byte(opts["host_key"])
+	for {
+		key, _, _, next, err := ssh.ParseAuthorizedKey(rest)
+		if err != nil {
+			break
+		}
+		keys = append(keys, key)
+		if key.Type() == ssh.KeyAlgoRSA {
+			// rsa keys are negotiated with the sha2 signature algorithms
+			algorithms = append(algorithms, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256)
+		}
+		algorithms = append(algorithms, key.Type())
+		rest = next
+	}
+	if len(keys) == 0 {
+		return nil, nil, fmt.Errorf("failed parsing host key, it must be a public key in the authorized_keys or known_hosts format")
+	}
+	callback := func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		for _, k := range keys {
+			if bytes.Equal(k.Marshal(), key.Marshal()) {
+				return nil
+			}
+		}
+		return fmt.Errorf("host key mismatch, the server presented the %v key %v",
+			key.Type(), ssh.FingerprintSHA256(key))
+	}
+	return callback, algorithms, nil
+}
+
+func (p *sshProxy) Run(onErr func(exitCode int, errMsg string)) {
+	go func() {
+		err := p.run()
+		userClosed := p.userClosed.Load()
+		p.close()
+		if userClosed {
+			return
+		}
+		if err != nil {
+			log.With("sid", p.sid, "conn", p.connectionID).Infof("ssh connection closed, reason=%v", err)
+			onErr(1, err.Error())
+			return
+		}
+		onErr(0, "")
+	}()
+}
+
+func (p *sshProxy) run() error {
+	conn, err := dialServer(p.ctx, p.address)
+	if err != nil {
+		return fmt.Errorf("failed connecting with ssh server, err=%v", err)
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, p.address, p.config)
+	if err != nil {
+		_ = conn.Close()
+		return fmt.Errorf("failed authenticating with ssh server, err=%v", err)
+	}
+	client := ssh.NewClient(sshConn, chans, reqs)
+	p.mu.Lock()
+	p.client = client
+	p.mu.Unlock()
+	if p.userClosed.Load() {
+		_ = client.Close()
+		return net.ErrClosed
+	}
+	log.With("sid", p.sid, "conn", p.connectionID).Infof("ssh connection established with %v", p.address)
+
+	errCh := make(chan error, 2)
+	go func() { errCh <- client.Wait() }()
+	go func() { errCh <- p.relayClient() }()
+	err = <-errCh
+	if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+		return nil
+	}
+	return err
+}
+
+// relayClient processes the messages of the client in order
+func (p *sshProxy) relayClient() error {
+	for {
+		var data []byte
+		select {
+		case data = <-p.clientCh:
+		case <-p.doneCh:
+			return net.ErrClosed
+		}
+		if err := p.processMessage(data); err != nil {
+			return err
+		}
+		p.consume.consumed(len(data))
+	}
+}
+
+func (p *sshProxy) processMessage(data []byte) error {
+	switch sshtypes.DecodeType(data) {
+	case sshtypes.OpenChannelType:
+		var msg sshtypes.OpenChannel
+		if err := sshtypes.Decode(data, &msg); err != nil {
+			return fmt.Errorf("failed decoding open channel message, err=%v", err)
+		}
+		p.openChannel(msg)
+	case sshtypes.SSHRequestType:
+		var msg sshtypes.SSHRequest
+		if err := sshtypes.Decode(data, &msg); err != nil {
+			return fmt.Errorf("failed decoding ssh request message, err=%v", err)
+		}
+		ch := p.getChannel(msg.ChannelID)
+		if ch == nil {
+			log.With("sid", p.sid, "conn", p.connectionID).Warnf("channel %v not found for request %v",
+				msg.ChannelID, msg.RequestType)
+			return nil
+		}
+		ok, err := ch.SendRequest(msg.RequestType, msg.WantReply, msg.Payload)
+		if err != nil || (msg.WantReply && !ok) {
+			log.With("sid", p.sid, "conn", p.connectionID).Infof("ssh request %v failed on channel %v, ok=%v, err=%v",
+				msg.RequestType, msg.ChannelID, ok, err)
+		}
+		if msg.WantReply {
+			_, _ = p.clientW.Write(sshtypes.SSHRequestReply{ChannelID: msg.ChannelID, OK: ok}.Encode())
+		}
+	case sshtypes.SSHRequestReplyType:
+		var msg sshtypes.SSHRequestReply
+		if err := sshtypes.Decode(data, &msg); err != nil {
+			return fmt.Errorf("failed decoding ssh request reply message, err=%v", err)
+		}
+		if req := p.popPendingReply(msg.ChannelID); req != nil {
+			_ = req.Reply(msg.OK, msg.Payload)
+		}
+	case sshtypes.DataType:
+		var msg sshtypes.Data
+		if err := sshtypes.Decode(data, &msg); err != nil {
+			return fmt.Errorf("failed decoding data message, err=%v", err)
+		}
+		if ch := p.getChannel(msg.ChannelID); ch != nil {
+			if _, err := ch.Write(msg.Payload); err != nil {
+				log.With("sid", p.sid, "conn", p.connectionID).Infof("failed writing to channel %v, err=%v",
+					msg.ChannelID, err)
+			}
+		}
+	case sshtypes.ExtendedDataType:
+		var msg sshtypes.ExtendedData
+		if err := sshtypes.Decode(data, &msg); err != nil {
+			return fmt.Errorf("failed decoding extended data message, err=%v", err)
+		}
+		// stderr is the only extended data of the ssh connection protocol
+		if ch := p.getChannel(msg.ChannelID); ch != nil && msg.DataTypeCode == sshtypes.ExtendedDataStderr {
+			if _, err := ch.Stderr().Write(msg.Payload); err != nil {
+				log.With("sid", p.sid, "conn", p.connectionID).Infof("failed writing stderr to channel %v, err=%v",
+					msg.ChannelID, err)
+			}
+		}
+	case sshtypes.CloseChannelType:
+		var msg sshtypes.CloseChannel
+		if err := sshtypes.Decode(data, &msg); err != nil {
+			return fmt.Errorf("failed decoding close channel message, err=%v", err)
+		}
+		if ch := p.getChannel(msg.ID); ch != nil {
+			_ = ch.Close()
+		}
+	default:
+		return fmt.Errorf("unknown ssh message type (%X)", data[0])
+	}
+	return nil
+}
+
+// openChannel opens a channel (session, direct-tcpip) in the server and relays its
+// data and requests to the client. A CloseChannel is sent when the channel is closed.
+func (p *sshProxy) openChannel(msg sshtypes.OpenChannel) {
+	sshCh, reqs, err := p.client.OpenChannel(msg.ChannelType, msg.ChannelExtraData)
+	if err != nil {
+		log.With("sid", p.sid, "conn", p.connectionID).Infof("failed opening channel %v (%v), err=%v",
+			msg.ChannelID, msg.ChannelType, err)
+		_, _ = p.clientW.Write(sshtypes.CloseChannel{ID: msg.ChannelID, Type: msg.ChannelType}.Encode())
+		return
+	}
+	ch := &sshChannel{Channel: sshCh}
+	p.mu.Lock()
+	p.channels[msg.ChannelID] = ch
+	p.mu.Unlock()
+	log.With("sid", p.sid, "conn", p.connectionID).Infof("opened channel %v (%v)", msg.ChannelID, msg.ChannelType)
+
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		// the requests channel is closed when the server closes the channel
+		for req := range reqs {
+			// the reply is queued before the request is sent, the client may reply right away
+			if req.WantReply {
+				p.mu.Lock()
+				ch.pendingReplies = append(ch.pendingReplies, req)
+				p.mu.Unlock()
+			}
+			_, _ = p.clientW.Write(sshtypes.SSHRequest{
+				ChannelID:   msg.ChannelID,
+				RequestType: req.Type,
+				WantReply:   req.WantReply,
+				Payload:     req.Payload,
+			}.Encode())
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(sshtypes.NewDataWriter(p.clientW, msg.ChannelID), ch)
+	}()
+	go func() {
+		defer wg.Done()
+		_, _ = io.Copy(sshtypes.NewExtendedDataWriter(p.clientW, msg.ChannelID, sshtypes.ExtendedDataStderr), ch.Stderr())
+	}()
+	go func() {
+		// the exit status must be relayed before closing the channel in the client
+		wg.Wait()
+		_ = ch.Close()
+		p.mu.Lock()
+		if p.channels[msg.ChannelID] == ch {
+			delete(p.channels, msg.ChannelID)
+		}
+		p.mu.Unlock()
+		_, _ = p.clientW.Write(sshtypes.CloseChannel{ID: msg.ChannelID, Type: msg.ChannelType}.Encode())
+	}()
+}
+
+func (p *sshProxy) getChannel(id uint16) ssh.Channel {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if ch, ok := p.channels[id]; ok {
+		return ch
+	}
+	return nil
+}
+
+// popPendingReply returns the oldest request of the channel waiting for a reply
+func (p *sshProxy) popPendingReply(id uint16) *ssh.Request {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ch, ok := p.channels[id]
+	if !ok || len(ch.pendingReplies) == 0 {
+		log.With("sid", p.sid, "conn", p.connectionID).Warnf("no pending request to reply on channel %v", id)
+		return nil
+	}
+	req := ch.pendingReplies[0]
+	ch.pendingReplies = ch.pendingReplies[1:]
+	return req
+}
+
+// Write writes a message sent by the client
+func (p *sshProxy) Write(data []byte) (int, error) {
+	if len(data) == 0 {
+		return 0, nil
+	}
+	// the messages written after the close are not queued
+	select {
+	case <-p.doneCh:
+		return 0, io.ErrClosedPipe
+	default:
+	}
+	msg := make([]byte, len(data))
+	_ = copy(msg, data)
+	select {
+	case p.clientCh <- msg:
+		return len(data), nil
+	case <-p.doneCh:
+		return 0, io.ErrClosedPipe
+	}
+}
+
+func (p *sshProxy) OnConsume(fn func(n int)) { p.consume.OnConsume(fn) }
+func (p *sshProxy) Done() <-chan struct{}    { return p.doneCh }
+func (p *sshProxy) Close() error {
+	p.userClosed.Store(true)
+	p.close()
+	return nil
+}
+
+func (p *sshProxy) close() {
+	p.closeOnce.Do(func() {
+		p.cancelFn()
+		p.mu.Lock()
+		for _, ch := range p.channels {
+			_ = ch.Close()
+		}
+		if p.client != nil {
+			_ = p.client.Close()
+		}
+		p.mu.Unlock()
+		close(p.doneCh)
+	})
+}
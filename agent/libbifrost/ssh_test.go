@@ -0,0 +1,217 @@
+package libbifrost
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	sshtypes "github.com/bifrost/poc/libbifrost/proxy/ssh/types"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestHostKey(t *testing.T) ssh.Signer {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+	signer, err := ssh.NewSignerFromKey(key)
+	assert.Nil(t, err)
+	return signer
+}
+
+// serveSSH authenticates the user bifrost with the password secret and serves the
+// sessions opened by the agent: an exec request writes to stdout the data sent
+// by the client and exits with the status 3, a shell request waits for the close.
+// The connection is closed when the first session is closed.
+func serveSSH(hostKey ssh.Signer) func(*testing.T, net.Conn) {
+	return func(t *testing.T, conn net.Conn) {
+		config := &ssh.ServerConfig{
+			PasswordCallback: func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+				if c.User() == "bifrost" && string(password) == "secret" {
+					return nil, nil
+				}
+				return nil, fmt.Errorf("invalid credentials")
+			},
+		}
+		config.AddHostKey(hostKey)
+		// both sides send their version when the connection starts
+		asyncConn := newAsyncWriteConn(conn)
+		defer asyncConn.close()
+		sconn, chans, reqs, err := ssh.NewServerConn(asyncConn, config)
+		if err != nil {
+			return
+		}
+		defer func() {
+			_ = sconn.Close()
+			_ = sconn.Wait()
+		}()
+		go ssh.DiscardRequests(reqs)
+		newCh, ok := <-chans
+		if !ok {
+			return
+		}
+		assert.Equal(t, "session", newCh.ChannelType())
+		ch, chReqs, err := newCh.Accept()
+		if !assert.Nil(t, err) {
+			return
+		}
+		for req := range chReqs {
+			switch req.Type {
+			case "shell":
+				_ = req.Reply(true, nil)
+			case "exec":
+				var cmd struct{ Command string }
+				assert.Nil(t, ssh.Unmarshal(req.Payload, &cmd))
+				assert.Equal(t, "echo", cmd.Command)
+				_ = req.Reply(true, nil)
+				data := make([]byte, 4)
+				if _, err := io.ReadFull(ch, data); !assert.Nil(t, err) {
+					return
+				}
+				_, _ = ch.Write(append([]byte("hello "), data...))
+				_, _ = ch.Stderr().Write([]byte("oops"))
+				_, _ = ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{3}))
+				_ = ch.Close()
+			default:
+				_ = req.Reply(false, nil)
+			}
+		}
+	}
+}
+
+// readSSHMessage reads a message written by the proxy, each write is a message
+func readSSHMessage(t *testing.T, client *testClient) []byte {
+	data := make([]byte, 64*1024)
+	n, err := client.Read(data)
+	assert.Nil(t, err)
+	return data[:n]
+}
+
+func TestSSHSession(t *testing.T) {
+	hostKey := newTestHostKey(t)
+	fakeServer(t, serveSSH(hostKey))
+	client := newTestClient(t)
+	p, err := newSSHProxy(context.Background(), client.proxyConn, map[string]string{
+		"hostname": "ssh.local", "username": "bifrost", "password": "secret",
+		"host_key": string(ssh.MarshalAuthorizedKey(hostKey.PublicKey()))})
+	assert.Nil(t, err)
+	resultCh := runProxy(t, p)
+
+	_, err = p.Write(sshtypes.OpenChannel{ChannelID: 1, ChannelType: "session"}.Encode())
+	assert.Nil(t, err)
+	_, err = p.Write(sshtypes.SSHRequest{ChannelID: 1, RequestType: "exec", WantReply: true,
+		Payload: ssh.Marshal(struct{ Command string }{"echo"})}.Encode())
+	assert.Nil(t, err)
+	var reply sshtypes.SSHRequestReply
+	assert.Nil(t, sshtypes.Decode(readSSHMessage(t, client), &reply))
+	assert.Equal(t, sshtypes.SSHRequestReply{ChannelID: 1, OK: true, Payload: reply.Payload}, reply)
+	_, err = p.Write(sshtypes.Data{ChannelID: 1, Payload: []byte("ping")}.Encode())
+	assert.Nil(t, err)
+
+	// the stdout, stderr and exit status are relayed before the close of the channel
+	var stdout, stderr []byte
+	var exitStatus *sshtypes.SSHRequest
+	for {
+		data := readSSHMessage(t, client)
+		if len(data) == 0 {
+			break
+		}
+		typ := sshtypes.DecodeType(data)
+		if typ == sshtypes.CloseChannelType {
+			var msg sshtypes.CloseChannel
+			assert.Nil(t, sshtypes.Decode(data, &msg))
+			assert.Equal(t, sshtypes.CloseChannel{ID: 1, Type: "session"}, msg)
+			break
+		}
+		switch typ {
+		case sshtypes.DataType:
+			var msg sshtypes.Data
+			assert.Nil(t, sshtypes.Decode(data, &msg))
+			stdout = append(stdout, msg.Payload...)
+		case sshtypes.ExtendedDataType:
+			var msg sshtypes.ExtendedData
+			assert.Nil(t, sshtypes.Decode(data, &msg))
+			assert.Equal(t, sshtypes.ExtendedDataStderr, msg.DataTypeCode)
+			stderr = append(stderr, msg.Payload...)
+		case sshtypes.SSHRequestType:
+			exitStatus = &sshtypes.SSHRequest{}
+			assert.Nil(t, sshtypes.Decode(data, exitStatus))
+		default:
+			t.Fatalf("unexpected message %v", typ)
+		}
+	}
+	assert.Equal(t, "hello ping", string(stdout))
+	assert.Equal(t, "oops", string(stderr))
+	if assert.NotNil(t, exitStatus) {
+		assert.Equal(t, "exit-status", exitStatus.RequestType)
+		assert.Equal(t, []byte{0, 0, 0, 3}, exitStatus.Payload)
+	}
+	// the server closing the connection ends the proxy without errors
+	assert.Equal(t, &proxyResult{0, ""}, waitResult(t, p, resultCh))
+}
+
+func TestSSHClose(t *testing.T) {
+	hostKey := newTestHostKey(t)
+	fakeServer(t, serveSSH(hostKey))
+	client := newTestClient(t)
+	p, err := newSSHProxy(context.Background(), client.proxyConn, map[string]string{
+		"hostname": "ssh.local", "username": "bifrost", "password": "secret",
+		"host_key": string(ssh.MarshalAuthorizedKey(hostKey.PublicKey()))})
+	assert.Nil(t, err)
+	resultCh := runProxy(t, p)
+
+	_, err = p.Write(sshtypes.OpenChannel{ChannelID: 1, ChannelType: "session"}.Encode())
+	assert.Nil(t, err)
+	_, err = p.Write(sshtypes.SSHRequest{ChannelID: 1, RequestType: "shell", WantReply: true}.Encode())
+	assert.Nil(t, err)
+	var reply sshtypes.SSHRequestReply
+	assert.Nil(t, sshtypes.Decode(readSSHMessage(t, client), &reply))
+	assert.True(t, reply.OK)
+
+	// the client closing the proxy is not reported
+	assert.Nil(t, p.Close())
+	assert.Nil(t, waitResult(t, p, resultCh))
+	_, err = p.Write(sshtypes.Data{ChannelID: 1, Payload: []byte("ls")}.Encode())
+	assert.Equal(t, io.ErrClosedPipe, err)
+}
+
+func TestSSHAuthentication(t *testing.T) {
+	hostKey := newTestHostKey(t)
+	for _, tt := range []struct {
+		msg      string
+		password string
+		hostKey  ssh.PublicKey
+		wantErr  string
+	}{
+		{
+			msg:      "it must fail when the server presents another host key",
+			password: "secret",
+			hostKey:  newTestHostKey(t).PublicKey(),
+			wantErr:  "host key mismatch, the server presented the ssh-ed25519 key " + ssh.FingerprintSHA256(hostKey.PublicKey()),
+		},
+		{
+			msg:      "it must fail when the server rejects the password",
+			password: "wrong",
+			hostKey:  hostKey.PublicKey(),
+			wantErr:  "unable to authenticate",
+		},
+	} {
+		t.Run(tt.msg, func(t *testing.T) {
+			fakeServer(t, serveSSH(hostKey))
+			client := newTestClient(t)
+			p, err := newSSHProxy(context.Background(), client.proxyConn, map[string]string{
+				"hostname": "ssh.local", "username": "bifrost", "password": tt.password,
+				"host_key": string(ssh.MarshalAuthorizedKey(tt.hostKey))})
+			assert.Nil(t, err)
+			result := waitResult(t, p, runProxy(t, p))
+			if assert.NotNil(t, result) {
+				assert.Equal(t, 1, result.exitCode)
+				assert.Contains(t, result.errMsg, "failed authenticating with ssh server")
+				assert.Contains(t, result.errMsg, tt.wantErr)
+			}
+		})
+	}
+}
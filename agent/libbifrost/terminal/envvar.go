@@ -4,6 +4,7 @@ import (
 	"encoding/base64"
 	"fmt"
 	"os"
+	"sort"
 	"strings"
 
 	"github.com/google/uuid"
@@ -73,6 +74,7 @@ func NewEnvVarStore(rawEnvVarList map[string]any) (*EnvVarStore, error) {
 				if err != nil {
 					return fmt.Errorf("failed creating temp file for %v, err=%v", env.Key, err)
 				}
+				defer f.Close()
 				if err := f.Chmod(0600); err != nil {
 					return fmt.Errorf("failed changing permission of env var file, err=%v", err)
 				}
@@ -94,3 +96,40 @@ func NewEnvVarStore(rawEnvVarList map[string]any) (*EnvVarStore, error) {
 	}
 	return store, nil
 }
+
+// Environ returns the environment variables in the form "key=value"
+func (s *EnvVarStore) Environ() []string {
+	var environ []string
+	for key, env := range s.store {
+		environ = append(environ, fmt.Sprintf("%s=%s", key, env.Val))
+	}
+	sort.Strings(environ)
+	return environ
+}
+
+// OnPreExec runs the hooks of the environment variables before executing a command
+func (s *EnvVarStore) OnPreExec() error {
+	for _, env := range s.store {
+		if env.OnPreExec == nil {
+			continue
+		}
+		if err := env.OnPreExec(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OnPostExec runs the hooks of the environment variables after executing a command,
+// all hooks are executed and the first error is returned.
+func (s *EnvVarStore) OnPostExec() (err error) {
+	for _, env := range s.store {
+		if env.OnPostExec == nil {
+			continue
+		}
+		if hookErr := env.OnPostExec(); hookErr != nil && err == nil {
+			err = hookErr
+		}
+	}
+	return
+}
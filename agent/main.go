@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"os"
 	"os/exec"
 	"time"
 
@@ -10,6 +11,7 @@ import (
 
 	agentconfig "github.com/bifrost/poc/config"
 	"github.com/bifrost/poc/controller"
+	"github.com/bifrost/poc/metrics"
 	"github.com/bifrost/common/backoff"
 	"github.com/bifrost/common/clientconfig"
 	"github.com/bifrost/common/grpc"
@@ -46,6 +48,9 @@ func Run() {
 		return
 	}
 	handleOsInterrupt(nil)
+	startMetricsServer()
+	startTracing()
+	warnSessionResumeGrace()
 	if err := runDefaultMode(config); err != nil {
 		log.With("version", vi.Version).Fatal(err)
 	}
@@ -81,6 +86,9 @@ func RunV2(req *pb.PreConnectRequest, runtimeEnvs map[string]string, commandArgs
 	// do not sync, use the runtime environment variables instead
 	req.Envs = nil
 
+	startMetricsServer()
+	startTracing()
+	warnSessionResumeGrace()
 	stopFn := runAgentController(c, clientConfig, req, runtimeEnvs)
 	if len(commandArgs) == 0 {
 		// block forever until it receives
@@ -143,10 +151,11 @@ func runAgent(config *agentconfig.Config, clientConfig grpc.ClientConfig, connec
 		log.Errorf("failed connecting to gateway, err=%v", err)
 		return
 	}
-	ctrl := controller.New(client, config, runtimeEnvs)
-	agentStore.Set(agentInstanceKey, ctrl)
-	defer func() { agentStore.Del(agentInstanceKey); ctrl.Close(nil) }()
+	ctrl := newOrResumeController(client, config, runtimeEnvs)
+	metrics.SetGatewayConnected(true)
 	err = ctrl.Run()
+	metrics.SetGatewayConnected(false)
+	releaseController(ctrl)
 	// err = New(client, config).Run()
 	var grpcStatusCode = codes.Code(99)
 	if status, ok := status.FromError(err); ok {
@@ -156,7 +165,7 @@ func runAgent(config *agentconfig.Config, clientConfig grpc.ClientConfig, connec
 	case codes.Canceled:
 		log.With("version", vi.Version).Infof("context canceled")
 	case codes.Unauthenticated:
-		log.With("version", vi.Version).Infof("unauthenticated")
+		log.With("version", vi.Version).Infof("unauthenticated, reason=%v", pb.AuthErrorReason(err))
 	default:
 		log.With("version", vi.Version, "status", grpcStatusCode).Infof("disconnected from %v, reason=%v", config.URL, err)
 	}
@@ -184,11 +193,12 @@ func runDefaultMode(config *agentconfig.Config) error {
 				Warnf("failed to connect to %s, reason=%v", config.URL, err.Error())
 			return backoff.Error()
 		}
-		ctrl := controller.New(client, config, nil)
-		agentStore.Set(agentInstanceKey, ctrl)
-		defer func() { agentStore.Del(agentInstanceKey); ctrl.Close(nil) }()
+		ctrl := newOrResumeController(client, config, nil)
 		connectStart := time.Now().UTC()
+		metrics.SetGatewayConnected(true)
 		err = ctrl.Run()
+		metrics.SetGatewayConnected(false)
+		releaseController(ctrl)
 		// reset backoff if it has been connected for more than the specified time
 		backoffErr, elapsed := backoff.Error(), int(time.Now().UTC().Sub(connectStart).Seconds())
 		if elapsed > defaultBackoffResetSec {
@@ -205,7 +215,7 @@ func runDefaultMode(config *agentconfig.Config) error {
 			log.With("version", vi.Version, "backoff", v.String()).Infof("context canceled")
 			return nil
 		case codes.Unauthenticated:
-			log.With("version", vi.Version, "backoff", v.String()).Infof("unauthenticated")
+			log.With("version", vi.Version, "backoff", v.String()).Infof("unauthenticated, reason=%v", pb.AuthErrorReason(err))
 			return backoffErr
 		}
 		log.With("version", vi.Version, "backoff", v.String(), "status", grpcStatusCode).
@@ -213,3 +223,55 @@ func runDefaultMode(config *agentconfig.Config) error {
 		return backoffErr
 	})
 }
+
+// newOrResumeController resumes the suspended agent instance with the new connection,
+// it creates a new instance if there isn't one or it has not reconnected in time.
+func newOrResumeController(client pb.ClientTransport, config *agentconfig.Config, runtimeEnvs map[string]string) *controller.Agent {
+	if ctrl, _ := agentStore.Get(agentInstanceKey).(*controller.Agent); ctrl != nil {
+		if ctrl.Resume(client) {
+			log.Infof("resuming sessions of the previous connection")
+			return ctrl
+		}
+		agentStore.Del(agentInstanceKey)
+	}
+	ctrl := controller.New(client, config, runtimeEnvs)
+	agentStore.Set(agentInstanceKey, ctrl)
+	return ctrl
+}
+
+// releaseController suspends the agent instance after it disconnects, allowing the gateway
+// to resume its sessions. The instance is closed if the gateway doesn't resume sessions.
+func releaseController(ctrl *controller.Agent) {
+	if grace := ctrl.ResumeGrace(); grace > 0 && ctrl.Suspend(grace) {
+		return
+	}
+	agentStore.Del(agentInstanceKey)
+	ctrl.Close(nil)
+}
+
+// warnSessionResumeGrace warns about the setting removed in favor of the grace of the gateway
+func warnSessionResumeGrace() {
+	if os.Getenv("BIFROST_SESSION_RESUME_GRACE") != "" {
+		log.Warnf("BIFROST_SESSION_RESUME_GRACE is ignored, the sessions are kept for the session_resume_grace of the gateway")
+	}
+}
+
+// shutdownTracing flushes the spans of the agent before exiting
+var shutdownTracing monitoring.ShutdownFn = func() {}
+
+// startTracing exports the spans of the sessions when an OTLP endpoint is configured
+func startTracing() {
+	shutdownFn, err := monitoring.NewOpenTracing("bifrost-agent")
+	if err != nil {
+		log.Warnf("tracing is disabled, reason=%v", err)
+		return
+	}
+	shutdownTracing = shutdownFn
+}
+
+// startMetricsServer serves the prometheus metrics when BIFROST_METRICS_ADDR is set (e.g.: :9090)
+func startMetricsServer() {
+	if addr := os.Getenv("BIFROST_METRICS_ADDR"); addr != "" {
+		go metrics.Serve(addr)
+	}
+}
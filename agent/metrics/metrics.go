@@ -0,0 +1,62 @@
+// Package metrics exposes the prometheus metrics of the agent
+package metrics
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bifrost/common/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	gatewayConnected = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "bifrost_agent_gateway_connected",
+		Help: "1 if the agent is connected with the gateway",
+	})
+	secretsLookupDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "bifrost_agent_secrets_lookup_duration_seconds",
+		Help:    "Latency of the secrets manager lookups",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"provider", "status"})
+	provisionerRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "bifrost_agent_provisioner_requests_total",
+		Help: "Database provisioner requests by outcome",
+	}, []string{"status"})
+)
+
+// Serve starts the metrics endpoint (/metrics) on the address
+func Serve(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	log.Infof("starting metrics server at %v", addr)
+	if err := http.ListenAndServe(addr, mux); err != nil {
+		log.Errorf("metrics server failed, err=%v", err)
+	}
+}
+
+// SetGatewayConnected records if the agent is connected with the gateway
+func SetGatewayConnected(connected bool) {
+	if connected {
+		gatewayConnected.Set(1)
+		return
+	}
+	gatewayConnected.Set(0)
+}
+
+// ObserveSecretsLookup records the latency of a lookup in a secrets manager provider (_aws, _vaultkv1, ...)
+func ObserveSecretsLookup(provider string, err error, elapsed time.Duration) {
+	status := "ok"
+	if err != nil {
+		status = "error"
+	}
+	secretsLookupDuration.WithLabelValues(strings.TrimPrefix(provider, "_"), status).Observe(elapsed.Seconds())
+}
+
+// IncProvisionerRequest counts a provisioner request by its response status
+func IncProvisionerRequest(status string) {
+	provisionerRequests.WithLabelValues(status).Inc()
+}
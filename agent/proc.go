@@ -112,6 +112,7 @@ func handleOsInterrupt(shutdownFn context.CancelFunc) {
 		log.Debug(err)
 		cleanExit := cleanupAgentInstance(shutdownFn, err)
 		sentry.Flush(time.Second * 2)
+		shutdownTracing()
 		log.With("clean-exit", cleanExit).Debugf("exiting program")
 		switch sigval {
 		case syscall.SIGHUP:
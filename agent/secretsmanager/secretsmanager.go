@@ -4,6 +4,9 @@ import (
 	"encoding/base64"
 	"fmt"
 	"strings"
+	"time"
+
+	"github.com/bifrost/poc/metrics"
 )
 
 type secretsGetter interface {
@@ -81,7 +84,9 @@ func Decode(envVars map[string]any) (map[string]any, error) {
 			decodedEnvVars[envKey] = encEnvVal
 			continue
 		}
+		lookupStart := time.Now()
 		val, err := provider.GetKey(attr.secretID, attr.secretKey)
+		metrics.ObserveSecretsLookup(string(attr.provider), err, time.Since(lookupStart))
 		if err != nil {
 			errors = append(errors, fmt.Sprintf("%s %v", envKey, err))
 			continue
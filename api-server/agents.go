@@ -18,8 +18,24 @@ type Agent struct {
 	Name        string    `json:"name"`
 	Description string    `json:"description"`
 	Status      string    `json:"status,omitempty"`      // Runtime status (connected/disconnected)
+	Key         string    `json:"key,omitempty"`         // DSN of the agent, only returned when it's generated
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
+
+	// Connection info recorded by the gateway
+	Hostname           string     `json:"hostname,omitempty"`
+	Version            string     `json:"version,omitempty"`
+	Platform           string     `json:"platform,omitempty"`
+	LastConnectedAt    *time.Time `json:"last_connected_at,omitempty"`
+	LastDisconnectedAt *time.Time `json:"last_disconnected_at,omitempty"`
+}
+
+const agentColumns = `id, agent_id, name, description, created_at, updated_at,
+	COALESCE(hostname, ''), COALESCE(version, ''), COALESCE(platform, ''), last_connected_at, last_disconnected_at`
+
+func (a *Agent) scanFields() []any {
+	return []any{&a.ID, &a.AgentID, &a.Name, &a.Description, &a.CreatedAt, &a.UpdatedAt,
+		&a.Hostname, &a.Version, &a.Platform, &a.LastConnectedAt, &a.LastDisconnectedAt}
 }
 
 type CreateAgentRequest struct {
@@ -41,7 +57,7 @@ func handleGetAgents(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rows, err := db.Query("SELECT id, agent_id, name, description, created_at, updated_at FROM agents ORDER BY created_at DESC")
+	rows, err := db.Query("SELECT " + agentColumns + " FROM agents ORDER BY created_at DESC")
 	if err != nil {
 		log.Printf("Error querying agents: %v", err)
 		w.Header().Set("Content-Type", "application/json")
@@ -54,7 +70,7 @@ func handleGetAgents(w http.ResponseWriter, r *http.Request) {
 	agents := []Agent{}
 	for rows.Next() {
 		var agent Agent
-		err := rows.Scan(&agent.ID, &agent.AgentID, &agent.Name, &agent.Description, &agent.CreatedAt, &agent.UpdatedAt)
+		err := rows.Scan(agent.scanFields()...)
 		if err != nil {
 			log.Printf("Error scanning agent: %v", err)
 			continue
@@ -104,8 +120,7 @@ func handleGetAgent(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var agent Agent
-	err = db.QueryRow("SELECT id, agent_id, name, description, created_at, updated_at FROM agents WHERE id = $1", id).
-		Scan(&agent.ID, &agent.AgentID, &agent.Name, &agent.Description, &agent.CreatedAt, &agent.UpdatedAt)
+	err = db.QueryRow("SELECT "+agentColumns+" FROM agents WHERE id = $1", id).Scan(agent.scanFields()...)
 
 	if err == sql.ErrNoRows {
 		w.Header().Set("Content-Type", "application/json")
@@ -159,10 +174,19 @@ func handleCreateAgent(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	agentKey, agentKeyHash, err := newAgentKey(req.AgentID)
+	if err != nil {
+		log.Printf("Error generating agent key: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid agent ID"})
+		return
+	}
+
 	var agent Agent
-	err := db.QueryRow(
-		"INSERT INTO agents (agent_id, name, description) VALUES ($1, $2, $3) RETURNING id, agent_id, name, description, created_at, updated_at",
-		req.AgentID, req.Name, req.Description,
+	err = db.QueryRow(
+		"INSERT INTO agents (agent_id, name, description, key_hash) VALUES ($1, $2, $3, $4) RETURNING id, agent_id, name, description, created_at, updated_at",
+		req.AgentID, req.Name, req.Description, agentKeyHash,
 	).Scan(&agent.ID, &agent.AgentID, &agent.Name, &agent.Description, &agent.CreatedAt, &agent.UpdatedAt)
 
 	if err != nil {
@@ -181,6 +205,7 @@ func handleCreateAgent(w http.ResponseWriter, r *http.Request) {
 	}
 
 	agent.Status = "disconnected"
+	agent.Key = agentKey
 	log.Printf("Created agent: %s (ID: %d)", agent.AgentID, agent.ID)
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
@@ -222,6 +247,8 @@ func handleUpdateAgent(w http.ResponseWriter, r *http.Request) {
 	argCount := 1
 
 	if req.AgentID != "" {
+		// the key is bound to the agent id (dsn name), a new key must be generated when it changes
+		updates = append(updates, fmt.Sprintf("key_hash = CASE WHEN agent_id = $%d THEN key_hash END", argCount))
 		updates = append(updates, fmt.Sprintf("agent_id = $%d", argCount))
 		args = append(args, req.AgentID)
 		argCount++
@@ -278,6 +305,62 @@ func handleUpdateAgent(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(agent)
 }
 
+// handleRotateAgentKey generates a new key for an agent, the previous key stops
+// authenticating new connections of the agent in the gateway.
+func handleRotateAgentKey(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	// Extract ID from path: /api/agents/:id/key
+	pathParts := strings.Split(r.URL.Path, "/")
+	if len(pathParts) != 5 || pathParts[4] != "key" {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	id, err := strconv.Atoi(pathParts[3])
+	if err != nil {
+		http.Error(w, "Invalid agent ID", http.StatusBadRequest)
+		return
+	}
+
+	var agent Agent
+	err = db.QueryRow("SELECT id, agent_id, name, description, created_at, updated_at FROM agents WHERE id = $1", id).
+		Scan(&agent.ID, &agent.AgentID, &agent.Name, &agent.Description, &agent.CreatedAt, &agent.UpdatedAt)
+	if err == sql.ErrNoRows {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Agent not found"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error querying agent: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Failed to fetch agent"})
+		return
+	}
+
+	agentKey, agentKeyHash, err := newAgentKey(agent.AgentID)
+	if err == nil {
+		_, err = db.Exec("UPDATE agents SET key_hash = $1 WHERE id = $2", agentKeyHash, id)
+	}
+	if err != nil {
+		log.Printf("Error rotating agent key: %v", err)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Failed to generate agent key"})
+		return
+	}
+
+	log.Printf("Rotated key of agent: %s (ID: %d)", agent.AgentID, agent.ID)
+	agent.Key = agentKey
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(agent)
+}
+
 // handleDeleteAgent deletes an agent
 func handleDeleteAgent(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"time"
+
+	"github.com/bifrost/common/dsnkeys"
+	"github.com/bifrost/common/keys"
+	pb "github.com/bifrost/common/proto"
+)
+
+const gatewayTokenDuration = 5 * time.Minute
+
+var (
+	gatewayPublicURL = getEnv("GATEWAY_PUBLIC_URL", "grpc://localhost:8010")
+
+	jwtSigningKey ed25519.PrivateKey
+)
+
+// loadJWTSigningKey loads the key used to sign the access tokens of the gateway
+func loadJWTSigningKey() error {
+	encPrivKey := getEnv("JWT_SIGNING_KEY", "")
+	if encPrivKey == "" {
+		return fmt.Errorf("missing JWT_SIGNING_KEY environment variable")
+	}
+	privKey, err := keys.Base64DecodeEd25519PrivateKey(encPrivKey)
+	if err != nil {
+		return fmt.Errorf("failed decoding JWT_SIGNING_KEY: %v", err)
+	}
+	jwtSigningKey = privKey
+	return nil
+}
+
+// newGatewayAccessToken issues a short lived token to open a client stream in the gateway
+func newGatewayAccessToken() (string, error) {
+	return keys.NewJwtToken(jwtSigningKey, "api-server", "web@example.com", gatewayTokenDuration)
+}
+
+// newAgentKey generates the DSN of an agent and the hash of its secret key.
+// Only the hash is stored, the DSN is returned once to the user.
+func newAgentKey(agentID string) (dsn, secretKeyHash string, err error) {
+	secretKey, secretKeyHash, err := keys.GenerateSecureRandomKey("", 0)
+	if err != nil {
+		return "", "", err
+	}
+	dsn, err = dsnkeys.NewString(gatewayPublicURL, agentID, secretKey, pb.AgentModeStandardType)
+	if err != nil {
+		return "", "", fmt.Errorf("failed generating agent dsn: %v", err)
+	}
+	return dsn, secretKeyHash, nil
+}
@@ -0,0 +1,403 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// the formats of the exports of the execute query endpoint
+const (
+	exportCSV        = "csv"
+	exportJSONLines  = "jsonl"
+	exportParquet    = "parquet"
+	exportBatchRows  = 10000
+	exportTimeLayout = "20060102T150405"
+)
+
+var exportContentTypes = map[string]string{
+	exportCSV:       "text/csv; charset=utf-8",
+	exportJSONLines: contentTypeNDJSON,
+	exportParquet:   "application/vnd.apache.parquet",
+}
+
+// exportEncoder encodes the result sets of a query in one of the export formats
+type exportEncoder interface {
+	// header starts a result set, it's called before its first rows
+	header(columns []ResultColumn) error
+	writeRows(rows [][]any) error
+	// close writes the end of the file (e.g.: the parquet footer)
+	close() error
+}
+
+// resultExport writes the result sets of a query as a file download while the rows are
+// read. The rows are buffered in batches of exportBatchRows, the header of a result set is
+// written with the first batch. The mongodb documents may add fields after it: csv writes
+// a new header with all the fields and json lines adds the keys to the next rows. The
+// parquet schema can't change, the rows of variable columns are spooled to a temporary
+// file and the file is written when the result set ends with the columns of all the rows.
+// Statements without rows (e.g.: UPDATE) are not exported.
+type resultExport struct {
+	out     *exportResponse
+	enc     exportEncoder
+	format  string
+	columns []ResultColumn
+	pending [][]any
+	// the header of the current result set was written
+	fixed      bool
+	resultSets int
+	// the rows of the parquet exports with variable columns, in the format of the stored results
+	spooled bool
+	spool   *os.File
+}
+
+// newResultExport returns the export of a query in one of the export formats
+func newResultExport(w http.ResponseWriter, format string, databaseID int) (*resultExport, error) {
+	contentType, ok := exportContentTypes[format]
+	if !ok {
+		return nil, fmt.Errorf("unsupported export format %q, the formats are %s, %s and %s",
+			format, exportCSV, exportJSONLines, exportParquet)
+	}
+	out := &exportResponse{
+		w:           w,
+		rc:          http.NewResponseController(w),
+		contentType: contentType,
+		filename:    fmt.Sprintf("query-%d-%s.%s", databaseID, time.Now().UTC().Format(exportTimeLayout), format),
+	}
+	e := &resultExport{out: out, format: format}
+	switch format {
+	case exportCSV:
+		e.enc = &csvEncoder{w: csv.NewWriter(out)}
+	case exportJSONLines:
+		e.enc = &jsonLinesEncoder{w: out}
+	case exportParquet:
+		e.enc = &parquetEncoder{w: out}
+	}
+	return e, nil
+}
+
+func (e *resultExport) startResultSet(columns []ResultColumn) error {
+	e.columns, e.pending, e.fixed, e.spooled = columns, nil, false, false
+	if len(columns) == 0 {
+		return nil
+	}
+	if e.resultSets++; e.resultSets > 1 && e.format == exportParquet {
+		return fmt.Errorf("parquet exports support a single result set, the query returned more than one")
+	}
+	return nil
+}
+
+// variableColumns spools the rows of the parquet exports, the schema has the columns of all the rows
+func (e *resultExport) variableColumns() { e.spooled = e.format == exportParquet }
+
+// addColumns adds the fields of the mongodb documents, the rows already written
+// keep the previous header and the next rows have empty values for the fields
+// they're missing.
+func (e *resultExport) addColumns(columns []ResultColumn) error {
+	if !e.fixed {
+		e.columns = append(e.columns, columns...)
+		return nil
+	}
+	if err := e.flush(); err != nil {
+		return err
+	}
+	e.columns = append(e.columns, columns...)
+	return e.enc.header(e.columns)
+}
+
+// widenColumn changes the type of a column of the mongodb documents, the parquet schema
+// is written with the types when the result set ends. The csv and json lines values
+// don't depend on the type of their column.
+func (e *resultExport) widenColumn(index int, columnType string) error {
+	e.columns[index].Type = columnType
+	return nil
+}
+
+func (e *resultExport) writeRow(values []any) error {
+	e.pending = append(e.pending, values)
+	if len(e.pending) < exportBatchRows {
+		return nil
+	}
+	return e.flush()
+}
+
+func (e *resultExport) endResultSet(rowCount int64, affectedRows *int64) error {
+	if len(e.columns) == 0 {
+		return nil
+	}
+	if err := e.flush(); err != nil {
+		return err
+	}
+	if e.spooled {
+		return e.writeSpool()
+	}
+	return nil
+}
+
+// flush writes the pending rows, the rows written before the columns were added are padded
+func (e *resultExport) flush() error {
+	if e.spooled {
+		return e.spoolRows()
+	}
+	if !e.fixed {
+		if err := e.enc.header(e.columns); err != nil {
+			return err
+		}
+		e.fixed = true
+	}
+	for i, row := range e.pending {
+		if len(row) < len(e.columns) {
+			e.pending[i] = append(row, make([]any, len(e.columns)-len(row))...)
+		}
+	}
+	if err := e.enc.writeRows(e.pending); err != nil {
+		return err
+	}
+	e.pending = e.pending[:0]
+	if e.out.started {
+		return e.out.rc.Flush()
+	}
+	return nil
+}
+
+// spoolRows writes the pending rows to the spool file as json arrays
+func (e *resultExport) spoolRows() error {
+	if e.spool == nil {
+		spool, err := os.CreateTemp("", "bifrost-export-*.jsonl")
+		if err != nil {
+			return fmt.Errorf("failed creating the export spool file: %v", err)
+		}
+		e.spool = spool
+	}
+	bw := bufio.NewWriter(e.spool)
+	enc := json.NewEncoder(bw)
+	for _, row := range e.pending {
+		if err := enc.Encode(row); err != nil {
+			return err
+		}
+	}
+	e.pending = e.pending[:0]
+	return bw.Flush()
+}
+
+// writeSpool writes the spooled rows with the final columns, the values are decoded as
+// the stored results with the type of their column (see storedValue).
+func (e *resultExport) writeSpool() error {
+	defer e.removeSpool()
+	e.spooled = false
+	if e.spool == nil {
+		return e.flush()
+	}
+	if _, err := e.spool.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bufio.NewReader(e.spool))
+	for {
+		var row []json.RawMessage
+		if err := dec.Decode(&row); err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("failed reading the export spool file: %v", err)
+		}
+		values := make([]any, len(row))
+		for i, raw := range row {
+			v, err := storedValue(e.columns[i], raw)
+			if err != nil {
+				return fmt.Errorf("failed decoding the column %v: %v", e.columns[i].Name, err)
+			}
+			values[i] = v
+		}
+		e.pending = append(e.pending, values)
+		if len(e.pending) == exportBatchRows {
+			if err := e.flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return e.flush()
+}
+
+func (e *resultExport) removeSpool() {
+	if e.spool == nil {
+		return
+	}
+	_ = e.spool.Close()
+	if err := os.Remove(e.spool.Name()); err != nil {
+		log.Printf("Failed removing the export spool file %s: %v", e.spool.Name(), err)
+	}
+	e.spool = nil
+}
+
+// finish ends the export. A failed query is returned as the json response with an error
+// status when the download has not started, otherwise the response is aborted so the
+// client doesn't take a truncated file as a complete one.
+func (e *resultExport) finish(resp *ExecuteQueryResponse) {
+	e.removeSpool()
+	statusCode := http.StatusUnprocessableEntity
+	if resp.ExitCode == 0 && resp.Error == "" {
+		err := e.enc.close()
+		if err == nil {
+			// an empty file when the query has no rows to export
+			_, err = e.out.Write(nil)
+		}
+		if err == nil {
+			return
+		}
+		resp.ExitCode, resp.Error = 1, fmt.Sprintf("failed exporting the results: %v", err)
+		statusCode = http.StatusInternalServerError
+	}
+	if e.out.started {
+		log.Printf("Aborting the %s export %s: %s", e.format, e.out.filename, resp.Error)
+		panic(http.ErrAbortHandler)
+	}
+	if resp.ResultSets == nil {
+		resp.ResultSets = []ResultSet{}
+	}
+	e.out.w.Header().Set("Content-Type", "application/json")
+	e.out.w.WriteHeader(statusCode)
+	json.NewEncoder(e.out.w).Encode(resp)
+}
+
+// exportResponse writes the headers of the download with the first bytes of the file
+type exportResponse struct {
+	w           http.ResponseWriter
+	rc          *http.ResponseController
+	contentType string
+	filename    string
+	started     bool
+}
+
+func (o *exportResponse) Write(p []byte) (int, error) {
+	if !o.started {
+		o.started = true
+		o.w.Header().Set("Content-Type", o.contentType)
+		o.w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", o.filename))
+		o.w.Header().Set("X-Accel-Buffering", "no")
+		o.w.WriteHeader(http.StatusOK)
+	}
+	return o.w.Write(p)
+}
+
+// csvEncoder writes a header line for every result set, the result sets are separated
+// by an empty line. Nulls are empty fields and binary values are base64 encoded.
+type csvEncoder struct {
+	w          *csv.Writer
+	resultSets int
+}
+
+func (c *csvEncoder) header(columns []ResultColumn) error {
+	if c.resultSets++; c.resultSets > 1 {
+		if err := c.w.Write(nil); err != nil {
+			return err
+		}
+	}
+	names := make([]string, len(columns))
+	for i, column := range columns {
+		names[i] = column.Name
+	}
+	return c.w.Write(names)
+}
+
+func (c *csvEncoder) writeRows(rows [][]any) error {
+	record := []string{}
+	for _, row := range rows {
+		record = record[:0]
+		for _, v := range row {
+			field, err := csvField(v)
+			if err != nil {
+				return err
+			}
+			record = append(record, field)
+		}
+		if err := c.w.Write(record); err != nil {
+			return err
+		}
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvEncoder) close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func csvField(v any) (string, error) {
+	switch val := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return val, nil
+	case []byte:
+		return base64.StdEncoding.EncodeToString(val), nil
+	case json.RawMessage:
+		return string(val), nil
+	}
+	// numbers and booleans have the same representation of the json response
+	data, err := json.Marshal(v)
+	return string(data), err
+}
+
+// jsonLinesEncoder writes every row as an object with the values of the json response,
+// the keys are in the order of the columns.
+type jsonLinesEncoder struct {
+	w    io.Writer
+	keys [][]byte
+}
+
+func (j *jsonLinesEncoder) header(columns []ResultColumn) error {
+	j.keys = make([][]byte, len(columns))
+	for i, name := range uniqueColumnNames(columns) {
+		key, err := json.Marshal(name)
+		if err != nil {
+			return err
+		}
+		j.keys[i] = key
+	}
+	return nil
+}
+
+func (j *jsonLinesEncoder) writeRows(rows [][]any) error {
+	var buf []byte
+	for _, row := range rows {
+		buf = append(buf, '{')
+		for i, v := range row {
+			if i > 0 {
+				buf = append(buf, ',')
+			}
+			data, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			buf = append(append(append(buf, j.keys[i]...), ':'), data...)
+		}
+		buf = append(buf, '}', '\n')
+	}
+	_, err := j.w.Write(buf)
+	return err
+}
+
+func (j *jsonLinesEncoder) close() error { return nil }
+
+// uniqueColumnNames returns the names of the columns with a suffix for the repeated
+// names (e.g.: SELECT a.id, b.id), the keys of the objects and the parquet fields are unique.
+func uniqueColumnNames(columns []ResultColumn) []string {
+	names := make([]string, len(columns))
+	seen := map[string]bool{}
+	for i, column := range columns {
+		name := column.Name
+		for n := 2; seen[name]; n++ {
+			name = fmt.Sprintf("%s_%d", column.Name, n)
+		}
+		seen[name] = true
+		names[i] = name
+	}
+	return names
+}
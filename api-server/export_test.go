@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/parquet-go/parquet-go"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestWidenMongoType(t *testing.T) {
+	for _, tt := range []struct {
+		msg   string
+		typ   string
+		other string
+		want  string
+	}{
+		{msg: "it must keep the same type", typ: "int", other: "int", want: "int"},
+		{msg: "it must keep the type with nulls", typ: "date", other: "null", want: "date"},
+		{msg: "it must take the type of the values after nulls", typ: "null", other: "bool", want: "bool"},
+		{msg: "it must widen int to long", typ: "int", other: "long", want: "long"},
+		{msg: "it must widen the integers to double", typ: "long", other: "double", want: "double"},
+		{msg: "it must not narrow double to int", typ: "double", other: "int", want: "double"},
+		{msg: "it must use string for numbers and strings", typ: "double", other: "string", want: "string"},
+		{msg: "it must use string for other mixed types", typ: "object", other: "array", want: "string"},
+	} {
+		t.Run(tt.msg, func(t *testing.T) {
+			assert.Equal(t, tt.want, widenMongoType(tt.typ, tt.other))
+		})
+	}
+}
+
+// exportMongoDocs exports the documents as parquet, it returns the response and the error of the export
+func exportMongoDocs(docs []bson.D) (*httptest.ResponseRecorder, error) {
+	rec := httptest.NewRecorder()
+	export, err := newResultExport(rec, exportParquet, 1)
+	if err != nil {
+		return nil, err
+	}
+	w := &mongoResultWriter{w: export, columns: map[string]int{}}
+	for _, doc := range docs {
+		if err := w.write(doc); err != nil {
+			return rec, err
+		}
+	}
+	if err := w.close(); err != nil {
+		return rec, err
+	}
+	export.finish(&ExecuteQueryResponse{})
+	return rec, nil
+}
+
+func TestExportMongoMixedTypes(t *testing.T) {
+	rec, err := exportMongoDocs([]bson.D{
+		{{Key: "n", Value: int32(1)}, {Key: "count", Value: int32(1)}, {Key: "note", Value: nil}},
+		{{Key: "n", Value: 2.5}, {Key: "count", Value: int64(1) << 40}, {Key: "note", Value: true}},
+		{{Key: "n", Value: "three"}, {Key: "count", Value: int32(3)}, {Key: "note", Value: false}},
+	})
+	if !assert.Nil(t, err) {
+		return
+	}
+	data := rec.Body.Bytes()
+	file, err := parquet.OpenFile(bytes.NewReader(data), int64(len(data)))
+	if !assert.Nil(t, err) {
+		return
+	}
+	var types []string
+	for _, field := range file.Schema().Fields() {
+		types = append(types, field.Type().String())
+	}
+	// the columns have the widest type of the documents
+	assert.Equal(t, []string{"STRING", "INT(64,true)", "BOOLEAN"}, types)
+
+	rows := make([]parquet.Row, 3)
+	n, err := parquet.NewReader(file).ReadRows(rows)
+	if err != io.EOF {
+		assert.Nil(t, err)
+	}
+	if !assert.Equal(t, 3, n) {
+		return
+	}
+	assert.Equal(t, "1", rows[0][0].String())
+	assert.Equal(t, "2.5", rows[1][0].String())
+	assert.Equal(t, "three", rows[2][0].String())
+	assert.Equal(t, int64(1)<<40, rows[1][1].Int64())
+	assert.True(t, rows[0][2].IsNull())
+	assert.True(t, rows[1][2].Boolean())
+}
+
+func TestExportMongoChangesAfterFirstBatch(t *testing.T) {
+	docs := make([]bson.D, exportBatchRows, exportBatchRows+1)
+	for i := range docs {
+		docs[i] = bson.D{{Key: "n", Value: int32(i)}, {Key: "s", Value: "x"}}
+	}
+	docs = append(docs, bson.D{{Key: "n", Value: "x"}, {Key: "s", Value: int32(1)}, {Key: "added", Value: true}})
+
+	t.Run("it must write the parquet schema with the fields and types of all the documents", func(t *testing.T) {
+		rec, err := exportMongoDocs(docs)
+		if !assert.Nil(t, err) {
+			return
+		}
+		data := rec.Body.Bytes()
+		file, err := parquet.OpenFile(bytes.NewReader(data), int64(len(data)))
+		if !assert.Nil(t, err) {
+			return
+		}
+		var types []string
+		for _, field := range file.Schema().Fields() {
+			types = append(types, field.Name()+" "+field.Type().String())
+		}
+		assert.Equal(t, []string{"n STRING", "s STRING", "added BOOLEAN"}, types)
+		assert.Equal(t, int64(len(docs)), file.NumRows())
+
+		rows := make([]parquet.Row, len(docs))
+		n, err := parquet.NewReader(file).ReadRows(rows)
+		if err != io.EOF {
+			assert.Nil(t, err)
+		}
+		if !assert.Equal(t, len(docs), n) {
+			return
+		}
+		assert.Equal(t, "9999", rows[9999][0].String())
+		assert.True(t, rows[9999][2].IsNull())
+		assert.Equal(t, "x", rows[10000][0].String())
+		assert.Equal(t, "1", rows[10000][1].String())
+		assert.True(t, rows[10000][2].Boolean())
+	})
+	for _, tt := range []struct {
+		format string
+		want   string
+	}{
+		{format: exportCSV, want: "9999,x\n\nn,s,added\nx,1,true\n"},
+		{format: exportJSONLines, want: `{"n":9999,"s":"x"}` + "\n" + `{"n":"x","s":1,"added":true}` + "\n"},
+	} {
+		t.Run("it must add the fields of the documents to the "+tt.format+" export", func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			export, err := newResultExport(rec, tt.format, 1)
+			if !assert.Nil(t, err) {
+				return
+			}
+			w := &mongoResultWriter{w: export, columns: map[string]int{}}
+			for _, doc := range docs {
+				if !assert.Nil(t, w.write(doc)) {
+					return
+				}
+			}
+			assert.Nil(t, w.close())
+			export.finish(&ExecuteQueryResponse{})
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.True(t, strings.HasSuffix(rec.Body.String(), tt.want), rec.Body.String()[rec.Body.Len()-100:])
+		})
+	}
+}
+
+func TestExportFinishError(t *testing.T) {
+	rec := httptest.NewRecorder()
+	export, err := newResultExport(rec, exportCSV, 1)
+	if !assert.Nil(t, err) {
+		return
+	}
+	export.finish(&ExecuteQueryResponse{ExitCode: 1, Error: "relation \"orders\" does not exist"})
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.Empty(t, rec.Header().Get("Content-Disposition"))
+	assert.JSONEq(t, `{"resultSets":[],"exitCode":1,"error":"relation \"orders\" does not exist","duration":""}`, rec.Body.String())
+}
@@ -0,0 +1,492 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bifrost/common/monitoring"
+	pb "github.com/bifrost/common/proto"
+	pbagent "github.com/bifrost/common/proto/agent"
+	pbclient "github.com/bifrost/common/proto/client"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+)
+
+// gatewayProtocol maps a database type to the packet types used to tunnel
+// the connections of its native driver through the agent.
+type gatewayProtocol struct {
+	writeType string
+	readType  string
+	// the server sends the first packet after the connection is established,
+	// an empty packet is sent to start the connection in the agent
+	serverFirst bool
+}
+
+var gatewayProtocols = map[string]gatewayProtocol{
+	"mysql": {
+		writeType:   pbagent.MySQLConnectionWrite,
+		readType:    pbclient.MySQLConnectionWrite,
+		serverFirst: true,
+	},
+	"mongodb": {
+		writeType: pbagent.MongoDBConnectionWrite,
+		readType:  pbclient.MongoDBConnectionWrite,
+	},
+	"postgres": {
+		writeType: pbagent.PGConnectionWrite,
+		readType:  pbclient.PGConnectionWrite,
+	},
+	"mssql": {
+		writeType: pbagent.MSSQLConnectionWrite,
+		readType:  pbclient.MSSQLConnectionWrite,
+	},
+}
+
+// dialGateway opens a client stream in the gateway routed to the agent
+func dialGateway(ctx context.Context, agentID string) (*grpc.ClientConn, pb.Transport_ConnectClient, error) {
+	accessToken, err := newGatewayAccessToken()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to generate gateway access token: %v", err)
+	}
+	creds, err := gatewayTransportCredentials()
+	if err != nil {
+		return nil, nil, err
+	}
+	conn, err := grpc.Dial(gatewayAddr, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to gateway: %v", err)
+	}
+
+	// Create client stream with origin and authentication metadata
+	md := metadata.New(map[string]string{
+		"origin":          "client",
+		"connection-name": "web-client",
+		"agent-id":        agentID,
+		"authorization":   "Bearer " + accessToken,
+	})
+	stream, err := pb.NewTransportClient(conn).Connect(metadata.NewOutgoingContext(ctx, md))
+	if err != nil {
+		_ = conn.Close()
+		return nil, nil, fmt.Errorf("failed to create stream: %v", err)
+	}
+	return conn, stream, nil
+}
+
+// gatewayTransportCredentials connects with TLS when GATEWAY_TLS_CA is set,
+// it's the path of the CA that signed the certificate of the gateway.
+func gatewayTransportCredentials() (credentials.TransportCredentials, error) {
+	caFile := os.Getenv("GATEWAY_TLS_CA")
+	if caFile == "" {
+		return insecure.NewCredentials(), nil
+	}
+	pemData, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed reading GATEWAY_TLS_CA: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemData) {
+		return nil, fmt.Errorf("failed parsing GATEWAY_TLS_CA %s", caFile)
+	}
+	return credentials.NewTLS(&tls.Config{
+		RootCAs:    pool,
+		ServerName: os.Getenv("GATEWAY_TLS_SERVER_NAME"),
+	}), nil
+}
+
+// newSessionOpenPacket creates the packet that opens a session in the agent
+// with the credentials of the database.
+func newSessionOpenPacket(dbConfig *Database) (*pb.Packet, error) {
+	connParams := &pb.AgentConnectionParams{
+		ConnectionName: dbConfig.DatabaseName,
+		ConnectionType: dbConfig.Type,
+		UserID:         "web-user",
+		UserEmail:      "web@example.com",
+		ClientOrigin:   pb.ConnectionOriginClientAPI,
+		ClientVerb:     pb.ClientVerbExec,
+		EnvVars: map[string]any{
+			"envvar:HOST": base64Encode(dbConfig.Host),
+			"envvar:PORT": base64Encode(dbConfig.Port),
+			"envvar:USER": base64Encode(dbConfig.Username),
+			"envvar:PASS": base64Encode(dbConfig.Password),
+			"envvar:DB":   base64Encode(dbConfig.DBName),
+		},
+	}
+	encodedParams, err := encodeConnectionParams(connParams)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode params: %v", err)
+	}
+	return &pb.Packet{
+		Type: pbagent.SessionOpen,
+		Spec: map[string][]byte{
+			pb.SpecAgentConnectionParamsKey: encodedParams,
+			pb.SpecConnectionType:           []byte(dbConfig.Type),
+			pb.SpecFlowControlWindow:        []byte(strconv.Itoa(pb.DefaultFlowControlWindow)),
+		},
+	}, nil
+}
+
+// gatewaySession is a session opened in the gateway that tunnels
+// the connections of a native database driver through the agent.
+type gatewaySession struct {
+	conn      *grpc.ClientConn
+	stream    pb.Transport_ConnectClient
+	sessionID []byte
+	protocol  gatewayProtocol
+	// the receive window of the connections in the agent, the
+	// writes are not flow controlled if the agent doesn't support it
+	agentWindow int
+
+	sendMu sync.Mutex
+	mu     sync.Mutex
+	conns  map[string]*gatewayConn
+	closed bool
+	doneCh chan struct{}
+
+	exitCode int
+	err      error
+}
+
+// openGatewaySession opens a session and waits until the agent is ready
+// to accept connections.
+func openGatewaySession(ctx context.Context, dbConfig *Database) (*gatewaySession, error) {
+	protocol, ok := gatewayProtocols[dbConfig.Type]
+	if !ok {
+		return nil, fmt.Errorf("unsupported database type: %s", dbConfig.Type)
+	}
+	conn, stream, err := dialGateway(ctx, dbConfig.AgentID)
+	if err != nil {
+		return nil, err
+	}
+	s := &gatewaySession{
+		conn:     conn,
+		stream:   stream,
+		protocol: protocol,
+		conns:    map[string]*gatewayConn{},
+		doneCh:   make(chan struct{}),
+	}
+	openPkt, err := newSessionOpenPacket(dbConfig)
+	if err == nil {
+		monitoring.InjectTraceContext(ctx, openPkt.Spec)
+		err = stream.Send(openPkt)
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("failed to send SessionOpen: %v", err)
+	}
+
+	for {
+		pkt, err := stream.Recv()
+		if err != nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("stream error: %v", err)
+		}
+		switch pkt.Type {
+		case pbclient.SessionOpenOK:
+			s.sessionID = pkt.Spec[pb.SpecGatewaySessionID]
+			s.agentWindow = pb.ParseFlowControlWindow(pkt.Spec)
+			go s.recvLoop()
+			return s, nil
+		case pbclient.SessionClose:
+			_ = conn.Close()
+			if len(pkt.Payload) > 0 {
+				return nil, fmt.Errorf("failed opening session: %s", pkt.Payload)
+			}
+			return nil, fmt.Errorf("session closed before it was opened")
+		case pbclient.SessionOpenAgentOffline:
+			_ = conn.Close()
+			return nil, fmt.Errorf("agent is offline: %s", pkt.Payload)
+		}
+	}
+}
+
+func (s *gatewaySession) recvLoop() {
+	for {
+		pkt, err := s.stream.Recv()
+		if err != nil {
+			if err == io.EOF {
+				err = fmt.Errorf("gateway closed the stream")
+			}
+			s.shutdown(1, err)
+			return
+		}
+		connID := string(pkt.Spec[pb.SpecClientConnectionID])
+		switch pkt.Type {
+		case s.protocol.readType:
+			if c := s.getConn(connID); c != nil {
+				c.push(pkt.Payload)
+			}
+		case pbclient.TCPConnectionClose:
+			if c := s.getConn(connID); c != nil {
+				c.closeRead(io.EOF)
+			}
+		case pbclient.ConnectionCredit:
+			credits, err := pb.DecodeFlowCredits(pkt.Payload)
+			if c := s.getConn(connID); c != nil && c.sendWindow != nil && err == nil {
+				c.sendWindow.Grant(credits)
+			}
+		case pbclient.SessionClose:
+			exitCode, _ := strconv.Atoi(string(pkt.Spec[pb.SpecClientExitCodeKey]))
+			var sessionErr error
+			if len(pkt.Payload) > 0 {
+				sessionErr = errors.New(string(pkt.Payload))
+			}
+			s.shutdown(exitCode, sessionErr)
+			return
+		}
+	}
+}
+
+// shutdown records how the session has ended and closes the read side
+// of all the connections.
+func (s *gatewaySession) shutdown(exitCode int, err error) {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	s.exitCode = exitCode
+	s.err = err
+	conns := s.conns
+	s.conns = map[string]*gatewayConn{}
+	s.mu.Unlock()
+	for _, c := range conns {
+		c.closeRead(io.EOF)
+		c.closeSendWindow()
+	}
+	close(s.doneCh)
+}
+
+// exitStatus returns the exit code and the error reported by the agent when the session has ended
+func (s *gatewaySession) exitStatus() (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.exitCode, s.err
+}
+
+func (s *gatewaySession) getConn(connID string) *gatewayConn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.conns[connID]
+}
+
+func (s *gatewaySession) send(pktType string, connID string, payload []byte) error {
+	s.sendMu.Lock()
+	defer s.sendMu.Unlock()
+	spec := map[string][]byte{pb.SpecGatewaySessionID: s.sessionID}
+	if connID != "" {
+		spec[pb.SpecClientConnectionID] = []byte(connID)
+	}
+	return s.stream.Send(&pb.Packet{Type: pktType, Payload: payload, Spec: spec})
+}
+
+// DialContext opens a new connection in the agent, it has the signature
+// expected by the dial functions of the database drivers.
+func (s *gatewaySession) DialContext(ctx context.Context, _, _ string) (net.Conn, error) {
+	c := &gatewayConn{
+		session: s,
+		id:      uuid.NewString(),
+		notify:  make(chan struct{}, 1),
+		// the agent sends up to this window of data before receiving credits
+		credits: pb.NewFlowCredits(pb.DefaultFlowControlWindow),
+	}
+	if s.agentWindow > 0 {
+		c.sendWindow = pb.NewFlowWindow(s.agentWindow)
+	}
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil, fmt.Errorf("session is closed")
+	}
+	s.conns[c.id] = c
+	s.mu.Unlock()
+
+	if s.protocol.serverFirst {
+		if err := s.send(s.protocol.writeType, c.id, nil); err != nil {
+			_ = c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Close ends the session in the agent and releases the stream
+func (s *gatewaySession) Close() error {
+	s.mu.Lock()
+	conns := make([]*gatewayConn, 0, len(s.conns))
+	for _, c := range s.conns {
+		conns = append(conns, c)
+	}
+	s.mu.Unlock()
+	for _, c := range conns {
+		_ = c.Close()
+	}
+	_ = s.send(pbagent.SessionClose, "", nil)
+	_ = s.stream.CloseSend()
+	return s.conn.Close()
+}
+
+// gatewayConn is a net.Conn backed by a client connection of the session. The data
+// read by the driver is returned as credits to the agent, and the writes wait for the
+// credits of the agent when it supports the flow control.
+type gatewayConn struct {
+	session    *gatewaySession
+	id         string
+	credits    *pb.FlowCredits
+	sendWindow *pb.FlowWindow
+
+	mu           sync.Mutex
+	buf          bytes.Buffer
+	readErr      error
+	readDeadline time.Time
+	notify       chan struct{}
+	closeOnce    sync.Once
+}
+
+func (c *gatewayConn) push(data []byte) {
+	c.mu.Lock()
+	_, _ = c.buf.Write(data)
+	c.mu.Unlock()
+	c.wakeup()
+}
+
+func (c *gatewayConn) closeRead(err error) {
+	c.mu.Lock()
+	if c.readErr == nil {
+		c.readErr = err
+	}
+	c.mu.Unlock()
+	c.wakeup()
+}
+
+func (c *gatewayConn) wakeup() {
+	select {
+	case c.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (c *gatewayConn) Read(b []byte) (int, error) {
+	for {
+		c.mu.Lock()
+		if c.buf.Len() > 0 {
+			n, _ := c.buf.Read(b)
+			c.mu.Unlock()
+			c.returnCredits(n)
+			return n, nil
+		}
+		if c.readErr != nil {
+			err := c.readErr
+			c.mu.Unlock()
+			return 0, err
+		}
+		deadline := c.readDeadline
+		c.mu.Unlock()
+
+		if deadline.IsZero() {
+			<-c.notify
+			continue
+		}
+		timeout := time.Until(deadline)
+		if timeout <= 0 {
+			return 0, os.ErrDeadlineExceeded
+		}
+		timer := time.NewTimer(timeout)
+		select {
+		case <-c.notify:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+func (c *gatewayConn) Write(b []byte) (int, error) {
+	c.mu.Lock()
+	closed := c.readErr == net.ErrClosed
+	c.mu.Unlock()
+	if closed {
+		return 0, net.ErrClosed
+	}
+	if c.sendWindow == nil || len(b) == 0 {
+		if err := c.session.send(c.session.protocol.writeType, c.id, b); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	written := 0
+	for written < len(b) {
+		n, err := c.sendWindow.Acquire(len(b) - written)
+		if err != nil {
+			return written, net.ErrClosed
+		}
+		if err := c.session.send(c.session.protocol.writeType, c.id, b[written:written+n]); err != nil {
+			return written, err
+		}
+		written += n
+	}
+	return written, nil
+}
+
+// returnCredits sends the credits of the data read by the driver to the agent
+func (c *gatewayConn) returnCredits(n int) {
+	if credits := c.credits.Consume(n); credits > 0 {
+		_ = c.session.send(pbagent.ConnectionCredit, c.id, pb.EncodeFlowCredits(credits))
+	}
+}
+
+func (c *gatewayConn) closeSendWindow() {
+	if c.sendWindow != nil {
+		_ = c.sendWindow.Close()
+	}
+}
+
+func (c *gatewayConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.session.mu.Lock()
+		delete(c.session.conns, c.id)
+		c.session.mu.Unlock()
+		c.mu.Lock()
+		c.readErr = net.ErrClosed
+		c.mu.Unlock()
+		c.wakeup()
+		c.closeSendWindow()
+		_ = c.session.send(pbagent.TCPConnectionClose, c.id, nil)
+	})
+	return nil
+}
+
+func (c *gatewayConn) LocalAddr() net.Addr  { return tunnelAddr(c.id) }
+func (c *gatewayConn) RemoteAddr() net.Addr { return tunnelAddr(c.id) }
+
+func (c *gatewayConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *gatewayConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.readDeadline = t
+	c.mu.Unlock()
+	c.wakeup()
+	return nil
+}
+
+// SetWriteDeadline is a noop, writes are sent straight to the gateway stream
+func (c *gatewayConn) SetWriteDeadline(time.Time) error { return nil }
+
+type tunnelAddr string
+
+func (a tunnelAddr) Network() string { return "bifrost" }
+func (a tunnelAddr) String() string  { return string(a) }
@@ -4,22 +4,21 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
 	"os"
 	"time"
 
-	"github.com/google/uuid"
+	"github.com/bifrost/common/monitoring"
 	"github.com/rs/cors"
-	pb "github.com/bifrost/common/proto"
-	pbagent "github.com/bifrost/common/proto/agent"
-	pbclient "github.com/bifrost/common/proto/client"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
-	"google.golang.org/grpc/metadata"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("github.com/bifrost/api-server")
+
 func getEnv(key, defaultValue string) string {
 	if value := os.Getenv(key); value != "" {
 		return value
@@ -34,22 +33,36 @@ var (
 type ExecuteQueryRequest struct {
 	Query      string `json:"query"`
 	DatabaseID int    `json:"database_id"`
+	// downloads the results as a file: csv, jsonl or parquet
+	Export string `json:"export,omitempty"`
+	// stores the results of the json response, they're exported with /api/query-results/{id}/export
+	Store bool `json:"store,omitempty"`
 }
 
 type ExecuteQueryResponse struct {
-	Results  string `json:"results"`
-	ExitCode int    `json:"exitCode"`
-	Error    string `json:"error,omitempty"`
-	Duration string `json:"duration"`
+	ResultSets []ResultSet `json:"resultSets"`
+	ExitCode   int         `json:"exitCode"`
+	Error      string      `json:"error,omitempty"`
+	Duration   string      `json:"duration"`
+	// the id of the stored results
+	ResultID string `json:"resultId,omitempty"`
 }
 
-// executeQuery sends a query to the gateway and returns results
-func executeQuery(query string, databaseID int) (*ExecuteQueryResponse, error) {
+// executeQuery sends a query to the gateway, the result sets are written to w as they're read
+func executeQuery(ctx context.Context, query string, databaseID int, w resultWriter) (resp *ExecuteQueryResponse, err error) {
 	startTime := time.Now()
+	ctx, span := tracer.Start(ctx, "api.executeQuery",
+		trace.WithAttributes(attribute.Int("bifrost.database_id", databaseID)))
+	defer func() {
+		if err != nil {
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
 
 	// Fetch database credentials from the databases table
 	var dbConfig Database
-	err := db.QueryRow(`
+	err = db.QueryRow(`
 		SELECT id, database_name, type, agent_id, host, port, username, password, db_name
 		FROM databases
 		WHERE id = $1
@@ -60,140 +73,27 @@ func executeQuery(query string, databaseID int) (*ExecuteQueryResponse, error) {
 		return nil, fmt.Errorf("database not found or invalid database_id: %v", err)
 	}
 
-	// Connect to gateway
-	conn, err := grpc.Dial(gatewayAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
-	if err != nil {
-		return nil, fmt.Errorf("failed to connect to gateway: %v", err)
-	}
-	defer conn.Close()
-
-	client := pb.NewTransportClient(conn)
-
-	// Create client stream with origin and authentication metadata
-	// Use the agent_id from the database configuration
-	md := metadata.New(map[string]string{
-		"origin":          "client",
-		"connection-name": "web-client",
-		"agent-id":        dbConfig.AgentID,
-		"authorization":   "Bearer test-token-123",
-	})
-	ctx := metadata.NewOutgoingContext(context.Background(), md)
-
-	stream, err := client.Connect(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create stream: %v", err)
-	}
-
-	// Prepare connection parameters using database credentials
-	connParams := &pb.AgentConnectionParams{
-		ConnectionName: dbConfig.DatabaseName,
-		ConnectionType: dbConfig.Type,  // Use dynamic database type (mysql, postgres, mssql, mongodb)
-		UserID:         "web-user",
-		UserEmail:      "web@example.com",
-		ClientOrigin:   pb.ConnectionOriginClientAPI,
-		ClientVerb:     pb.ClientVerbExec,
-		EnvVars: map[string]any{
-			"envvar:HOST": base64Encode(dbConfig.Host),
-			"envvar:PORT": base64Encode(dbConfig.Port),
-			"envvar:USER": base64Encode(dbConfig.Username),
-			"envvar:PASS": base64Encode(dbConfig.Password),
-			"envvar:DB":   base64Encode(dbConfig.DBName),
-		},
-	}
-
-	encodedParams, err := encodeConnectionParams(connParams)
-	if err != nil {
-		return nil, fmt.Errorf("failed to encode params: %v", err)
-	}
-
-	sessionID := []byte(uuid.NewString())
-
-	// Send SessionOpen with connection type in spec
-	err = stream.Send(&pb.Packet{
-		Type:    pbagent.SessionOpen,
-		Payload: []byte(query),
-		Spec: map[string][]byte{
-			pb.SpecAgentConnectionParamsKey: encodedParams,
-			pb.SpecConnectionType:           []byte(dbConfig.Type),
-		},
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to send SessionOpen: %v", err)
-	}
-
-	var results string
-	exitCode := 0
-
-	// Determine the correct packet types based on database type
-	var sendPacketType, receivePacketType pb.PacketType
+	span.SetAttributes(
+		attribute.String("bifrost.connection_type", dbConfig.Type),
+		attribute.String("bifrost.agent_id", dbConfig.AgentID),
+	)
 	switch dbConfig.Type {
 	case "mysql":
-		sendPacketType = pbagent.MySQLConnectionWrite
-		receivePacketType = pbclient.MySQLConnectionWrite
+		resp, err = executeMySQLQuery(ctx, &dbConfig, query, w)
+	case "mongodb":
+		resp, err = executeMongoDBQuery(ctx, &dbConfig, query, w)
 	case "postgres":
-		sendPacketType = pbagent.PGConnectionWrite
-		receivePacketType = pbclient.PGConnectionWrite
+		resp, err = executePostgresQuery(ctx, &dbConfig, query, w)
 	case "mssql":
-		sendPacketType = pbagent.MSSQLConnectionWrite
-		receivePacketType = pbclient.MSSQLConnectionWrite
-	case "mongodb":
-		sendPacketType = pbagent.MongoDBConnectionWrite
-		receivePacketType = pbclient.MongoDBConnectionWrite
+		resp, err = executeMSSQLQuery(ctx, &dbConfig, query, w)
 	default:
 		return nil, fmt.Errorf("unsupported database type: %s", dbConfig.Type)
 	}
-
-	// Receive responses
-	for {
-		pkt, err := stream.Recv()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return nil, fmt.Errorf("stream error: %v", err)
-		}
-
-		switch pkt.Type {
-		case pbclient.SessionOpenOK:
-			sessionID = pkt.Spec[pb.SpecGatewaySessionID]
-
-			// Send query with the correct packet type for this database
-			err = stream.Send(&pb.Packet{
-				Type:    sendPacketType.String(),
-				Payload: []byte(query),
-				Spec: map[string][]byte{
-					pb.SpecGatewaySessionID:   sessionID,
-					pb.SpecClientConnectionID: []byte("web-conn-1"),
-				},
-			})
-			if err != nil {
-				return nil, fmt.Errorf("failed to send query: %v", err)
-			}
-
-		case receivePacketType.String():
-			// Query results
-			results = string(pkt.Payload)
-
-		case pbclient.SessionClose:
-			// Session closed, get exit code
-			exitCodeStr := string(pkt.Spec[pb.SpecClientExitCodeKey])
-			fmt.Sscanf(exitCodeStr, "%d", &exitCode)
-
-			duration := time.Since(startTime)
-			return &ExecuteQueryResponse{
-				Results:  results,
-				ExitCode: exitCode,
-				Duration: duration.String(),
-			}, nil
-		}
+	if err != nil {
+		return nil, err
 	}
-
-	duration := time.Since(startTime)
-	return &ExecuteQueryResponse{
-		Results:  results,
-		ExitCode: exitCode,
-		Duration: duration.String(),
-	}, nil
+	resp.Duration = time.Since(startTime).String()
+	return resp, nil
 }
 
 // HTTP Handlers
@@ -219,13 +119,53 @@ func handleExecuteQuery(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if req.Store && (req.Export != "" || streamContentType(r) != "") {
+		http.Error(w, "store is only supported with the json response", http.StatusBadRequest)
+		return
+	}
+
 	log.Printf("Executing query on database_id=%d: %s", req.DatabaseID, req.Query)
 
-	resp, err := executeQuery(req.Query, req.DatabaseID)
+	var export *resultExport
+	if req.Export != "" {
+		var err error
+		if export, err = newResultExport(w, req.Export, req.DatabaseID); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	startTime := time.Now()
+	var out resultWriter
+	var stream *resultStream
+	table := &resultTable{resultSets: []ResultSet{}}
+	if export != nil {
+		out = export
+	} else if stream = newResultStream(w, r); stream != nil {
+		out = stream
+	} else {
+		out = table
+	}
+	resp, err := executeQuery(r.Context(), req.Query, req.DatabaseID, out)
 	if err != nil {
 		resp = &ExecuteQueryResponse{
 			Error:    err.Error(),
 			ExitCode: 1,
+			Duration: time.Since(startTime).String(),
+		}
+	}
+	if export != nil {
+		export.finish(resp)
+		return
+	}
+	if stream != nil {
+		stream.finish(resp)
+		return
+	}
+	resp.ResultSets = table.resultSets
+	if req.Store && resp.ExitCode == 0 && resp.Error == "" {
+		if resp.ResultID, err = storeQueryResults(req.DatabaseID, req.Query, resp.ResultSets); err != nil {
+			resp.ExitCode, resp.Error = 1, fmt.Sprintf("failed storing the results: %v", err)
 		}
 	}
 
@@ -247,10 +187,21 @@ func main() {
 	}
 	defer CloseDB()
 
+	shutdownTracing, err := monitoring.NewOpenTracing("bifrost-api-server")
+	if err != nil {
+		log.Printf("Warning: tracing is disabled: %v", err)
+	}
+	defer shutdownTracing()
+
+	if err := loadJWTSigningKey(); err != nil {
+		log.Fatalf("Failed to load gateway signing key: %v", err)
+	}
+
 	mux := http.NewServeMux()
 
 	// Query execution endpoints
 	mux.HandleFunc("/api/execute-query", handleExecuteQuery)
+	mux.HandleFunc("GET /api/query-results/{id}/export", handleExportQueryResults)
 
 	// User management endpoints
 	mux.HandleFunc("/api/users", func(w http.ResponseWriter, r *http.Request) {
@@ -291,6 +242,8 @@ func main() {
 		switch r.Method {
 		case http.MethodGet:
 			handleGetAgent(w, r)
+		case http.MethodPost:
+			handleRotateAgentKey(w, r)
 		case http.MethodPut:
 			handleUpdateAgent(w, r)
 		case http.MethodDelete:
@@ -332,6 +285,7 @@ func main() {
 		AllowedOrigins:   []string{"http://localhost:5173", "http://localhost:3000"},
 		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 		AllowedHeaders:   []string{"Content-Type"},
+		ExposedHeaders:   []string{"Content-Disposition"},
 		AllowCredentials: true,
 	}).Handler(mux)
 
@@ -350,6 +304,7 @@ func main() {
 	log.Println("   - GET    /api/agents/:id")
 	log.Println("   - PUT    /api/agents/:id")
 	log.Println("   - DELETE /api/agents/:id")
+	log.Println("   - POST   /api/agents/:id/key")
 	log.Println("   Database Management:")
 	log.Println("   - GET    /api/databases")
 	log.Println("   - POST   /api/databases")
@@ -0,0 +1,825 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// executeMongoDBQuery runs a mongosh like expression with the native mongodb driver,
+// the connections are tunneled through the agent which authenticates in the server.
+// The documents are flattened into rows while the cursor is read.
+func executeMongoDBQuery(ctx context.Context, dbConfig *Database, query string, w resultWriter) (*ExecuteQueryResponse, error) {
+	mq, err := parseMongoQuery(query)
+	if err != nil {
+		return &ExecuteQueryResponse{ExitCode: 1, Error: err.Error()}, nil
+	}
+	sess, err := openGatewaySession(ctx, dbConfig)
+	if err != nil {
+		return nil, err
+	}
+	defer sess.Close()
+
+	port := dbConfig.Port
+	if port == "" {
+		port = "27017"
+	}
+	clientOpts := options.Client().
+		SetHosts([]string{net.JoinHostPort(dbConfig.Host, port)}).
+		SetDirect(true).
+		SetDialer(sess).
+		SetAppName("bifrost-api").
+		SetServerMonitoringMode(options.ServerMonitoringModePoll).
+		SetServerSelectionTimeout(15 * time.Second)
+	client, err := mongo.Connect(ctx, clientOpts)
+	if err != nil {
+		return nil, fmt.Errorf("failed connecting to mongodb: %v", err)
+	}
+	defer client.Disconnect(context.Background())
+
+	dbName := dbConfig.DBName
+	if mq.admin {
+		dbName = "admin"
+	}
+	docs := &mongoResultWriter{w: w, columns: map[string]int{}}
+	err = mq.run(ctx, client.Database(dbName), docs.write)
+	if err == nil {
+		err = docs.close()
+	}
+	if err != nil {
+		return queryError(sess, err), nil
+	}
+	return &ExecuteQueryResponse{}, nil
+}
+
+// mongoResultWriter flattens the documents into a result set, the top level fields are the
+// columns in the order they're found. Nested documents and arrays are relaxed extended json.
+// The type of a column is widened when a document has another type in the field.
+type mongoResultWriter struct {
+	w       resultWriter
+	columns map[string]int
+	types   []string
+	started bool
+	count   int64
+}
+
+func (m *mongoResultWriter) write(doc any) error {
+	d, ok := doc.(bson.D)
+	if !ok {
+		d = bson.D{{Key: "value", Value: doc}}
+	}
+	var added []ResultColumn
+	var widened []int
+	for _, elem := range d {
+		i, ok := m.columns[elem.Key]
+		if !ok {
+			m.columns[elem.Key] = len(m.columns)
+			m.types = append(m.types, mongoType(elem.Value))
+			added = append(added, ResultColumn{Name: elem.Key, Type: m.types[len(m.types)-1]})
+			continue
+		}
+		if typ := widenMongoType(m.types[i], mongoType(elem.Value)); typ != m.types[i] {
+			m.types[i] = typ
+			widened = append(widened, i)
+		}
+	}
+	switch {
+	case !m.started:
+		m.started = true
+		if added == nil {
+			added = []ResultColumn{}
+		}
+		if err := m.w.startResultSet(added); err != nil {
+			return err
+		}
+		if w, ok := m.w.(columnWidener); ok {
+			w.variableColumns()
+		}
+	case len(added) > 0:
+		if err := m.w.addColumns(added); err != nil {
+			return err
+		}
+	}
+	if w, ok := m.w.(columnWidener); ok {
+		for _, i := range widened {
+			if err := w.widenColumn(i, m.types[i]); err != nil {
+				return err
+			}
+		}
+	}
+	row := make([]any, len(m.columns))
+	for _, elem := range d {
+		val, err := mongoValue(elem.Value)
+		if err != nil {
+			return fmt.Errorf("failed encoding field %v: %v", elem.Key, err)
+		}
+		row[m.columns[elem.Key]] = val
+	}
+	m.count++
+	return m.w.writeRow(row)
+}
+
+// close ends the result set, an empty result set is written when there are no documents
+func (m *mongoResultWriter) close() error {
+	if !m.started {
+		if err := m.w.startResultSet([]ResultColumn{}); err != nil {
+			return err
+		}
+	}
+	return m.w.endResultSet(m.count, nil)
+}
+
+// mongoType returns the alias of the bson type of a value, as the $type operator
+func mongoType(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case primitive.ObjectID:
+		return "objectId"
+	case string:
+		return "string"
+	case int32:
+		return "int"
+	case int64:
+		return "long"
+	case float64:
+		return "double"
+	case bool:
+		return "bool"
+	case primitive.DateTime:
+		return "date"
+	case primitive.Decimal128:
+		return "decimal"
+	case bson.D, bson.M:
+		return "object"
+	case bson.A:
+		return "array"
+	case primitive.Binary:
+		return "binData"
+	case primitive.Timestamp:
+		return "timestamp"
+	case primitive.Regex:
+		return "regex"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// mongoNumberTypes are the numeric types in the order they're widened
+var mongoNumberTypes = map[string]int{"int": 1, "long": 2, "double": 3}
+
+// widenMongoType returns a type that holds the values of both types, the numbers are
+// widened up to double and the other mixed types are strings. Nulls have any type.
+func widenMongoType(typ, other string) string {
+	switch {
+	case typ == other || other == "null":
+		return typ
+	case typ == "null":
+		return other
+	case mongoNumberTypes[typ] > 0 && mongoNumberTypes[other] > 0:
+		if mongoNumberTypes[other] > mongoNumberTypes[typ] {
+			return other
+		}
+		return typ
+	}
+	return "string"
+}
+
+// mongoValue converts a field to its json representation, the scalar types are
+// converted to json values and the other types to relaxed extended json.
+func mongoValue(v any) (any, error) {
+	switch val := v.(type) {
+	case nil, string, bool, int32, int64:
+		return val, nil
+	case float64:
+		return convertValue("", val), nil
+	case primitive.ObjectID:
+		return val.Hex(), nil
+	case primitive.DateTime:
+		return val.Time().UTC().Format(time.RFC3339Nano), nil
+	case primitive.Decimal128:
+		return val.String(), nil
+	}
+	data, err := bson.MarshalExtJSON(bson.D{{Key: "v", Value: v}}, false, false)
+	if err != nil {
+		return nil, err
+	}
+	var field struct {
+		V json.RawMessage `json:"v"`
+	}
+	if err := json.Unmarshal(data, &field); err != nil {
+		return nil, err
+	}
+	return field.V, nil
+}
+
+// mongoCall is a method call of a mongosh expression, e.g.: find({name: "alice"})
+type mongoCall struct {
+	name string
+	args bson.A
+}
+
+// mongoQuery is a query parsed from the mongosh syntax
+type mongoQuery struct {
+	collection string
+	// database command, used when the collection is empty
+	command bson.D
+	admin   bool
+	method  mongoCall
+	// cursor modifiers: sort, limit, skip and projection
+	modifiers []mongoCall
+}
+
+// parseMongoQuery parses a command document or the expressions:
+//
+//	db.<collection>.<method>(<args>)[.<modifier>(<args>)...]
+//	db.getCollection("<collection>").<method>(<args>)
+//	db.runCommand(<document>), db.adminCommand(<document>)
+func parseMongoQuery(query string) (*mongoQuery, error) {
+	query = strings.TrimSuffix(strings.TrimSpace(query), ";")
+	if strings.HasPrefix(query, "{") {
+		args, err := parseMongoArgs(query)
+		if err != nil {
+			return nil, err
+		}
+		cmd, ok := args[0].(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("the command must be a document")
+		}
+		return &mongoQuery{command: cmd}, nil
+	}
+	if !strings.HasPrefix(query, "db.") {
+		return nil, fmt.Errorf("unsupported query, use a command document or db.<collection>.<method>(...)")
+	}
+	segments, err := splitMongoExpression(query[3:])
+	if err != nil {
+		return nil, err
+	}
+
+	mq := &mongoQuery{}
+	var collection []string
+	for _, seg := range segments {
+		if !seg.call {
+			if mq.method.name != "" {
+				return nil, fmt.Errorf("unexpected property %q", seg.name)
+			}
+			collection = append(collection, seg.name)
+			continue
+		}
+		args, err := parseMongoArgs(seg.args)
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing arguments of %v: %v", seg.name, err)
+		}
+		call := mongoCall{name: seg.name, args: args}
+		switch {
+		case mq.method.name != "":
+			mq.modifiers = append(mq.modifiers, call)
+		case len(collection) == 0 && seg.name == "getCollection":
+			name, _ := argAt(args, 0).(string)
+			if name == "" {
+				return nil, fmt.Errorf("getCollection requires the collection name")
+			}
+			collection = append(collection, name)
+		case len(collection) == 0 && (seg.name == "runCommand" || seg.name == "adminCommand"):
+			cmd, ok := argAt(args, 0).(bson.D)
+			if !ok {
+				return nil, fmt.Errorf("%v requires a command document", seg.name)
+			}
+			mq.command, mq.admin = cmd, seg.name == "adminCommand"
+			mq.method = call
+		case len(collection) == 0:
+			return nil, fmt.Errorf("unsupported database method %q", seg.name)
+		default:
+			mq.method = call
+		}
+	}
+	mq.collection = strings.Join(collection, ".")
+	if mq.method.name == "" {
+		return nil, fmt.Errorf("missing method, e.g.: db.%v.find()", mq.collection)
+	}
+	return mq, nil
+}
+
+// run executes the query, emit is called with each document of the result
+func (q *mongoQuery) run(ctx context.Context, db *mongo.Database, emit func(doc any) error) error {
+	if q.command != nil {
+		var reply bson.D
+		if err := db.RunCommand(ctx, q.command).Decode(&reply); err != nil {
+			return err
+		}
+		return emit(reply)
+	}
+
+	coll := db.Collection(q.collection)
+	args := q.method.args
+	switch q.method.name {
+	case "find":
+		findOpts := options.Find()
+		if projection := argAt(args, 1); projection != nil {
+			findOpts.SetProjection(projection)
+		}
+		for _, m := range q.modifiers {
+			switch m.name {
+			case "sort":
+				findOpts.SetSort(argAt(m.args, 0))
+			case "limit":
+				findOpts.SetLimit(toInt64(argAt(m.args, 0)))
+			case "skip":
+				findOpts.SetSkip(toInt64(argAt(m.args, 0)))
+			case "projection":
+				findOpts.SetProjection(argAt(m.args, 0))
+			case "pretty", "toArray":
+			default:
+				return fmt.Errorf("unsupported cursor method %q", m.name)
+			}
+		}
+		cursor, err := coll.Find(ctx, argDocument(args, 0), findOpts)
+		if err != nil {
+			return err
+		}
+		return cursorDocuments(ctx, cursor, emit)
+	case "findOne":
+		findOpts := options.FindOne()
+		if projection := argAt(args, 1); projection != nil {
+			findOpts.SetProjection(projection)
+		}
+		var doc bson.D
+		err := coll.FindOne(ctx, argDocument(args, 0), findOpts).Decode(&doc)
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return emit(doc)
+	case "aggregate":
+		pipeline, ok := argAt(args, 0).(bson.A)
+		if !ok {
+			return fmt.Errorf("aggregate requires a pipeline array")
+		}
+		cursor, err := coll.Aggregate(ctx, pipeline)
+		if err != nil {
+			return err
+		}
+		return cursorDocuments(ctx, cursor, emit)
+	case "countDocuments", "count":
+		count, err := coll.CountDocuments(ctx, argDocument(args, 0))
+		if err != nil {
+			return err
+		}
+		return emit(bson.D{{Key: "count", Value: count}})
+	case "estimatedDocumentCount":
+		count, err := coll.EstimatedDocumentCount(ctx)
+		if err != nil {
+			return err
+		}
+		return emit(bson.D{{Key: "count", Value: count}})
+	case "distinct":
+		field, _ := argAt(args, 0).(string)
+		values, err := coll.Distinct(ctx, field, argDocument(args, 1))
+		if err != nil {
+			return err
+		}
+		for _, v := range values {
+			if err := emit(bson.D{{Key: field, Value: v}}); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "insertOne":
+		res, err := coll.InsertOne(ctx, argDocument(args, 0))
+		if err != nil {
+			return err
+		}
+		return emit(bson.D{{Key: "acknowledged", Value: true}, {Key: "insertedId", Value: res.InsertedID}})
+	case "insertMany":
+		docs, ok := argAt(args, 0).(bson.A)
+		if !ok {
+			return fmt.Errorf("insertMany requires an array of documents")
+		}
+		res, err := coll.InsertMany(ctx, docs)
+		if err != nil {
+			return err
+		}
+		return emit(bson.D{{Key: "acknowledged", Value: true}, {Key: "insertedIds", Value: res.InsertedIDs}})
+	case "updateOne", "updateMany", "replaceOne":
+		var res *mongo.UpdateResult
+		var err error
+		switch q.method.name {
+		case "updateOne":
+			res, err = coll.UpdateOne(ctx, argDocument(args, 0), argAt(args, 1))
+		case "updateMany":
+			res, err = coll.UpdateMany(ctx, argDocument(args, 0), argAt(args, 1))
+		default:
+			res, err = coll.ReplaceOne(ctx, argDocument(args, 0), argAt(args, 1))
+		}
+		if err != nil {
+			return err
+		}
+		return emit(bson.D{
+			{Key: "acknowledged", Value: true},
+			{Key: "matchedCount", Value: res.MatchedCount},
+			{Key: "modifiedCount", Value: res.ModifiedCount},
+			{Key: "upsertedId", Value: res.UpsertedID},
+		})
+	case "deleteOne", "deleteMany":
+		var res *mongo.DeleteResult
+		var err error
+		if q.method.name == "deleteOne" {
+			res, err = coll.DeleteOne(ctx, argDocument(args, 0))
+		} else {
+			res, err = coll.DeleteMany(ctx, argDocument(args, 0))
+		}
+		if err != nil {
+			return err
+		}
+		return emit(bson.D{{Key: "acknowledged", Value: true}, {Key: "deletedCount", Value: res.DeletedCount}})
+	}
+	return fmt.Errorf("unsupported collection method %q", q.method.name)
+}
+
+// cursorDocuments emits the documents of the cursor as they are fetched
+func cursorDocuments(ctx context.Context, cursor *mongo.Cursor, emit func(doc any) error) error {
+	defer cursor.Close(ctx)
+	for cursor.Next(ctx) {
+		var doc bson.D
+		if err := cursor.Decode(&doc); err != nil {
+			return err
+		}
+		if err := emit(doc); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+func argAt(args bson.A, i int) any {
+	if i < len(args) {
+		return args[i]
+	}
+	return nil
+}
+
+// argDocument returns the argument at the position i or an empty document
+func argDocument(args bson.A, i int) any {
+	if v := argAt(args, i); v != nil {
+		return v
+	}
+	return bson.D{}
+}
+
+func toInt64(v any) int64 {
+	switch n := v.(type) {
+	case int32:
+		return int64(n)
+	case int64:
+		return n
+	case float64:
+		return int64(n)
+	}
+	return 0
+}
+
+type mongoSegment struct {
+	name string
+	args string
+	call bool
+}
+
+// splitMongoExpression splits an expression like coll.find({}).limit(1)
+// into the segments separated by dots.
+func splitMongoExpression(expr string) ([]mongoSegment, error) {
+	var segments []mongoSegment
+	for pos := 0; pos < len(expr); {
+		end := strings.IndexAny(expr[pos:], ".(")
+		if end == -1 {
+			end = len(expr) - pos
+		}
+		seg := mongoSegment{name: strings.TrimSpace(expr[pos : pos+end])}
+		if seg.name == "" {
+			return nil, fmt.Errorf("invalid expression at position %v", pos+3)
+		}
+		pos += end
+		if pos < len(expr) && expr[pos] == '(' {
+			closeAt, err := matchingParen(expr, pos)
+			if err != nil {
+				return nil, err
+			}
+			seg.call, seg.args = true, expr[pos+1:closeAt]
+			pos = closeAt + 1
+			for pos < len(expr) && expr[pos] == ' ' {
+				pos++
+			}
+		}
+		segments = append(segments, seg)
+		if pos < len(expr) {
+			if expr[pos] != '.' {
+				return nil, fmt.Errorf("unexpected character %q at position %v", expr[pos], pos+3)
+			}
+			pos++
+		}
+	}
+	return segments, nil
+}
+
+// matchingParen returns the position of the parenthesis that closes the one at pos
+func matchingParen(expr string, pos int) (int, error) {
+	depth := 0
+	for i := pos; i < len(expr); i++ {
+		switch expr[i] {
+		case '"', '\'', '/':
+			n, err := skipShellLiteral(expr[i:])
+			if err != nil {
+				return 0, err
+			}
+			i += n - 1
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("missing closing parenthesis")
+}
+
+// skipShellLiteral returns the length of the string or regular expression at the start of src
+func skipShellLiteral(src string) (int, error) {
+	if src[0] == '/' {
+		_, _, n, err := readShellRegex(src)
+		return n, err
+	}
+	_, n, err := readShellString(src)
+	return n, err
+}
+
+// parseMongoArgs parses the arguments of a method call as relaxed extended json
+func parseMongoArgs(args string) (bson.A, error) {
+	if strings.TrimSpace(args) == "" {
+		return nil, nil
+	}
+	normalized, err := mongoShellToExtJSON(args)
+	if err != nil {
+		return nil, err
+	}
+	var doc struct {
+		Args bson.A `bson:"args"`
+	}
+	if err := bson.UnmarshalExtJSON([]byte(`{"args":[`+normalized+`]}`), false, &doc); err != nil {
+		return nil, err
+	}
+	return doc.Args, nil
+}
+
+// mongoShellToExtJSON converts the shell notation to extended json: unquoted keys are quoted,
+// single quoted strings and regular expression literals are converted, trailing commas are
+// removed and the helpers ObjectId, ISODate, NumberLong, NumberInt, NumberDecimal and RegExp
+// are replaced by their extended json representation.
+func mongoShellToExtJSON(src string) (string, error) {
+	var out []byte
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '"' || c == '\'':
+			s, n, err := readShellString(src[i:])
+			if err != nil {
+				return "", err
+			}
+			out = strconv.AppendQuote(out, s)
+			i += n
+		case c == '/':
+			pattern, flags, n, err := readShellRegex(src[i:])
+			if err != nil {
+				return "", err
+			}
+			out = append(out, regexExtJSON(pattern, flags)...)
+			i += n
+		case c == '}' || c == ']':
+			// trailing commas are valid in the shell
+			out = bytes.TrimRight(out, " \t\r\n")
+			out = append(bytes.TrimSuffix(out, []byte(",")), c)
+			i++
+		case isIdentStart(c):
+			j := i
+			for j < len(src) && isIdentPart(src[j]) {
+				j++
+			}
+			ident := src[i:j]
+			k := skipSpaces(src, j)
+			switch {
+			case k < len(src) && src[k] == ':':
+				out = strconv.AppendQuote(out, ident)
+				i = j
+			case ident == "new":
+				i = k
+			case k < len(src) && src[k] == '(':
+				closeAt, err := matchingParen(src, k)
+				if err != nil {
+					return "", err
+				}
+				v, err := shellHelperToExtJSON(ident, src[k+1:closeAt])
+				if err != nil {
+					return "", err
+				}
+				out = append(out, v...)
+				i = closeAt + 1
+			default:
+				out = append(out, ident...)
+				i = j
+			}
+		default:
+			out = append(out, c)
+			i++
+		}
+	}
+	return string(out), nil
+}
+
+// shellDateLayouts are the formats accepted by ISODate, the dates without a time zone are UTC
+var shellDateLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+}
+
+func shellHelperToExtJSON(name, args string) (string, error) {
+	values, err := shellHelperArgs(args)
+	if err != nil {
+		return "", fmt.Errorf("invalid arguments of %v(): %v", name, err)
+	}
+	value := ""
+	if len(values) > 0 {
+		value = values[0]
+	}
+	switch name {
+	case "ObjectId":
+		if value == "" {
+			value = primitive.NewObjectID().Hex()
+		}
+		return fmt.Sprintf(`{"$oid":%q}`, value), nil
+	case "ISODate", "Date":
+		if value == "" {
+			return fmt.Sprintf(`{"$date":%q}`, time.Now().UTC().Format(time.RFC3339Nano)), nil
+		}
+		// milliseconds since the epoch
+		if _, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return fmt.Sprintf(`{"$date":{"$numberLong":%q}}`, value), nil
+		}
+		for _, layout := range shellDateLayouts {
+			if t, err := time.Parse(layout, value); err == nil {
+				return fmt.Sprintf(`{"$date":%q}`, t.UTC().Format(time.RFC3339Nano)), nil
+			}
+		}
+		return "", fmt.Errorf("invalid date %q", value)
+	case "NumberLong":
+		return fmt.Sprintf(`{"$numberLong":%q}`, value), nil
+	case "NumberInt":
+		return fmt.Sprintf(`{"$numberInt":%q}`, value), nil
+	case "NumberDecimal":
+		return fmt.Sprintf(`{"$numberDecimal":%q}`, value), nil
+	case "RegExp":
+		flags := ""
+		if len(values) > 1 {
+			flags = values[1]
+		}
+		return regexExtJSON(value, flags), nil
+	}
+	return "", fmt.Errorf("unsupported shell helper %v()", name)
+}
+
+// shellHelperArgs returns the arguments of a helper, they are strings or numbers
+func shellHelperArgs(args string) ([]string, error) {
+	var values []string
+	for i := skipSpaces(args, 0); i < len(args); i = skipSpaces(args, i) {
+		if len(values) > 0 {
+			if args[i] != ',' {
+				return nil, fmt.Errorf("unexpected character %q", args[i])
+			}
+			if i = skipSpaces(args, i+1); i == len(args) {
+				break
+			}
+		}
+		if args[i] == '"' || args[i] == '\'' {
+			s, n, err := readShellString(args[i:])
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, s)
+			i += n
+			continue
+		}
+		j := i
+		for j < len(args) && args[j] != ',' && args[j] != ' ' {
+			j++
+		}
+		values = append(values, args[i:j])
+		i = j
+	}
+	return values, nil
+}
+
+// regexExtJSON returns the extended json of a regular expression, the options must be sorted
+func regexExtJSON(pattern, flags string) string {
+	options := []byte(flags)
+	slices.Sort(options)
+	return fmt.Sprintf(`{"$regularExpression":{"pattern":%s,"options":%s}}`,
+		strconv.Quote(pattern), strconv.Quote(string(options)))
+}
+
+// readShellString reads a quoted string returning its value and the number of bytes read
+func readShellString(src string) (string, int, error) {
+	quote := src[0]
+	var out strings.Builder
+	for i := 1; i < len(src); i++ {
+		c := src[i]
+		switch {
+		case c == '\\' && i+1 < len(src):
+			i++
+			switch src[i] {
+			case 'n':
+				out.WriteByte('\n')
+			case 't':
+				out.WriteByte('\t')
+			case 'r':
+				out.WriteByte('\r')
+			case 'b':
+				out.WriteByte('\b')
+			case 'f':
+				out.WriteByte('\f')
+			case '0':
+				out.WriteByte(0)
+			case 'u':
+				if i+4 >= len(src) {
+					return "", 0, fmt.Errorf("invalid unicode escape")
+				}
+				r, err := strconv.ParseUint(src[i+1:i+5], 16, 16)
+				if err != nil {
+					return "", 0, fmt.Errorf("invalid unicode escape \\u%v", src[i+1:i+5])
+				}
+				out.WriteRune(rune(r))
+				i += 4
+			default:
+				out.WriteByte(src[i])
+			}
+		case c == quote:
+			return out.String(), i + 1, nil
+		default:
+			out.WriteByte(c)
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string")
+}
+
+// readShellRegex reads a regular expression literal (/pattern/flags) returning the pattern,
+// the flags and the number of bytes read. The escapes are kept in the pattern.
+func readShellRegex(src string) (string, string, int, error) {
+	inClass := false
+	for i := 1; i < len(src); i++ {
+		switch c := src[i]; {
+		case c == '\\' && i+1 < len(src):
+			i++
+		case c == '\n':
+			return "", "", 0, fmt.Errorf("unterminated regular expression")
+		case c == '[':
+			inClass = true
+		case c == ']':
+			inClass = false
+		case c == '/' && !inClass:
+			j := i + 1
+			for j < len(src) && strings.IndexByte("dgimsuy", src[j]) >= 0 {
+				j++
+			}
+			return src[1:i], src[i+1 : j], j, nil
+		}
+	}
+	return "", "", 0, fmt.Errorf("unterminated regular expression")
+}
+
+func skipSpaces(src string, i int) int {
+	for i < len(src) && (src[i] == ' ' || src[i] == '\t' || src[i] == '\n' || src[i] == '\r') {
+		i++
+	}
+	return i
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isIdentPart(c byte) bool {
+	return isIdentStart(c) || c == '.' || (c >= '0' && c <= '9')
+}
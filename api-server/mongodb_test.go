@@ -0,0 +1,216 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func mustObjectID(hex string) primitive.ObjectID {
+	id, err := primitive.ObjectIDFromHex(hex)
+	if err != nil {
+		panic(err)
+	}
+	return id
+}
+
+func TestParseMongoQuery(t *testing.T) {
+	for _, tt := range []struct {
+		msg   string
+		query string
+		want  *mongoQuery
+	}{
+		{
+			msg:   "it must parse the method, the arguments and the cursor modifiers",
+			query: `db.users.find({age: {$gt: 30}}, {name: 1}).sort({name: -1}).limit(10)`,
+			want: &mongoQuery{
+				collection: "users",
+				method: mongoCall{name: "find", args: bson.A{
+					bson.D{{Key: "age", Value: bson.D{{Key: "$gt", Value: int32(30)}}}},
+					bson.D{{Key: "name", Value: int32(1)}},
+				}},
+				modifiers: []mongoCall{
+					{name: "sort", args: bson.A{bson.D{{Key: "name", Value: int32(-1)}}}},
+					{name: "limit", args: bson.A{int32(10)}},
+				},
+			},
+		},
+		{
+			msg:   "it must join the collection names with dots",
+			query: `db.app.logs.find()`,
+			want:  &mongoQuery{collection: "app.logs", method: mongoCall{name: "find"}},
+		},
+		{
+			msg:   "it must ignore the spaces and the semicolon at the end",
+			query: "  db.users.countDocuments();\n",
+			want:  &mongoQuery{collection: "users", method: mongoCall{name: "countDocuments"}},
+		},
+		{
+			msg:   "it must use the collection of getCollection",
+			query: `db.getCollection("my-coll").findOne({_id: ObjectId("65a1b2c3d4e5f6a7b8c9d0e1")})`,
+			want: &mongoQuery{
+				collection: "my-coll",
+				method: mongoCall{name: "findOne", args: bson.A{
+					bson.D{{Key: "_id", Value: mustObjectID("65a1b2c3d4e5f6a7b8c9d0e1")}},
+				}},
+			},
+		},
+		{
+			msg:   "it must not split the expression in dots and parenthesis of strings",
+			query: `db.users.find({email: "a.b(c)@example.com", note: 'x)y'})`,
+			want: &mongoQuery{
+				collection: "users",
+				method: mongoCall{name: "find", args: bson.A{
+					bson.D{{Key: "email", Value: "a.b(c)@example.com"}, {Key: "note", Value: "x)y"}},
+				}},
+			},
+		},
+		{
+			msg:   "it must parse regular expression literals",
+			query: `db.users.find({name: /^al(i|e)\/x/i})`,
+			want: &mongoQuery{
+				collection: "users",
+				method: mongoCall{name: "find", args: bson.A{
+					bson.D{{Key: "name", Value: primitive.Regex{Pattern: `^al(i|e)\/x`, Options: "i"}}},
+				}},
+			},
+		},
+		{
+			msg:   "it must parse database commands",
+			query: `db.runCommand({ping: 1})`,
+			want: &mongoQuery{
+				command: bson.D{{Key: "ping", Value: int32(1)}},
+				method:  mongoCall{name: "runCommand", args: bson.A{bson.D{{Key: "ping", Value: int32(1)}}}},
+			},
+		},
+		{
+			msg:   "it must run admin commands in the admin database",
+			query: `db.adminCommand({listDatabases: 1})`,
+			want: &mongoQuery{
+				command: bson.D{{Key: "listDatabases", Value: int32(1)}},
+				admin:   true,
+				method:  mongoCall{name: "adminCommand", args: bson.A{bson.D{{Key: "listDatabases", Value: int32(1)}}}},
+			},
+		},
+		{
+			msg:   "it must parse command documents",
+			query: `{listCollections: 1, nameOnly: true}`,
+			want:  &mongoQuery{command: bson.D{{Key: "listCollections", Value: int32(1)}, {Key: "nameOnly", Value: true}}},
+		},
+	} {
+		t.Run(tt.msg, func(t *testing.T) {
+			got, err := parseMongoQuery(tt.query)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParseMongoQueryErrors(t *testing.T) {
+	for _, tt := range []struct {
+		msg     string
+		query   string
+		wantErr string
+	}{
+		{msg: "it must fail without a method", query: `db.users`, wantErr: "missing method, e.g.: db.users.find()"},
+		{msg: "it must fail with shell commands", query: `show dbs`, wantErr: "unsupported query, use a command document or db.<collection>.<method>(...)"},
+		{msg: "it must fail with unbalanced parenthesis", query: `db.users.find({a: 1}`, wantErr: "missing closing parenthesis"},
+		{msg: "it must fail with unterminated strings", query: `db.users.find({a: "x})`, wantErr: "unterminated string"},
+		{msg: "it must fail with unknown helpers", query: `db.users.find({a: UUID("x")})`, wantErr: "failed parsing arguments of find: unsupported shell helper UUID()"},
+		{msg: "it must fail with properties after the method", query: `db.users.find().length`, wantErr: `unexpected property "length"`},
+		{msg: "it must fail with unknown database methods", query: `db.dropDatabase()`, wantErr: `unsupported database method "dropDatabase"`},
+		{msg: "it must fail when the command is not a document", query: `db.runCommand("ping")`, wantErr: "runCommand requires a command document"},
+		{msg: "it must fail with unterminated regular expressions", query: `db.users.find({a: /abc})`, wantErr: "unterminated regular expression"},
+		{msg: "it must fail with invalid dates", query: `db.users.find({a: ISODate("yesterday")})`, wantErr: `failed parsing arguments of find: invalid date "yesterday"`},
+	} {
+		t.Run(tt.msg, func(t *testing.T) {
+			_, err := parseMongoQuery(tt.query)
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestParseMongoArgs(t *testing.T) {
+	for _, tt := range []struct {
+		msg  string
+		args string
+		want bson.A
+	}{
+		{
+			msg:  "it must quote the keys and the single quoted strings",
+			args: `{name: 'it\'s', "a.b": 1, c.d: "x", $or: [{e: null}]}`,
+			want: bson.A{bson.D{
+				{Key: "name", Value: "it's"},
+				{Key: "a.b", Value: int32(1)},
+				{Key: "c.d", Value: "x"},
+				{Key: "$or", Value: bson.A{bson.D{{Key: "e", Value: nil}}}},
+			}},
+		},
+		{
+			msg:  "it must decode the escape sequences of strings",
+			args: `'café\n\ttab', "quote\"d", '\u00e9\/'`,
+			want: bson.A{"café\n\ttab", `quote"d`, "é/"},
+		},
+		{
+			msg:  "it must accept trailing commas",
+			args: `{a: [1, 2,], b: 3,}`,
+			want: bson.A{bson.D{{Key: "a", Value: bson.A{int32(1), int32(2)}}, {Key: "b", Value: int32(3)}}},
+		},
+		{
+			msg:  "it must convert the number helpers",
+			args: `NumberLong("9007199254740993"), NumberInt(7), NumberDecimal("1.50"), 2.5`,
+			want: bson.A{int64(9007199254740993), int32(7), mustDecimal("1.50"), 2.5},
+		},
+		{
+			msg:  "it must convert the date helpers",
+			args: `ISODate("2024-01-02T03:04:05Z"), new Date("2024-01-02T03:04:05.123Z"), ISODate('2024-01-02'), new Date(1704164645000)`,
+			want: bson.A{
+				primitive.NewDateTimeFromTime(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)),
+				primitive.NewDateTimeFromTime(time.Date(2024, 1, 2, 3, 4, 5, 123e6, time.UTC)),
+				primitive.NewDateTimeFromTime(time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)),
+				primitive.NewDateTimeFromTime(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)),
+			},
+		},
+		{
+			msg:  "it must convert the regular expression helpers",
+			args: `/a"b/, RegExp("^x", "m"), /[/]/g`,
+			want: bson.A{
+				primitive.Regex{Pattern: `a"b`},
+				primitive.Regex{Pattern: "^x", Options: "m"},
+				primitive.Regex{Pattern: "[/]", Options: "g"},
+			},
+		},
+		{
+			msg:  "it must return no arguments for empty calls",
+			args: "  ",
+			want: nil,
+		},
+	} {
+		t.Run(tt.msg, func(t *testing.T) {
+			got, err := parseMongoArgs(tt.args)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParseMongoArgsObjectID(t *testing.T) {
+	got, err := parseMongoArgs(`ObjectId()`)
+	assert.Nil(t, err)
+	if assert.Len(t, got, 1) {
+		id, ok := got[0].(primitive.ObjectID)
+		assert.True(t, ok, "expected an object id, got %T", got[0])
+		assert.False(t, id.IsZero())
+	}
+}
+
+func mustDecimal(s string) primitive.Decimal128 {
+	d, err := primitive.ParseDecimal128(s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
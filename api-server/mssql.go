@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net"
+	"net/url"
+
+	mssql "github.com/microsoft/go-mssqldb"
+)
+
+// executeMSSQLQuery runs the query with the native sql server driver, the connections
+// are tunneled through the agent which replaces the credentials of the login.
+func executeMSSQLQuery(ctx context.Context, dbConfig *Database, query string, w resultWriter) (*ExecuteQueryResponse, error) {
+	sess, err := openGatewaySession(ctx, dbConfig)
+	if err != nil {
+		return nil, err
+	}
+	defer sess.Close()
+
+	// the password is not required and the encryption is negotiated by the agent,
+	// the connection with the agent is protected by the gateway
+	params := url.Values{}
+	params.Set("encrypt", "disable")
+	if dbConfig.DBName != "" {
+		params.Set("database", dbConfig.DBName)
+	}
+	dsn := (&url.URL{
+		Scheme:   "sqlserver",
+		User:     url.User(dbConfig.Username),
+		Host:     net.JoinHostPort(dbConfig.Host, dbConfig.Port),
+		RawQuery: params.Encode(),
+	}).String()
+	connector, err := mssql.NewConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	connector.Dialer = sess
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	if err := queryResultSets(ctx, db, query, w); err != nil {
+		return queryError(sess, err), nil
+	}
+	return &ExecuteQueryResponse{}, nil
+}
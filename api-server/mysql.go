@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// executeMySQLQuery runs the query with the native mysql driver, the connections
+// are tunneled through the agent which authenticates with the database credentials.
+func executeMySQLQuery(ctx context.Context, dbConfig *Database, query string, w resultWriter) (*ExecuteQueryResponse, error) {
+	sess, err := openGatewaySession(ctx, dbConfig)
+	if err != nil {
+		return nil, err
+	}
+	defer sess.Close()
+
+	cfg := mysql.NewConfig()
+	cfg.Net = "tcp"
+	cfg.Addr = net.JoinHostPort(dbConfig.Host, dbConfig.Port)
+	// the password is not required, the agent replaces the credentials
+	cfg.User = dbConfig.Username
+	cfg.DBName = dbConfig.DBName
+	cfg.MultiStatements = true
+	cfg.DialFunc = sess.DialContext
+	connector, err := mysql.NewConnector(cfg)
+	if err != nil {
+		return nil, err
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	if err := queryResultSets(ctx, db, query, w); err != nil {
+		return queryError(sess, err), nil
+	}
+	return &ExecuteQueryResponse{}, nil
+}
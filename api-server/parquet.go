@@ -0,0 +1,298 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// the kinds of the parquet columns, the types of the databases are mapped to them
+const (
+	parquetString = iota
+	parquetInt
+	parquetUint
+	parquetDouble
+	parquetBool
+	parquetDecimal
+	parquetTimestamp
+	parquetDate
+	parquetJSON
+	parquetBinary
+)
+
+// parquetKinds maps the type names of the sql drivers and the bson types of mongodb, the
+// types not listed (e.g.: VARCHAR, UUID, TIME, objectId) are strings.
+var parquetKinds = map[string]int{
+	"INT": parquetInt, "INTEGER": parquetInt, "TINYINT": parquetInt, "SMALLINT": parquetInt,
+	"MEDIUMINT": parquetInt, "BIGINT": parquetInt, "YEAR": parquetInt, "INT2": parquetInt,
+	"INT4": parquetInt, "INT8": parquetInt, "UNSIGNED INT": parquetInt, "UNSIGNED TINYINT": parquetInt,
+	"UNSIGNED SMALLINT": parquetInt, "UNSIGNED MEDIUMINT": parquetInt, "UNSIGNED BIGINT": parquetUint,
+	"FLOAT": parquetDouble, "DOUBLE": parquetDouble, "REAL": parquetDouble, "FLOAT4": parquetDouble,
+	"FLOAT8": parquetDouble,
+	// sql server bit columns are booleans
+	"BOOL": parquetBool, "BOOLEAN": parquetBool, "BIT": parquetBool,
+	"DECIMAL": parquetDecimal, "NUMERIC": parquetDecimal, "MONEY": parquetDecimal, "SMALLMONEY": parquetDecimal,
+	"DATETIME": parquetTimestamp, "DATETIME2": parquetTimestamp, "SMALLDATETIME": parquetTimestamp,
+	"DATETIMEOFFSET": parquetTimestamp, "TIMESTAMP": parquetTimestamp, "TIMESTAMPTZ": parquetTimestamp,
+	"DATE": parquetDate,
+	"JSON": parquetJSON, "JSONB": parquetJSON,
+	"BLOB": parquetBinary, "TINYBLOB": parquetBinary, "MEDIUMBLOB": parquetBinary, "LONGBLOB": parquetBinary,
+	"BINARY": parquetBinary, "VARBINARY": parquetBinary, "BYTEA": parquetBinary, "IMAGE": parquetBinary,
+	"GEOMETRY": parquetBinary,
+	"int":      parquetInt, "long": parquetInt, "double": parquetDouble, "bool": parquetBool,
+	"date": parquetTimestamp, "object": parquetJSON, "array": parquetJSON, "binData": parquetJSON,
+}
+
+// parquetTimeLayouts are the formats of the dates, the values of the drivers that return
+// time.Time are RFC 3339 and mysql returns the dates as text.
+var parquetTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02",
+}
+
+// parquetColumn is an optional column of the file, the decimals with a precision
+// of up to 18 digits are stored as int64, the bigger ones are strings.
+type parquetColumn struct {
+	name  string
+	kind  int
+	scale int64
+}
+
+func newParquetColumn(column ResultColumn) (parquetColumn, parquet.Node) {
+	c := parquetColumn{name: column.Name, kind: parquetKinds[column.Type], scale: column.Scale}
+	if c.kind == parquetDecimal && (column.Precision <= 0 || column.Precision > 18) {
+		c.kind = parquetString
+	}
+	var node parquet.Node
+	switch c.kind {
+	case parquetInt:
+		node = parquet.Int(64)
+	case parquetUint:
+		node = parquet.Uint(64)
+	case parquetDouble:
+		node = parquet.Leaf(parquet.DoubleType)
+	case parquetBool:
+		node = parquet.Leaf(parquet.BooleanType)
+	case parquetDecimal:
+		node = parquet.Decimal(int(column.Scale), int(column.Precision), parquet.Int64Type)
+	case parquetTimestamp:
+		node = parquet.Timestamp(parquet.Microsecond)
+	case parquetDate:
+		node = parquet.Date()
+	case parquetJSON:
+		node = parquet.JSON()
+	case parquetBinary:
+		node = parquet.Leaf(parquet.ByteArrayType)
+	default:
+		node = parquet.String()
+	}
+	return c, parquet.Optional(node)
+}
+
+// value converts a value of the result set to the physical type of the column
+func (c parquetColumn) value(v any) (parquet.Value, error) {
+	if v == nil {
+		return parquet.NullValue(), nil
+	}
+	switch c.kind {
+	case parquetInt:
+		n, err := parquetInt64(v)
+		return parquet.Int64Value(n), err
+	case parquetUint:
+		if n, ok := v.(uint64); ok {
+			return parquet.Int64Value(int64(n)), nil
+		}
+		n, err := strconv.ParseUint(parquetText(v), 10, 64)
+		return parquet.Int64Value(int64(n)), err
+	case parquetDouble:
+		switch n := v.(type) {
+		case float64:
+			return parquet.DoubleValue(n), nil
+		case float32:
+			return parquet.DoubleValue(float64(n)), nil
+		}
+		// NaN and infinity are strings in the result sets
+		f, err := strconv.ParseFloat(parquetText(v), 64)
+		return parquet.DoubleValue(f), err
+	case parquetBool:
+		switch b := v.(type) {
+		case bool:
+			return parquet.BooleanValue(b), nil
+		case []byte:
+			// mysql bit columns, true when any bit is set
+			return parquet.BooleanValue(strings.Trim(string(b), "\x00") != ""), nil
+		}
+		b, err := strconv.ParseBool(parquetText(v))
+		return parquet.BooleanValue(b), err
+	case parquetDecimal:
+		n, err := parseDecimal(parquetText(v), c.scale)
+		return parquet.Int64Value(n), err
+	case parquetTimestamp, parquetDate:
+		t, ok, err := parseTime(parquetText(v))
+		if err != nil || !ok {
+			return parquet.NullValue(), err
+		}
+		if c.kind == parquetDate {
+			days := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).Unix() / 86400
+			return parquet.Int32Value(int32(days)), nil
+		}
+		return parquet.Int64Value(t.UnixMicro()), nil
+	case parquetBinary:
+		if b, ok := v.([]byte); ok {
+			return parquet.ByteArrayValue(b), nil
+		}
+	case parquetJSON:
+		if raw, ok := v.(json.RawMessage); ok {
+			return parquet.ByteArrayValue(raw), nil
+		}
+		data, err := json.Marshal(v)
+		return parquet.ByteArrayValue(data), err
+	}
+	return parquet.ByteArrayValue([]byte(parquetText(v))), nil
+}
+
+func parquetInt64(v any) (int64, error) {
+	switch n := v.(type) {
+	case int64:
+		return n, nil
+	case int32:
+		return int64(n), nil
+	case int:
+		return int64(n), nil
+	case uint64:
+		if n > math.MaxInt64 {
+			return 0, fmt.Errorf("integer %d overflows int64", n)
+		}
+		return int64(n), nil
+	case float64:
+		if n == math.Trunc(n) {
+			return int64(n), nil
+		}
+	}
+	return strconv.ParseInt(parquetText(v), 10, 64)
+}
+
+// parquetText returns the text of a value as in the csv exports
+func parquetText(v any) string {
+	switch val := v.(type) {
+	case string:
+		return val
+	case json.RawMessage:
+		return string(val)
+	case []byte:
+		return string(val)
+	}
+	text, _ := csvField(v)
+	return text
+}
+
+// parseDecimal returns the unscaled value of a decimal (e.g.: 12.50 with scale 2 is 1250)
+func parseDecimal(s string, scale int64) (int64, error) {
+	digits, fraction, _ := strings.Cut(strings.TrimSpace(s), ".")
+	if int64(len(fraction)) > scale {
+		if strings.Trim(fraction[scale:], "0") != "" {
+			return 0, fmt.Errorf("decimal %s has more than %d fractional digits", s, scale)
+		}
+		fraction = fraction[:scale]
+	}
+	fraction += strings.Repeat("0", int(scale)-len(fraction))
+	n, err := strconv.ParseInt(digits+fraction, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid decimal %s", s)
+	}
+	return n, nil
+}
+
+// parseTime parses the dates of the drivers, the zero dates of mysql (0000-00-00) are nulls
+func parseTime(s string) (time.Time, bool, error) {
+	if strings.HasPrefix(s, "0000-00-00") {
+		return time.Time{}, false, nil
+	}
+	for _, layout := range parquetTimeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, true, nil
+		}
+	}
+	return time.Time{}, false, fmt.Errorf("invalid date %s", s)
+}
+
+// parquetEncoder writes a row group with every batch of rows, the footer is written on close
+type parquetEncoder struct {
+	w       io.Writer
+	writer  *parquet.Writer
+	columns []parquetColumn
+	rows    []parquet.Row
+}
+
+func (p *parquetEncoder) header(columns []ResultColumn) error {
+	fields := make([]parquet.Field, len(columns))
+	p.columns = make([]parquetColumn, len(columns))
+	for i, name := range uniqueColumnNames(columns) {
+		column, node := newParquetColumn(columns[i])
+		p.columns[i] = column
+		fields[i] = parquetField{Node: node, name: name}
+	}
+	schema := parquet.NewSchema("query", parquetGroup{fields: fields})
+	p.writer = parquet.NewWriter(p.w, schema, parquet.Compression(&parquet.Snappy))
+	return nil
+}
+
+func (p *parquetEncoder) writeRows(rows [][]any) error {
+	p.rows = p.rows[:0]
+	for _, row := range rows {
+		prow := make(parquet.Row, len(row))
+		for i, v := range row {
+			value, err := p.columns[i].value(v)
+			if err != nil {
+				return fmt.Errorf("failed converting the column %v to parquet: %v", p.columns[i].name, err)
+			}
+			definitionLevel := 1
+			if value.IsNull() {
+				definitionLevel = 0
+			}
+			prow[i] = value.Level(0, definitionLevel, i)
+		}
+		p.rows = append(p.rows, prow)
+	}
+	if _, err := p.writer.WriteRows(p.rows); err != nil {
+		return err
+	}
+	return p.writer.Flush()
+}
+
+func (p *parquetEncoder) close() error {
+	if p.writer == nil {
+		// a file without columns when the query has no rows to export
+		if err := p.header([]ResultColumn{}); err != nil {
+			return err
+		}
+	}
+	return p.writer.Close()
+}
+
+// parquetGroup keeps the fields in the order of the columns, parquet.Group sorts them by name
+type parquetGroup struct {
+	parquet.Group
+	fields []parquet.Field
+}
+
+func (g parquetGroup) Fields() []parquet.Field { return g.fields }
+
+type parquetField struct {
+	parquet.Node
+	name string
+}
+
+func (f parquetField) Name() string { return f.name }
+
+// Value is used to deconstruct go values, the rows are written as values
+func (f parquetField) Value(base reflect.Value) reflect.Value { return reflect.Value{} }
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net"
+	"net/url"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// pgDialer adapts the gateway session to the dialer of the postgres driver
+type pgDialer struct {
+	sess *gatewaySession
+}
+
+func (d pgDialer) Dial(network, address string) (net.Conn, error) {
+	return d.sess.DialContext(context.Background(), network, address)
+}
+
+func (d pgDialer) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return d.sess.DialContext(ctx, network, address)
+}
+
+func (d pgDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return d.sess.DialContext(ctx, network, address)
+}
+
+// executePostgresQuery runs the query with the native postgres driver, the connections
+// are tunneled through the agent which authenticates with the database credentials.
+func executePostgresQuery(ctx context.Context, dbConfig *Database, query string, w resultWriter) (*ExecuteQueryResponse, error) {
+	sess, err := openGatewaySession(ctx, dbConfig)
+	if err != nil {
+		return nil, err
+	}
+	defer sess.Close()
+
+	// the password is not required and ssl is handled by the agent,
+	// the connection with the agent is protected by the gateway
+	dsn := (&url.URL{
+		Scheme:   "postgres",
+		User:     url.User(dbConfig.Username),
+		Host:     net.JoinHostPort(dbConfig.Host, dbConfig.Port),
+		Path:     "/" + dbConfig.DBName,
+		RawQuery: "sslmode=disable",
+	}).String()
+	connector, err := pq.NewConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	connector.Dialer(pgDialer{sess: sess})
+	db := sql.OpenDB(connector)
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	if err := queryResultSets(ctx, db, query, w); err != nil {
+		return queryError(sess, err), nil
+	}
+	return &ExecuteQueryResponse{}, nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"unicode"
+)
+
+// queryResultSets runs the query and writes every result set to w as soon as the rows
+// are read, the result is not held in memory. The values are converted based on the
+// types of the columns.
+func queryResultSets(ctx context.Context, db *sql.DB, query string, w resultWriter) error {
+	if isExecStatement(query) {
+		return execStatement(ctx, db, query, w)
+	}
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for {
+		columnTypes, err := rows.ColumnTypes()
+		if err != nil {
+			return err
+		}
+		columns := make([]ResultColumn, len(columnTypes))
+		for i, ct := range columnTypes {
+			columns[i] = ResultColumn{Name: ct.Name(), Type: ct.DatabaseTypeName()}
+			if nullable, ok := ct.Nullable(); ok {
+				columns[i].Nullable = &nullable
+			}
+			if precision, scale, ok := ct.DecimalSize(); ok {
+				columns[i].Precision, columns[i].Scale = precision, scale
+			}
+		}
+		if err := w.startResultSet(columns); err != nil {
+			return err
+		}
+		values := make([]any, len(columns))
+		dest := make([]any, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		var rowCount int64
+		for rows.Next() {
+			if err := rows.Scan(dest...); err != nil {
+				return err
+			}
+			row := make([]any, len(values))
+			for i, v := range values {
+				row[i] = convertValue(columns[i].Type, v)
+			}
+			if err := w.writeRow(row); err != nil {
+				return err
+			}
+			rowCount++
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		if err := w.endResultSet(rowCount, nil); err != nil {
+			return err
+		}
+		if !rows.NextResultSet() {
+			break
+		}
+	}
+	return rows.Err()
+}
+
+// execStatement runs a statement that doesn't return rows and writes the affected rows,
+// the mysql driver reports the affected rows of each statement of a multi statement query.
+func execStatement(ctx context.Context, db *sql.DB, query string, w resultWriter) error {
+	res, err := db.ExecContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	var affected []int64
+	if multi, ok := res.(interface{ AllRowsAffected() []int64 }); ok {
+		affected = multi.AllRowsAffected()
+	} else if n, err := res.RowsAffected(); err == nil {
+		affected = []int64{n}
+	}
+	for _, n := range affected {
+		if err := w.startResultSet([]ResultColumn{}); err != nil {
+			return err
+		}
+		if err := w.endResultSet(0, &n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// execKeywords are the statements that don't return rows unless they have a returning clause
+var execKeywords = map[string]bool{
+	"INSERT": true, "UPDATE": true, "DELETE": true, "MERGE": true, "REPLACE": true,
+	"CREATE": true, "ALTER": true, "DROP": true, "TRUNCATE": true, "RENAME": true,
+	"GRANT": true, "REVOKE": true,
+}
+
+// isExecStatement returns true when the query starts with a statement that doesn't return rows
+func isExecStatement(query string) bool {
+	words := strings.FieldsFunc(strings.ToUpper(stripSQLComments(query)), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+	if len(words) == 0 || !execKeywords[words[0]] {
+		return false
+	}
+	for _, word := range words {
+		// postgres and sql server return the rows of these clauses
+		if word == "RETURNING" || word == "OUTPUT" {
+			return false
+		}
+	}
+	return true
+}
+
+// stripSQLComments removes the leading comments of a query
+func stripSQLComments(query string) string {
+	for {
+		query = strings.TrimSpace(query)
+		switch {
+		case strings.HasPrefix(query, "--"), strings.HasPrefix(query, "#"):
+			end := strings.IndexByte(query, '\n')
+			if end < 0 {
+				return ""
+			}
+			query = query[end+1:]
+		case strings.HasPrefix(query, "/*"):
+			end := strings.Index(query, "*/")
+			if end < 0 {
+				return ""
+			}
+			query = query[end+2:]
+		default:
+			return query
+		}
+	}
+}
+
+// queryError returns the response of a query that has failed, the error and the
+// exit code reported by the agent are used when the session has been closed.
+func queryError(sess *gatewaySession, err error) *ExecuteQueryResponse {
+	exitCode := 1
+	if sessExitCode, sessErr := sess.exitStatus(); sessErr != nil {
+		err = sessErr
+		if sessExitCode != 0 {
+			exitCode = sessExitCode
+		}
+	}
+	return &ExecuteQueryResponse{ExitCode: exitCode, Error: err.Error()}
+}
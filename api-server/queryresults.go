@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// maxStoredResultsSize is the size limit of the json of the stored result sets
+	maxStoredResultsSize = 16 * 1024 * 1024 // 16 MiB
+	// storedResultsRetention is the time the stored results can be exported
+	storedResultsRetention = 24 * time.Hour
+)
+
+// storeQueryResults saves the result sets of a query, it returns the id of the stored results.
+// The expired results are deleted when new results are stored.
+func storeQueryResults(databaseID int, query string, resultSets []ResultSet) (string, error) {
+	data, err := json.Marshal(resultSets)
+	if err != nil {
+		return "", err
+	}
+	if len(data) > maxStoredResultsSize {
+		return "", fmt.Errorf("the results have %d bytes, the limit is %d bytes", len(data), maxStoredResultsSize)
+	}
+	if _, err := db.Exec("DELETE FROM query_results WHERE expires_at <= NOW()"); err != nil {
+		return "", err
+	}
+	id := uuid.NewString()
+	_, err = db.Exec(`
+		INSERT INTO query_results (id, database_id, query, result_sets, expires_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, id, databaseID, query, data, time.Now().Add(storedResultsRetention))
+	return id, err
+}
+
+// storedResultSet is a result set as it's stored, the values are decoded with the type of their column
+type storedResultSet struct {
+	Columns      []ResultColumn      `json:"columns"`
+	Rows         [][]json.RawMessage `json:"rows"`
+	RowCount     int64               `json:"rowCount"`
+	AffectedRows *int64              `json:"affectedRows,omitempty"`
+}
+
+// GET /api/query-results/{id}/export?database_id=1&format=csv - Export stored query results.
+// The results are exported with the database of the query, the same access of running the query.
+func handleExportQueryResults(w http.ResponseWriter, r *http.Request) {
+	id, err := uuid.Parse(r.PathValue("id"))
+	if err != nil {
+		http.Error(w, "Invalid query result ID", http.StatusBadRequest)
+		return
+	}
+	databaseID, err := strconv.Atoi(r.URL.Query().Get("database_id"))
+	if err != nil {
+		http.Error(w, "Invalid database ID", http.StatusBadRequest)
+		return
+	}
+
+	var data []byte
+	err = db.QueryRow(`
+		SELECT q.result_sets
+		FROM query_results q
+		JOIN databases d ON d.id = q.database_id
+		WHERE q.id = $1 AND q.database_id = $2 AND q.expires_at > NOW()
+	`, id, databaseID).Scan(&data)
+	if err == sql.ErrNoRows {
+		http.Error(w, "Query result not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var resultSets []storedResultSet
+	if err := json.Unmarshal(data, &resultSets); err != nil {
+		http.Error(w, fmt.Sprintf("failed decoding the stored results: %v", err), http.StatusInternalServerError)
+		return
+	}
+	format := r.URL.Query().Get("format")
+	export, err := newResultExport(w, format, databaseID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	export.out.filename = fmt.Sprintf("query-result-%s.%s", id, format)
+	resp := &ExecuteQueryResponse{}
+	if err := replayResultSets(resultSets, export); err != nil {
+		resp.ExitCode, resp.Error = 1, err.Error()
+	}
+	export.finish(resp)
+}
+
+// replayResultSets writes the stored result sets as if they were read from the database
+func replayResultSets(resultSets []storedResultSet, w resultWriter) error {
+	for _, rs := range resultSets {
+		if err := w.startResultSet(rs.Columns); err != nil {
+			return err
+		}
+		for _, row := range rs.Rows {
+			if len(row) > len(rs.Columns) {
+				return fmt.Errorf("the stored row has %d values for %d columns", len(row), len(rs.Columns))
+			}
+			values := make([]any, len(row))
+			for i, raw := range row {
+				v, err := storedValue(rs.Columns[i], raw)
+				if err != nil {
+					return fmt.Errorf("failed decoding the column %v: %v", rs.Columns[i].Name, err)
+				}
+				values[i] = v
+			}
+			if err := w.writeRow(values); err != nil {
+				return err
+			}
+		}
+		if err := w.endResultSet(rs.RowCount, rs.AffectedRows); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// storedValue decodes a value of the json response: the numbers keep their text, the
+// binary values are decoded from base64 and the json values, objects and arrays are kept as json.
+func storedValue(column ResultColumn, raw json.RawMessage) (any, error) {
+	kind := parquetKinds[column.Type]
+	switch {
+	case len(raw) == 0 || bytes.Equal(raw, []byte("null")):
+		return nil, nil
+	case kind == parquetJSON || raw[0] == '{' || raw[0] == '[':
+		return raw, nil
+	case raw[0] == '"':
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, err
+		}
+		// the mysql bit columns are bytes
+		if kind == parquetBinary || column.Type == "BIT" {
+			return base64.StdEncoding.DecodeString(s)
+		}
+		return s, nil
+	case raw[0] == 't' || raw[0] == 'f':
+		var b bool
+		err := json.Unmarshal(raw, &b)
+		return b, err
+	}
+	var n json.Number
+	err := json.Unmarshal(raw, &n)
+	return n, err
+}
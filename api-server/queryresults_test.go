@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplayResultSets(t *testing.T) {
+	affectedRows := int64(2)
+	resultSets := []ResultSet{
+		{
+			Columns: []ResultColumn{
+				{Name: "id", Type: "INT8"},
+				{Name: "price", Type: "DECIMAL", Precision: 10, Scale: 2},
+				{Name: "ratio", Type: "FLOAT8"},
+				{Name: "active", Type: "BOOL"},
+				{Name: "flags", Type: "BIT"},
+				{Name: "avatar", Type: "BYTEA"},
+				{Name: "meta", Type: "JSONB"},
+				{Name: "created_at", Type: "TIMESTAMPTZ"},
+				{Name: "name", Type: "VARCHAR"},
+			},
+			Rows: [][]any{
+				{int64(9007199254740993), "12.50", 0.25, true, []byte{1}, []byte("\x00\xff"), json.RawMessage(`{"b":1,"a":2}`), "2024-01-02T03:04:05Z", "Alice"},
+				{int64(2), nil, nil, false, []byte{0}, nil, json.RawMessage(`"text"`), nil, nil},
+			},
+			RowCount: 2,
+		},
+		{Columns: []ResultColumn{}, Rows: [][]any{}, AffectedRows: &affectedRows},
+	}
+	data, err := json.Marshal(resultSets)
+	if !assert.Nil(t, err) {
+		return
+	}
+	var stored []storedResultSet
+	if !assert.Nil(t, json.Unmarshal(data, &stored)) {
+		return
+	}
+
+	for _, format := range []string{exportCSV, exportJSONLines, exportParquet} {
+		t.Run("it must export the stored results as the results of the query in "+format, func(t *testing.T) {
+			want := httptest.NewRecorder()
+			export, err := newResultExport(want, format, 1)
+			if !assert.Nil(t, err) {
+				return
+			}
+			for _, rs := range resultSets {
+				assert.Nil(t, export.startResultSet(rs.Columns))
+				for _, row := range rs.Rows {
+					assert.Nil(t, export.writeRow(row))
+				}
+				assert.Nil(t, export.endResultSet(rs.RowCount, rs.AffectedRows))
+			}
+			export.finish(&ExecuteQueryResponse{})
+
+			got := httptest.NewRecorder()
+			export, err = newResultExport(got, format, 1)
+			if !assert.Nil(t, err) {
+				return
+			}
+			assert.Nil(t, replayResultSets(stored, export))
+			export.finish(&ExecuteQueryResponse{})
+
+			assert.Equal(t, 200, got.Code)
+			assert.Equal(t, want.Body.String(), got.Body.String())
+		})
+	}
+}
+
+func TestReplayResultSetsErrors(t *testing.T) {
+	for _, tt := range []struct {
+		msg     string
+		stored  string
+		wantErr string
+	}{
+		{
+			msg:     "it must fail with invalid base64 values of binary columns",
+			stored:  `[{"columns":[{"name":"avatar","type":"BYTEA"}],"rows":[["%%"]],"rowCount":1}]`,
+			wantErr: "failed decoding the column avatar: illegal base64 data at input byte 0",
+		},
+		{
+			msg:     "it must fail with more values than columns",
+			stored:  `[{"columns":[{"name":"id","type":"INT4"}],"rows":[[1,2]],"rowCount":1}]`,
+			wantErr: "the stored row has 2 values for 1 columns",
+		},
+	} {
+		t.Run(tt.msg, func(t *testing.T) {
+			var stored []storedResultSet
+			if !assert.Nil(t, json.Unmarshal([]byte(tt.stored), &stored)) {
+				return
+			}
+			assert.EqualError(t, replayResultSets(stored, &resultTable{}), tt.wantErr)
+		})
+	}
+}
+
+func TestStoreQueryResultsLimit(t *testing.T) {
+	resultSets := []ResultSet{{
+		Columns:  []ResultColumn{{Name: "data", Type: "TEXT"}},
+		Rows:     [][]any{{strings.Repeat("a", maxStoredResultsSize)}},
+		RowCount: 1,
+	}}
+	id, err := storeQueryResults(1, "SELECT data FROM blobs", resultSets)
+	assert.Empty(t, id)
+	if assert.NotNil(t, err) {
+		assert.Contains(t, err.Error(), fmt.Sprintf("the limit is %d bytes", maxStoredResultsSize))
+	}
+}
+
+func TestExportQueryResultsRequest(t *testing.T) {
+	for _, tt := range []struct {
+		msg     string
+		id      string
+		query   string
+		wantErr string
+	}{
+		{
+			msg:     "it must refuse sequential ids",
+			id:      "42",
+			query:   "database_id=1&format=csv",
+			wantErr: "Invalid query result ID",
+		},
+		{
+			msg:     "it must require the database of the results",
+			id:      "6f1c1d2e-8f9a-4b3c-9d7e-2a1b3c4d5e6f",
+			query:   "format=csv",
+			wantErr: "Invalid database ID",
+		},
+	} {
+		t.Run(tt.msg, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/api/query-results/"+tt.id+"/export?"+tt.query, nil)
+			r.SetPathValue("id", tt.id)
+			w := httptest.NewRecorder()
+			handleExportQueryResults(w, r)
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+			assert.Equal(t, tt.wantErr+"\n", w.Body.String())
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	mssql "github.com/microsoft/go-mssqldb"
+)
+
+// ResultColumn is a column of a result set, the type is the name of the type in the database
+// (e.g.: VARCHAR, INT4) or the bson type of the values for mongodb (e.g.: objectId, string).
+type ResultColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// it's omitted when the driver doesn't report it
+	Nullable *bool `json:"nullable,omitempty"`
+	// the precision and scale of the decimal columns
+	Precision int64 `json:"precision,omitempty"`
+	Scale     int64 `json:"scale,omitempty"`
+}
+
+// ResultSet is the result of a statement, statements that don't return
+// rows (e.g.: INSERT, UPDATE) have the number of affected rows.
+type ResultSet struct {
+	Columns      []ResultColumn `json:"columns"`
+	Rows         [][]any        `json:"rows"`
+	RowCount     int64          `json:"rowCount"`
+	AffectedRows *int64         `json:"affectedRows,omitempty"`
+}
+
+// resultWriter receives the result sets of a query as they're read from the database
+type resultWriter interface {
+	// startResultSet starts a result set with its columns
+	startResultSet(columns []ResultColumn) error
+	// addColumns appends columns to the current result set, the documents of
+	// mongodb add the fields that were not present in the previous documents
+	addColumns(columns []ResultColumn) error
+	// writeRow writes the values of a row, rows may have fewer values than
+	// the columns of the result set when the columns are added later
+	writeRow(values []any) error
+	// endResultSet ends the current result set
+	endResultSet(rowCount int64, affectedRows *int64) error
+}
+
+// columnWidener is implemented by the result writers that depend on the type of the
+// columns, the fields of mongodb documents may have a different type in each document.
+type columnWidener interface {
+	// variableColumns is called when a result set starts, its columns are added
+	// and widened until it ends
+	variableColumns()
+	// widenColumn changes the type of a column to a type that holds its previous values
+	widenColumn(index int, columnType string) error
+}
+
+// resultTable holds the result sets in memory for the json response
+type resultTable struct {
+	resultSets []ResultSet
+}
+
+func (t *resultTable) current() *ResultSet { return &t.resultSets[len(t.resultSets)-1] }
+
+func (t *resultTable) startResultSet(columns []ResultColumn) error {
+	t.resultSets = append(t.resultSets, ResultSet{Columns: columns, Rows: [][]any{}})
+	return nil
+}
+
+func (t *resultTable) addColumns(columns []ResultColumn) error {
+	rs := t.current()
+	rs.Columns = append(rs.Columns, columns...)
+	return nil
+}
+
+func (t *resultTable) writeRow(values []any) error {
+	rs := t.current()
+	rs.Rows = append(rs.Rows, values)
+	return nil
+}
+
+// endResultSet pads the rows written before the columns were added
+func (t *resultTable) endResultSet(rowCount int64, affectedRows *int64) error {
+	rs := t.current()
+	for i, row := range rs.Rows {
+		if len(row) < len(rs.Columns) {
+			rs.Rows[i] = append(row, make([]any, len(rs.Columns)-len(row))...)
+		}
+	}
+	rs.RowCount, rs.AffectedRows = rowCount, affectedRows
+	return nil
+}
+
+// convertValue converts a value scanned by a sql driver to its json representation based on
+// the type of the column. The drivers return most values of the text protocols as bytes.
+func convertValue(databaseType string, v any) any {
+	switch val := v.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return convertBytes(strings.ToUpper(databaseType), val)
+	case float64:
+		// NaN and infinity are not valid json numbers
+		if math.IsNaN(val) || math.IsInf(val, 0) {
+			return strconv.FormatFloat(val, 'g', -1, 64)
+		}
+	case float32:
+		if math.IsNaN(float64(val)) || math.IsInf(float64(val), 0) {
+			return strconv.FormatFloat(float64(val), 'g', -1, 32)
+		}
+	case time.Time:
+		return val.Format(time.RFC3339Nano)
+	}
+	return v
+}
+
+func convertBytes(databaseType string, val []byte) any {
+	switch databaseType {
+	case "INT", "INTEGER", "TINYINT", "SMALLINT", "MEDIUMINT", "BIGINT", "YEAR", "INT2", "INT4", "INT8",
+		"UNSIGNED INT", "UNSIGNED TINYINT", "UNSIGNED SMALLINT", "UNSIGNED MEDIUMINT", "UNSIGNED BIGINT":
+		if n, err := strconv.ParseInt(string(val), 10, 64); err == nil {
+			return n
+		}
+		if n, err := strconv.ParseUint(string(val), 10, 64); err == nil {
+			return n
+		}
+	case "FLOAT", "DOUBLE", "REAL", "FLOAT4", "FLOAT8":
+		if f, err := strconv.ParseFloat(string(val), 64); err == nil && !math.IsNaN(f) && !math.IsInf(f, 0) {
+			return f
+		}
+	case "JSON", "JSONB":
+		if json.Valid(val) {
+			return json.RawMessage(val)
+		}
+	case "UNIQUEIDENTIFIER":
+		var id mssql.UniqueIdentifier
+		if err := id.Scan(val); err == nil {
+			return id.String()
+		}
+	case "BLOB", "TINYBLOB", "MEDIUMBLOB", "LONGBLOB", "BINARY", "VARBINARY", "BYTEA", "IMAGE", "GEOMETRY", "BIT":
+		// encoded as base64 in json
+		return val
+	}
+	// decimals are kept as strings to preserve their precision
+	return string(val)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// the content types of the streaming modes of the execute query endpoint
+const (
+	contentTypeNDJSON = "application/x-ndjson"
+	contentTypeSSE    = "text/event-stream"
+)
+
+// resultStream writes the result sets of a query as they're read, as json lines or
+// as server-sent events. The last event has the exit code and duration.
+//
+//	{"type":"resultSet","columns":[{"name":"id","type":"INT4","nullable":false}]}
+//	{"type":"row","values":[1]}
+//	{"type":"resultSetEnd","rowCount":1}
+//	{"type":"done","exitCode":0,"duration":"25ms"}
+type resultStream struct {
+	w   http.ResponseWriter
+	rc  *http.ResponseController
+	sse bool
+}
+
+type streamColumnsEvent struct {
+	Type    string         `json:"type"`
+	Columns []ResultColumn `json:"columns"`
+}
+
+type streamRowEvent struct {
+	Type   string `json:"type"`
+	Values []any  `json:"values"`
+}
+
+type streamResultSetEndEvent struct {
+	Type         string `json:"type"`
+	RowCount     int64  `json:"rowCount"`
+	AffectedRows *int64 `json:"affectedRows,omitempty"`
+}
+
+type streamDoneEvent struct {
+	Type     string `json:"type"`
+	ExitCode int    `json:"exitCode"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// newResultStream returns a stream when the request accepts one of the streaming
+// content types, it returns nil for the default json response.
+func newResultStream(w http.ResponseWriter, r *http.Request) *resultStream {
+	contentType := streamContentType(r)
+	if contentType == "" {
+		return nil
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "no-cache")
+	// disables the buffering of reverse proxies (nginx)
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	s := &resultStream{w: w, rc: http.NewResponseController(w), sse: contentType == contentTypeSSE}
+	_ = s.rc.Flush()
+	return s
+}
+
+// streamContentType returns the streaming content type accepted by the request, it's
+// empty for the default json response.
+func streamContentType(r *http.Request) string {
+	accept := r.Header.Get("Accept")
+	switch {
+	case strings.Contains(accept, contentTypeNDJSON):
+		return contentTypeNDJSON
+	case strings.Contains(accept, contentTypeSSE):
+		return contentTypeSSE
+	}
+	return ""
+}
+
+func (s *resultStream) startResultSet(columns []ResultColumn) error {
+	return s.send("resultSet", streamColumnsEvent{Type: "resultSet", Columns: columns})
+}
+
+// addColumns sends the columns appended to the current result set, the
+// previous rows don't have values for them.
+func (s *resultStream) addColumns(columns []ResultColumn) error {
+	return s.send("columns", streamColumnsEvent{Type: "columns", Columns: columns})
+}
+
+// writeRow sends a row, it fails when the client has disconnected
+func (s *resultStream) writeRow(values []any) error {
+	return s.send("row", streamRowEvent{Type: "row", Values: values})
+}
+
+func (s *resultStream) endResultSet(rowCount int64, affectedRows *int64) error {
+	return s.send("resultSetEnd", streamResultSetEndEvent{Type: "resultSetEnd", RowCount: rowCount, AffectedRows: affectedRows})
+}
+
+// finish sends the exit code, the error and the duration of the query. The duration is
+// measured by the api-server from the request to the end of the results: the api-server
+// closes the gateway session when the query ends, the SessionClose of the gateway is
+// only received when the agent ends the session and it has the exit code, not a duration.
+func (s *resultStream) finish(resp *ExecuteQueryResponse) {
+	_ = s.send("done", streamDoneEvent{
+		Type:     "done",
+		ExitCode: resp.ExitCode,
+		Error:    resp.Error,
+		Duration: resp.Duration,
+	})
+}
+
+func (s *resultStream) send(event string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if s.sse {
+		_, err = fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", event, data)
+	} else {
+		_, err = s.w.Write(append(data, '\n'))
+	}
+	if err != nil {
+		return err
+	}
+	return s.rc.Flush()
+}
+
+var _ resultWriter = (*resultStream)(nil)
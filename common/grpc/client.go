@@ -47,6 +47,9 @@ type (
 		TLSCA string
 		// This is used to specify a different DNS name when connecting via TLS
 		TLSServerName string
+		// The certificate and key (PEM) presented to servers requiring client certificates
+		TLSClientCert string
+		TLSClientKey  string
 	}
 )
 
@@ -166,6 +169,13 @@ func loadTLSCredentials(cc ClientConfig) (credentials.TransportCredentials, erro
 		RootCAs:    certPool,
 		ServerName: cc.TLSServerName,
 	}
+	if cc.TLSClientCert != "" || cc.TLSClientKey != "" {
+		cert, err := tls.X509KeyPair([]byte(cc.TLSClientCert), []byte(cc.TLSClientKey))
+		if err != nil {
+			return nil, fmt.Errorf("failed loading client certificate, reason=%v", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
 	return credentials.NewTLS(config), nil
 }
 
@@ -14,6 +14,9 @@ import (
 var (
 	defaultKeySize   uint16 = 32
 	defaultPrefixKey string = "xagt"
+
+	// ErrTokenExpired is returned when verifying an access token that has expired
+	ErrTokenExpired = jwt.ErrTokenExpired
 )
 
 // GenerateSecureRandomKey generates a secure random key of the specified size.
@@ -69,7 +72,7 @@ func VerifyAccessToken(tokenString string, pubKey ed25519.PublicKey) (subject st
 		return pubKey, nil
 	})
 	if err != nil {
-		return "", fmt.Errorf("failed to parse access token: %v", err)
+		return "", fmt.Errorf("failed to parse access token: %w", err)
 	}
 	if !token.Valid {
 		return "", fmt.Errorf("access token is invalid")
@@ -0,0 +1,37 @@
+package keys
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestVerifyAccessToken(t *testing.T) {
+	pubKey, privKey, err := GenerateEd25519KeyPair()
+	if err != nil {
+		t.Fatalf("failed generating key pair: %v", err)
+	}
+	otherPubKey, _, err := GenerateEd25519KeyPair()
+	if err != nil {
+		t.Fatalf("failed generating key pair: %v", err)
+	}
+	validToken, err := NewJwtToken(privKey, "api-server", "", time.Minute)
+	if err != nil {
+		t.Fatalf("failed generating token: %v", err)
+	}
+	expiredToken, err := NewJwtToken(privKey, "api-server", "", -time.Minute)
+	if err != nil {
+		t.Fatalf("failed generating token: %v", err)
+	}
+
+	subject, err := VerifyAccessToken(validToken, pubKey)
+	if err != nil || subject != "api-server" {
+		t.Errorf("expected valid token with subject api-server, got subject=%q, err=%v", subject, err)
+	}
+	if _, err := VerifyAccessToken(expiredToken, pubKey); !errors.Is(err, ErrTokenExpired) {
+		t.Errorf("expected expired token error, got %v", err)
+	}
+	if _, err := VerifyAccessToken(validToken, otherPubKey); err == nil || errors.Is(err, ErrTokenExpired) {
+		t.Errorf("expected signature error, got %v", err)
+	}
+}
@@ -1,5 +1,8 @@
 package mongotypes
 
+// MaxMessageSize is the default maximum size of a message accepted by the server
+const MaxMessageSize uint32 = 48000000
+
 const (
 	// Wraps other opcodes using compression
 	OpCompressed uint32 = 2012
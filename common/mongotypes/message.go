@@ -0,0 +1,235 @@
+package mongotypes
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// OP_MSG flag bits
+// https://www.mongodb.com/docs/manual/reference/mongodb-wire-protocol/#flag-bits
+const (
+	MsgFlagChecksumPresent uint32 = 1 << 0
+	MsgFlagMoreToCome      uint32 = 1 << 1
+	MsgFlagExhaustAllowed  uint32 = 1 << 16
+)
+
+const (
+	sectionKindBody             byte = 0
+	sectionKindDocumentSequence byte = 1
+)
+
+// NewOpMsg creates an OP_MSG packet with a single body section
+func NewOpMsg(requestID uint32, doc []byte) *Packet {
+	frame := make([]byte, 5, 5+len(doc))
+	frame[4] = sectionKindBody
+	frame = append(frame, doc...)
+	return &Packet{
+		MessageLength: uint32(len(frame) + 16),
+		RequestID:     requestID,
+		OpCode:        OpMsgType,
+		Frame:         frame,
+	}
+}
+
+// NewOpReply creates a legacy OP_REPLY packet with a single document
+func NewOpReply(requestID, responseTo uint32, doc []byte) *Packet {
+	// response flags (4) + cursor id (8) + starting from (4) + number returned (4)
+	frame := make([]byte, 20, 20+len(doc))
+	binary.LittleEndian.PutUint32(frame[16:20], 1)
+	frame = append(frame, doc...)
+	return &Packet{
+		MessageLength: uint32(len(frame) + 16),
+		RequestID:     requestID,
+		ResponseTo:    responseTo,
+		OpCode:        OpReplyType,
+		Frame:         frame,
+	}
+}
+
+// NewErrorReply creates a command error reply for the request packet using
+// the same protocol of the request (OP_MSG or OP_QUERY)
+func NewErrorReply(req *Packet, requestID uint32, code int32, codeName, errMsg string) *Packet {
+	doc, _ := bson.Marshal(bson.D{
+		{Key: "ok", Value: 0.0},
+		{Key: "errmsg", Value: errMsg},
+		{Key: "code", Value: code},
+		{Key: "codeName", Value: codeName},
+	})
+	if req.OpCode == OpQueryType {
+		return NewOpReply(requestID, req.RequestID, doc)
+	}
+	pkt := NewOpMsg(requestID, doc)
+	pkt.ResponseTo = req.RequestID
+	return pkt
+}
+
+// MsgFlags returns the flag bits of an OP_MSG packet
+func (p *Packet) MsgFlags() uint32 {
+	if p.OpCode != OpMsgType || len(p.Frame) < 4 {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(p.Frame[0:4])
+}
+
+// CommandDocument returns the body document of an OP_MSG packet,
+// the query document of an OP_QUERY packet or the first document of an OP_REPLY packet
+func (p *Packet) CommandDocument() (bsoncore.Document, error) {
+	switch p.OpCode {
+	case OpMsgType:
+		start, end, err := p.msgBodyOffset()
+		if err != nil {
+			return nil, err
+		}
+		return bsoncore.Document(p.Frame[start:end]), nil
+	case OpQueryType:
+		start, end, err := p.queryDocOffset()
+		if err != nil {
+			return nil, err
+		}
+		return bsoncore.Document(p.Frame[start:end]), nil
+	case OpReplyType:
+		if len(p.Frame) < 20 {
+			return nil, fmt.Errorf("failed decoding OP_REPLY: frame is too short")
+		}
+		doc, _, ok := bsoncore.ReadDocument(p.Frame[20:])
+		if !ok {
+			return nil, fmt.Errorf("failed decoding OP_REPLY: unable to read document")
+		}
+		return doc, nil
+	}
+	return nil, fmt.Errorf("unsupported op code %v", p.OpCode)
+}
+
+// CommandName returns the name of the command, it's the first key of the command document
+func (p *Packet) CommandName() string {
+	doc, err := p.CommandDocument()
+	if err != nil {
+		return ""
+	}
+	elem, err := doc.IndexErr(0)
+	if err != nil {
+		return ""
+	}
+	return elem.Key()
+}
+
+// ReplaceCommandDocument replaces the command document of an OP_MSG or OP_QUERY packet.
+// The checksum of OP_MSG packets is removed because the content has changed.
+func (p *Packet) ReplaceCommandDocument(doc bsoncore.Document) error {
+	var start, end int
+	var err error
+	switch p.OpCode {
+	case OpMsgType:
+		start, end, err = p.msgBodyOffset()
+	case OpQueryType:
+		start, end, err = p.queryDocOffset()
+	default:
+		err = fmt.Errorf("unsupported op code %v", p.OpCode)
+	}
+	if err != nil {
+		return err
+	}
+	frame := make([]byte, 0, len(p.Frame)-(end-start)+len(doc))
+	frame = append(frame, p.Frame[:start]...)
+	frame = append(frame, doc...)
+	frame = append(frame, p.Frame[end:]...)
+	if p.OpCode == OpMsgType {
+		flags := binary.LittleEndian.Uint32(frame[0:4])
+		if flags&MsgFlagChecksumPresent > 0 {
+			binary.LittleEndian.PutUint32(frame[0:4], flags&^MsgFlagChecksumPresent)
+			frame = frame[:len(frame)-4]
+		}
+	}
+	p.Frame = frame
+	p.MessageLength = uint32(len(frame) + 16)
+	return nil
+}
+
+func (p *Packet) msgBodyOffset() (start, end int, err error) {
+	if len(p.Frame) < 5 {
+		return 0, 0, fmt.Errorf("failed decoding OP_MSG: frame is too short")
+	}
+	sections := p.Frame[4:]
+	if binary.LittleEndian.Uint32(p.Frame[0:4])&MsgFlagChecksumPresent > 0 {
+		if len(sections) < 4 {
+			return 0, 0, fmt.Errorf("failed decoding OP_MSG: frame is too short")
+		}
+		sections = sections[:len(sections)-4]
+	}
+	pos := 4
+	for len(sections) > 0 {
+		kind := sections[0]
+		switch kind {
+		case sectionKindBody:
+			length, _, ok := bsoncore.ReadLength(sections[1:])
+			if !ok || int(length) > len(sections)-1 {
+				return 0, 0, fmt.Errorf("failed decoding OP_MSG: unable to read body section")
+			}
+			return pos + 1, pos + 1 + int(length), nil
+		case sectionKindDocumentSequence:
+			length, _, ok := bsoncore.ReadLength(sections[1:])
+			if !ok || int(length) > len(sections)-1 {
+				return 0, 0, fmt.Errorf("failed decoding OP_MSG: unable to read document sequence section")
+			}
+			sections = sections[1+length:]
+			pos += 1 + int(length)
+		default:
+			return 0, 0, fmt.Errorf("failed decoding OP_MSG: found unknown section type (%v)", kind)
+		}
+	}
+	return 0, 0, fmt.Errorf("failed decoding OP_MSG: body section not found")
+}
+
+func (p *Packet) queryDocOffset() (start, end int, err error) {
+	// flags (4) + full collection name (cstring) + number to skip (4) + number to return (4)
+	if len(p.Frame) < 4 {
+		return 0, 0, fmt.Errorf("failed decoding OP_QUERY: frame is too short")
+	}
+	idx := bytes.IndexByte(p.Frame[4:], 0x00)
+	if idx == -1 {
+		return 0, 0, fmt.Errorf("failed decoding OP_QUERY: unable to read collection name")
+	}
+	start = 4 + idx + 1 + 8
+	if len(p.Frame) < start {
+		return 0, 0, fmt.Errorf("failed decoding OP_QUERY: frame is too short")
+	}
+	length, _, ok := bsoncore.ReadLength(p.Frame[start:])
+	if !ok || start+int(length) > len(p.Frame) {
+		return 0, 0, fmt.Errorf("failed decoding OP_QUERY: unable to read query document")
+	}
+	return start, start + int(length), nil
+}
+
+// RemoveKeys returns a copy of the document without the top level keys,
+// the keys are compared case insensitive.
+func RemoveKeys(doc bsoncore.Document, keys ...string) (bsoncore.Document, bool, error) {
+	elems, err := doc.Elements()
+	if err != nil {
+		return nil, false, err
+	}
+	idx, dst := bsoncore.AppendDocumentStart(nil)
+	var removed bool
+	for _, elem := range elems {
+		if containsFold(keys, elem.Key()) {
+			removed = true
+			continue
+		}
+		dst = append(dst, elem...)
+	}
+	dst, err = bsoncore.AppendDocumentEnd(dst, idx)
+	return dst, removed, err
+}
+
+func containsFold(list []string, v string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, v) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package mongotypes
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// isMaster (OP_QUERY) and hello (OP_MSG) commands sent by mongosh
+const (
+	opQueryIsMasterHex = `5a0100000100000000000000d40700000000000061646d696e2e24636d640000000000ffffffff330100001069736d617374657200010000000868656c6c6f4f6b000103636c69656e7400f0000000036170706c69636174696f6e001d000000026e616d65000e0000006d6f6e676f736820322e312e350000036472697665720037000000026e616d65000f0000006e6f64656a737c6d6f6e676f7368000276657273696f6e000c000000362e332e307c322e312e35000002706c6174666f726d00150000004e6f64652e6a73207632302e31312e312c204c4500036f73005b000000026e616d6500060000006c696e75780002617263686974656374757265000600000061726d3634000276657273696f6e0011000000352e31352e34392d6c696e75786b697400027479706500060000004c696e757800000004636f6d7072657373696f6e0011000000023000050000006e6f6e65000000`
+	opMsgHelloHex      = `c50000000400000000000000dd0700000000010000b00000001068656c6c6f00010000000868656c6c6f4f6b000103746f706f6c6f677956657273696f6e002d0000000770726f6365737349640066314ea2a13a0bf9a6366d7412636f756e74657200060000000000000000126d6178417761697454696d654d5300102700000000000002246462000600000061646d696e00032472656164507265666572656e63650020000000026d6f646500110000007072696d617279507265666572726564000000`
+)
+
+func TestCommandName(t *testing.T) {
+	for _, tt := range []struct {
+		msg    string
+		rawPkt []byte
+		want   string
+	}{
+		{msg: "it must return the command of OP_QUERY packets", rawPkt: decodeHexStr(opQueryIsMasterHex), want: "ismaster"},
+		{msg: "it must return the command of OP_MSG packets", rawPkt: decodeHexStr(opMsgHelloHex), want: "hello"},
+	} {
+		t.Run(tt.msg, func(t *testing.T) {
+			pkt, err := Decode(bytes.NewBuffer(tt.rawPkt))
+			assert.Nil(t, err)
+			assert.Equal(t, tt.want, pkt.CommandName())
+		})
+	}
+}
+
+func TestReplaceCommandDocument(t *testing.T) {
+	for _, tt := range []struct {
+		msg     string
+		rawPkt  []byte
+		key     string
+		removed bool
+	}{
+		{msg: "it must remove the compression key of OP_QUERY packets", rawPkt: decodeHexStr(opQueryIsMasterHex), key: "compression", removed: true},
+		{msg: "it must remove the $readPreference key of OP_MSG packets", rawPkt: decodeHexStr(opMsgHelloHex), key: "$readpreference", removed: true},
+		{msg: "it must keep the document when the key does not exist", rawPkt: decodeHexStr(opMsgHelloHex), key: "compression", removed: false},
+	} {
+		t.Run(tt.msg, func(t *testing.T) {
+			pkt, err := Decode(bytes.NewBuffer(tt.rawPkt))
+			assert.Nil(t, err)
+			doc, err := pkt.CommandDocument()
+			assert.Nil(t, err)
+			newDoc, removed, err := RemoveKeys(doc, tt.key)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.removed, removed)
+			assert.Nil(t, pkt.ReplaceCommandDocument(newDoc))
+
+			// the packet must be valid after replacing the document
+			got, err := Decode(bytes.NewBuffer(pkt.Encode()))
+			assert.Nil(t, err)
+			gotDoc, err := got.CommandDocument()
+			assert.Nil(t, err)
+			assert.Equal(t, newDoc, gotDoc)
+			assert.Equal(t, int(got.MessageLength), len(pkt.Encode()))
+		})
+	}
+}
+
+func TestNewErrorReply(t *testing.T) {
+	for _, tt := range []struct {
+		msg        string
+		rawPkt     []byte
+		wantOpCode uint32
+	}{
+		{msg: "it must reply OP_QUERY requests with OP_REPLY", rawPkt: decodeHexStr(opQueryIsMasterHex), wantOpCode: OpReplyType},
+		{msg: "it must reply OP_MSG requests with OP_MSG", rawPkt: decodeHexStr(opMsgHelloHex), wantOpCode: OpMsgType},
+	} {
+		t.Run(tt.msg, func(t *testing.T) {
+			req, err := Decode(bytes.NewBuffer(tt.rawPkt))
+			assert.Nil(t, err)
+			reply := NewErrorReply(req, 10, 13, "Unauthorized", "not allowed")
+			assert.Equal(t, tt.wantOpCode, reply.OpCode)
+			assert.Equal(t, req.RequestID, reply.ResponseTo)
+
+			doc, err := reply.CommandDocument()
+			assert.Nil(t, err)
+			var got bson.M
+			assert.Nil(t, bson.Unmarshal(doc, &got))
+			assert.Equal(t, bson.M{"ok": 0.0, "errmsg": "not allowed", "code": int32(13), "codeName": "Unauthorized"}, got)
+		})
+	}
+}
@@ -62,6 +62,9 @@ func Decode(r io.Reader) (*Packet, error) {
 		ResponseTo:    binary.LittleEndian.Uint32(header[8:12]),
 		OpCode:        binary.LittleEndian.Uint32(header[12:16]),
 	}
+	if p.MessageLength < 16 || p.MessageLength > MaxMessageSize {
+		return nil, fmt.Errorf("invalid message length (%v)", p.MessageLength)
+	}
 	pktLen := int(p.MessageLength - 16)
 	frame := make([]byte, pktLen)
 	_, err = io.ReadFull(r, frame)
@@ -1,6 +1,7 @@
 package monitoring
 
 import (
+	"fmt"
 	"net/url"
 	"os"
 	"strings"
@@ -9,6 +10,8 @@ import (
 	"github.com/honeycombio/honeycomb-opentelemetry-go"
 	"github.com/honeycombio/otel-config-go/otelconfig"
 	"github.com/bifrost/common/version"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
 )
 
 type TransportConfig struct {
@@ -58,24 +61,76 @@ func StartSentry() (bool, error) {
 
 type ShutdownFn func()
 
-func NewOpenTracing(apiURL string) (ShutdownFn, error) {
-	// Enable multi-span attributes
-	bsp := honeycomb.NewBaggageSpanProcessor()
-
+// NewOpenTracing configures the OpenTelemetry SDK to export the traces with OTLP.
+// It's configured with the environment variables:
+//
+// OTEL_EXPORTER_OTLP_ENDPOINT - the collector address, tracing is disabled if it's empty
+//
+// OTEL_EXPORTER_OTLP_HEADERS - headers sent to the collector (key1=val1,key2=val2)
+//
+// OTEL_EXPORTER_OTLP_PROTOCOL, OTEL_EXPORTER_OTLP_INSECURE - grpc (default) or http/protobuf
+//
+// OTEL_SERVICE_NAME - the name of the service, defaults to serviceName
+//
+// The traces are sent to Honeycomb when the endpoint is not set and the api key is available.
+func NewOpenTracing(serviceName string) (ShutdownFn, error) {
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	headers, err := parseHeaders(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"))
+	if err != nil {
+		return func() {}, fmt.Errorf("failed parsing OTEL_EXPORTER_OTLP_HEADERS, reason=%v", err)
+	}
 	hcApiKey := honeycombApiKey
 	if hcApiKey == "" {
 		// used only for development purposes
 		hcApiKey = os.Getenv("HONEYCOMB_API_KEY")
 	}
-	// Use the Honeycomb distro to set up the OpenTelemetry SDK
-	return otelconfig.ConfigureOpenTelemetry(
-		otelconfig.WithSpanProcessor(bsp),
-		otelconfig.WithServiceName("hoopdev"),
+	if endpoint == "" {
+		if hcApiKey == "" {
+			// the trace context of other services is still propagated
+			otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+				propagation.TraceContext{}, propagation.Baggage{}))
+			return func() {}, nil
+		}
+		endpoint = "https://api.honeycomb.io:443"
+		headers["x-honeycomb-team"] = hcApiKey
+	}
+	if name := os.Getenv("OTEL_SERVICE_NAME"); name != "" {
+		serviceName = name
+	}
+	shutdownFn, err := otelconfig.ConfigureOpenTelemetry(
+		// Enable multi-span attributes
+		otelconfig.WithSpanProcessor(honeycomb.NewBaggageSpanProcessor()),
+		otelconfig.WithServiceName(serviceName),
 		otelconfig.WithServiceVersion(version.Get().Version),
-		otelconfig.WithExporterEndpoint("https://api.honeycomb.io:443"),
-		// otelconfig.WithLogLevel("debug"),
-		otelconfig.WithHeaders(map[string]string{
-			"x-honeycomb-team": hcApiKey,
-		}),
+		otelconfig.WithExporterEndpoint(endpoint),
+		otelconfig.WithHeaders(headers),
+		// the metrics are exported with prometheus
+		otelconfig.WithMetricsEnabled(false),
 	)
+	if shutdownFn == nil {
+		shutdownFn = func() {}
+	}
+	return shutdownFn, err
+}
+
+// parseHeaders parses a list of headers in the format key1=val1,key2=val2,
+// the values may be url encoded.
+func parseHeaders(raw string) (map[string]string, error) {
+	headers := map[string]string{}
+	for _, item := range strings.Split(raw, ",") {
+		if strings.TrimSpace(item) == "" {
+			continue
+		}
+		key, val, found := strings.Cut(item, "=")
+		key = strings.TrimSpace(key)
+		if !found || key == "" {
+			return nil, fmt.Errorf("header %q is not in the format key=value", item)
+		}
+		decoded, err := url.QueryUnescape(strings.TrimSpace(val))
+		if err != nil {
+			return nil, fmt.Errorf("failed decoding the value of header %q, reason=%v", key, err)
+		}
+		headers[key] = decoded
+	}
+	return headers, nil
 }
@@ -0,0 +1,60 @@
+package monitoring
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestParseHeaders(t *testing.T) {
+	for _, tt := range []struct {
+		msg     string
+		raw     string
+		want    map[string]string
+		wantErr bool
+	}{
+		{msg: "it must return empty headers", raw: "", want: map[string]string{}},
+		{msg: "it must parse a list of headers", raw: "x-api-key=abc, x-team=dev", want: map[string]string{"x-api-key": "abc", "x-team": "dev"}},
+		{msg: "it must decode url encoded values", raw: "authorization=Basic%20dXNlcjpwYXNz", want: map[string]string{"authorization": "Basic dXNlcjpwYXNz"}},
+		{msg: "it must fail with headers without value", raw: "x-api-key", wantErr: true},
+	} {
+		t.Run(tt.msg, func(t *testing.T) {
+			got, err := parseHeaders(tt.raw)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected error, got headers %v", got)
+				}
+				return
+			}
+			if err != nil || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expected headers %v, got %v, err=%v", tt.want, got, err)
+			}
+		})
+	}
+}
+
+func TestSpecCarrier(t *testing.T) {
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	spanCtx := trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+		Remote:     true,
+	})
+	propagator := propagation.TraceContext{}
+	spec := map[string][]byte{"gateway.session_id": []byte("sid")}
+	propagator.Inject(trace.ContextWithSpanContext(context.Background(), spanCtx), specCarrier(spec))
+
+	want := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	if got := string(spec["trace.traceparent"]); got != want {
+		t.Fatalf("expected traceparent %q, got %q", want, got)
+	}
+	got := trace.SpanContextFromContext(propagator.Extract(context.Background(), specCarrier(spec)))
+	if !got.Equal(spanCtx) {
+		t.Errorf("expected span context %v, got %v", spanCtx, got)
+	}
+}
@@ -0,0 +1,40 @@
+package monitoring
+
+import (
+	"context"
+	"strings"
+
+	pb "github.com/bifrost/common/proto"
+	"go.opentelemetry.io/otel"
+)
+
+// specCarrier propagates the trace context in the spec of packets
+type specCarrier map[string][]byte
+
+func (c specCarrier) Get(key string) string {
+	return string(c[pb.SpecTraceContextPrefix+key])
+}
+
+func (c specCarrier) Set(key, val string) {
+	c[pb.SpecTraceContextPrefix+key] = []byte(val)
+}
+
+func (c specCarrier) Keys() []string {
+	var keys []string
+	for key := range c {
+		if name, found := strings.CutPrefix(key, pb.SpecTraceContextPrefix); found {
+			keys = append(keys, name)
+		}
+	}
+	return keys
+}
+
+// InjectTraceContext adds the trace context of ctx to the spec of a packet
+func InjectTraceContext(ctx context.Context, spec map[string][]byte) {
+	otel.GetTextMapPropagator().Inject(ctx, specCarrier(spec))
+}
+
+// ExtractTraceContext returns a context with the trace context of the spec of a packet
+func ExtractTraceContext(ctx context.Context, spec map[string][]byte) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, specCarrier(spec))
+}
@@ -87,6 +87,16 @@ func (l *login) DisablePasswordChange() {
 	}
 }
 
+// fIntSecurity of OptionFlags2, the client uses integrated security (SSPI)
+const fIntSecurity byte = 0x80
+
+// DisableIntegratedSecurity change the OptionFlag2 and removes the SSPI data
+// forcing the server to authenticate with the username and password
+func (l *login) DisableIntegratedSecurity() {
+	l.header.OptionFlags2 &= ^fIntSecurity
+	l.SSPI = nil
+}
+
 func DecodeLogin(data []byte) *login {
 	buf := bytes.NewBuffer(data)
 	l := &login{header: &loginHeader{}}
@@ -0,0 +1,133 @@
+package mssqltypes
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// prelogin option tokens
+// https://learn.microsoft.com/en-us/openspecs/windows_protocols/ms-tds/60f56408-0188-4cd5-8b90-25c6f2423868
+const (
+	PreloginVersion         byte = 0x00
+	PreloginEncryption      byte = 0x01
+	PreloginInstOpt         byte = 0x02
+	PreloginThreadID        byte = 0x03
+	PreloginMars            byte = 0x04
+	PreloginTraceID         byte = 0x05
+	PreloginFedAuthRequired byte = 0x06
+	PreloginNonceOpt        byte = 0x07
+	preloginTerminator      byte = 0xff
+)
+
+// encryption values of the prelogin
+const (
+	EncryptOff    byte = 0x00
+	EncryptOn     byte = 0x01
+	EncryptNotSup byte = 0x02
+	EncryptReq    byte = 0x03
+)
+
+// packet status
+const statusEOM byte = 0x01
+
+// Prelogin maps the option tokens to its values
+type Prelogin map[byte][]byte
+
+// DecodePrelogin decodes the options of a prelogin message
+func DecodePrelogin(data []byte) (Prelogin, error) {
+	p := Prelogin{}
+	for pos := 0; ; pos += 5 {
+		if pos >= len(data) {
+			return nil, fmt.Errorf("prelogin terminator not found")
+		}
+		token := data[pos]
+		if token == preloginTerminator {
+			return p, nil
+		}
+		if pos+5 > len(data) {
+			return nil, fmt.Errorf("prelogin option (%X) is too short", token)
+		}
+		offset := int(binary.BigEndian.Uint16(data[pos+1 : pos+3]))
+		length := int(binary.BigEndian.Uint16(data[pos+3 : pos+5]))
+		if offset+length > len(data) {
+			return nil, fmt.Errorf("prelogin option (%X) is greater than the message", token)
+		}
+		p[token] = data[offset : offset+length]
+	}
+}
+
+// Encryption returns the encryption value of the prelogin
+func (p Prelogin) Encryption() byte {
+	if v := p[PreloginEncryption]; len(v) > 0 {
+		return v[0]
+	}
+	return EncryptNotSup
+}
+
+// Encode encodes the options of the prelogin sorted by the token
+func (p Prelogin) Encode() []byte {
+	tokens := make([]int, 0, len(p))
+	for token := range p {
+		tokens = append(tokens, int(token))
+	}
+	sort.Ints(tokens)
+	offset := len(tokens)*5 + 1
+	header := make([]byte, 0, offset)
+	var data []byte
+	for _, token := range tokens {
+		val := p[byte(token)]
+		header = append(header, byte(token))
+		header = binary.BigEndian.AppendUint16(header, uint16(offset+len(data)))
+		header = binary.BigEndian.AppendUint16(header, uint16(len(val)))
+		data = append(data, val...)
+	}
+	header = append(header, preloginTerminator)
+	return append(header, data...)
+}
+
+// IsEOM reports if it's the last packet of a message
+func (p *Packet) IsEOM() bool { return p.header[1]&statusEOM > 0 }
+
+// DecodeMessage decodes the packets until the end of the message,
+// returning the type of the first packet and the frame of all packets
+func DecodeMessage(data io.Reader) (PacketType, []byte, error) {
+	var msg []byte
+	var typ PacketType
+	for i := 0; ; i++ {
+		pkt, err := Decode(data)
+		if err != nil {
+			return 0, nil, err
+		}
+		if i == 0 {
+			typ = pkt.Type()
+		}
+		msg = append(msg, pkt.Frame...)
+		if pkt.IsEOM() {
+			return typ, msg, nil
+		}
+	}
+}
+
+// EncodeMessage splits the data in packets of packetSize, the last packet
+// has the status end of message.
+func EncodeMessage(typ PacketType, data []byte, packetSize int) []byte {
+	maxFrameSize := packetSize - 8
+	var dst []byte
+	for packetID := 1; ; packetID++ {
+		frameSize := min(len(data), maxFrameSize)
+		header := NewHeader(typ, frameSize)
+		header[1] = 0x00
+		if frameSize == len(data) {
+			header[1] = statusEOM
+		}
+		header[6] = byte(packetID)
+		dst = append(dst, header[:]...)
+		dst = append(dst, data[:frameSize]...)
+		data = data[frameSize:]
+		if len(data) == 0 {
+			return dst
+		}
+	}
+}
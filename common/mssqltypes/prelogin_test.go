@@ -0,0 +1,54 @@
+package mssqltypes
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestPreloginEncodeDecode(t *testing.T) {
+	// prelogin sent by sqlcmd (go-mssqldb) with encryption disabled
+	data, _ := hex.DecodeString("120100580000010000001f000601002500010200260001030027000404002b000105002c0024ff02000b01000002000000000000cc4c32229d254d7aab3d2a1bc55997254d44920130314193a3b42257dd3edb6400000000")
+	pkt, err := Decode(bytes.NewBuffer(data))
+	if err != nil {
+		t.Fatalf("failed decoding packet, err=%v", err)
+	}
+	prelogin, err := DecodePrelogin(pkt.Frame)
+	if err != nil {
+		t.Fatalf("failed decoding prelogin, err=%v", err)
+	}
+	if got := prelogin.Encryption(); got != EncryptNotSup {
+		t.Errorf("expected encryption to be not supported, got=%X", got)
+	}
+	if len(prelogin) != 6 {
+		t.Errorf("expected 6 options, got=%v", len(prelogin))
+	}
+	if got := prelogin.Encode(); !bytes.Equal(got, pkt.Frame) {
+		t.Errorf("expected re-encoding to match the original frame, got=%X", got)
+	}
+
+	prelogin[PreloginEncryption] = []byte{EncryptOn}
+	prelogin, err = DecodePrelogin(prelogin.Encode())
+	if err != nil {
+		t.Fatalf("failed decoding prelogin, err=%v", err)
+	}
+	if got := prelogin.Encryption(); got != EncryptOn {
+		t.Errorf("expected encryption to be on, got=%X", got)
+	}
+}
+
+func TestEncodeMessage(t *testing.T) {
+	data := bytes.Repeat([]byte{0x0a}, 100)
+	msg := EncodeMessage(PacketPreloginType, data, 48)
+	typ, got, err := DecodeMessage(bytes.NewBuffer(msg))
+	if err != nil {
+		t.Fatalf("failed decoding message, err=%v", err)
+	}
+	if typ != PacketPreloginType || !bytes.Equal(got, data) {
+		t.Errorf("decoded message does not match, type=%X, data=%X", typ, got)
+	}
+	// 100 bytes in frames of 40 bytes
+	if len(msg) != 100+3*8 {
+		t.Errorf("expected 3 packets, got length=%v", len(msg))
+	}
+}
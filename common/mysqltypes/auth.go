@@ -0,0 +1,84 @@
+package mysqltypes
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+)
+
+// ScrambleNativePassword computes the auth response of the mysql_native_password plugin
+// SHA1(password) XOR SHA1(scramble <concat> SHA1(SHA1(password)))
+func ScrambleNativePassword(scramble []byte, password string) []byte {
+	if len(password) == 0 {
+		return nil
+	}
+	if len(scramble) > 20 {
+		scramble = scramble[:20]
+	}
+	stage1 := sha1.Sum([]byte(password))
+	stage2 := sha1.Sum(stage1[:])
+
+	h := sha1.New()
+	_, _ = h.Write(scramble)
+	_, _ = h.Write(stage2[:])
+	dst := h.Sum(nil)
+	for i := range dst {
+		dst[i] ^= stage1[i]
+	}
+	return dst
+}
+
+// ScrambleCachingSha2Password computes the auth response of the caching_sha2_password plugin
+// XOR(SHA256(password), SHA256(SHA256(SHA256(password)), scramble))
+func ScrambleCachingSha2Password(scramble []byte, password string) []byte {
+	if len(password) == 0 {
+		return nil
+	}
+	if len(scramble) > 20 {
+		scramble = scramble[:20]
+	}
+	stage1 := sha256.Sum256([]byte(password))
+	stage2 := sha256.Sum256(stage1[:])
+
+	h := sha256.New()
+	_, _ = h.Write(stage2[:])
+	_, _ = h.Write(scramble)
+	dst := h.Sum(nil)
+	for i := range dst {
+		dst[i] ^= stage1[i]
+	}
+	return dst
+}
+
+// ParsePublicKey parses a PEM encoded RSA public key sent by the server
+func ParsePublicKey(data []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("failed decoding public key pem block")
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed parsing public key, err=%v", err)
+	}
+	pubKey, ok := pub.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("unknown public key type %T", pub)
+	}
+	return pubKey, nil
+}
+
+// EncryptPassword encrypts the password with the public key of the server,
+// it's used by the caching_sha2_password and sha256_password plugins
+// when the connection is not secure.
+func EncryptPassword(password string, scramble []byte, pub *rsa.PublicKey) ([]byte, error) {
+	plain := make([]byte, len(password)+1)
+	_ = copy(plain, password)
+	for i := range plain {
+		plain[i] ^= scramble[i%len(scramble)]
+	}
+	return rsa.EncryptOAEP(sha1.New(), rand.Reader, pub, plain, nil)
+}
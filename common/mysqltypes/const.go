@@ -0,0 +1,147 @@
+package mysqltypes
+
+// MaxPacketSize is the maximum payload length of a single packet,
+// larger payloads are split in multiple packets by the peers.
+const MaxPacketSize = 1<<24 - 1
+
+const (
+	DefaultProtocolVersion byte = 10
+	// utf8mb4_general_ci
+	DefaultCharset byte = 45
+)
+
+// Command is the first byte of a packet sent by the client in the command phase
+// https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_command_phase.html
+type Command byte
+
+const (
+	ComQuit             Command = 0x01
+	ComInitDB           Command = 0x02
+	ComQuery            Command = 0x03
+	ComFieldList        Command = 0x04
+	ComStatistics       Command = 0x09
+	ComPing             Command = 0x0e
+	ComChangeUser       Command = 0x11
+	ComBinlogDump       Command = 0x12
+	ComStmtPrepare      Command = 0x16
+	ComStmtExecute      Command = 0x17
+	ComStmtSendLongData Command = 0x18
+	ComStmtClose        Command = 0x19
+	ComStmtReset        Command = 0x1a
+	ComSetOption        Command = 0x1b
+	ComStmtFetch        Command = 0x1c
+	ComResetConnection  Command = 0x1f
+)
+
+var commandMap = map[Command]string{
+	ComQuit:             "ComQuit",
+	ComInitDB:           "ComInitDB",
+	ComQuery:            "ComQuery",
+	ComFieldList:        "ComFieldList",
+	ComStatistics:       "ComStatistics",
+	ComPing:             "ComPing",
+	ComChangeUser:       "ComChangeUser",
+	ComBinlogDump:       "ComBinlogDump",
+	ComStmtPrepare:      "ComStmtPrepare",
+	ComStmtExecute:      "ComStmtExecute",
+	ComStmtSendLongData: "ComStmtSendLongData",
+	ComStmtClose:        "ComStmtClose",
+	ComStmtReset:        "ComStmtReset",
+	ComSetOption:        "ComSetOption",
+	ComStmtFetch:        "ComStmtFetch",
+	ComResetConnection:  "ComResetConnection",
+}
+
+func (c Command) Byte() byte { return byte(c) }
+func (c Command) String() string {
+	if s, ok := commandMap[c]; ok {
+		return s
+	}
+	return "unknown"
+}
+
+// generic response packets headers
+// https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_basic_response_packets.html
+const (
+	HeaderOK                byte = 0x00
+	HeaderAuthMoreData      byte = 0x01
+	HeaderLocalInfile       byte = 0xfb
+	HeaderEOF               byte = 0xfe
+	HeaderAuthSwitchRequest byte = 0xfe
+	HeaderErr               byte = 0xff
+)
+
+// CapabilityFlag are the flags exchanged in the connection phase
+// https://dev.mysql.com/doc/dev/mysql-server/latest/group__group__cs__capabilities__flags.html
+type CapabilityFlag uint32
+
+const (
+	ClientLongPassword CapabilityFlag = 1 << iota
+	ClientFoundRows
+	ClientLongFlag
+	ClientConnectWithDB
+	ClientNoSchema
+	ClientCompress
+	ClientODBC
+	ClientLocalFiles
+	ClientIgnoreSpace
+	ClientProtocol41
+	ClientInteractive
+	ClientSSL
+	ClientIgnoreSIGPIPE
+	ClientTransactions
+	ClientReserved
+	ClientSecureConnection
+	ClientMultiStatements
+	ClientMultiResults
+	ClientPSMultiResults
+	ClientPluginAuth
+	ClientConnectAttrs
+	ClientPluginAuthLenEncClientData
+	ClientCanHandleExpiredPasswords
+	ClientSessionTrack
+	ClientDeprecateEOF
+	ClientOptionalResultsetMetadata
+	ClientZstdCompressionAlgorithm
+	ClientQueryAttributes
+)
+
+// Has report if all the flags in f are set
+func (c CapabilityFlag) Has(f CapabilityFlag) bool { return c&f == f }
+
+// authentication plugins
+const (
+	NativePasswordPlugin      string = "mysql_native_password"
+	CachingSha2PasswordPlugin string = "caching_sha2_password"
+	Sha256PasswordPlugin      string = "sha256_password"
+	ClearPasswordPlugin       string = "mysql_clear_password"
+)
+
+// caching_sha2_password and sha256_password states
+const (
+	AuthRequestPublicKey       byte = 0x02
+	AuthCachingSha2FastSuccess byte = 0x03
+	AuthCachingSha2FullAuth    byte = 0x04
+)
+
+const (
+	StatusAutocommit uint16 = 0x0002
+)
+
+// server error codes
+// https://dev.mysql.com/doc/mysql-errors/8.0/en/server-error-reference.html
+const (
+	ErrHandshake          uint16 = 1043
+	ErrAccessDenied       uint16 = 1045
+	ErrUnknownCommand     uint16 = 1047
+	ErrNotSupportedAuth   uint16 = 1251
+	ErrMalformedPacket    uint16 = 1835
+	ErrConnectionFailure  uint16 = 2013
+	ErrUnknownServerError uint16 = 2000
+)
+
+const (
+	SQLStateGeneral         string = "HY000"
+	SQLStateAccessDenied    string = "28000"
+	SQLStateConnectionError string = "08S01"
+)
@@ -0,0 +1,436 @@
+package mysqltypes
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+)
+
+// Packet represents a MySQL packet
+// https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_basic_packets.html
+type Packet struct {
+	SequenceID uint8
+	// Payload of the packet
+	Frame []byte
+}
+
+// New creates a packet with the provided sequence id and payload
+func New(seq uint8, frame []byte) *Packet { return &Packet{SequenceID: seq, Frame: frame} }
+
+func (p *Packet) Encode() []byte {
+	dst := make([]byte, 4+len(p.Frame))
+	pktLen := len(p.Frame)
+	dst[0] = byte(pktLen)
+	dst[1] = byte(pktLen >> 8)
+	dst[2] = byte(pktLen >> 16)
+	dst[3] = p.SequenceID
+	_ = copy(dst[4:], p.Frame)
+	return dst
+}
+
+func (p *Packet) Length() int { return len(p.Frame) }
+func (p *Packet) Dump()       { fmt.Print(hex.Dump(p.Encode())) }
+
+// Header returns the first byte of the payload
+func (p *Packet) Header() byte {
+	if len(p.Frame) == 0 {
+		return 0x00
+	}
+	return p.Frame[0]
+}
+
+func (p *Packet) IsOK() bool  { return len(p.Frame) > 0 && p.Frame[0] == HeaderOK }
+func (p *Packet) IsErr() bool { return len(p.Frame) > 0 && p.Frame[0] == HeaderErr }
+
+// IsEOF report if it's an EOF packet, it uses the same header of the OK packet
+// when the capability ClientDeprecateEOF is set, the length distinguish the types
+func (p *Packet) IsEOF() bool { return len(p.Frame) > 0 && len(p.Frame) < 9 && p.Frame[0] == HeaderEOF }
+
+// Command returns the command type of a packet sent by a client
+func (p *Packet) Command() Command { return Command(p.Header()) }
+
+func Decode(data io.Reader) (*Packet, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(data, header[:]); err != nil {
+		return nil, err
+	}
+	pktLen := int(uint32(header[0]) | uint32(header[1])<<8 | uint32(header[2])<<16)
+	p := &Packet{SequenceID: header[3], Frame: make([]byte, pktLen)}
+	if _, err := io.ReadFull(data, p.Frame); err != nil {
+		return nil, fmt.Errorf("failed reading packet frame, err=%v", err)
+	}
+	return p, nil
+}
+
+// ParseQuery returns the query of a ComQuery packet payload or nil in case
+// it's not a query packet. When the query attributes capability is set it parses
+// only queries without attributes.
+func ParseQuery(frame []byte, capabilities CapabilityFlag) []byte {
+	if len(frame) < 2 || Command(frame[0]) != ComQuery {
+		return nil
+	}
+	frame = frame[1:]
+	if capabilities.Has(ClientQueryAttributes) {
+		paramCount, _, n := ReadLengthEncodedInteger(frame)
+		if paramCount > 0 || n == 0 {
+			return nil
+		}
+		// parameter_count + parameter_set_count (always 1)
+		frame = frame[n:]
+		_, _, n = ReadLengthEncodedInteger(frame)
+		frame = frame[n:]
+	}
+	return frame
+}
+
+// Handshake is the initial handshake packet (protocol version 10) sent by the server
+// https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_connection_phase_packets_protocol_handshake_v10.html
+type Handshake struct {
+	ProtocolVersion byte
+	ServerVersion   string
+	ConnectionID    uint32
+	// the scramble used to authenticate
+	AuthPluginData []byte
+	Capabilities   CapabilityFlag
+	CharacterSet   byte
+	StatusFlags    uint16
+	AuthPluginName string
+}
+
+func DecodeHandshake(frame []byte) (*Handshake, error) {
+	if len(frame) == 0 || frame[0] != DefaultProtocolVersion {
+		return nil, fmt.Errorf("unsupported protocol version, data=%X", frame[:min(len(frame), 1)])
+	}
+	h := &Handshake{ProtocolVersion: frame[0]}
+	buf := bytes.NewBuffer(frame[1:])
+	serverVersion, err := buf.ReadString(0x00)
+	if err != nil {
+		return nil, fmt.Errorf("failed decoding server version, err=%v", err)
+	}
+	h.ServerVersion = serverVersion[:len(serverVersion)-1]
+	// connection id (4) + auth-plugin-data-part-1 (8) + filler (1) + capability flags (2)
+	if buf.Len() < 15 {
+		return nil, fmt.Errorf("handshake packet is too short")
+	}
+	h.ConnectionID = binary.LittleEndian.Uint32(buf.Next(4))
+	h.AuthPluginData = append(h.AuthPluginData, buf.Next(8)...)
+	_ = buf.Next(1)
+	h.Capabilities = CapabilityFlag(binary.LittleEndian.Uint16(buf.Next(2)))
+	if buf.Len() == 0 {
+		return h, nil
+	}
+	// character set (1) + status flags (2) + capability flags upper (2) +
+	// auth plugin data length (1) + reserved (10)
+	if buf.Len() < 16 {
+		return nil, fmt.Errorf("handshake packet is too short")
+	}
+	h.CharacterSet = buf.Next(1)[0]
+	h.StatusFlags = binary.LittleEndian.Uint16(buf.Next(2))
+	h.Capabilities |= CapabilityFlag(binary.LittleEndian.Uint16(buf.Next(2))) << 16
+	authPluginDataLen := int(buf.Next(1)[0])
+	_ = buf.Next(10)
+	if h.Capabilities.Has(ClientSecureConnection) {
+		part2Len := max(13, authPluginDataLen-8)
+		if buf.Len() < part2Len {
+			return nil, fmt.Errorf("handshake packet is too short")
+		}
+		// the last byte is a null terminator
+		part2 := buf.Next(part2Len)
+		h.AuthPluginData = append(h.AuthPluginData, bytes.TrimRight(part2, "\x00")...)
+	}
+	if h.Capabilities.Has(ClientPluginAuth) {
+		pluginName, _ := buf.ReadString(0x00)
+		h.AuthPluginName = string(bytes.TrimRight([]byte(pluginName), "\x00"))
+	}
+	return h, nil
+}
+
+func (h *Handshake) Encode() []byte {
+	protocolVersion := h.ProtocolVersion
+	if protocolVersion == 0 {
+		protocolVersion = DefaultProtocolVersion
+	}
+	authData := make([]byte, max(len(h.AuthPluginData), 20))
+	_ = copy(authData, h.AuthPluginData)
+	data := []byte{protocolVersion}
+	data = append(data, h.ServerVersion...)
+	data = append(data, 0x00)
+	data = binary.LittleEndian.AppendUint32(data, h.ConnectionID)
+	data = append(data, authData[:8]...)
+	data = append(data, 0x00)
+	data = binary.LittleEndian.AppendUint16(data, uint16(h.Capabilities))
+	data = append(data, h.CharacterSet)
+	data = binary.LittleEndian.AppendUint16(data, h.StatusFlags)
+	data = binary.LittleEndian.AppendUint16(data, uint16(h.Capabilities>>16))
+	if h.Capabilities.Has(ClientPluginAuth) {
+		data = append(data, byte(len(authData)+1))
+	} else {
+		data = append(data, 0x00)
+	}
+	data = append(data, make([]byte, 10)...)
+	if h.Capabilities.Has(ClientSecureConnection) {
+		data = append(data, authData[8:]...)
+		data = append(data, 0x00)
+	}
+	if h.Capabilities.Has(ClientPluginAuth) {
+		data = append(data, h.AuthPluginName...)
+		data = append(data, 0x00)
+	}
+	return data
+}
+
+// HandshakeResponse is the response sent by the client for the initial handshake.
+// Only the protocol 4.1 is supported.
+// https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_connection_phase_packets_protocol_handshake_response.html
+type HandshakeResponse struct {
+	Capabilities   CapabilityFlag
+	MaxPacketSize  uint32
+	CharacterSet   byte
+	Username       string
+	AuthResponse   []byte
+	Database       string
+	AuthPluginName string
+	// the raw key value attributes without the length prefix
+	ConnectAttrs         []byte
+	ZstdCompressionLevel byte
+}
+
+// IsSSLRequest report if the frame is a SSL request sent by the client
+// before proceeding with the handshake response.
+func IsSSLRequest(frame []byte) bool {
+	if len(frame) != 32 {
+		return false
+	}
+	capabilities := CapabilityFlag(binary.LittleEndian.Uint32(frame[:4]))
+	return capabilities.Has(ClientSSL)
+}
+
+func DecodeHandshakeResponse(frame []byte) (*HandshakeResponse, error) {
+	// capabilities (4) + max packet size (4) + character set (1) + filler (23)
+	if len(frame) < 32 {
+		return nil, fmt.Errorf("handshake response packet is too short")
+	}
+	r := &HandshakeResponse{}
+	buf := bytes.NewBuffer(frame)
+	r.Capabilities = CapabilityFlag(binary.LittleEndian.Uint32(buf.Next(4)))
+	if !r.Capabilities.Has(ClientProtocol41) {
+		return nil, fmt.Errorf("client protocol version 320 is not supported")
+	}
+	r.MaxPacketSize = binary.LittleEndian.Uint32(buf.Next(4))
+	r.CharacterSet = buf.Next(1)[0]
+	_ = buf.Next(23)
+	username, err := buf.ReadString(0x00)
+	if err != nil {
+		return nil, fmt.Errorf("failed decoding username, err=%v", err)
+	}
+	r.Username = username[:len(username)-1]
+	switch {
+	case r.Capabilities.Has(ClientPluginAuthLenEncClientData):
+		authLen, _, n := ReadLengthEncodedInteger(buf.Bytes())
+		if n == 0 || uint64(buf.Len()-n) < authLen {
+			return nil, fmt.Errorf("failed decoding auth response")
+		}
+		_ = buf.Next(n)
+		r.AuthResponse = append([]byte{}, buf.Next(int(authLen))...)
+	case r.Capabilities.Has(ClientSecureConnection):
+		if buf.Len() == 0 {
+			return nil, fmt.Errorf("failed decoding auth response")
+		}
+		authLen := int(buf.Next(1)[0])
+		if buf.Len() < authLen {
+			return nil, fmt.Errorf("failed decoding auth response")
+		}
+		r.AuthResponse = append([]byte{}, buf.Next(authLen)...)
+	default:
+		authResponse, err := buf.ReadBytes(0x00)
+		if err != nil {
+			return nil, fmt.Errorf("failed decoding auth response, err=%v", err)
+		}
+		r.AuthResponse = authResponse[:len(authResponse)-1]
+	}
+	if r.Capabilities.Has(ClientConnectWithDB) {
+		database, _ := buf.ReadString(0x00)
+		r.Database = string(bytes.TrimRight([]byte(database), "\x00"))
+	}
+	if r.Capabilities.Has(ClientPluginAuth) {
+		pluginName, _ := buf.ReadString(0x00)
+		r.AuthPluginName = string(bytes.TrimRight([]byte(pluginName), "\x00"))
+	}
+	if r.Capabilities.Has(ClientConnectAttrs) && buf.Len() > 0 {
+		attrsLen, _, n := ReadLengthEncodedInteger(buf.Bytes())
+		if n == 0 || uint64(buf.Len()-n) < attrsLen {
+			return nil, fmt.Errorf("failed decoding connection attributes")
+		}
+		_ = buf.Next(n)
+		r.ConnectAttrs = append([]byte{}, buf.Next(int(attrsLen))...)
+	}
+	if r.Capabilities.Has(ClientZstdCompressionAlgorithm) && buf.Len() > 0 {
+		r.ZstdCompressionLevel = buf.Next(1)[0]
+	}
+	return r, nil
+}
+
+func (r *HandshakeResponse) Encode() []byte {
+	data := binary.LittleEndian.AppendUint32(nil, uint32(r.Capabilities))
+	data = binary.LittleEndian.AppendUint32(data, r.MaxPacketSize)
+	data = append(data, r.CharacterSet)
+	data = append(data, make([]byte, 23)...)
+	data = append(data, r.Username...)
+	data = append(data, 0x00)
+	switch {
+	case r.Capabilities.Has(ClientPluginAuthLenEncClientData):
+		data = AppendLengthEncodedInteger(data, uint64(len(r.AuthResponse)))
+		data = append(data, r.AuthResponse...)
+	case r.Capabilities.Has(ClientSecureConnection):
+		data = append(data, byte(len(r.AuthResponse)))
+		data = append(data, r.AuthResponse...)
+	default:
+		data = append(data, r.AuthResponse...)
+		data = append(data, 0x00)
+	}
+	if r.Capabilities.Has(ClientConnectWithDB) {
+		data = append(data, r.Database...)
+		data = append(data, 0x00)
+	}
+	if r.Capabilities.Has(ClientPluginAuth) {
+		data = append(data, r.AuthPluginName...)
+		data = append(data, 0x00)
+	}
+	if r.Capabilities.Has(ClientConnectAttrs) {
+		data = AppendLengthEncodedInteger(data, uint64(len(r.ConnectAttrs)))
+		data = append(data, r.ConnectAttrs...)
+	}
+	if r.Capabilities.Has(ClientZstdCompressionAlgorithm) {
+		data = append(data, r.ZstdCompressionLevel)
+	}
+	return data
+}
+
+// DecodeAuthSwitchRequest returns the plugin name and the data (scramble)
+// of an auth switch request sent by the server
+func DecodeAuthSwitchRequest(frame []byte) (pluginName string, data []byte, err error) {
+	if len(frame) < 2 || frame[0] != HeaderAuthSwitchRequest {
+		return "", nil, fmt.Errorf("it's not an auth switch request packet")
+	}
+	name, rest, found := bytes.Cut(frame[1:], []byte{0x00})
+	if !found {
+		return "", nil, fmt.Errorf("failed decoding plugin name from auth switch request")
+	}
+	return string(name), bytes.TrimRight(rest, "\x00"), nil
+}
+
+// ErrPacket is the error packet sent by the server
+// https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_basic_err_packet.html
+type ErrPacket struct {
+	Code     uint16
+	SQLState string
+	Message  string
+}
+
+func (e *ErrPacket) Error() string {
+	if e.SQLState == "" {
+		return fmt.Sprintf("ERROR %d: %s", e.Code, e.Message)
+	}
+	return fmt.Sprintf("ERROR %d (%s): %s", e.Code, e.SQLState, e.Message)
+}
+
+// DecodeErr decodes an error packet assuming the protocol 4.1 is being used,
+// it returns nil in case it's not an error packet.
+func DecodeErr(frame []byte) *ErrPacket {
+	if len(frame) < 3 || frame[0] != HeaderErr {
+		return nil
+	}
+	e := &ErrPacket{Code: binary.LittleEndian.Uint16(frame[1:3])}
+	msg := frame[3:]
+	if len(msg) >= 6 && msg[0] == '#' {
+		e.SQLState = string(msg[1:6])
+		msg = msg[6:]
+	}
+	e.Message = string(msg)
+	return e
+}
+
+// NewErrPacket creates an error packet using the protocol 4.1 format
+func NewErrPacket(seq uint8, code uint16, sqlState, format string, a ...any) *Packet {
+	if sqlState == "" {
+		sqlState = SQLStateGeneral
+	}
+	frame := []byte{HeaderErr}
+	frame = binary.LittleEndian.AppendUint16(frame, code)
+	frame = append(frame, '#')
+	frame = append(frame, sqlState[:5]...)
+	frame = append(frame, fmt.Sprintf(format, a...)...)
+	return New(seq, frame)
+}
+
+// NewOKPacket creates an ok packet without affected rows using the protocol 4.1 format
+func NewOKPacket(seq uint8, statusFlags uint16) *Packet {
+	// header, affected rows (lenenc), last insert id (lenenc)
+	frame := []byte{HeaderOK, 0x00, 0x00}
+	frame = binary.LittleEndian.AppendUint16(frame, statusFlags)
+	// warnings
+	frame = binary.LittleEndian.AppendUint16(frame, 0)
+	return New(seq, frame)
+}
+
+// ReadLengthEncodedInteger decodes a length encoded integer returning the number
+// and how many bytes were read. A zero value of n means it was unable to decode it.
+// https://dev.mysql.com/doc/dev/mysql-server/latest/page_protocol_basic_dt_integers.html
+func ReadLengthEncodedInteger(b []byte) (num uint64, isNull bool, n int) {
+	if len(b) == 0 {
+		return 0, true, 0
+	}
+	switch b[0] {
+	// 251: NULL
+	case 0xfb:
+		return 0, true, 1
+	// 252: value of following 2
+	case 0xfc:
+		if len(b) < 3 {
+			return 0, false, 0
+		}
+		return uint64(b[1]) | uint64(b[2])<<8, false, 3
+	// 253: value of following 3
+	case 0xfd:
+		if len(b) < 4 {
+			return 0, false, 0
+		}
+		return uint64(b[1]) | uint64(b[2])<<8 | uint64(b[3])<<16, false, 4
+	// 254: value of following 8
+	case 0xfe:
+		if len(b) < 9 {
+			return 0, false, 0
+		}
+		return binary.LittleEndian.Uint64(b[1:9]), false, 9
+	}
+	// 0-250: value of first byte
+	return uint64(b[0]), false, 1
+}
+
+// AppendLengthEncodedInteger appends n as a length encoded integer into b
+func AppendLengthEncodedInteger(b []byte, n uint64) []byte {
+	switch {
+	case n <= 250:
+		return append(b, byte(n))
+	case n <= 0xffff:
+		return append(b, 0xfc, byte(n), byte(n>>8))
+	case n <= 0xffffff:
+		return append(b, 0xfd, byte(n), byte(n>>8), byte(n>>16))
+	}
+	return binary.LittleEndian.AppendUint64(append(b, 0xfe), n)
+}
+
+// ReadLengthEncodedString decodes a length encoded string returning the value
+// and how many bytes were read. A zero value of n means it was unable to decode it.
+func ReadLengthEncodedString(b []byte) (v []byte, isNull bool, n int) {
+	num, isNull, n := ReadLengthEncodedInteger(b)
+	if n == 0 || isNull {
+		return nil, isNull, n
+	}
+	if uint64(len(b[n:])) < num {
+		return nil, false, 0
+	}
+	return b[n : n+int(num)], false, n + int(num)
+}
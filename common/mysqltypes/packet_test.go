@@ -0,0 +1,137 @@
+package mysqltypes
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEncodeDecodePacket(t *testing.T) {
+	pkt := New(3, []byte{ComQuery.Byte(), 's', 'e', 'l', 'e', 'c', 't', ' ', '1'})
+	data := pkt.Encode()
+	assert.Equal(t, []byte{0x09, 0x00, 0x00, 0x03}, data[:4])
+
+	got, err := Decode(bytes.NewBuffer(data))
+	assert.Nil(t, err)
+	assert.Equal(t, pkt, got)
+	assert.Equal(t, ComQuery, got.Command())
+}
+
+func TestHandshakeRoundTrip(t *testing.T) {
+	h := &Handshake{
+		ProtocolVersion: DefaultProtocolVersion,
+		ServerVersion:   "8.0.36",
+		ConnectionID:    42,
+		AuthPluginData:  []byte("abcdefghijklmnopqrst"),
+		Capabilities:    ClientProtocol41 | ClientSecureConnection | ClientPluginAuth | ClientLongPassword,
+		CharacterSet:    DefaultCharset,
+		StatusFlags:     StatusAutocommit,
+		AuthPluginName:  CachingSha2PasswordPlugin,
+	}
+	got, err := DecodeHandshake(h.Encode())
+	assert.Nil(t, err)
+	assert.Equal(t, h, got)
+}
+
+func TestHandshakeResponseRoundTrip(t *testing.T) {
+	for _, tt := range []struct {
+		msg  string
+		resp *HandshakeResponse
+	}{
+		{
+			msg: "it must encode and decode length encoded auth response",
+			resp: &HandshakeResponse{
+				Capabilities: ClientProtocol41 | ClientSecureConnection | ClientPluginAuth |
+					ClientPluginAuthLenEncClientData | ClientConnectWithDB | ClientConnectAttrs,
+				MaxPacketSize:  MaxPacketSize,
+				CharacterSet:   DefaultCharset,
+				Username:       "root",
+				AuthResponse:   bytes.Repeat([]byte{0xfa}, 20),
+				Database:       "testdb",
+				AuthPluginName: NativePasswordPlugin,
+				ConnectAttrs:   []byte{0x04, '_', 'o', 's', 0x05, 'l', 'i', 'n', 'u', 'x'},
+			},
+		},
+		{
+			msg: "it must encode and decode secure connection auth response",
+			resp: &HandshakeResponse{
+				Capabilities:   ClientProtocol41 | ClientSecureConnection,
+				MaxPacketSize:  MaxPacketSize,
+				CharacterSet:   DefaultCharset,
+				Username:       "bifrost",
+				AuthResponse:   []byte{0x01, 0x02, 0x03},
+				AuthPluginName: "",
+			},
+		},
+	} {
+		t.Run(tt.msg, func(t *testing.T) {
+			got, err := DecodeHandshakeResponse(tt.resp.Encode())
+			assert.Nil(t, err)
+			assert.Equal(t, tt.resp, got)
+			assert.False(t, IsSSLRequest(tt.resp.Encode()))
+		})
+	}
+}
+
+func TestDecodeErr(t *testing.T) {
+	pkt := NewErrPacket(2, ErrAccessDenied, SQLStateAccessDenied, "Access denied for user '%s'", "root")
+	got := DecodeErr(pkt.Frame)
+	assert.True(t, pkt.IsErr())
+	assert.Equal(t, &ErrPacket{Code: ErrAccessDenied, SQLState: SQLStateAccessDenied, Message: "Access denied for user 'root'"}, got)
+	assert.Nil(t, DecodeErr(NewOKPacket(2, StatusAutocommit).Frame))
+}
+
+func TestParseQuery(t *testing.T) {
+	for _, tt := range []struct {
+		msg          string
+		frame        []byte
+		capabilities CapabilityFlag
+		expected     []byte
+	}{
+		{
+			msg:      "it must parse a simple query",
+			frame:    append([]byte{ComQuery.Byte()}, "SELECT 1"...),
+			expected: []byte("SELECT 1"),
+		},
+		{
+			msg:          "it must parse a query without attributes",
+			frame:        append([]byte{ComQuery.Byte(), 0x00, 0x01}, "SELECT 1"...),
+			capabilities: ClientQueryAttributes,
+			expected:     []byte("SELECT 1"),
+		},
+		{
+			msg:      "it must return nil when it's not a query",
+			frame:    []byte{ComPing.Byte()},
+			expected: nil,
+		},
+	} {
+		t.Run(tt.msg, func(t *testing.T) {
+			assert.Equal(t, tt.expected, ParseQuery(tt.frame, tt.capabilities))
+		})
+	}
+}
+
+func TestScrambleNativePassword(t *testing.T) {
+	scramble := []byte("12345678901234567890")
+	got := ScrambleNativePassword(scramble, "secret")
+	// emulate the verification performed by the server
+	stage1 := sha1.Sum([]byte("secret"))
+	stage2 := sha1.Sum(stage1[:])
+	h := sha1.Sum(append(append([]byte{}, scramble...), stage2[:]...))
+	for i := range got {
+		got[i] ^= h[i]
+	}
+	assert.Equal(t, stage1[:], got)
+	assert.Nil(t, ScrambleNativePassword(scramble, ""))
+}
+
+func TestLengthEncodedInteger(t *testing.T) {
+	for _, n := range []uint64{0, 250, 251, 0xffff, 0x10000, 0xffffff, 0x1000000} {
+		num, isNull, read := ReadLengthEncodedInteger(AppendLengthEncodedInteger(nil, n))
+		assert.False(t, isNull)
+		assert.Equal(t, n, num)
+		assert.Greater(t, read, 0)
+	}
+}
@@ -70,8 +70,34 @@ var clientPacketType = map[PacketType]string{
 const (
 	ClientSSLRequestMessage    uint32 = 80877103
 	ClientGSSENCRequestMessage uint32 = 80877104
+	// ProtocolVersion is the version 3.0 of the protocol sent in the startup message
+	ProtocolVersion uint32 = 196608
 )
 
+// server responses of a SSLRequest or GSSENCRequest
+const (
+	ServerSSLAccepted byte = 'S'
+	ServerSSLRejected byte = 'N'
+)
+
+// ServerNegotiateProtocolVersion is sent when the server doesn't support
+// the minor version or the protocol options requested by the client
+const ServerNegotiateProtocolVersion PacketType = 'v'
+
+type AuthType uint32
+
+// https://www.postgresql.org/docs/current/protocol-message-formats.html
+const (
+	AuthenticationOk                AuthType = 0
+	AuthenticationCleartextPassword AuthType = 3
+	AuthenticationMD5Password       AuthType = 5
+	AuthenticationSASL              AuthType = 10
+	AuthenticationSASLContinue      AuthType = 11
+	AuthenticationSASLFinal         AuthType = 12
+)
+
+const ScramSha256Mechanism = "SCRAM-SHA-256"
+
 func isClientType(packetType byte) (isClient bool) {
 	_, isClient = clientPacketType[PacketType(packetType)]
 	return
@@ -0,0 +1,155 @@
+package pgtypes
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// StartupMessage is the first message sent by the client
+// after the SSL negotiation
+type StartupMessage struct {
+	ProtocolVersion uint32
+	Parameters      map[string]string
+}
+
+// DecodeStartupMessage decodes the frame of a startup packet
+func DecodeStartupMessage(frame []byte) (*StartupMessage, error) {
+	if len(frame) < 4 {
+		return nil, fmt.Errorf("startup message is too short")
+	}
+	msg := &StartupMessage{
+		ProtocolVersion: binary.BigEndian.Uint32(frame[:4]),
+		Parameters:      map[string]string{},
+	}
+	data := frame[4:]
+	for len(data) > 0 && data[0] != 0x00 {
+		key, rest, found := bytes.Cut(data, []byte{0x00})
+		if !found {
+			return nil, fmt.Errorf("failed decoding startup parameter name")
+		}
+		val, rest, found := bytes.Cut(rest, []byte{0x00})
+		if !found {
+			return nil, fmt.Errorf("failed decoding startup parameter %q", key)
+		}
+		msg.Parameters[string(key)] = string(val)
+		data = rest
+	}
+	return msg, nil
+}
+
+// Encode encodes the startup message, the parameters are sorted
+// to produce the same packet for the same parameters.
+func (m *StartupMessage) Encode() *Packet {
+	keys := make([]string, 0, len(m.Parameters))
+	for key := range m.Parameters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	frame := binary.BigEndian.AppendUint32(nil, m.ProtocolVersion)
+	for _, key := range keys {
+		frame = append(frame, key...)
+		frame = append(frame, 0x00)
+		frame = append(frame, m.Parameters[key]...)
+		frame = append(frame, 0x00)
+	}
+	frame = append(frame, 0x00)
+	return (&Packet{frame: frame}).setHeaderLength(len(frame) + 4)
+}
+
+// NewSSLRequest creates the packet that requests a SSL connection
+func NewSSLRequest() *Packet {
+	frame := binary.BigEndian.AppendUint32(nil, ClientSSLRequestMessage)
+	return (&Packet{frame: frame}).setHeaderLength(len(frame) + 4)
+}
+
+// NewAuthenticationOK creates the packet that informs a successful authentication
+func NewAuthenticationOK() *Packet {
+	return NewPacket(ServerAuth, binary.BigEndian.AppendUint32(nil, uint32(AuthenticationOk)))
+}
+
+// AuthType returns the authentication request type of a server authentication packet
+func (p *Packet) AuthType() (AuthType, error) {
+	if p.Type() != ServerAuth || len(p.frame) < 4 {
+		return 0, fmt.Errorf("it's not an authentication packet")
+	}
+	return AuthType(binary.BigEndian.Uint32(p.frame[:4])), nil
+}
+
+// AuthData returns the data of a server authentication packet
+// without the authentication type
+func (p *Packet) AuthData() []byte {
+	if len(p.frame) < 4 {
+		return nil
+	}
+	return p.frame[4:]
+}
+
+// NewPasswordMessage creates a password message packet, it's used
+// as the response of the password, md5 and sasl authentication requests.
+func NewPasswordMessage(data []byte) *Packet {
+	return NewPacket(ClientPassword, data)
+}
+
+// NewSASLInitialResponse creates the first message of the SASL authentication
+func NewSASLInitialResponse(mechanism string, data []byte) *Packet {
+	frame := append([]byte(mechanism), 0x00)
+	frame = binary.BigEndian.AppendUint32(frame, uint32(len(data)))
+	frame = append(frame, data...)
+	return NewPacket(ClientPassword, frame)
+}
+
+// ParseSASLMechanisms parses the mechanisms sent by the server
+// in a AuthenticationSASL packet
+func ParseSASLMechanisms(data []byte) []string {
+	var mechanisms []string
+	for _, mechanism := range bytes.Split(data, []byte{0x00}) {
+		if len(mechanism) > 0 {
+			mechanisms = append(mechanisms, string(mechanism))
+		}
+	}
+	return mechanisms
+}
+
+// EncryptMD5Password encrypts the password with the salt of the server.
+// The result is concat('md5', md5(concat(md5(concat(password, username)), salt)))
+func EncryptMD5Password(username, password string, salt []byte) string {
+	h := md5.Sum([]byte(password + username))
+	passwdHash := hex.EncodeToString(h[:])
+	h = md5.Sum(append([]byte(passwdHash), salt...))
+	return "md5" + hex.EncodeToString(h[:])
+}
+
+// ErrorResponse is the decoded error or notice response sent by the server
+type ErrorResponse struct {
+	Severity string
+	Code     string
+	Message  string
+}
+
+func (e *ErrorResponse) Error() string {
+	return fmt.Sprintf("%s: %s (SQLSTATE %s)", e.Severity, e.Message, e.Code)
+}
+
+// DecodeErrorResponse decodes the fields of an error response frame
+func DecodeErrorResponse(frame []byte) *ErrorResponse {
+	resp := &ErrorResponse{}
+	for _, field := range bytes.Split(frame, []byte{0x00}) {
+		if len(field) < 2 {
+			continue
+		}
+		switch field[0] {
+		case 'S':
+			resp.Severity = string(field[1:])
+		case 'C':
+			resp.Code = string(field[1:])
+		case 'M':
+			resp.Message = strings.TrimSpace(string(field[1:]))
+		}
+	}
+	return resp
+}
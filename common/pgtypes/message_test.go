@@ -0,0 +1,61 @@
+package pgtypes
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStartupMessage(t *testing.T) {
+	msg := &StartupMessage{
+		ProtocolVersion: ProtocolVersion,
+		Parameters:      map[string]string{"user": "bob", "database": "dellstore", "application_name": "psql"},
+	}
+	pkt, err := Decode(bytes.NewBuffer(msg.Encode().Encode()))
+	assert.Nil(t, err)
+	assert.False(t, pkt.IsFrontendSSLRequest())
+	assert.False(t, pkt.IsCancelRequest())
+	got, err := DecodeStartupMessage(pkt.Frame())
+	assert.Nil(t, err)
+	assert.Equal(t, msg, got)
+}
+
+func TestSSLRequest(t *testing.T) {
+	pkt, err := Decode(bytes.NewBuffer(NewSSLRequest().Encode()))
+	assert.Nil(t, err)
+	assert.True(t, pkt.IsFrontendSSLRequest())
+}
+
+func TestDecodeTyped(t *testing.T) {
+	for _, tt := range []struct {
+		msg  string
+		pkt  *Packet
+		want PacketType
+	}{
+		{msg: "it must decode server packets", pkt: NewAuthenticationOK(), want: ServerAuth},
+		{msg: "it must decode packets with the same type of client packets", pkt: NewPacket(ServerParameterStatus, []byte("TimeZone\x00UTC\x00")), want: ServerParameterStatus},
+	} {
+		t.Run(tt.msg, func(t *testing.T) {
+			got, err := DecodeTyped(bytes.NewBuffer(tt.pkt.Encode()))
+			assert.Nil(t, err)
+			assert.Equal(t, tt.want, got.Type())
+			assert.Equal(t, tt.pkt.Encode(), got.Encode())
+		})
+	}
+}
+
+func TestEncryptMD5Password(t *testing.T) {
+	got := EncryptMD5Password("postgres", "secret", []byte{0x01, 0x02, 0x03, 0x04})
+	assert.Equal(t, "md5bb41a296aab6baccb36ff243a562abff", got)
+}
+
+func TestDecodeErrorResponse(t *testing.T) {
+	pkt := NewFatalError("password authentication failed for user %q", "bob")
+	got := DecodeErrorResponse(pkt.Frame())
+	assert.Equal(t, &ErrorResponse{
+		Severity: string(LevelFatal),
+		Code:     string(ConnectionFailure),
+		Message:  `password authentication failed for user "bob"`,
+	}, got)
+}
@@ -166,3 +166,34 @@ func (p *Packet) setHeaderLength(length int) *Packet {
 	p.header = header
 	return p
 }
+
+// NewPacket creates a packet with a type, messages without a type
+// (startup, ssl and cancel requests) must use NewStartupMessage
+func NewPacket(typ PacketType, frame []byte) *Packet {
+	t := typ.Byte()
+	p := &Packet{typ: &t, frame: frame}
+	return p.setHeaderLength(len(frame) + 4)
+}
+
+// DecodeTyped decodes a packet that always starts with the type byte.
+// It must be used to decode messages sent by the server and the messages
+// sent by the client after the startup phase.
+func DecodeTyped(data io.Reader) (*Packet, error) {
+	var typ [1]byte
+	if _, err := io.ReadFull(data, typ[:]); err != nil {
+		return nil, err
+	}
+	pkt := &Packet{typ: &typ[0]}
+	if _, err := io.ReadFull(data, pkt.header[:]); err != nil {
+		return nil, err
+	}
+	pktLen := pkt.HeaderLength() - 4 // length includes itself.
+	if pktLen > DefaultBufferSize || pktLen < 0 {
+		return nil, fmt.Errorf("max size (%v) reached", DefaultBufferSize)
+	}
+	pkt.frame = make([]byte, pktLen)
+	if _, err := io.ReadFull(data, pkt.frame); err != nil {
+		return nil, fmt.Errorf("failed reading packet frame, err=%v", err)
+	}
+	return pkt, nil
+}
@@ -3,8 +3,10 @@ package pbagent
 
 const (
 	GatewayConnectOK = "AgentGatewayConnectOK"
-	SessionOpen      = "AgentSessionOpen"
-	SessionClose     = "AgentSessionClose"
+	// the gateway is shutting down, the agent must not accept new sessions
+	GatewayDraining = "AgentGatewayDraining"
+	SessionOpen     = "AgentSessionOpen"
+	SessionClose    = "AgentSessionClose"
 
 	ExecWriteStdin = "AgentExecWriteStdin"
 
@@ -20,4 +22,8 @@ const (
 	MongoDBConnectionWrite   = "AgentMongoDBConnectionWrite"
 	SSHConnectionWrite       = "AgentSSHConnectionWrite"
 	HttpProxyConnectionWrite = "AgentHttpProxyConnectionWrite"
+
+	// the client has consumed data of a connection, the payload is the number of bytes
+	// the agent is allowed to send, see proto.FlowWindow
+	ConnectionCredit = "AgentConnectionCredit"
 )
@@ -8,6 +8,9 @@ const (
 	SessionOpenApproveOK       = "ClientSessionOpenApproveOK"
 	SessionOpenAgentOffline    = "ClientSessionOpenAgentOffline"
 	SessionClose               = "ClientSessionClose"
+	// the agent has the session after reconnecting, SpecSessionAck is the last sequence
+	// received from the gateway, see proto.ReplayBuffer
+	SessionResume = "ClientSessionResume"
 
 	ProxyManagerConnectOK = "ClientProxyManagerConnectOK"
 
@@ -21,4 +24,8 @@ const (
 	WriteStdout              = "ClientWriteStdout"
 	WriteStderr              = "ClientWriteStderr"
 	HttpProxyConnectionWrite = "ClientHttpProxyConnectionWrite"
+
+	// the agent has written data of a connection to the target, the payload is the
+	// number of bytes the client is allowed to send, see proto.FlowWindow
+	ConnectionCredit = "ClientConnectionCredit"
 )
@@ -37,6 +37,25 @@ const (
 	SpecJitStatus           string = "jit.status"
 	SpecJitTimeout          string = "jit.timeout"
 
+	// SpecTraceContextPrefix prefixes the keys of the trace context (traceparent, tracestate)
+	SpecTraceContextPrefix string = "trace."
+	// SpecFlowControlWindow is the receive window of each connection in bytes, the
+	// client sends it in SessionOpen and the agent in SessionOpenOK to enable the flow control
+	SpecFlowControlWindow string = "flow.window"
+
+	// SpecSessionSequence numbers the packets of a session sent between the gateway and the
+	// agent, each side numbers its own packets from 1. SpecSessionAck is the last sequence
+	// received from the other side, see ReplayBuffer.
+	SpecSessionSequence string = "session.seq"
+	SpecSessionAck      string = "session.ack"
+	// SpecResumeSessions are the suspended sessions of the agent replica sent in GatewayConnectOK,
+	// SpecSessionResumeGrace is how long the agent keeps its sessions while it reconnects.
+	SpecResumeSessions     string = "gateway.resume_sessions"
+	SpecSessionResumeGrace string = "gateway.session_resume_grace"
+
+	// DefaultFlowControlWindow is the amount of data of a connection that can be in flight
+	DefaultFlowControlWindow = 256 * 1024
+
 	DefaultKeepAlive time.Duration = 10 * time.Second
 
 	ConnectionTypeCommandLine ConnectionType = "command-line"
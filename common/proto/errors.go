@@ -1,8 +1,44 @@
 package proto
 
 import (
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/grpc/codes"
 	status "google.golang.org/grpc/status"
 )
 
 var ErrAgentOffline = status.Errorf(codes.FailedPrecondition, "agent is offline")
+
+// The reasons of authentication errors, they are sent as the reason of
+// the error info details of Unauthenticated errors.
+const (
+	AuthErrMissingCredentials string = "MISSING_CREDENTIALS"
+	AuthErrInvalidCredentials string = "INVALID_CREDENTIALS"
+	AuthErrUnknownCredentials string = "UNKNOWN_CREDENTIALS"
+	AuthErrExpiredCredentials string = "EXPIRED_CREDENTIALS"
+
+	authErrDomain string = "bifrost"
+)
+
+// NewAuthError returns an Unauthenticated error with the reason in its details
+func NewAuthError(reason, msg string) error {
+	st := status.New(codes.Unauthenticated, msg)
+	if stWithDetails, err := st.WithDetails(&errdetails.ErrorInfo{Reason: reason, Domain: authErrDomain}); err == nil {
+		st = stWithDetails
+	}
+	return st.Err()
+}
+
+// AuthErrorReason returns the reason of an authentication error,
+// it returns an empty string if the error is not an authentication error.
+func AuthErrorReason(err error) string {
+	st, ok := status.FromError(err)
+	if !ok || st.Code() != codes.Unauthenticated {
+		return ""
+	}
+	for _, detail := range st.Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok && info.Domain == authErrDomain {
+			return info.Reason
+		}
+	}
+	return ""
+}
@@ -0,0 +1,25 @@
+package proto
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestAuthErrorReason(t *testing.T) {
+	for _, tt := range []struct {
+		msg  string
+		err  error
+		want string
+	}{
+		{msg: "it must return the reason of expired credentials", err: NewAuthError(AuthErrExpiredCredentials, "token expired"), want: AuthErrExpiredCredentials},
+		{msg: "it must return the reason of unknown credentials", err: NewAuthError(AuthErrUnknownCredentials, "unknown key"), want: AuthErrUnknownCredentials},
+		{msg: "it must return empty for other status errors", err: ErrAgentOffline, want: ""},
+		{msg: "it must return empty for non status errors", err: fmt.Errorf("generic error"), want: ""},
+	} {
+		t.Run(tt.msg, func(t *testing.T) {
+			if got := AuthErrorReason(tt.err); got != tt.want {
+				t.Errorf("expected reason %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
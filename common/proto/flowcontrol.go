@@ -0,0 +1,124 @@
+package proto
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+var ErrFlowWindowClosed = fmt.Errorf("flow control window is closed")
+
+// FlowWindow is the send window of a connection. The sender acquires credits before
+// sending data and waits when the window is exhausted, the receiver grants the credits
+// back (ConnectionCredit packets) as it consumes the data. A slow receiver throttles
+// the reads of the sender instead of accumulating data in the gateway or in the receiver.
+type FlowWindow struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	credits int
+	closed  bool
+}
+
+// NewFlowWindow returns a window with the initial credits of the receiver
+func NewFlowWindow(size int) *FlowWindow {
+	w := &FlowWindow{credits: size}
+	w.cond = sync.NewCond(&w.mu)
+	return w
+}
+
+// Acquire waits until there are credits and takes up to max of them, it returns
+// the number of bytes that can be sent or an error if the window is closed.
+func (w *FlowWindow) Acquire(max int) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for w.credits <= 0 && !w.closed {
+		w.cond.Wait()
+	}
+	if w.closed {
+		return 0, ErrFlowWindowClosed
+	}
+	n := min(max, w.credits)
+	w.credits -= n
+	return n, nil
+}
+
+// AcquireMessage waits until there are credits and takes size of them, a message bigger
+// than the credits takes the window below zero instead of being split. The receiver holds
+// at most a message more than the window.
+func (w *FlowWindow) AcquireMessage(size int) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for w.credits <= 0 && !w.closed {
+		w.cond.Wait()
+	}
+	if w.closed {
+		return ErrFlowWindowClosed
+	}
+	w.credits -= size
+	return nil
+}
+
+// Grant adds the credits returned by the receiver
+func (w *FlowWindow) Grant(n int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.credits += n
+	w.cond.Broadcast()
+}
+
+// Close releases the senders waiting for credits
+func (w *FlowWindow) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	w.cond.Broadcast()
+	return nil
+}
+
+// FlowCredits counts the data of a connection consumed by the receiver. The credits are
+// returned in batches of half the window, it avoids a credit packet for every read.
+type FlowCredits struct {
+	mu       sync.Mutex
+	window   int
+	consumed int
+}
+
+func NewFlowCredits(window int) *FlowCredits {
+	return &FlowCredits{window: window}
+}
+
+// Consume records n consumed bytes, it returns the credits to grant to the
+// sender or zero if they should be accumulated.
+func (c *FlowCredits) Consume(n int) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.consumed += n
+	if c.consumed < max(c.window/2, 1) {
+		return 0
+	}
+	credits := c.consumed
+	c.consumed = 0
+	return credits
+}
+
+// EncodeFlowCredits encodes the payload of a ConnectionCredit packet
+func EncodeFlowCredits(n int) []byte { return []byte(strconv.Itoa(n)) }
+
+// DecodeFlowCredits decodes the payload of a ConnectionCredit packet
+func DecodeFlowCredits(payload []byte) (int, error) {
+	n, err := strconv.Atoi(string(payload))
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid flow control credits %q", payload)
+	}
+	return n, nil
+}
+
+// ParseFlowControlWindow returns the window of the spec of a SessionOpen or SessionOpenOK
+// packet, it's zero if the peer does not support the flow control.
+func ParseFlowControlWindow(spec map[string][]byte) int {
+	n, err := strconv.Atoi(string(spec[SpecFlowControlWindow]))
+	if err != nil || n <= 0 {
+		return 0
+	}
+	return n
+}
@@ -0,0 +1,164 @@
+package proto
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type fakeClientTransport struct {
+	ClientTransport
+	sent chan *Packet
+}
+
+func (f *fakeClientTransport) Send(pkt *Packet) error {
+	f.sent <- pkt
+	return nil
+}
+
+func (f *fakeClientTransport) StreamContext() context.Context { return context.Background() }
+
+func TestFlowWindowAcquire(t *testing.T) {
+	w := NewFlowWindow(10)
+	if n, err := w.Acquire(4); err != nil || n != 4 {
+		t.Fatalf("expected 4 credits, got %v, err=%v", n, err)
+	}
+	if n, err := w.Acquire(100); err != nil || n != 6 {
+		t.Fatalf("expected the remaining 6 credits, got %v, err=%v", n, err)
+	}
+
+	acquired := make(chan int)
+	go func() {
+		n, _ := w.Acquire(100)
+		acquired <- n
+	}()
+	select {
+	case n := <-acquired:
+		t.Fatalf("expected to wait for credits, acquired %v", n)
+	case <-time.After(50 * time.Millisecond):
+	}
+	w.Grant(3)
+	if n := <-acquired; n != 3 {
+		t.Fatalf("expected the 3 granted credits, got %v", n)
+	}
+}
+
+func TestFlowWindowClose(t *testing.T) {
+	w := NewFlowWindow(0)
+	errCh := make(chan error)
+	go func() {
+		_, err := w.Acquire(1)
+		errCh <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	_ = w.Close()
+	select {
+	case err := <-errCh:
+		if err != ErrFlowWindowClosed {
+			t.Fatalf("expected closed window error, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the close to release the sender")
+	}
+}
+
+func TestFlowCreditsConsume(t *testing.T) {
+	c := NewFlowCredits(100)
+	for _, tt := range []struct {
+		msg      string
+		consumed int
+		want     int
+	}{
+		{msg: "it must accumulate the credits below half the window", consumed: 30, want: 0},
+		{msg: "it must return the accumulated credits at half the window", consumed: 20, want: 50},
+		{msg: "it must start accumulating again", consumed: 10, want: 0},
+		{msg: "it must return reads bigger than the window", consumed: 200, want: 210},
+	} {
+		t.Run(tt.msg, func(t *testing.T) {
+			if got := c.Consume(tt.consumed); got != tt.want {
+				t.Errorf("expected %v credits, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestDecodeFlowCredits(t *testing.T) {
+	for _, tt := range []struct {
+		msg     string
+		payload []byte
+		want    int
+		wantErr bool
+	}{
+		{msg: "it must decode the credits", payload: EncodeFlowCredits(4096), want: 4096},
+		{msg: "it must fail with empty payloads", payload: nil, wantErr: true},
+		{msg: "it must fail with negative credits", payload: []byte("-1"), wantErr: true},
+	} {
+		t.Run(tt.msg, func(t *testing.T) {
+			got, err := DecodeFlowCredits(tt.payload)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("expected %v (err=%v), got %v, err=%v", tt.want, tt.wantErr, got, err)
+			}
+		})
+	}
+}
+
+func TestFlowControlledStreamWriter(t *testing.T) {
+	client := &fakeClientTransport{sent: make(chan *Packet, 10)}
+	w := NewFlowWindow(4)
+	writer := NewFlowControlledStreamWriter(client, "ClientTCPConnectionWrite", nil, w)
+
+	done := make(chan int)
+	go func() {
+		n, _ := writer.Write([]byte("0123456789"))
+		done <- n
+	}()
+	if pkt := <-client.sent; string(pkt.Payload) != "0123" {
+		t.Fatalf("expected the first chunk with the window size, got %q", pkt.Payload)
+	}
+	w.Grant(10)
+	if pkt := <-client.sent; string(pkt.Payload) != "456789" {
+		t.Fatalf("expected the remaining data, got %q", pkt.Payload)
+	}
+	if n := <-done; n != 10 {
+		t.Fatalf("expected 10 bytes written, got %v", n)
+	}
+}
+
+func TestFlowControlledMessageWriter(t *testing.T) {
+	client := &fakeClientTransport{sent: make(chan *Packet, 10)}
+	w := NewFlowWindow(4)
+	writer := NewFlowControlledMessageWriter(client, "ClientSSHConnectionWrite", nil, w)
+
+	// a message bigger than the credits is sent as a single packet
+	if n, err := writer.Write([]byte("0123456789")); err != nil || n != 10 {
+		t.Fatalf("expected 10 bytes written, got %v, err=%v", n, err)
+	}
+	if pkt := <-client.sent; string(pkt.Payload) != "0123456789" {
+		t.Fatalf("expected the whole message, got %q", pkt.Payload)
+	}
+
+	// the window is below zero, the next message waits until the credits are granted
+	done := make(chan int)
+	go func() {
+		n, _ := writer.Write([]byte("abc"))
+		done <- n
+	}()
+	w.Grant(6)
+	select {
+	case pkt := <-client.sent:
+		t.Fatalf("expected to wait for credits, sent %q", pkt.Payload)
+	case <-time.After(50 * time.Millisecond):
+	}
+	w.Grant(1)
+	if pkt := <-client.sent; string(pkt.Payload) != "abc" {
+		t.Fatalf("expected the second message, got %q", pkt.Payload)
+	}
+	if n := <-done; n != 3 {
+		t.Fatalf("expected 3 bytes written, got %v", n)
+	}
+
+	_ = w.Close()
+	if _, err := writer.Write([]byte("x")); err != ErrFlowWindowClosed {
+		t.Fatalf("expected closed window error, got %v", err)
+	}
+}
@@ -0,0 +1,143 @@
+package proto
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// ReplayBuffer numbers the packets sent in a session and keeps the ones not acknowledged by
+// the other side, they're sent again when the session resumes on a new connection. The
+// packets are acknowledged by the packets of the other side (SpecSessionAck). The buffer
+// keeps up to maxSize bytes of payloads, the session can't be resumed when the other side
+// is missing packets that were removed to stay within the limit.
+type ReplayBuffer struct {
+	mu      sync.Mutex
+	maxSize int
+	// the last sequence sent and received
+	sent     uint64
+	received uint64
+	packets  []replayPacket
+	size     int
+	// the last sequence removed from the buffer before it was acknowledged
+	dropped uint64
+}
+
+type replayPacket struct {
+	seq uint64
+	pkt *Packet
+}
+
+func NewReplayBuffer(maxSize int) *ReplayBuffer {
+	return &ReplayBuffer{maxSize: maxSize}
+}
+
+// Stamp numbers a packet and acknowledges the packets received, the packet is kept until
+// the other side acknowledges it. The packets must be sent in the order they're stamped.
+func (b *ReplayBuffer) Stamp(pkt *Packet) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.sent++
+	if pkt.Spec == nil {
+		pkt.Spec = map[string][]byte{}
+	}
+	pkt.Spec[SpecSessionSequence] = []byte(strconv.FormatUint(b.sent, 10))
+	pkt.Spec[SpecSessionAck] = []byte(strconv.FormatUint(b.received, 10))
+	b.packets = append(b.packets, replayPacket{seq: b.sent, pkt: pkt})
+	b.size += len(pkt.Payload)
+	for b.size > b.maxSize && len(b.packets) > 1 {
+		b.dropped = b.packets[0].seq
+		b.size -= len(b.packets[0].pkt.Payload)
+		b.packets = b.packets[1:]
+	}
+}
+
+// Receive checks the sequence of a packet of the other side, it returns false for the
+// packets received again after a reconnection and an error when packets are missing.
+// The packets without a sequence are accepted.
+func (b *ReplayBuffer) Receive(pkt *Packet) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if ack, ok := parseSequence(pkt.Spec[SpecSessionAck]); ok {
+		b.ackLocked(ack)
+	}
+	seq, ok := parseSequence(pkt.Spec[SpecSessionSequence])
+	switch {
+	case !ok:
+		return true, nil
+	case seq <= b.received:
+		return false, nil
+	case seq > b.received+1:
+		return false, fmt.Errorf("missing the packets %d to %d of the session", b.received+1, seq-1)
+	}
+	b.received = seq
+	return true, nil
+}
+
+// Received returns the last sequence received from the other side
+func (b *ReplayBuffer) Received() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.received
+}
+
+// Replay returns the packets to send again, ack is the last sequence received by the other
+// side. It fails if some of the packets are no longer in the buffer.
+func (b *ReplayBuffer) Replay(ack uint64) ([]*Packet, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.ackLocked(ack)
+	if ack < b.dropped {
+		return nil, fmt.Errorf("the packets %d to %d of the session exceeded the replay buffer of %d bytes",
+			ack+1, b.dropped, b.maxSize)
+	}
+	packets := make([]*Packet, len(b.packets))
+	for i, p := range b.packets {
+		packets[i] = p.pkt
+	}
+	return packets, nil
+}
+
+// ackLocked removes the packets received by the other side
+func (b *ReplayBuffer) ackLocked(ack uint64) {
+	n := 0
+	for n < len(b.packets) && b.packets[n].seq <= ack {
+		b.size -= len(b.packets[n].pkt.Payload)
+		n++
+	}
+	b.packets = b.packets[n:]
+}
+
+// SessionAck returns the acknowledged sequence of a packet, it's zero if it's not set
+func SessionAck(pkt *Packet) uint64 {
+	ack, _ := parseSequence(pkt.Spec[SpecSessionAck])
+	return ack
+}
+
+func parseSequence(val []byte) (uint64, bool) {
+	if len(val) == 0 {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(string(val), 10, 64)
+	return seq, err == nil
+}
+
+// EncodeResumeSessions encodes the suspended sessions of GatewayConnectOK (SpecResumeSessions),
+// the sessions are mapped to the last sequence received from the agent.
+func EncodeResumeSessions(sessions map[string]uint64) []byte {
+	data, _ := json.Marshal(sessions)
+	return data
+}
+
+// DecodeResumeSessions decodes the suspended sessions of GatewayConnectOK, it's empty if they're not set
+func DecodeResumeSessions(val []byte) (map[string]uint64, error) {
+	sessions := map[string]uint64{}
+	if len(val) == 0 {
+		return sessions, nil
+	}
+	if err := json.Unmarshal(val, &sessions); err != nil {
+		return nil, fmt.Errorf("invalid resume sessions: %v", err)
+	}
+	return sessions, nil
+}
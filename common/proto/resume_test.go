@@ -0,0 +1,92 @@
+package proto
+
+import (
+	"strings"
+	"testing"
+)
+
+func newSessionPacket(payload string) *Packet {
+	return &Packet{Type: "test", Payload: []byte(payload), Spec: map[string][]byte{SpecGatewaySessionID: []byte("sid")}}
+}
+
+func replayedPayloads(t *testing.T, b *ReplayBuffer, ack uint64) string {
+	packets, err := b.Replay(ack)
+	if err != nil {
+		t.Fatalf("failed replaying packets after %d: %v", ack, err)
+	}
+	var payloads []string
+	for _, pkt := range packets {
+		payloads = append(payloads, string(pkt.Payload))
+	}
+	return strings.Join(payloads, ",")
+}
+
+func TestReplayBufferStamp(t *testing.T) {
+	sender, receiver := NewReplayBuffer(1024), NewReplayBuffer(1024)
+	for _, payload := range []string{"a", "b", "c"} {
+		pkt := newSessionPacket(payload)
+		sender.Stamp(pkt)
+		if ok, err := receiver.Receive(pkt); !ok || err != nil {
+			t.Fatalf("expected packet %s to be accepted, ok=%v, err=%v", payload, ok, err)
+		}
+	}
+	if got := receiver.Received(); got != 3 {
+		t.Fatalf("expected to receive up to 3, got %v", got)
+	}
+	if got := replayedPayloads(t, sender, 1); got != "b,c" {
+		t.Fatalf("expected to replay the packets after the ack, got %q", got)
+	}
+
+	// the packets of the receiver acknowledge the packets of the sender
+	reply := newSessionPacket("reply")
+	receiver.Stamp(reply)
+	if ok, err := sender.Receive(reply); !ok || err != nil {
+		t.Fatalf("expected reply to be accepted, ok=%v, err=%v", ok, err)
+	}
+	if got := replayedPayloads(t, sender, 0); got != "" {
+		t.Fatalf("expected the acknowledged packets to be removed, got %q", got)
+	}
+}
+
+func TestReplayBufferReceive(t *testing.T) {
+	sender, receiver := NewReplayBuffer(1024), NewReplayBuffer(1024)
+	a, b, c := newSessionPacket("a"), newSessionPacket("b"), newSessionPacket("c")
+	for _, pkt := range []*Packet{a, b, c} {
+		sender.Stamp(pkt)
+	}
+	if ok, _ := receiver.Receive(a); !ok {
+		t.Fatal("expected the first packet to be accepted")
+	}
+	if ok, err := receiver.Receive(a); ok || err != nil {
+		t.Fatalf("expected a replayed packet to be skipped, ok=%v, err=%v", ok, err)
+	}
+	if _, err := receiver.Receive(c); err == nil || err.Error() != "missing the packets 2 to 2 of the session" {
+		t.Fatalf("expected an error for the missing packet, got %v", err)
+	}
+	if ok, err := receiver.Receive(newSessionPacket("unnumbered")); !ok || err != nil {
+		t.Fatalf("expected packets without sequence to be accepted, ok=%v, err=%v", ok, err)
+	}
+}
+
+func TestReplayBufferMaxSize(t *testing.T) {
+	b := NewReplayBuffer(4)
+	for _, payload := range []string{"aa", "bb", "cc"} {
+		b.Stamp(newSessionPacket(payload))
+	}
+	if _, err := b.Replay(0); err == nil {
+		t.Fatal("expected an error replaying a packet removed from the buffer")
+	}
+	if got := replayedPayloads(t, b, 1); got != "bb,cc" {
+		t.Fatalf("expected to replay the buffered packets, got %q", got)
+	}
+}
+
+func TestResumeSessionsEncoding(t *testing.T) {
+	sessions, err := DecodeResumeSessions(EncodeResumeSessions(map[string]uint64{"sid": 42}))
+	if err != nil || sessions["sid"] != 42 {
+		t.Fatalf("expected the sessions to be decoded, got %v, err=%v", sessions, err)
+	}
+	if sessions, err := DecodeResumeSessions(nil); err != nil || len(sessions) != 0 {
+		t.Fatalf("expected no sessions, got %v, err=%v", sessions, err)
+	}
+}
@@ -30,6 +30,10 @@ type (
 		client     ClientTransport
 		packetType PacketType
 		packetSpec map[string][]byte
+		// the data is sent in chunks of the available credits when it's set
+		window *FlowWindow
+		// each write is sent as a single packet, the messages are never split
+		framed bool
 	}
 	AgentConnectionParams struct {
 		ConnectionName string
@@ -96,16 +100,52 @@ func NewStreamWriter(client ClientTransport, pktType PacketType, spec map[string
 	return &streamWriter{client: client, packetType: pktType, packetSpec: spec}
 }
 
+// NewFlowControlledStreamWriter returns a stream writer that waits for the credits of the window
+// before sending the data, the writes are split when they're bigger than the available credits.
+func NewFlowControlledStreamWriter(client ClientTransport, pktType PacketType, spec map[string][]byte, window *FlowWindow) io.WriteCloser {
+	return &streamWriter{client: client, packetType: pktType, packetSpec: spec, window: window}
+}
+
+// NewFlowControlledMessageWriter returns a flow controlled stream writer for protocols where each
+// packet is a message (e.g.: SSH), a write is sent as a single packet when there are credits.
+func NewFlowControlledMessageWriter(client ClientTransport, pktType PacketType, spec map[string][]byte, window *FlowWindow) io.WriteCloser {
+	return &streamWriter{client: client, packetType: pktType, packetSpec: spec, window: window, framed: true}
+}
+
 func (s *streamWriter) Write(data []byte) (int, error) {
 	if s.client == nil {
 		return 0, fmt.Errorf("stream writer client is empty")
 	}
+	if s.window == nil || len(data) == 0 {
+		return len(data), s.send(data)
+	}
+	if s.framed {
+		if err := s.window.AcquireMessage(len(data)); err != nil {
+			return 0, err
+		}
+		return len(data), s.send(data)
+	}
+	written := 0
+	for written < len(data) {
+		n, err := s.window.Acquire(len(data) - written)
+		if err != nil {
+			return written, err
+		}
+		if err := s.send(data[written : written+n]); err != nil {
+			return written, err
+		}
+		written += n
+	}
+	return written, nil
+}
+
+func (s *streamWriter) send(data []byte) error {
 	packetType := s.packetType.String()
 	p := &Packet{Spec: map[string][]byte{}}
 	p.Type = packetType
 	p.Spec = s.packetSpec
 	p.Payload = data
-	return len(p.Payload), s.client.Send(p)
+	return s.client.Send(p)
 }
 
 func (s *streamWriter) AddSpecVal(key string, val []byte) {
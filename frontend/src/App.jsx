@@ -1,5 +1,5 @@
 import { useState, useEffect } from 'react'
-import { Play, Database, AlertCircle, CheckCircle2, Loader2, Copy, Trash2, Users, Plus, Edit, X, Activity, Circle, Server } from 'lucide-react'
+import { Play, Database, AlertCircle, CheckCircle2, Loader2, Copy, Trash2, Users, Plus, Edit, X, Activity, Circle, Server, Download } from 'lucide-react'
 import { cn } from './lib/utils'
 
 const API_BASE_URL = import.meta.env.VITE_API_BASE_URL || 'http://localhost:8080'
@@ -14,6 +14,7 @@ function App() {
   const [activeTab, setActiveTab] = useState(getInitialTab())
   const [query, setQuery] = useState('SELECT * FROM users;')
   const [results, setResults] = useState(null)
+  const [exporting, setExporting] = useState(null)
   const [loading, setLoading] = useState(false)
   const [error, setError] = useState(null)
   const [copied, setCopied] = useState(false)
@@ -115,6 +116,45 @@ function App() {
     }
   }
 
+  // Runs the query again with the export format and downloads the file
+  const exportResults = async (format) => {
+    setExporting(format)
+    setError(null)
+
+    try {
+      const response = await fetch(`${API_BASE_URL}/api/execute-query`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ query, database_id: selectedDatabaseId, export: format }),
+      })
+
+      // failed queries are returned as the json response
+      if ((response.headers.get('Content-Type') || '').startsWith('application/json')) {
+        const data = await response.json()
+        setError(data.error || 'Export failed')
+        return
+      }
+      if (!response.ok) {
+        setError(await response.text() || 'Export failed')
+        return
+      }
+
+      const blob = await response.blob()
+      const disposition = response.headers.get('Content-Disposition') || ''
+      const match = disposition.match(/filename="([^"]+)"/)
+      const url = URL.createObjectURL(blob)
+      const link = document.createElement('a')
+      link.href = url
+      link.download = match ? match[1] : `query.${format}`
+      link.click()
+      URL.revokeObjectURL(url)
+    } catch (err) {
+      setError(`Failed to export results: ${err.message}`)
+    } finally {
+      setExporting(null)
+    }
+  }
+
   const handleKeyPress = (e) => {
     if (e.key === 'Enter' && (e.ctrlKey || e.metaKey)) {
       executeQuery()
@@ -133,80 +173,25 @@ function App() {
     setError(null)
   }
 
-  const parseResults = (resultsString) => {
-    if (!resultsString) return { headers: [], rows: [] }
-
-    const trimmed = resultsString.trim()
-
-    // Check if the output is JSON/JavaScript object format (MongoDB, or JSON-formatted results)
-    if (trimmed.startsWith('[') || trimmed.startsWith('{')) {
-      try {
-        // Convert MongoDB/JavaScript object notation to valid JSON
-        let jsonString = trimmed
-          // Replace ObjectId("...") with just the ID string
-          .replace(/ObjectId\(['"]([^'"]+)['"]\)/g, '"$1"')
-          // Replace ISODate("...") with the date string
-          .replace(/ISODate\(['"]([^'"]+)['"]\)/g, '"$1"')
-          // Replace NumberLong(...) with the number
-          .replace(/NumberLong\((\d+)\)/g, '$1')
-          // Replace NumberInt(...) with the number
-          .replace(/NumberInt\((\d+)\)/g, '$1')
-          // Replace unquoted property names with quoted ones (for JavaScript object notation)
-          .replace(/(\w+):/g, '"$1":')
-          // Fix double-quoted quotes (from the replacements above)
-          .replace(/""+/g, '"')
-
-        // Try to parse as JSON
-        const jsonData = JSON.parse(jsonString)
-
-        // Handle array of objects (most common case)
-        if (Array.isArray(jsonData) && jsonData.length > 0) {
-          const firstItem = jsonData[0]
-          if (typeof firstItem === 'object' && firstItem !== null) {
-            // Extract headers from object keys
-            const headers = Object.keys(firstItem)
-            // Extract rows from object values
-            const rows = jsonData.map(item => headers.map(key => {
-              const val = item[key]
-              // Convert objects/arrays to JSON string for display
-              return val === null ? 'null' :
-                     typeof val === 'object' ? JSON.stringify(val) :
-                     String(val)
-            }))
-            return { headers, rows }
-          }
-        }
-
-        // Handle single object
-        if (typeof jsonData === 'object' && jsonData !== null && !Array.isArray(jsonData)) {
-          const headers = Object.keys(jsonData)
-          const rows = [headers.map(key => {
-            const val = jsonData[key]
-            return val === null ? 'null' :
-                   typeof val === 'object' ? JSON.stringify(val) :
-                   String(val)
-          })]
-          return { headers, rows }
-        }
-
-        // Handle array of primitives
-        if (Array.isArray(jsonData)) {
-          return { headers: ['value'], rows: jsonData.map(item => [String(item)]) }
-        }
-
-      } catch (e) {
-        // If JSON parsing fails, fall through to tab-separated parsing
-        console.warn('Failed to parse as JSON/MongoDB format, trying tab-separated format', e)
-      }
-    }
+  const formatCell = (val) => {
+    if (val === null || val === undefined) return 'null'
+    return typeof val === 'object' ? JSON.stringify(val) : String(val)
+  }
+
+  // Shows the last result set with columns, statements without rows (INSERT, UPDATE)
+  // only have the number of affected rows
+  const parseResults = (resultSets) => {
+    if (!resultSets || resultSets.length === 0) return { headers: [], rows: [], affectedRows: null }
 
-    // Default: parse as tab-separated values (for SQL databases)
-    const lines = resultsString.split('\n').filter(line => line.trim())
-    const dataLines = lines.filter(line => !line.includes('Deprecated'))
-    if (dataLines.length === 0) return { headers: [], rows: [] }
-    const headers = dataLines[0].split('\t')
-    const rows = dataLines.slice(1).map(line => line.split('\t'))
-    return { headers, rows }
+    const withColumns = resultSets.filter(rs => rs.columns.length > 0)
+    if (withColumns.length === 0) {
+      const affectedRows = resultSets.reduce((total, rs) => total + (rs.affectedRows || 0), 0)
+      return { headers: [], rows: [], affectedRows }
+    }
+    const resultSet = withColumns[withColumns.length - 1]
+    const headers = resultSet.columns.map(column => column.name)
+    const rows = resultSet.rows.map(row => headers.map((_, i) => formatCell(row[i])))
+    return { headers, rows, affectedRows: null }
   }
 
   // User Management Functions
@@ -543,7 +528,7 @@ function App() {
     }
   }
 
-  const { headers, rows } = results ? parseResults(results.results) : { headers: [], rows: [] }
+  const { headers, rows, affectedRows } = results ? parseResults(results.resultSets) : { headers: [], rows: [], affectedRows: null }
 
   return (
     <div className="min-h-screen bg-white flex flex-col">
@@ -729,9 +714,25 @@ function App() {
                     <span className="text-sm font-medium">Results</span>
                   </div>
                   <div className="flex items-center gap-4 text-xs text-gray-400">
-                    <span>{rows.length} rows</span>
+                    <span>{affectedRows !== null ? `${affectedRows} affected rows` : `${rows.length} rows`}</span>
                     <span>•</span>
                     <span>{results.duration}</span>
+                    {headers.length > 0 && (
+                      <>
+                        <span>•</span>
+                        {['csv', 'jsonl', 'parquet'].map(format => (
+                          <button
+                            key={format}
+                            onClick={() => exportResults(format)}
+                            disabled={exporting !== null}
+                            className="flex items-center gap-1 uppercase hover:text-white transition-colors disabled:opacity-50"
+                          >
+                            {exporting === format ? <Loader2 className="w-3 h-3 animate-spin" /> : <Download className="w-3 h-3" />}
+                            {format}
+                          </button>
+                        ))}
+                      </>
+                    )}
                   </div>
                 </div>
 
@@ -765,7 +766,7 @@ function App() {
                   </div>
                 ) : (
                   <div className="p-8 text-center text-gray-500 text-sm">
-                    No data returned
+                    {affectedRows !== null ? `${affectedRows} rows affected` : 'No data returned'}
                   </div>
                 )}
               </div>
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const defaultClosedSessionsLimit = 100
+
+// adminHandler serves the admin api of the sessions, the requests must have the
+// admin token as a bearer token. The api is disabled while the token is not set.
+type adminHandler struct {
+	broker *Broker
+	db     *sql.DB
+	config *configStore
+}
+
+func registerAdminHandlers(mux *http.ServeMux, broker *Broker, db *sql.DB, config *configStore) {
+	h := &adminHandler{broker: broker, db: db, config: config}
+	if config.Get().AdminToken == "" {
+		log.Println("admin_token (GATEWAY_ADMIN_TOKEN) is not set, the sessions admin api is disabled")
+	}
+	mux.HandleFunc("GET /sessions", h.authorize(h.listSessions))
+	mux.HandleFunc("GET /sessions/closed", h.authorize(h.listClosedSessions))
+	mux.HandleFunc("GET /sessions/{id}", h.authorize(h.getSession))
+	mux.HandleFunc("DELETE /sessions/{id}", h.authorize(h.deleteSession))
+}
+
+func (h *adminHandler) authorize(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// the token is reloaded, the api is enabled when it's set
+		adminToken := h.config.Get().AdminToken
+		if adminToken == "" {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "the sessions admin api is disabled, set admin_token to enable it"})
+			return
+		}
+		token, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (h *adminHandler) listSessions(w http.ResponseWriter, r *http.Request) {
+	sessions := h.broker.ListSessions()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"sessions": sessions,
+		"count":    len(sessions),
+	})
+}
+
+// listClosedSessions returns the traffic totals recorded when the sessions closed,
+// it accepts the agent_id and limit query parameters.
+func (h *adminHandler) listClosedSessions(w http.ResponseWriter, r *http.Request) {
+	limit := defaultClosedSessionsLimit
+	if val := r.URL.Query().Get("limit"); val != "" {
+		n, err := strconv.Atoi(val)
+		if err != nil || n <= 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "limit must be a positive number"})
+			return
+		}
+		limit = n
+	}
+	sessions, err := listClosedSessions(h.db, r.URL.Query().Get("agent_id"), limit)
+	if err != nil {
+		log.Printf("Failed to list closed sessions: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "failed to list closed sessions"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"sessions": sessions,
+		"count":    len(sessions),
+	})
+}
+
+func (h *adminHandler) getSession(w http.ResponseWriter, r *http.Request) {
+	sess := h.broker.GetSession(r.PathValue("id"))
+	if sess == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "session not found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, sess.info(true))
+}
+
+// deleteSession closes the session in the agent and in the client
+func (h *adminHandler) deleteSession(w http.ResponseWriter, r *http.Request) {
+	sessionID := r.PathValue("id")
+	if !h.broker.TerminateSession(sessionID, "session terminated by the gateway administrator") {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "session not found"})
+		return
+	}
+	log.Printf("Session %s terminated by the admin api (remote=%s)", sessionID, r.RemoteAddr)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/bifrost/common/dsnkeys"
+	"github.com/bifrost/common/keys"
+	pb "github.com/bifrost/common/proto"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// authenticator validates the credentials of agents and clients.
+// Agents authenticate with the DSN key generated by the api-server, the hash
+// of its secret is stored in the agents table. Clients authenticate with
+// EdDSA tokens signed by the api-server.
+type authenticator struct {
+	db     *sql.DB
+	config *configStore
+	// agents must present a verified client certificate with
+	// the common name equal to their identity
+	requireAgentCert bool
+}
+
+// authInfo is the identity of an authenticated connection
+type authInfo struct {
+	// the agent_id of the agents table (the name of the dsn)
+	// or the subject of the client token
+	subject string
+	dsn     *dsnkeys.DSN
+}
+
+// newAuthenticator creates the authenticator, the public key of the access
+// tokens is read from the current config and it's reloaded on SIGHUP.
+func newAuthenticator(db *sql.DB, config *configStore, requireAgentCert bool) *authenticator {
+	return &authenticator{db: db, config: config, requireAgentCert: requireAgentCert}
+}
+
+// authenticate validates the bearer token of the request. The token of agents
+// is the DSN key and the token of clients is an access token.
+func (a *authenticator) authenticate(ctx context.Context, isAgent bool) (*authInfo, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	authHeader := getMetadataValue(md, "authorization")
+	if authHeader == "" {
+		return nil, pb.NewAuthError(pb.AuthErrMissingCredentials, "missing authorization header")
+	}
+	token, found := strings.CutPrefix(authHeader, "Bearer ")
+	if !found || token == "" {
+		return nil, pb.NewAuthError(pb.AuthErrInvalidCredentials, "invalid authorization format")
+	}
+	if isAgent {
+		return a.authenticateAgent(ctx, token)
+	}
+	return a.authenticateClient(token)
+}
+
+func (a *authenticator) authenticateAgent(ctx context.Context, token string) (*authInfo, error) {
+	dsn, err := dsnkeys.Parse(token)
+	if err != nil {
+		return nil, pb.NewAuthError(pb.AuthErrInvalidCredentials, "invalid agent key format")
+	}
+	var agentID string
+	err = a.db.QueryRowContext(ctx, "SELECT agent_id FROM agents WHERE key_hash = $1", dsn.SecretKeyHash).
+		Scan(&agentID)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil, pb.NewAuthError(pb.AuthErrUnknownCredentials, "unknown agent key")
+	case err != nil:
+		log.Printf("Failed to validate agent key: %v", err)
+		return nil, fmt.Errorf("failed validating agent key")
+	}
+	// the name of the dsn is the identity of the agent, the key must belong to it
+	if dsn.Name != agentID {
+		return nil, pb.NewAuthError(pb.AuthErrInvalidCredentials,
+			fmt.Sprintf("agent key does not belong to agent %q", dsn.Name))
+	}
+	if a.requireAgentCert {
+		if err := verifyAgentCertificate(ctx, agentID); err != nil {
+			return nil, err
+		}
+	}
+	return &authInfo{subject: agentID, dsn: dsn}, nil
+}
+
+// verifyAgentCertificate checks the client certificate verified in the TLS handshake
+// has the common name of the agent.
+func verifyAgentCertificate(ctx context.Context, agentID string) error {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return pb.NewAuthError(pb.AuthErrMissingCredentials, "missing agent client certificate")
+	}
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return pb.NewAuthError(pb.AuthErrMissingCredentials, "missing agent client certificate")
+	}
+	if cn := tlsInfo.State.VerifiedChains[0][0].Subject.CommonName; cn != agentID {
+		return pb.NewAuthError(pb.AuthErrInvalidCredentials,
+			fmt.Sprintf("client certificate %q does not belong to agent %q", cn, agentID))
+	}
+	return nil
+}
+
+func (a *authenticator) authenticateClient(token string) (*authInfo, error) {
+	subject, err := keys.VerifyAccessToken(token, a.config.Get().jwtPublicKey)
+	switch {
+	case errors.Is(err, keys.ErrTokenExpired):
+		return nil, pb.NewAuthError(pb.AuthErrExpiredCredentials, "access token expired")
+	case err != nil:
+		log.Printf("Failed to verify access token: %v", err)
+		return nil, pb.NewAuthError(pb.AuthErrUnknownCredentials, "invalid access token")
+	}
+	return &authInfo{subject: subject}, nil
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Load balancing strategies to pick the replica of an agent for new sessions
+const (
+	BalanceRoundRobin    = "round-robin"
+	BalanceLeastSessions = "least-sessions"
+)
+
+// Broker manages routing between clients and agents
+type Broker struct {
+	strategy string
+
+	mu       sync.RWMutex
+	agents   map[string]*agentPool // agent identity -> replicas
+	sessions sync.Map              // map[string]*Session
+}
+
+// agentPool holds the replicas connected with the same agent identity
+type agentPool struct {
+	mu       sync.Mutex
+	replicas []*AgentConnection
+	next     int
+}
+
+func NewBroker(strategy string) (*Broker, error) {
+	if strategy == "" {
+		strategy = BalanceRoundRobin
+	}
+	if err := validateStrategy(strategy); err != nil {
+		return nil, err
+	}
+	return &Broker{strategy: strategy, agents: map[string]*agentPool{}}, nil
+}
+
+func validateStrategy(strategy string) error {
+	switch strategy {
+	case BalanceRoundRobin, BalanceLeastSessions:
+		return nil
+	}
+	return fmt.Errorf("unknown load balancing strategy %q, accepted: %q, %q",
+		strategy, BalanceRoundRobin, BalanceLeastSessions)
+}
+
+// SetStrategy changes the load balancing of the new sessions
+func (b *Broker) SetStrategy(strategy string) error {
+	if err := validateStrategy(strategy); err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.strategy = strategy
+	return nil
+}
+
+// AddAgent registers a replica in the pool of its identity, it returns the number of replicas
+func (b *Broker) AddAgent(agent *AgentConnection) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	pool, ok := b.agents[agent.agentID]
+	if !ok {
+		pool = &agentPool{}
+		b.agents[agent.agentID] = pool
+	}
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	pool.replicas = append(pool.replicas, agent)
+	return len(pool.replicas)
+}
+
+// RemoveAgent removes a replica from the pool of its identity, it returns the remaining replicas
+func (b *Broker) RemoveAgent(agent *AgentConnection) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	pool, ok := b.agents[agent.agentID]
+	if !ok {
+		return 0
+	}
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	for i, replica := range pool.replicas {
+		if replica == agent {
+			pool.replicas = append(pool.replicas[:i], pool.replicas[i+1:]...)
+			break
+		}
+	}
+	if len(pool.replicas) == 0 {
+		delete(b.agents, agent.agentID)
+	}
+	return len(pool.replicas)
+}
+
+// PickAgent returns a replica of the agent for a new session and increments its sessions.
+// Replicas with a dropped stream are skipped, if the agent id is empty a replica of any
+// agent is returned.
+func (b *Broker) PickAgent(agentID string) *AgentConnection {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if agentID != "" {
+		if pool, ok := b.agents[agentID]; ok {
+			return pool.pick(b.strategy)
+		}
+		return nil
+	}
+	for _, id := range b.sortedAgentIDs() {
+		if agent := b.agents[id].pick(b.strategy); agent != nil {
+			return agent
+		}
+	}
+	return nil
+}
+
+// pick selects a replica starting from the next position of the pool,
+// it allows distributing sessions between replicas with the same load.
+func (p *agentPool) pick(strategy string) *AgentConnection {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var picked *AgentConnection
+	pickedIdx := 0
+	for i := range p.replicas {
+		idx := (p.next + i) % len(p.replicas)
+		replica := p.replicas[idx]
+		if replica.stream.Context().Err() != nil {
+			continue
+		}
+		if picked == nil || (strategy == BalanceLeastSessions && replica.sessions.Load() < picked.sessions.Load()) {
+			picked, pickedIdx = replica, idx
+			if strategy == BalanceRoundRobin {
+				break
+			}
+		}
+	}
+	if picked != nil {
+		p.next = pickedIdx + 1
+		picked.sessions.Add(1)
+	}
+	return picked
+}
+
+// Broker methods
+func (b *Broker) GetSession(sessionID string) *Session {
+	if val, ok := b.sessions.Load(sessionID); ok {
+		return val.(*Session)
+	}
+	return nil
+}
+
+// ListSessions returns the state of the sessions ordered by the start time
+func (b *Broker) ListSessions() []sessionInfo {
+	sessions := []sessionInfo{}
+	b.sessions.Range(func(_, val any) bool {
+		sessions = append(sessions, val.(*Session).info(false))
+		return true
+	})
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].StartedAt.Before(sessions[j].StartedAt) })
+	return sessions
+}
+
+// TerminateSession ends a session in the agent and in the client and removes it
+func (b *Broker) TerminateSession(sessionID, reason string) bool {
+	sess := b.GetSession(sessionID)
+	if sess == nil {
+		return false
+	}
+	sess.Terminate(reason)
+	b.sessions.Delete(sessionID)
+	return true
+}
+
+// ExpireSessions ends the sessions that are idle or have exceeded the maximum
+// duration, it returns the number of expired sessions.
+func (b *Broker) ExpireSessions(idleTimeout, maxDuration time.Duration) int {
+	expired := 0
+	now := time.Now().UTC()
+	b.sessions.Range(func(key, val any) bool {
+		sess := val.(*Session)
+		if reason := sess.expiredReason(now, idleTimeout, maxDuration); reason != "" {
+			sess.Expire(reason)
+			b.sessions.Delete(key)
+			expired++
+		}
+		return true
+	})
+	return expired
+}
+
+// DetachSessions suspends or terminates the sessions pinned to a disconnected replica
+func (b *Broker) DetachSessions(agent *AgentConnection, grace time.Duration) {
+	b.sessions.Range(func(_, val any) bool {
+		val.(*Session).detachAgent(agent, grace)
+		return true
+	})
+}
+
+// ResumableSessions returns the suspended sessions that a reconnected replica can resume
+// with the last sequence received from the agent, they're sent in GatewayConnectOK.
+func (b *Broker) ResumableSessions(agent *AgentConnection) map[string]uint64 {
+	sessions := map[string]uint64{}
+	b.sessions.Range(func(key, val any) bool {
+		if received, ok := val.(*Session).resumableOn(agent); ok {
+			sessions[key.(string)] = received
+		}
+		return true
+	})
+	return sessions
+}
+
+// ResumeSession pins a suspended session to the replica that reconnected with it,
+// ack is the last sequence received by the agent.
+func (b *Broker) ResumeSession(agent *AgentConnection, sessionID string, ack uint64) bool {
+	sess := b.GetSession(sessionID)
+	return sess != nil && sess.resumeOn(agent, ack)
+}
+
+// ListAgents returns the connected replicas of all agents
+func (b *Broker) ListAgents() []*AgentConnection {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	agents := []*AgentConnection{}
+	for _, pool := range b.agents {
+		pool.mu.Lock()
+		agents = append(agents, pool.replicas...)
+		pool.mu.Unlock()
+	}
+	return agents
+}
+
+// CountSessions returns the number of active sessions
+func (b *Broker) CountSessions() int {
+	count := 0
+	b.sessions.Range(func(_, _ any) bool {
+		count++
+		return true
+	})
+	return count
+}
+
+// TerminateSessions ends all the sessions, it returns the number of terminated sessions
+func (b *Broker) TerminateSessions(reason string) int {
+	terminated := 0
+	b.sessions.Range(func(key, val any) bool {
+		val.(*Session).Terminate(reason)
+		b.sessions.Delete(key)
+		terminated++
+		return true
+	})
+	return terminated
+}
+
+// GetActiveAgents returns a list of all currently connected agents
+func (b *Broker) GetActiveAgents() []string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.sortedAgentIDs()
+}
+
+// GetAgentReplicas returns the number of replicas connected of each agent
+func (b *Broker) GetAgentReplicas() map[string]int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	replicas := map[string]int{}
+	for agentID, pool := range b.agents {
+		pool.mu.Lock()
+		replicas[agentID] = len(pool.replicas)
+		pool.mu.Unlock()
+	}
+	return replicas
+}
+
+func (b *Broker) sortedAgentIDs() []string {
+	agents := []string{}
+	for agentID := range b.agents {
+		agents = append(agents, agentID)
+	}
+	sort.Strings(agents)
+	return agents
+}
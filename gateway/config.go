@@ -0,0 +1,277 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/bifrost/common/keys"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the configuration of the gateway. It's loaded from a yaml file
+// (-config or GATEWAY_CONFIG) and the environment variables override its values.
+type Config struct {
+	GRPCListenAddr string          `yaml:"grpc_listen_addr"`
+	HTTPListenAddr string          `yaml:"http_listen_addr"`
+	MaxMessageSize int             `yaml:"max_message_size"`
+	TLS            TLSConfig       `yaml:"tls"`
+	Auth           AuthConfig      `yaml:"auth"`
+	Keepalive      KeepaliveConfig `yaml:"keepalive"`
+	// the fields below are reloaded on SIGHUP
+	LBStrategy         string   `yaml:"lb_strategy"`
+	AdminToken         string   `yaml:"admin_token"`
+	SessionResumeGrace Duration `yaml:"session_resume_grace"`
+	// sessions without packets in any direction for this time are closed
+	SessionIdleTimeout Duration `yaml:"session_idle_timeout"`
+	// sessions are closed after this time even if they're active
+	SessionMaxDuration Duration `yaml:"session_max_duration"`
+	// the time the active sessions have to finish when the gateway shuts down
+	ShutdownDrainTimeout Duration `yaml:"shutdown_drain_timeout"`
+
+	// the decoded auth.jwt_public_key
+	jwtPublicKey ed25519.PublicKey
+}
+
+// TLSConfig are the certificates of the gRPC and HTTP listeners, see loadTLSSettings
+type TLSConfig struct {
+	Cert             string `yaml:"cert"`
+	Key              string `yaml:"key"`
+	ClientCA         string `yaml:"client_ca"`
+	RequireAgentCert bool   `yaml:"require_agent_cert"`
+}
+
+// AuthConfig is the backend that authenticates agents and clients: agent keys
+// are validated against the database of the api-server and client tokens are
+// verified with the public key of the api-server.
+type AuthConfig struct {
+	JWTPublicKey string         `yaml:"jwt_public_key"`
+	Database     DatabaseConfig `yaml:"database"`
+}
+
+type DatabaseConfig struct {
+	Host     string `yaml:"host"`
+	Port     string `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	Name     string `yaml:"name"`
+	SSLMode  string `yaml:"sslmode"`
+}
+
+// KeepaliveConfig are the HTTP/2 keepalive settings of the gRPC server,
+// zero values keep the defaults of grpc.
+type KeepaliveConfig struct {
+	// the time without activity before pinging the connection
+	Time Duration `yaml:"time"`
+	// the time to wait for the ping ack before closing the connection
+	Timeout Duration `yaml:"timeout"`
+	// the minimum interval of the pings of agents and clients
+	MinTime Duration `yaml:"min_time"`
+	// connections without streams are closed after this time
+	MaxConnectionIdle Duration `yaml:"max_connection_idle"`
+}
+
+// Duration is a time.Duration in the format of time.ParseDuration (e.g.: 30s)
+type Duration time.Duration
+
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	parsed, err := time.ParseDuration(value.Value)
+	if err != nil {
+		return fmt.Errorf("line %d: %v", value.Line, err)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func defaultConfig() *Config {
+	return &Config{
+		GRPCListenAddr: ":8010",
+		HTTPListenAddr: ":8011",
+		MaxMessageSize: 1024 * 1024 * 17, // 17 MiB
+		Auth: AuthConfig{Database: DatabaseConfig{
+			Host:     "localhost",
+			Port:     "5432",
+			User:     "bifrost_admin",
+			Password: "bifrost_secure_pass",
+			Name:     "bifrost_app",
+			SSLMode:  "disable",
+		}},
+		LBStrategy:           BalanceRoundRobin,
+		SessionIdleTimeout:   Duration(time.Hour),
+		SessionMaxDuration:   Duration(24 * time.Hour),
+		ShutdownDrainTimeout: Duration(30 * time.Second),
+	}
+}
+
+// loadConfig loads the defaults, the config file if the path is not empty, the
+// environment variables and the overrides of the command line, in this order.
+func loadConfig(path string, overrides func(*Config)) (*Config, error) {
+	c := defaultConfig()
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading config file: %v", err)
+		}
+		if err := yaml.Unmarshal(data, c); err != nil {
+			return nil, fmt.Errorf("failed parsing config file %s: %v", path, err)
+		}
+	}
+	if err := c.loadEnv(); err != nil {
+		return nil, err
+	}
+	if overrides != nil {
+		overrides(c)
+	}
+	return c, c.validate()
+}
+
+// loadEnv overrides the config with the environment variables that are set
+func (c *Config) loadEnv() error {
+	envString(&c.GRPCListenAddr, "GATEWAY_GRPC_LISTEN_ADDR")
+	envString(&c.HTTPListenAddr, "GATEWAY_HTTP_LISTEN_ADDR")
+	envString(&c.TLS.Cert, "GATEWAY_TLS_CERT")
+	envString(&c.TLS.Key, "GATEWAY_TLS_KEY")
+	envString(&c.TLS.ClientCA, "GATEWAY_TLS_CLIENT_CA")
+	envString(&c.Auth.JWTPublicKey, "JWT_PUBLIC_KEY")
+	envString(&c.Auth.Database.Host, "POSTGRES_HOST")
+	envString(&c.Auth.Database.Port, "POSTGRES_PORT")
+	envString(&c.Auth.Database.User, "POSTGRES_USER")
+	envString(&c.Auth.Database.Password, "POSTGRES_PASSWORD")
+	envString(&c.Auth.Database.Name, "POSTGRES_DB")
+	envString(&c.Auth.Database.SSLMode, "POSTGRES_SSLMODE")
+	envString(&c.LBStrategy, "GATEWAY_LB_STRATEGY")
+	envString(&c.AdminToken, "GATEWAY_ADMIN_TOKEN")
+	if val := os.Getenv("GATEWAY_TLS_REQUIRE_AGENT_CERT"); val != "" {
+		c.TLS.RequireAgentCert = val == "true"
+	}
+	if val := os.Getenv("GATEWAY_MAX_MESSAGE_SIZE"); val != "" {
+		size, err := strconv.Atoi(val)
+		if err != nil {
+			return fmt.Errorf("invalid GATEWAY_MAX_MESSAGE_SIZE: %v", err)
+		}
+		c.MaxMessageSize = size
+	}
+	for name, d := range map[string]*Duration{
+		"GATEWAY_KEEPALIVE_TIME":          &c.Keepalive.Time,
+		"GATEWAY_KEEPALIVE_TIMEOUT":       &c.Keepalive.Timeout,
+		"GATEWAY_KEEPALIVE_MIN_TIME":      &c.Keepalive.MinTime,
+		"GATEWAY_KEEPALIVE_MAX_CONN_IDLE": &c.Keepalive.MaxConnectionIdle,
+		"GATEWAY_SESSION_RESUME_GRACE":    &c.SessionResumeGrace,
+		"GATEWAY_SESSION_IDLE_TIMEOUT":    &c.SessionIdleTimeout,
+		"GATEWAY_SESSION_MAX_DURATION":    &c.SessionMaxDuration,
+		"GATEWAY_SHUTDOWN_DRAIN_TIMEOUT":  &c.ShutdownDrainTimeout,
+	} {
+		if err := envDuration(d, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func envString(dst *string, name string) {
+	if val := os.Getenv(name); val != "" {
+		*dst = val
+	}
+}
+
+func envDuration(dst *Duration, name string) error {
+	val := os.Getenv(name)
+	if val == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(val)
+	if err != nil {
+		return fmt.Errorf("invalid %s: %v", name, err)
+	}
+	*dst = Duration(d)
+	return nil
+}
+
+func (c *Config) validate() error {
+	switch {
+	case c.GRPCListenAddr == "":
+		return fmt.Errorf("grpc_listen_addr is required")
+	case c.HTTPListenAddr == "":
+		return fmt.Errorf("http_listen_addr is required")
+	case c.MaxMessageSize <= 0:
+		return fmt.Errorf("max_message_size must be greater than zero")
+	case c.SessionResumeGrace < 0:
+		return fmt.Errorf("session_resume_grace must not be negative")
+	case c.SessionIdleTimeout < 0 || c.SessionMaxDuration < 0:
+		return fmt.Errorf("session_idle_timeout and session_max_duration must not be negative")
+	case c.ShutdownDrainTimeout < 0:
+		return fmt.Errorf("shutdown_drain_timeout must not be negative")
+	}
+	if err := validateStrategy(c.LBStrategy); err != nil {
+		return err
+	}
+	if c.Auth.JWTPublicKey == "" {
+		return fmt.Errorf("auth.jwt_public_key (JWT_PUBLIC_KEY) is required")
+	}
+	pubKey, err := keys.Base64DecodeEd25519PublicKey(c.Auth.JWTPublicKey)
+	if err != nil {
+		return fmt.Errorf("failed decoding auth.jwt_public_key: %v", err)
+	}
+	c.jwtPublicKey = pubKey
+	return c.TLS.validate()
+}
+
+// keepRestartFields keeps the values of the fields that are applied when the
+// gateway starts, it returns the name of the fields that have changed.
+func (c *Config) keepRestartFields(old *Config) []string {
+	var changed []string
+	if c.GRPCListenAddr != old.GRPCListenAddr {
+		changed = append(changed, "grpc_listen_addr")
+	}
+	if c.HTTPListenAddr != old.HTTPListenAddr {
+		changed = append(changed, "http_listen_addr")
+	}
+	if c.MaxMessageSize != old.MaxMessageSize {
+		changed = append(changed, "max_message_size")
+	}
+	if c.TLS != old.TLS {
+		changed = append(changed, "tls")
+	}
+	if c.Auth.Database != old.Auth.Database {
+		changed = append(changed, "auth.database")
+	}
+	if c.Keepalive != old.Keepalive {
+		changed = append(changed, "keepalive")
+	}
+	c.GRPCListenAddr, c.HTTPListenAddr, c.MaxMessageSize = old.GRPCListenAddr, old.HTTPListenAddr, old.MaxMessageSize
+	c.TLS, c.Auth.Database, c.Keepalive = old.TLS, old.Auth.Database, old.Keepalive
+	return changed
+}
+
+// configStore holds the current configuration, it's replaced when reloaded
+type configStore struct {
+	path      string
+	overrides func(*Config)
+	current   atomic.Pointer[Config]
+}
+
+func newConfigStore(path string, overrides func(*Config)) (*configStore, error) {
+	c, err := loadConfig(path, overrides)
+	if err != nil {
+		return nil, err
+	}
+	s := &configStore{path: path, overrides: overrides}
+	s.current.Store(c)
+	return s, nil
+}
+
+func (s *configStore) Get() *Config { return s.current.Load() }
+
+// reload loads the configuration again, the fields that require a restart keep their values
+func (s *configStore) reload() (*Config, []string, error) {
+	c, err := loadConfig(s.path, s.overrides)
+	if err != nil {
+		return nil, nil, err
+	}
+	ignored := c.keepRestartFields(s.Get())
+	s.current.Store(c)
+	return c, ignored, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeepRestartFields(t *testing.T) {
+	old := Config{
+		GRPCListenAddr: ":8010",
+		HTTPListenAddr: ":8011",
+		MaxMessageSize: 1024,
+		TLS:            TLSConfig{Cert: "old.crt", Key: "old.key"},
+		Auth:           AuthConfig{JWTPublicKey: "old", Database: DatabaseConfig{Host: "db"}},
+		Keepalive:      KeepaliveConfig{Time: Duration(time.Hour)},
+		LBStrategy:     "round-robin",
+		AdminToken:     "old",
+	}
+	// withChanges returns a copy of the old configuration with the changes applied
+	withChanges := func(changes func(c *Config)) Config {
+		c := old
+		changes(&c)
+		return c
+	}
+	reloaded := func(c *Config) {
+		c.Auth.JWTPublicKey = "new"
+		c.LBStrategy = "least-sessions"
+		c.AdminToken = "new"
+		c.SessionIdleTimeout = Duration(time.Minute)
+	}
+	for _, tt := range []struct {
+		msg     string
+		config  Config
+		want    Config
+		changed []string
+	}{
+		{
+			msg:    "it must apply the changes of the reloaded fields",
+			config: withChanges(reloaded),
+			want:   withChanges(reloaded),
+		},
+		{
+			msg: "it must keep the fields applied when the gateway starts",
+			config: withChanges(func(c *Config) {
+				reloaded(c)
+				c.GRPCListenAddr, c.HTTPListenAddr, c.MaxMessageSize = ":9010", ":9011", 2048
+				c.TLS = TLSConfig{Cert: "new.crt", Key: "new.key"}
+				c.Auth.Database.Host = "db2"
+				c.Keepalive.Time = Duration(time.Minute)
+			}),
+			want:    withChanges(reloaded),
+			changed: []string{"grpc_listen_addr", "http_listen_addr", "max_message_size", "tls", "auth.database", "keepalive"},
+		},
+		{
+			msg:    "it must return no changes for the same configuration",
+			config: old,
+			want:   old,
+		},
+	} {
+		t.Run(tt.msg, func(t *testing.T) {
+			c := tt.config
+			assert.Equal(t, tt.changed, c.keepRestartFields(&old))
+			assert.Equal(t, tt.want, c)
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// openDB opens the database of the api-server, it's used to validate the agent keys
+func openDB(c DatabaseConfig) (*sql.DB, error) {
+	connStr := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=%s",
+		c.Host, c.Port, c.User, c.Password, c.Name, c.SSLMode)
+
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database: %v", err)
+	}
+	if err := db.Ping(); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("failed to ping database: %v", err)
+	}
+	log.Printf("Connected to PostgreSQL database: %s@%s:%s/%s", c.User, c.Host, c.Port, c.Name)
+	return db, nil
+}
+
+// recordAgentConnected stores the info of the agent connection in the agents table
+func recordAgentConnected(db *sql.DB, agentID string, metadata map[string]string) {
+	_, err := db.Exec(`UPDATE agents SET hostname = $1, version = $2, platform = $3, last_connected_at = NOW()
+		WHERE agent_id = $4`, metadata["hostname"], metadata["version"], metadata["platform"], agentID)
+	if err != nil {
+		log.Printf("Failed to record connection of agent %s: %v", agentID, err)
+	}
+}
+
+// recordAgentDisconnected stores the time the agent disconnected in the agents table
+func recordAgentDisconnected(db *sql.DB, agentID string) {
+	_, err := db.Exec("UPDATE agents SET last_disconnected_at = NOW() WHERE agent_id = $1", agentID)
+	if err != nil {
+		log.Printf("Failed to record disconnection of agent %s: %v", agentID, err)
+	}
+}
+
+// reconcileAgents marks as disconnected the agents that were connected
+// when the gateway stopped, no agent is connected when it starts.
+func reconcileAgents(db *sql.DB) error {
+	result, err := db.Exec(`UPDATE agents SET last_disconnected_at = NOW()
+		WHERE last_connected_at IS NOT NULL
+		AND (last_disconnected_at IS NULL OR last_disconnected_at < last_connected_at)`)
+	if err != nil {
+		return fmt.Errorf("failed to reconcile agents: %v", err)
+	}
+	if rows, _ := result.RowsAffected(); rows > 0 {
+		log.Printf("Reconciled %d agent(s) connected before the gateway started", rows)
+	}
+	return nil
+}
+
+// recordSessionClosed stores the traffic totals of a closed session in the gateway_sessions table
+func recordSessionClosed(db *sql.DB, info sessionInfo) {
+	byType, err := json.Marshal(map[string]interface{}{
+		"from_client": info.FromClient.ByType,
+		"from_agent":  info.FromAgent.ByType,
+	})
+	if err != nil {
+		log.Printf("Failed to encode traffic of session %s: %v", info.SessionID, err)
+		return
+	}
+	_, err = db.Exec(`INSERT INTO gateway_sessions (session_id, agent_id, hostname, connection_type,
+		started_at, ended_at, packets_from_client, bytes_from_client, packets_from_agent, bytes_from_agent, packets_by_type)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+		info.SessionID, info.AgentID, info.Hostname, info.ConnectionType, info.StartedAt, info.EndedAt,
+		info.FromClient.Packets, info.FromClient.Bytes, info.FromAgent.Packets, info.FromAgent.Bytes, byType)
+	if err != nil {
+		log.Printf("Failed to record session %s: %v", info.SessionID, err)
+	}
+}
+
+// listClosedSessions returns the most recent closed sessions, optionally of a single agent
+func listClosedSessions(db *sql.DB, agentID string, limit int) ([]sessionInfo, error) {
+	rows, err := db.Query(`SELECT session_id, agent_id, hostname, connection_type, started_at, ended_at,
+		packets_from_client, bytes_from_client, packets_from_agent, bytes_from_agent, packets_by_type
+		FROM gateway_sessions WHERE ($1 = '' OR agent_id = $1) ORDER BY ended_at DESC LIMIT $2`, agentID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query closed sessions: %v", err)
+	}
+	defer rows.Close()
+	sessions := []sessionInfo{}
+	for rows.Next() {
+		var info sessionInfo
+		var endedAt time.Time
+		var byType []byte
+		info.FromClient, info.FromAgent = &trafficStats{}, &trafficStats{}
+		if err := rows.Scan(&info.SessionID, &info.AgentID, &info.Hostname, &info.ConnectionType,
+			&info.StartedAt, &endedAt, &info.FromClient.Packets, &info.FromClient.Bytes,
+			&info.FromAgent.Packets, &info.FromAgent.Bytes, &byType); err != nil {
+			return nil, fmt.Errorf("failed to scan closed session: %v", err)
+		}
+		info.EndedAt = &endedAt
+		var traffic struct {
+			FromClient map[string]*packetStats `json:"from_client"`
+			FromAgent  map[string]*packetStats `json:"from_agent"`
+		}
+		if err := json.Unmarshal(byType, &traffic); err == nil {
+			info.FromClient.ByType, info.FromAgent.ByType = traffic.FromClient, traffic.FromAgent
+		}
+		sessions = append(sessions, info)
+	}
+	return sessions, rows.Err()
+}
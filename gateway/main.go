@@ -2,92 +2,210 @@ package main
 
 import (
 	"context"
+	"database/sql"
 	"encoding/base64"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
+	"os"
+	"os/signal"
 	"strings"
-	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
+	"github.com/bifrost/common/monitoring"
 	pb "github.com/bifrost/common/proto"
 	pbagent "github.com/bifrost/common/proto/agent"
+	pbclient "github.com/bifrost/common/proto/client"
 	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
 )
 
-const (
-	ListenAddr     = ":8010"
-	HTTPListenAddr = ":8011"
-	ValidToken     = "test-token-123"
-	MaxRecvSize    = 1024 * 1024 * 17 // 17 MiB
-)
+// sessionExpiryInterval is how often the sessions are checked for the idle and maximum duration timeouts
+const sessionExpiryInterval = 10 * time.Second
 
 type gatewayServer struct {
 	pb.UnimplementedTransportServer
 	broker *Broker
-}
-
-// Broker manages routing between clients and agents
-type Broker struct {
-	agents   sync.Map // map[string]*AgentConnection
-	sessions sync.Map // map[string]*Session
+	auth   *authenticator
+	db     *sql.DB
+	config *configStore
+	// the gateway is shutting down, new connections are rejected
+	draining atomic.Bool
+	// it's done when the agent streams must return
+	shutdownCtx context.Context
+	shutdownFn  context.CancelFunc
 }
 
 type AgentConnection struct {
-	stream   pb.Transport_ConnectServer
+	stream pb.Transport_ConnectServer
+	// the packets are sent to the stream through the queue
+	queue    *sendQueue
 	agentID  string
 	origin   string
 	metadata map[string]string
-}
-
-type Session struct {
-	sessionID     string
-	agent         *AgentConnection
-	clientStream  pb.Transport_ConnectServer
-	mu            sync.Mutex
-	closed        bool
-	ctx           context.Context
-	cancel        context.CancelFunc
+	// the number of active sessions of the replica
+	sessions atomic.Int64
 }
 
 func main() {
+	configFile := flag.String("config", os.Getenv("GATEWAY_CONFIG"), "path of the yaml config file")
+	grpcAddr := flag.String("grpc-addr", "", "listen address of the gRPC server, overrides the config")
+	httpAddr := flag.String("http-addr", "", "listen address of the HTTP server, overrides the config")
+	flag.Parse()
+
 	log.Println("Starting Complete Hoop Gateway Server...")
 
+	config, err := newConfigStore(*configFile, func(c *Config) {
+		if *grpcAddr != "" {
+			c.GRPCListenAddr = *grpcAddr
+		}
+		if *httpAddr != "" {
+			c.HTTPListenAddr = *httpAddr
+		}
+	})
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	cfg := config.Get()
+
+	shutdownTracing, err := monitoring.NewOpenTracing("bifrost-gateway")
+	if err != nil {
+		log.Printf("Warning: tracing is disabled: %v", err)
+	}
+	defer shutdownTracing()
+
+	db, err := openDB(cfg.Auth.Database)
+	if err != nil {
+		log.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer db.Close()
+	if err := reconcileAgents(db); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+	serverTLS, err := loadTLSSettings(cfg.TLS)
+	if err != nil {
+		log.Fatalf("Failed to load TLS configuration: %v", err)
+	}
+	auth := newAuthenticator(db, config, cfg.TLS.RequireAgentCert)
+
 	// Start gRPC server
-	listener, err := net.Listen("tcp", ListenAddr)
+	listener, err := net.Listen("tcp", cfg.GRPCListenAddr)
 	if err != nil {
-		log.Fatalf("Failed to listen on %s: %v", ListenAddr, err)
+		log.Fatalf("Failed to listen on %s: %v", cfg.GRPCListenAddr, err)
 	}
 
-	grpcServer := grpc.NewServer(
-		grpc.MaxRecvMsgSize(MaxRecvSize),
-		grpc.MaxSendMsgSize(MaxRecvSize),
-	)
+	serverOptions := []grpc.ServerOption{
+		grpc.MaxRecvMsgSize(cfg.MaxMessageSize),
+		grpc.MaxSendMsgSize(cfg.MaxMessageSize),
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			Time:              time.Duration(cfg.Keepalive.Time),
+			Timeout:           time.Duration(cfg.Keepalive.Timeout),
+			MaxConnectionIdle: time.Duration(cfg.Keepalive.MaxConnectionIdle),
+		}),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime: time.Duration(cfg.Keepalive.MinTime),
+		}),
+	}
+	if serverTLS != nil {
+		serverOptions = append(serverOptions, grpc.Creds(credentials.NewTLS(serverTLS.config)))
+		go serverTLS.certs.watch(context.Background())
+	}
+	grpcServer := grpc.NewServer(serverOptions...)
 
+	broker, err := NewBroker(cfg.LBStrategy)
+	if err != nil {
+		log.Fatalf("Failed to initialize broker: %v", err)
+	}
+	registerMetrics(broker)
+
+	shutdownCtx, shutdownFn := context.WithCancel(context.Background())
 	gateway := &gatewayServer{
-		broker: &Broker{},
+		broker:      broker,
+		auth:        auth,
+		db:          db,
+		config:      config,
+		shutdownCtx: shutdownCtx,
+		shutdownFn:  shutdownFn,
 	}
 	pb.RegisterTransportServer(grpcServer, gateway)
+	go gateway.reloadOnSignal()
+	go gateway.expireSessions()
 
 	// Start HTTP server for agent status queries
-	go startHTTPServer(gateway.broker)
+	httpServer := gateway.startHTTPServer(serverTLS)
 
-	log.Printf("Gateway gRPC listening on %s", ListenAddr)
-	log.Printf("Gateway HTTP listening on %s", HTTPListenAddr)
+	log.Printf("Gateway gRPC listening on %s, tls=%v", cfg.GRPCListenAddr, serverTLS != nil)
+	log.Printf("Gateway HTTP listening on %s, tls=%v", cfg.HTTPListenAddr, serverTLS != nil)
 	log.Println("Ready to accept agent and client connections")
 
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		sigc := make(chan os.Signal, 1)
+		signal.Notify(sigc, syscall.SIGTERM, syscall.SIGINT)
+		sig := <-sigc
+		log.Printf("Received %v, shutting down the gateway", sig)
+		gateway.shutdown(grpcServer, httpServer, sigc)
+	}()
+
 	if err := grpcServer.Serve(listener); err != nil {
 		log.Fatalf("Failed to serve: %v", err)
 	}
+	<-stopped
+	log.Println("Gateway stopped")
+}
+
+// expireSessions closes the sessions that are idle or have exceeded the
+// maximum duration of the current configuration.
+func (s *gatewayServer) expireSessions() {
+	ticker := time.NewTicker(sessionExpiryInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		cfg := s.config.Get()
+		idleTimeout, maxDuration := time.Duration(cfg.SessionIdleTimeout), time.Duration(cfg.SessionMaxDuration)
+		if n := s.broker.ExpireSessions(idleTimeout, maxDuration); n > 0 {
+			log.Printf("Expired %d session(s), idle_timeout=%v, max_duration=%v", n, idleTimeout, maxDuration)
+		}
+	}
+}
+
+// reloadOnSignal reloads the configuration on SIGHUP. The listeners, TLS, keepalive,
+// message size and database settings are applied only when the gateway starts.
+func (s *gatewayServer) reloadOnSignal() {
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGHUP)
+	for range sigc {
+		cfg, ignored, err := s.config.reload()
+		if err != nil {
+			log.Printf("Failed to reload configuration, keeping the current one: %v", err)
+			continue
+		}
+		if err := s.broker.SetStrategy(cfg.LBStrategy); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+		if len(ignored) > 0 {
+			log.Printf("Warning: restart the gateway to apply the changes of %s", strings.Join(ignored, ", "))
+		}
+		log.Printf("Reloaded configuration, lb_strategy=%s, session_resume_grace=%v, session_idle_timeout=%v, "+
+			"session_max_duration=%v, admin_token=%v", cfg.LBStrategy, time.Duration(cfg.SessionResumeGrace),
+			time.Duration(cfg.SessionIdleTimeout), time.Duration(cfg.SessionMaxDuration), cfg.AdminToken != "")
+	}
 }
 
 // startHTTPServer starts an HTTP server for querying gateway state
-func startHTTPServer(broker *Broker) {
+func (s *gatewayServer) startHTTPServer(serverTLS *tlsSettings) *http.Server {
+	broker := s.broker
 	mux := http.NewServeMux()
 
 	// Endpoint to list active agents
@@ -95,29 +213,60 @@ func startHTTPServer(broker *Broker) {
 		agents := broker.GetActiveAgents()
 		w.Header().Set("Content-Type", "application/json")
 		json.NewEncoder(w).Encode(map[string]interface{}{
-			"agents": agents,
-			"count":  len(agents),
+			"agents":   agents,
+			"count":    len(agents),
+			"replicas": broker.GetAgentReplicas(),
 		})
 	})
 
-	// Health check
+	// Prometheus metrics
+	mux.Handle("/metrics", promhttp.Handler())
+
+	// Sessions admin api
+	registerAdminHandlers(mux, broker, s.db, s.config)
+
+	// Health check, it fails while draining to remove the gateway from the load balancers
 	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
+		if s.draining.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			json.NewEncoder(w).Encode(map[string]string{"status": "draining"})
+			return
+		}
 		json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
 	})
 
-	log.Printf("Starting HTTP server on %s", HTTPListenAddr)
-	if err := http.ListenAndServe(HTTPListenAddr, mux); err != nil {
-		log.Fatalf("HTTP server failed: %v", err)
-	}
+	addr := s.config.Get().HTTPListenAddr
+	log.Printf("Starting HTTP server on %s", addr)
+	server := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		var err error
+		if serverTLS != nil {
+			server.TLSConfig = serverTLS.config
+			// the certificate is served by the config
+			err = server.ListenAndServeTLS("", "")
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && err != http.ErrServerClosed {
+			log.Fatalf("HTTP server failed: %v", err)
+		}
+	}()
+	return server
 }
 
-// PreConnect handles pre-connection validation
+// PreConnect handles pre-connection validation of agents
 func (s *gatewayServer) PreConnect(ctx context.Context, req *pb.PreConnectRequest) (*pb.PreConnectResponse, error) {
-	if err := s.validateAuth(ctx); err != nil {
+	if _, err := s.auth.authenticate(ctx, true); err != nil {
+		log.Printf("Agent pre-connect unauthorized: %v", err)
+		recordAuthFailure(pb.ConnectionOriginAgent, err)
+		message := "unauthorized"
+		if reason := pb.AuthErrorReason(err); reason != "" {
+			message = fmt.Sprintf("unauthorized, reason=%s", reason)
+		}
 		return &pb.PreConnectResponse{
 			Status:  "backoff",
-			Message: "unauthorized",
+			Message: message,
 		}, nil
 	}
 	return &pb.PreConnectResponse{
@@ -129,75 +278,128 @@ func (s *gatewayServer) PreConnect(ctx context.Context, req *pb.PreConnectReques
 // Connect handles bidirectional streaming for both agents and clients
 func (s *gatewayServer) Connect(stream pb.Transport_ConnectServer) error {
 	ctx := stream.Context()
-
-	if err := s.validateAuth(ctx); err != nil {
-		return status.Errorf(codes.Unauthenticated, "unauthorized")
+	if s.draining.Load() {
+		return errShuttingDown
 	}
 
 	md, _ := metadata.FromIncomingContext(ctx)
 	origin := getMetadataValue(md, "origin")
 
+	info, err := s.auth.authenticate(ctx, origin == "agent")
+	if err != nil {
+		log.Printf("Connection unauthorized: origin=%s, err=%v", origin, err)
+		recordAuthFailure(origin, err)
+		return err
+	}
+	log.Printf("Authenticated %s connection: subject=%s", origin, info.subject)
+
 	// Determine if this is an agent or client connection
 	if origin == "agent" {
-		return s.handleAgentConnection(stream, md)
+		return s.handleAgentConnection(stream, md, info)
 	}
 	return s.handleClientConnection(stream, md)
 }
 
 // handleAgentConnection manages agent connections
-func (s *gatewayServer) handleAgentConnection(stream pb.Transport_ConnectServer, md metadata.MD) error {
-	connectionName := getMetadataValue(md, "connection-name")
-
-	agentID := "agent-default"
-	if connectionName != "" {
-		agentID = fmt.Sprintf("agent-%s", connectionName)
-	}
-
-	log.Printf("Agent connected: id=%s", agentID)
+func (s *gatewayServer) handleAgentConnection(stream pb.Transport_ConnectServer, md metadata.MD, info *authInfo) error {
+	// the agent is identified by the name of the dsn used to authenticate,
+	// the connection-name sent by the agent is informative only
+	agentID := info.dsn.Name
 
 	agent := &AgentConnection{
 		stream:  stream,
+		queue:   newSendQueue(stream, sendQueueWaitsTotal.WithLabelValues(agentID)),
 		agentID: agentID,
 		origin:  "agent",
 		metadata: map[string]string{
-			"connection-name": connectionName,
+			"connection-name": getMetadataValue(md, "connection-name"),
+			"hostname":        getMetadataValue(md, "hostname"),
+			"version":         getMetadataValue(md, "version"),
+			"platform":        getMetadataValue(md, "platform"),
 		},
 	}
 
-	s.broker.agents.Store(agentID, agent)
+	// connections with the same identity are replicas of the agent
+	replicas := s.broker.AddAgent(agent)
+	log.Printf("Agent connected: id=%s, hostname=%s, version=%s, replicas=%d",
+		agentID, agent.metadata["hostname"], agent.metadata["version"], replicas)
+	recordAgentConnected(s.db, agentID, agent.metadata)
 	defer func() {
-		s.broker.agents.Delete(agentID)
-		log.Printf("Agent disconnected: id=%s", agentID)
+		replicas := s.broker.RemoveAgent(agent)
+		s.broker.DetachSessions(agent, time.Duration(s.config.Get().SessionResumeGrace))
+		if replicas == 0 {
+			recordAgentDisconnected(s.db, agentID)
+		}
+		// the stream can't be used after the handler returns
+		agent.queue.Close(queueDrainTimeout)
+		log.Printf("Agent disconnected: id=%s, hostname=%s, replicas=%d", agentID, agent.metadata["hostname"], replicas)
 	}()
 
-	// Send GatewayConnectOK
-	if err := stream.Send(&pb.Packet{
+	// the agent keeps its sessions for the grace of the gateway while it reconnects,
+	// the suspended sessions are resumed when the agent replies with SessionResume
+	resumable := s.broker.ResumableSessions(agent)
+	if err := agent.queue.Push(&pb.Packet{
 		Type:    pbagent.GatewayConnectOK,
 		Payload: []byte("connected"),
+		Spec: map[string][]byte{
+			pb.SpecSessionResumeGrace: []byte(time.Duration(s.config.Get().SessionResumeGrace).String()),
+			pb.SpecResumeSessions:     pb.EncodeResumeSessions(resumable),
+		},
 	}); err != nil {
 		return err
 	}
+	if len(resumable) > 0 {
+		log.Printf("Agent %s can resume %d session(s)", agentID, len(resumable))
+	}
+
+	// the agent reconnects to another gateway when this one shuts down
+	recvErrCh := make(chan error, 1)
+	go func() { recvErrCh <- s.forwardAgentPackets(agent) }()
+	select {
+	case err := <-recvErrCh:
+		return err
+	case <-s.shutdownCtx.Done():
+		return errShuttingDown
+	}
+}
 
-	// Receive packets from agent
+// forwardAgentPackets receives the packets of the agent and forwards them to the clients of the sessions
+func (s *gatewayServer) forwardAgentPackets(agent *AgentConnection) error {
 	for {
-		pkt, err := stream.Recv()
+		pkt, err := agent.stream.Recv()
 		if err != nil {
 			return err
 		}
 
 		// Route packet to appropriate client session
 		sessionID := string(pkt.Spec[pb.SpecGatewaySessionID])
+		if pkt.Type == pbclient.SessionResume {
+			if !s.broker.ResumeSession(agent, sessionID, pb.SessionAck(pkt)) {
+				_ = agent.queue.Push(&pb.Packet{
+					Type:    pbagent.SessionClose,
+					Payload: []byte("the session was not resumed in the gateway"),
+					Spec:    map[string][]byte{pb.SpecGatewaySessionID: []byte(sessionID)},
+				})
+			}
+			continue
+		}
 		if sessionID != "" {
 			if sess := s.broker.GetSession(sessionID); sess != nil {
 				if err := sess.SendToClient(pkt); err != nil {
 					log.Printf("Failed to send to client: %v", err)
 				}
+			} else if pkt.Type != pbclient.SessionClose {
+				// the session has ended in the gateway, e.g.: it was not resumed in time
+				_ = agent.queue.Push(&pb.Packet{
+					Type: pbagent.SessionClose,
+					Spec: map[string][]byte{pb.SpecGatewaySessionID: []byte(sessionID)},
+				})
 			}
 		}
 
 		// Log keepalives
 		if pkt.Type == "GatewayKeepAlive" {
-			log.Printf("Keepalive from %s", agentID)
+			log.Printf("Keepalive from %s", agent.agentID)
 		}
 	}
 }
@@ -209,63 +411,71 @@ func (s *gatewayServer) handleClientConnection(stream pb.Transport_ConnectServer
 	// Get requested agent ID from metadata
 	requestedAgentID := getMetadataValue(md, "agent-id")
 
-	// Find the requested agent or first available agent
-	var agent *AgentConnection
-	if requestedAgentID != "" {
-		// Try to find the specific agent requested
-		if val, ok := s.broker.agents.Load(requestedAgentID); ok {
-			agent = val.(*AgentConnection)
-			log.Printf("Using requested agent: %s", requestedAgentID)
-		} else {
-			log.Printf("Requested agent '%s' not found, looking for alternatives", requestedAgentID)
-			// Requested agent not found, return error
+	// Pick a replica of the requested agent or of the first available agent,
+	// the session is pinned to the replica until it's closed
+	agent := s.broker.PickAgent(requestedAgentID)
+	if agent == nil {
+		if requestedAgentID != "" {
+			log.Printf("Requested agent '%s' not found", requestedAgentID)
 			return status.Errorf(codes.Unavailable, "requested agent '%s' is not connected", requestedAgentID)
 		}
-	} else {
-		// No specific agent requested, use first available
-		s.broker.agents.Range(func(key, value interface{}) bool {
-			agent = value.(*AgentConnection)
-			return false // Stop after first
-		})
-		if agent != nil {
-			log.Printf("No agent requested, using first available: %s", agent.agentID)
-		}
-	}
-
-	if agent == nil {
 		return status.Error(codes.Unavailable, "no agents available")
 	}
 
 	// Create session
 	sessionID := uuid.NewString()
 	ctx, cancel := context.WithCancel(stream.Context())
+	now := time.Now().UTC()
 
 	session := &Session{
 		sessionID:    sessionID,
+		agentID:      agent.agentID,
 		agent:        agent,
-		clientStream: stream,
+		clientQueue:  newSendQueue(stream, nil),
+		replay:       pb.NewReplayBuffer(maxReplayBufferSize),
 		ctx:          ctx,
 		cancel:       cancel,
+		startedAt:    now,
+		lastActivity: now,
 	}
 
 	s.broker.sessions.Store(sessionID, session)
 	defer func() {
 		session.Close()
+		// the stream can't be used after the handler returns
+		session.clientQueue.Close(queueDrainTimeout)
 		s.broker.sessions.Delete(sessionID)
-		log.Printf("Session closed: %s", sessionID[:8])
+		info := session.info(true)
+		log.Printf("Session closed: %s, from_client=%d packets/%d bytes, from_agent=%d packets/%d bytes",
+			sessionID[:8], info.FromClient.Packets, info.FromClient.Bytes, info.FromAgent.Packets, info.FromAgent.Bytes)
+		recordSessionClosed(s.db, info)
+		sessionDuration.WithLabelValues(connectionTypeLabel(info.ConnectionType)).
+			Observe(info.EndedAt.Sub(info.StartedAt).Seconds())
 	}()
 
-	log.Printf("Session created: %s with agent %s", sessionID[:8], agent.agentID)
+	log.Printf("Session created: %s with agent %s (hostname=%s)", sessionID[:8], agent.agentID, agent.metadata["hostname"])
+
+	// Receive packets from client and forward to agent, the session
+	// ends when the client disconnects or the agent goes offline
+	recvErrCh := make(chan error, 1)
+	go func() { recvErrCh <- forwardClientPackets(stream, session) }()
+	select {
+	case err := <-recvErrCh:
+		return err
+	case <-ctx.Done():
+		return session.Err()
+	}
+}
 
-	// Receive packets from client and forward to agent
+// forwardClientPackets receives the packets of the client and forwards them to the agent of the session
+func forwardClientPackets(stream pb.Transport_ConnectServer, session *Session) error {
+	sessionID := session.sessionID
 	for {
 		pkt, err := stream.Recv()
 		if err != nil {
 			return err
 		}
 
-		log.Printf("Client packet: type=%s", pkt.Type)
-
 		switch pkt.Type {
 		case pbagent.SessionOpen:
 			// Add session ID to spec (preserve existing spec fields including SpecConnectionType)
@@ -276,7 +486,7 @@ func (s *gatewayServer) handleClientConnection(stream pb.Transport_ConnectServer
 			// Don't override SpecConnectionType - it's already set by the API server
 
 			// Forward to agent
-			if err := agent.stream.Send(pkt); err != nil {
+			if err := session.SendToAgent(pkt); err != nil {
 				log.Printf("Failed to send SessionOpen to agent: %v", err)
 				return err
 			}
@@ -291,12 +501,8 @@ func (s *gatewayServer) handleClientConnection(stream pb.Transport_ConnectServer
 			// Add session ID without overwriting other spec fields
 			pkt.Spec[pb.SpecGatewaySessionID] = []byte(sessionID)
 
-			// Debug: log spec fields
-			connID := string(pkt.Spec[pb.SpecClientConnectionID])
-			log.Printf("Forwarding %s - ConnectionID: '%s', SessionID: %s", pkt.Type, connID, sessionID[:8])
-
 			// Forward to agent (all spec fields including SpecClientConnectionID are preserved)
-			if err := agent.stream.Send(pkt); err != nil {
+			if err := session.SendToAgent(pkt); err != nil {
 				log.Printf("Failed to send to agent: %v", err)
 				return err
 			}
@@ -304,90 +510,11 @@ func (s *gatewayServer) handleClientConnection(stream pb.Transport_ConnectServer
 	}
 }
 
-// Session methods
-func (s *Session) SendToClient(pkt *pb.Packet) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	if s.closed {
-		return fmt.Errorf("session closed")
-	}
-
-	return s.clientStream.Send(pkt)
-}
-
-func (s *Session) Close() {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	if s.closed {
-		return
-	}
-	s.closed = true
-	s.cancel()
-}
-
-// Broker methods
-func (b *Broker) GetSession(sessionID string) *Session {
-	if val, ok := b.sessions.Load(sessionID); ok {
-		return val.(*Session)
-	}
-	return nil
-}
-
-// GetActiveAgents returns a list of all currently connected agents
-func (b *Broker) GetActiveAgents() []string {
-	agents := []string{}
-	b.agents.Range(func(key, value interface{}) bool {
-		if agentID, ok := key.(string); ok {
-			agents = append(agents, agentID)
-		}
-		return true
-	})
-	return agents
-}
-
 // HealthCheck endpoint
 func (s *gatewayServer) HealthCheck(ctx context.Context, req *pb.HealthCheckRequest) (*pb.HealthCheckResponse, error) {
 	return &pb.HealthCheckResponse{Status: "healthy"}, nil
 }
 
-// validateAuth checks Bearer token
-func (s *gatewayServer) validateAuth(ctx context.Context) error {
-	md, ok := metadata.FromIncomingContext(ctx)
-	if !ok {
-		return fmt.Errorf("missing metadata")
-	}
-
-	authHeader := getMetadataValue(md, "authorization")
-	if authHeader == "" {
-		return fmt.Errorf("missing authorization header")
-	}
-
-	if !strings.HasPrefix(authHeader, "Bearer ") {
-		return fmt.Errorf("invalid authorization format")
-	}
-
-	token := strings.TrimPrefix(authHeader, "Bearer ")
-
-	// Extract from DSN if needed
-	if strings.Contains(token, "://") && strings.Contains(token, "@") {
-		parts := strings.Split(token, "://")
-		if len(parts) == 2 {
-			userInfo := strings.Split(parts[1], "@")[0]
-			if strings.Contains(userInfo, ":") {
-				token = strings.Split(userInfo, ":")[1]
-			}
-		}
-	}
-
-	if token != ValidToken && len(token) != 64 && !strings.Contains(token, "test-token") {
-		return fmt.Errorf("invalid token")
-	}
-
-	return nil
-}
-
 func getMetadataValue(md metadata.MD, key string) string {
 	values := md.Get(key)
 	if len(values) > 0 {
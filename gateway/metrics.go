@@ -0,0 +1,117 @@
+package main
+
+import (
+	pb "github.com/bifrost/common/proto"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	sessionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "bifrost_gateway_session_duration_seconds",
+		Help:    "Duration of the closed sessions",
+		Buckets: []float64{1, 5, 15, 30, 60, 300, 900, 1800, 3600, 14400},
+	}, []string{"connection_type"})
+	packetsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "bifrost_gateway_packets_total",
+		Help: "Packets forwarded between clients and agents",
+	}, []string{"direction", "type"})
+	packetBytesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "bifrost_gateway_packet_bytes_total",
+		Help: "Payload bytes forwarded between clients and agents",
+	}, []string{"direction", "type"})
+	authFailuresTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "bifrost_gateway_auth_failures_total",
+		Help: "Connections rejected by the authentication",
+	}, []string{"origin", "reason"})
+	sendQueueWaitsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "bifrost_gateway_agent_send_queue_waits_total",
+		Help: "Packets of clients that waited for space in the send queue of the agent",
+	}, []string{"agent_id"})
+)
+
+// the direction label of the packet metrics
+const (
+	directionFromClient = "from_client"
+	directionFromAgent  = "from_agent"
+)
+
+// registerMetrics registers the metrics of the gateway and the state of the broker
+func registerMetrics(broker *Broker) {
+	prometheus.MustRegister(sessionDuration, packetsTotal, packetBytesTotal, authFailuresTotal,
+		sendQueueWaitsTotal, &brokerCollector{broker: broker})
+}
+
+// brokerCollector reports the connected agents and the active sessions
+// when the metrics are scraped
+type brokerCollector struct {
+	broker *Broker
+}
+
+var (
+	connectedAgentsDesc = prometheus.NewDesc("bifrost_gateway_connected_agents",
+		"Agents with at least one connected replica", nil, nil)
+	agentReplicasDesc = prometheus.NewDesc("bifrost_gateway_agent_replicas",
+		"Connected agent replicas", nil, nil)
+	activeSessionsDesc = prometheus.NewDesc("bifrost_gateway_active_sessions",
+		"Active sessions by connection type", []string{"connection_type"}, nil)
+	sendQueuePacketsDesc = prometheus.NewDesc("bifrost_gateway_agent_send_queue_packets",
+		"Packets waiting to be sent to the replicas of the agent", []string{"agent_id"}, nil)
+	sendQueueBytesDesc = prometheus.NewDesc("bifrost_gateway_agent_send_queue_bytes",
+		"Payload bytes waiting to be sent to the replicas of the agent", []string{"agent_id"}, nil)
+)
+
+func (c *brokerCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- connectedAgentsDesc
+	ch <- agentReplicasDesc
+	ch <- activeSessionsDesc
+	ch <- sendQueuePacketsDesc
+	ch <- sendQueueBytesDesc
+}
+
+func (c *brokerCollector) Collect(ch chan<- prometheus.Metric) {
+	replicas := c.broker.GetAgentReplicas()
+	totalReplicas := 0
+	for _, n := range replicas {
+		totalReplicas += n
+	}
+	ch <- prometheus.MustNewConstMetric(connectedAgentsDesc, prometheus.GaugeValue, float64(len(replicas)))
+	ch <- prometheus.MustNewConstMetric(agentReplicasDesc, prometheus.GaugeValue, float64(totalReplicas))
+	sessions := map[string]int{}
+	for _, info := range c.broker.ListSessions() {
+		sessions[connectionTypeLabel(info.ConnectionType)]++
+	}
+	for connectionType, n := range sessions {
+		ch <- prometheus.MustNewConstMetric(activeSessionsDesc, prometheus.GaugeValue, float64(n), connectionType)
+	}
+	queuedPackets, queuedBytes := map[string]int{}, map[string]int{}
+	for _, agent := range c.broker.ListAgents() {
+		packets, size := agent.queue.depth()
+		queuedPackets[agent.agentID] += packets
+		queuedBytes[agent.agentID] += size
+	}
+	for agentID, packets := range queuedPackets {
+		ch <- prometheus.MustNewConstMetric(sendQueuePacketsDesc, prometheus.GaugeValue, float64(packets), agentID)
+		ch <- prometheus.MustNewConstMetric(sendQueueBytesDesc, prometheus.GaugeValue, float64(queuedBytes[agentID]), agentID)
+	}
+}
+
+// recordAuthFailure counts a rejected connection by the reason of the authentication error
+func recordAuthFailure(origin string, err error) {
+	reason := pb.AuthErrorReason(err)
+	if reason == "" {
+		reason = "INTERNAL"
+	}
+	// connections that are not agents are authenticated as clients
+	if origin != pb.ConnectionOriginAgent {
+		origin = "client"
+	}
+	authFailuresTotal.WithLabelValues(origin, reason).Inc()
+}
+
+// connectionTypeLabel returns the label of sessions that have not sent SessionOpen yet
+func connectionTypeLabel(connectionType string) string {
+	if connectionType == "" {
+		return "unknown"
+	}
+	return connectionType
+}
@@ -0,0 +1,252 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	pb "github.com/bifrost/common/proto"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// maxSessionQueueSize is the amount of data of a session waiting to be sent to the agent,
+// the client is blocked until the writer catches up. A packet bigger than the limit is
+// accepted when the queue of the session is empty.
+const maxSessionQueueSize = 1024 * 1024 // 1 MiB
+
+// queueDrainTimeout is how long the queued packets are sent after the stream ends
+const queueDrainTimeout = 5 * time.Second
+
+// maxClientQueueSize is the amount of data of the agent waiting to be sent to the client of a
+// session, the session is closed when the client doesn't read it. The receive loop of the agent
+// doesn't wait for a slow client, it would delay the other sessions of the replica.
+const maxClientQueueSize = 16 * 1024 * 1024 // 16 MiB
+
+var (
+	errSendQueueClosed = fmt.Errorf("agent disconnected")
+	errSendQueueFull   = fmt.Errorf("send queue is full")
+)
+
+// sendQueue serializes the packets sent to an agent replica, a gRPC stream does not allow
+// concurrent calls of Send. The sessions have their own queue and the writer sends one
+// packet of each session in turns, a session that sends a lot of data fills its queue and
+// waits without delaying the other sessions of the replica. The packets that don't belong
+// to a session (e.g.: GatewayConnectOK) are sent first. The packets of the agent are sent
+// to the client of a session with a queue as well (see TrySend), a slow client doesn't hold
+// the session nor the agent.
+type sendQueue struct {
+	stream pb.Transport_ConnectServer
+	// counts the packets that waited for space, it's nil when they're not counted
+	waits prometheus.Counter
+
+	mu sync.Mutex
+	// signaled when packets are queued, sent or when the queue is closed
+	cond     *sync.Cond
+	control  []*pb.Packet
+	sessions map[string]*sessionQueue
+	// the sessions with queued packets in the order they are sent
+	ready []string
+	// the total of queued packets and payload bytes
+	packets int
+	size    int
+	closed  bool
+	err     error
+	done    chan struct{}
+}
+
+type sessionQueue struct {
+	packets []*pb.Packet
+	size    int
+}
+
+func newSendQueue(stream pb.Transport_ConnectServer, waits prometheus.Counter) *sendQueue {
+	q := &sendQueue{
+		stream:   stream,
+		waits:    waits,
+		sessions: map[string]*sessionQueue{},
+		done:     make(chan struct{}),
+	}
+	q.cond = sync.NewCond(&q.mu)
+	go q.run()
+	return q
+}
+
+// Send queues a packet, it waits while the queue of the session is full until
+// there's space, the context is done or the queue is closed.
+func (q *sendQueue) Send(ctx context.Context, pkt *pb.Packet) error {
+	sessionID := string(pkt.Spec[pb.SpecGatewaySessionID])
+	if sessionID == "" {
+		return q.Push(pkt)
+	}
+	// wake up the sender when the context is done
+	stop := context.AfterFunc(ctx, func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		q.cond.Broadcast()
+	})
+	defer stop()
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	waited := false
+	for {
+		if q.closed {
+			return q.closeErrLocked()
+		}
+		sq := q.sessions[sessionID]
+		if sq == nil || sq.size == 0 || sq.size+len(pkt.Payload) <= maxSessionQueueSize {
+			break
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !waited && q.waits != nil {
+			waited = true
+			q.waits.Inc()
+		}
+		q.cond.Wait()
+	}
+	q.pushLocked(sessionID, pkt)
+	return nil
+}
+
+// TrySend queues a packet without waiting, it fails with errSendQueueFull when the queue
+// of the session would exceed maxSize. A packet bigger than maxSize is accepted when the
+// queue of the session is empty.
+func (q *sendQueue) TrySend(pkt *pb.Packet, maxSize int) error {
+	sessionID := string(pkt.Spec[pb.SpecGatewaySessionID])
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return q.closeErrLocked()
+	}
+	if sq := q.sessions[sessionID]; sq != nil && sq.size > 0 && sq.size+len(pkt.Payload) > maxSize {
+		return errSendQueueFull
+	}
+	q.pushLocked(sessionID, pkt)
+	return nil
+}
+
+// Push queues a packet without waiting for space, it's used for control
+// packets and for packets that are already bounded (e.g.: resume buffers).
+func (q *sendQueue) Push(pkt *pb.Packet) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return q.closeErrLocked()
+	}
+	q.pushLocked(string(pkt.Spec[pb.SpecGatewaySessionID]), pkt)
+	return nil
+}
+
+func (q *sendQueue) pushLocked(sessionID string, pkt *pb.Packet) {
+	q.packets++
+	q.size += len(pkt.Payload)
+	q.cond.Broadcast()
+	if sessionID == "" {
+		q.control = append(q.control, pkt)
+		return
+	}
+	sq := q.sessions[sessionID]
+	if sq == nil {
+		sq = &sessionQueue{}
+		q.sessions[sessionID] = sq
+		q.ready = append(q.ready, sessionID)
+	}
+	sq.packets = append(sq.packets, pkt)
+	sq.size += len(pkt.Payload)
+}
+
+// popLocked returns the next packet to send, the sessions are rotated after each packet
+func (q *sendQueue) popLocked() *pb.Packet {
+	var pkt *pb.Packet
+	if len(q.control) > 0 {
+		pkt, q.control = q.control[0], q.control[1:]
+	} else {
+		sessionID := q.ready[0]
+		q.ready = q.ready[1:]
+		sq := q.sessions[sessionID]
+		pkt, sq.packets = sq.packets[0], sq.packets[1:]
+		sq.size -= len(pkt.Payload)
+		if len(sq.packets) > 0 {
+			q.ready = append(q.ready, sessionID)
+		} else {
+			delete(q.sessions, sessionID)
+		}
+	}
+	q.packets--
+	q.size -= len(pkt.Payload)
+	// there's space in the queue of the session
+	q.cond.Broadcast()
+	return pkt
+}
+
+// run is the single writer of the stream, it sends the queued packets until the
+// queue is closed and empty or the stream fails.
+func (q *sendQueue) run() {
+	defer close(q.done)
+	for {
+		q.mu.Lock()
+		for q.packets == 0 && !q.closed {
+			q.cond.Wait()
+		}
+		if q.packets == 0 {
+			q.mu.Unlock()
+			return
+		}
+		pkt := q.popLocked()
+		q.mu.Unlock()
+
+		if err := q.stream.Send(pkt); err != nil {
+			q.mu.Lock()
+			q.err, q.closed = err, true
+			q.control, q.sessions, q.ready = nil, map[string]*sessionQueue{}, nil
+			q.packets, q.size = 0, 0
+			q.cond.Broadcast()
+			q.mu.Unlock()
+			return
+		}
+	}
+}
+
+// Close rejects new packets and waits until the queued packets are sent, it must be
+// called before the handler of the stream returns. The packets not sent within the
+// timeout are dropped (e.g.: the client stopped reading), the writer returns when
+// the send in progress fails with the end of the stream.
+func (q *sendQueue) Close(timeout time.Duration) {
+	q.mu.Lock()
+	q.closed = true
+	q.cond.Broadcast()
+	q.mu.Unlock()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case <-q.done:
+		return
+	case <-timer.C:
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.packets > 0 {
+		log.Printf("Dropped %d packet(s) not sent within %v, %d bytes", q.packets, timeout, q.size)
+	}
+	q.control, q.sessions, q.ready = nil, map[string]*sessionQueue{}, nil
+	q.packets, q.size = 0, 0
+	q.cond.Broadcast()
+}
+
+func (q *sendQueue) closeErrLocked() error {
+	if q.err != nil {
+		return fmt.Errorf("%v: %v", errSendQueueClosed, q.err)
+	}
+	return errSendQueueClosed
+}
+
+// depth returns the number of queued packets and their payload bytes
+func (q *sendQueue) depth() (packets, size int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.packets, q.size
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	pb "github.com/bifrost/common/proto"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeStream records the packets sent, the sends wait until the gate is opened
+type fakeStream struct {
+	pb.Transport_ConnectServer
+
+	mu   sync.Mutex
+	sent []string
+	gate chan struct{}
+	err  error
+}
+
+func newFakeStream() *fakeStream { return &fakeStream{gate: make(chan struct{})} }
+
+func (s *fakeStream) Send(pkt *pb.Packet) error {
+	s.mu.Lock()
+	s.sent = append(s.sent, pkt.Type)
+	s.mu.Unlock()
+	<-s.gate
+	return s.err
+}
+
+func (s *fakeStream) sentTypes() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.sent...)
+}
+
+// waitSending waits until the writer of the queue is blocked sending n packets
+func (s *fakeStream) waitSending(t *testing.T, n int) {
+	assert.Eventually(t, func() bool { return len(s.sentTypes()) == n }, time.Second, time.Millisecond)
+}
+
+func newPacket(sessionID, pktType string, size int) *pb.Packet {
+	pkt := &pb.Packet{Type: pktType, Payload: make([]byte, size), Spec: map[string][]byte{}}
+	if sessionID != "" {
+		pkt.Spec[pb.SpecGatewaySessionID] = []byte(sessionID)
+	}
+	return pkt
+}
+
+func TestSendQueueFairness(t *testing.T) {
+	stream := newFakeStream()
+	q := newSendQueue(stream, nil)
+	assert.Nil(t, q.Push(newPacket("a", "a1", 1)))
+	stream.waitSending(t, 1)
+
+	for _, pkt := range []*pb.Packet{
+		newPacket("a", "a2", 1),
+		newPacket("a", "a3", 1),
+		newPacket("b", "b1", 1),
+		newPacket("b", "b2", 1),
+		newPacket("", "control", 1),
+	} {
+		assert.Nil(t, q.Send(context.Background(), pkt))
+	}
+	packets, size := q.depth()
+	assert.Equal(t, 5, packets)
+	assert.Equal(t, 5, size)
+
+	close(stream.gate)
+	q.Close(time.Second)
+	// the control packets are sent first and the sessions take turns
+	assert.Equal(t, []string{"a1", "control", "a2", "b1", "a3", "b2"}, stream.sentTypes())
+}
+
+func TestSendQueueBackpressure(t *testing.T) {
+	stream := newFakeStream()
+	q := newSendQueue(stream, nil)
+	assert.Nil(t, q.Push(newPacket("", "control", 0)))
+	stream.waitSending(t, 1)
+
+	// a packet bigger than the limit is accepted when the queue of the session is empty
+	assert.Nil(t, q.Send(context.Background(), newPacket("a", "a1", maxSessionQueueSize+1)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := q.Send(ctx, newPacket("a", "a2", 1))
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), "expected a deadline error, got %v", err)
+
+	// the other sessions don't wait for the full queue
+	assert.Nil(t, q.Send(context.Background(), newPacket("b", "b1", 1)))
+
+	sendErr := make(chan error, 1)
+	go func() { sendErr <- q.Send(context.Background(), newPacket("a", "a3", 1)) }()
+	select {
+	case err := <-sendErr:
+		t.Fatalf("expected the send to wait for the queue of the session, err=%v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(stream.gate)
+	assert.Nil(t, <-sendErr)
+	q.Close(time.Second)
+	assert.Equal(t, []string{"control", "a1", "b1", "a3"}, stream.sentTypes())
+}
+
+func TestSendQueueClose(t *testing.T) {
+	t.Run("it must send the queued packets before returning", func(t *testing.T) {
+		stream := newFakeStream()
+		q := newSendQueue(stream, nil)
+		assert.Nil(t, q.Push(newPacket("a", "a1", 1)))
+		stream.waitSending(t, 1)
+		assert.Nil(t, q.Push(newPacket("a", "a2", 1)))
+
+		closed := make(chan struct{})
+		go func() {
+			q.Close(time.Second)
+			close(closed)
+		}()
+		select {
+		case <-closed:
+			t.Fatal("expected close to wait for the queued packets")
+		case <-time.After(20 * time.Millisecond):
+		}
+		close(stream.gate)
+		<-closed
+		assert.Equal(t, []string{"a1", "a2"}, stream.sentTypes())
+		assert.Equal(t, errSendQueueClosed, q.Push(newPacket("a", "a3", 1)))
+		assert.Equal(t, errSendQueueClosed, q.Send(context.Background(), newPacket("a", "a3", 1)))
+	})
+	t.Run("it must drop the queued packets when the stream fails", func(t *testing.T) {
+		stream := newFakeStream()
+		stream.err = errors.New("stream reset")
+		q := newSendQueue(stream, nil)
+		assert.Nil(t, q.Push(newPacket("a", "a1", 1)))
+		stream.waitSending(t, 1)
+		assert.Nil(t, q.Push(newPacket("a", "a2", 1)))
+
+		close(stream.gate)
+		q.Close(time.Second)
+		assert.Equal(t, []string{"a1"}, stream.sentTypes())
+		assert.EqualError(t, q.Push(newPacket("a", "a3", 1)), "agent disconnected: stream reset")
+		packets, size := q.depth()
+		assert.Equal(t, 0, packets)
+		assert.Equal(t, 0, size)
+	})
+	t.Run("it must drop the queued packets when the timeout expires", func(t *testing.T) {
+		stream := newFakeStream()
+		q := newSendQueue(stream, nil)
+		assert.Nil(t, q.Push(newPacket("a", "a1", 1)))
+		stream.waitSending(t, 1)
+		assert.Nil(t, q.Push(newPacket("a", "a2", 1)))
+		assert.Nil(t, q.Push(newPacket("b", "b1", 1)))
+
+		q.Close(20 * time.Millisecond)
+		packets, size := q.depth()
+		assert.Equal(t, 0, packets)
+		assert.Equal(t, 0, size)
+		// the send in progress fails when the stream ends
+		stream.err = errors.New("stream ended")
+		close(stream.gate)
+		<-q.done
+		assert.Equal(t, []string{"a1"}, stream.sentTypes())
+	})
+}
+
+func TestSendQueueTrySend(t *testing.T) {
+	stream := newFakeStream()
+	q := newSendQueue(stream, nil)
+	assert.Nil(t, q.TrySend(newPacket("a", "a1", 1), 10))
+	stream.waitSending(t, 1)
+
+	assert.Nil(t, q.TrySend(newPacket("a", "a2", 10), 10))
+	assert.Equal(t, errSendQueueFull, q.TrySend(newPacket("a", "a3", 1), 10))
+	// the other sessions have their own limit
+	assert.Nil(t, q.TrySend(newPacket("b", "b1", 10), 10))
+
+	close(stream.gate)
+	q.Close(time.Second)
+	assert.Equal(t, []string{"a1", "a2", "b1"}, stream.sentTypes())
+}
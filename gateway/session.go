@@ -0,0 +1,454 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bifrost/common/monitoring"
+	pb "github.com/bifrost/common/proto"
+	pbagent "github.com/bifrost/common/proto/agent"
+	pbclient "github.com/bifrost/common/proto/client"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var tracer = otel.Tracer("github.com/bifrost/gateway")
+
+const (
+	// agentOfflineExitCode is the exit code sent to clients when the
+	// agent of the session disconnects before the session ends
+	agentOfflineExitCode = 254
+	// terminatedExitCode is the exit code sent to clients of sessions
+	// terminated by the admin api (128+SIGTERM)
+	terminatedExitCode = 143
+	// expiredExitCode is the exit code sent to both sides of sessions
+	// that are idle or exceed the maximum duration, as timeout(1)
+	expiredExitCode = 124
+	// slowClientExitCode is the exit code sent to both sides of sessions
+	// closed because the client doesn't read the packets of the agent
+	slowClientExitCode = 1
+	// maxResumeBufferSize is the amount of client data held for a suspended session
+	maxResumeBufferSize = 4 * 1024 * 1024 // 4 MiB
+	// maxReplayBufferSize is the amount of client data not acknowledged by the agent that
+	// is kept to be sent again when the session resumes, it includes the data held while
+	// the session is suspended.
+	maxReplayBufferSize = 2 * maxResumeBufferSize
+)
+
+type Session struct {
+	sessionID string
+	agentID   string
+	// the packets sent to the client, the lock is not held while they're sent
+	clientQueue *sendQueue
+	ctx         context.Context
+	cancel      context.CancelFunc
+	startedAt   time.Time
+
+	mu             sync.Mutex
+	agent          *AgentConnection
+	connectionType string
+	closed         bool
+	endedAt        time.Time
+	// the last packet forwarded in any direction
+	lastActivity time.Time
+	// the packets sent by the client and by the agent
+	fromClient trafficStats
+	fromAgent  trafficStats
+	// the agent has acknowledged the session
+	opened bool
+	// the agent has disconnected, the packets of the client are
+	// held until a replica with the session reconnects
+	suspended  bool
+	bufferSize int
+	graceTimer *time.Timer
+	// numbers the packets of the session, the packets of the client not acknowledged
+	// by the agent are sent again when the session resumes
+	replay *pb.ReplayBuffer
+	// the status returned to the client when the gateway ends the session
+	closeErr error
+	// the span of the session, it's started when the client opens the session
+	span trace.Span
+}
+
+// SendToClient forwards a packet of the agent to the client without waiting, the
+// session is closed when the queue of the client is full.
+func (s *Session) SendToClient(pkt *pb.Packet) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return fmt.Errorf("session closed")
+	}
+	// the agent sends again the packets that may have been lost when it reconnects
+	if ok, err := s.replay.Receive(pkt); !ok {
+		if err != nil {
+			reason := fmt.Sprintf("agent reconnected without the data of the session: %v", err)
+			s.endLocked(reason, agentOfflineExitCode, status.Error(codes.DataLoss, reason))
+		}
+		s.mu.Unlock()
+		return err
+	}
+	if pkt.Type == pbclient.SessionOpenOK {
+		s.opened = true
+	}
+	s.lastActivity = time.Now().UTC()
+	s.fromAgent.add(pkt)
+	packetsTotal.WithLabelValues(directionFromAgent, pkt.Type).Inc()
+	packetBytesTotal.WithLabelValues(directionFromAgent, pkt.Type).Add(float64(len(pkt.Payload)))
+	defer s.mu.Unlock()
+	err := s.clientQueue.TrySend(pkt, maxClientQueueSize)
+	if err == errSendQueueFull {
+		reason := fmt.Sprintf("the client is not reading the data of the session, %d bytes are waiting", maxClientQueueSize)
+		log.Printf("Session closed: %s, reason=%s", s.sessionID[:8], reason)
+		s.endLocked(reason, slowClientExitCode, status.Error(codes.ResourceExhausted, reason))
+	}
+	return err
+}
+
+// SendToAgent forwards a packet of the client to the replica of the session,
+// the packet is buffered if the session is suspended. It waits while the queue
+// of the session in the replica is full.
+func (s *Session) SendToAgent(pkt *pb.Packet) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return fmt.Errorf("session closed")
+	}
+	if pkt.Type == pbagent.SessionOpen {
+		s.connectionType = string(pkt.Spec[pb.SpecConnectionType])
+		s.startSpanLocked(pkt)
+	}
+	s.lastActivity = time.Now().UTC()
+	s.fromClient.add(pkt)
+	packetsTotal.WithLabelValues(directionFromClient, pkt.Type).Inc()
+	packetBytesTotal.WithLabelValues(directionFromClient, pkt.Type).Add(float64(len(pkt.Payload)))
+	s.replay.Stamp(pkt)
+	if s.suspended {
+		defer s.mu.Unlock()
+		s.bufferSize += len(pkt.Payload)
+		if s.bufferSize > maxResumeBufferSize {
+			s.terminateLocked("agent disconnected, resume buffer is full")
+			return fmt.Errorf("resume buffer is full")
+		}
+		// the packet is sent from the replay buffer when the session resumes
+		return nil
+	}
+	// the lock is released while waiting, the packets of the agent are forwarded meanwhile
+	agent := s.agent
+	s.mu.Unlock()
+	return agent.queue.Send(s.ctx, pkt)
+}
+
+// startSpanLocked starts the span of the session as a child of the trace of the client,
+// the span context is propagated to the agent in the spec of the packet.
+func (s *Session) startSpanLocked(pkt *pb.Packet) {
+	if s.span != nil {
+		return
+	}
+	ctx := monitoring.ExtractTraceContext(context.Background(), pkt.Spec)
+	ctx, s.span = tracer.Start(ctx, "gateway.session",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithTimestamp(s.startedAt),
+		trace.WithAttributes(
+			attribute.String("bifrost.session_id", s.sessionID),
+			attribute.String("bifrost.agent_id", s.agentID),
+			attribute.String("bifrost.agent_hostname", s.agent.metadata["hostname"]),
+			attribute.String("bifrost.connection_type", s.connectionType),
+		))
+	monitoring.InjectTraceContext(ctx, pkt.Spec)
+}
+
+// addSpanEventLocked records an event in the span of the session
+func (s *Session) addSpanEventLocked(name string, attrs ...attribute.KeyValue) {
+	if s.span != nil {
+		s.span.AddEvent(name, trace.WithAttributes(attrs...))
+	}
+}
+
+// detachAgent handles the disconnection of the replica of the session. The session is
+// suspended for the grace period waiting the replica to reconnect, if the grace is zero
+// the client is notified immediately.
+func (s *Session) detachAgent(agent *AgentConnection, grace time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed || s.suspended || s.agent != agent {
+		return
+	}
+	if grace <= 0 {
+		s.terminateLocked("agent disconnected")
+		return
+	}
+	s.suspended = true
+	agent.sessions.Add(-1)
+	s.graceTimer = time.AfterFunc(grace, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.suspended {
+			s.terminateLocked(fmt.Sprintf("agent disconnected, it did not reconnect within %v", grace))
+		}
+	})
+	s.addSpanEventLocked("session suspended", attribute.String("bifrost.resume_grace", grace.String()))
+	log.Printf("Session suspended: %s, waiting %v for agent %s to reconnect", s.sessionID[:8], grace, s.agentID)
+}
+
+// resumableOn returns the last sequence received from the agent if the session is
+// suspended and the replica has the identity and hostname of the replica that has the session.
+func (s *Session) resumableOn(agent *AgentConnection) (uint64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.resumableOnLocked(agent)
+}
+
+func (s *Session) resumableOnLocked(agent *AgentConnection) (uint64, bool) {
+	if s.closed || !s.suspended || s.agentID != agent.agentID ||
+		s.agent.metadata["hostname"] != agent.metadata["hostname"] {
+		return 0, false
+	}
+	return s.replay.Received(), true
+}
+
+// resumeOn pins a suspended session to a reconnected replica that still has the session,
+// ack is the last sequence the agent received. The packets of the client that the agent
+// didn't receive are sent again, the session is closed if some of them are missing.
+func (s *Session) resumeOn(agent *AgentConnection, ack uint64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.resumableOnLocked(agent); !ok {
+		return false
+	}
+	s.graceTimer.Stop()
+	s.agent, s.suspended = agent, false
+	s.bufferSize = 0
+	agent.sessions.Add(1)
+	packets, err := s.replay.Replay(ack)
+	if err == nil {
+		for _, pkt := range packets {
+			if err = agent.queue.Push(pkt); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		reason := fmt.Sprintf("failed resuming session: %v", err)
+		log.Printf("Session %s not resumed: %s", s.sessionID[:8], reason)
+		s.endLocked(reason, agentOfflineExitCode, status.Error(codes.DataLoss, reason))
+		return false
+	}
+	s.addSpanEventLocked("session resumed", attribute.Int("bifrost.replayed_packets", len(packets)))
+	log.Printf("Session resumed: %s with agent %s, replayed packets=%d", s.sessionID[:8], s.agentID, len(packets))
+	return true
+}
+
+// terminateLocked notifies the client that the agent is gone and closes the session.
+// Sessions not yet acknowledged by the agent receive SessionOpenAgentOffline.
+func (s *Session) terminateLocked(reason string) {
+	if s.closed {
+		return
+	}
+	pkt := &pb.Packet{
+		Type:    pbclient.SessionOpenAgentOffline,
+		Payload: []byte(reason),
+		Spec:    map[string][]byte{pb.SpecGatewaySessionID: []byte(s.sessionID)},
+	}
+	if s.opened {
+		pkt.Type = pbclient.SessionClose
+		pkt.Spec[pb.SpecClientExitCodeKey] = []byte(strconv.Itoa(agentOfflineExitCode))
+	}
+	if err := s.clientQueue.Push(pkt); err != nil {
+		log.Printf("Failed to notify client of session %s: %v", s.sessionID[:8], err)
+	}
+	log.Printf("Session terminated: %s, reason=%s", s.sessionID[:8], reason)
+	s.closeErr = status.Errorf(codes.Unavailable, "agent '%s' is offline", s.agentID)
+	s.closeLocked()
+}
+
+// Terminate ends the session in the agent and in the client
+func (s *Session) Terminate(reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+	log.Printf("Session terminated: %s, reason=%s", s.sessionID[:8], reason)
+	s.endLocked(reason, terminatedExitCode, status.Error(codes.Aborted, reason))
+}
+
+// expiredReason returns why the session has expired, it's empty if the session
+// is within the timeouts. A zero timeout disables the check.
+func (s *Session) expiredReason(now time.Time, idleTimeout, maxDuration time.Duration) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.closed:
+		return ""
+	case maxDuration > 0 && now.Sub(s.startedAt) > maxDuration:
+		return fmt.Sprintf("session exceeded the maximum duration of %v", maxDuration)
+	// the grace period of the agent reconnection applies to suspended sessions
+	case idleTimeout > 0 && !s.suspended && now.Sub(s.lastActivity) > idleTimeout:
+		return fmt.Sprintf("session idle for more than %v", idleTimeout)
+	}
+	return ""
+}
+
+// Expire ends a session that is idle or has exceeded the maximum duration
+func (s *Session) Expire(reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+	log.Printf("Session expired: %s, reason=%s", s.sessionID[:8], reason)
+	s.endLocked(reason, expiredExitCode, status.Error(codes.DeadlineExceeded, reason))
+}
+
+// endLocked closes the session in the agent and in the client with the reason
+// and the exit code, the client stream returns closeErr.
+func (s *Session) endLocked(reason string, exitCode int, closeErr error) {
+	if !s.suspended {
+		if err := s.agent.queue.Push(&pb.Packet{
+			Type:    pbagent.SessionClose,
+			Payload: []byte(reason),
+			Spec:    map[string][]byte{pb.SpecGatewaySessionID: []byte(s.sessionID)},
+		}); err != nil {
+			log.Printf("Failed to close session %s in the agent: %v", s.sessionID[:8], err)
+		}
+	}
+	if err := s.clientQueue.Push(&pb.Packet{
+		Type:    pbclient.SessionClose,
+		Payload: []byte(reason),
+		Spec: map[string][]byte{
+			pb.SpecGatewaySessionID:  []byte(s.sessionID),
+			pb.SpecClientExitCodeKey: []byte(strconv.Itoa(exitCode)),
+		},
+	}); err != nil {
+		log.Printf("Failed to close session %s in the client: %v", s.sessionID[:8], err)
+	}
+	s.closeErr = closeErr
+	s.closeLocked()
+}
+
+// Err returns the status of a session ended by the gateway
+func (s *Session) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closeErr
+}
+
+// trafficStats counts the packets and the bytes of the payloads sent in one direction of a session
+type trafficStats struct {
+	Packets int64                   `json:"packets"`
+	Bytes   int64                   `json:"bytes"`
+	ByType  map[string]*packetStats `json:"by_type,omitempty"`
+}
+
+type packetStats struct {
+	Packets int64 `json:"packets"`
+	Bytes   int64 `json:"bytes"`
+}
+
+func (t *trafficStats) add(pkt *pb.Packet) {
+	size := int64(len(pkt.Payload))
+	t.Packets++
+	t.Bytes += size
+	if t.ByType == nil {
+		t.ByType = map[string]*packetStats{}
+	}
+	stats, ok := t.ByType[pkt.Type]
+	if !ok {
+		stats = &packetStats{}
+		t.ByType[pkt.Type] = stats
+	}
+	stats.Packets++
+	stats.Bytes += size
+}
+
+// copy returns the totals, the breakdown by packet type is copied if byType is set
+func (t *trafficStats) copy(byType bool) *trafficStats {
+	c := &trafficStats{Packets: t.Packets, Bytes: t.Bytes}
+	if byType {
+		c.ByType = map[string]*packetStats{}
+		for pktType, stats := range t.ByType {
+			c.ByType[pktType] = &packetStats{Packets: stats.Packets, Bytes: stats.Bytes}
+		}
+	}
+	return c
+}
+
+// sessionInfo is the state of a session returned by the admin api
+type sessionInfo struct {
+	SessionID      string        `json:"session_id"`
+	AgentID        string        `json:"agent_id"`
+	Hostname       string        `json:"hostname"`
+	ConnectionType string        `json:"connection_type"`
+	StartedAt      time.Time     `json:"started_at"`
+	LastActivity   time.Time     `json:"last_activity"`
+	EndedAt        *time.Time    `json:"ended_at,omitempty"`
+	Opened         bool          `json:"opened"`
+	Suspended      bool          `json:"suspended"`
+	FromClient     *trafficStats `json:"from_client"`
+	FromAgent      *trafficStats `json:"from_agent"`
+}
+
+// info returns the state of the session, the traffic is broken down by packet type if detailed is set
+func (s *Session) info(detailed bool) sessionInfo {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	info := sessionInfo{
+		SessionID:      s.sessionID,
+		AgentID:        s.agentID,
+		Hostname:       s.agent.metadata["hostname"],
+		ConnectionType: s.connectionType,
+		StartedAt:      s.startedAt,
+		LastActivity:   s.lastActivity,
+		Opened:         s.opened,
+		Suspended:      s.suspended,
+		FromClient:     s.fromClient.copy(detailed),
+		FromAgent:      s.fromAgent.copy(detailed),
+	}
+	if s.closed {
+		endedAt := s.endedAt
+		info.EndedAt = &endedAt
+	}
+	return info
+}
+
+func (s *Session) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closeLocked()
+}
+
+func (s *Session) closeLocked() {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	s.endedAt = time.Now().UTC()
+	s.cancel()
+	if s.span != nil {
+		if s.closeErr != nil {
+			s.span.SetStatus(otelcodes.Error, s.closeErr.Error())
+		}
+		s.span.SetAttributes(
+			attribute.Int64("bifrost.bytes_from_client", s.fromClient.Bytes),
+			attribute.Int64("bifrost.bytes_from_agent", s.fromAgent.Bytes),
+		)
+		s.span.End(trace.WithTimestamp(s.endedAt))
+	}
+	if s.suspended {
+		s.graceTimer.Stop()
+		return
+	}
+	s.agent.sessions.Add(-1)
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	pb "github.com/bifrost/common/proto"
+	pbclient "github.com/bifrost/common/proto/client"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// newTestAgent returns a replica that sends its packets to an open stream
+func newTestAgent() (*AgentConnection, *fakeStream) {
+	stream := newFakeStream()
+	close(stream.gate)
+	return &AgentConnection{
+		stream:   stream,
+		queue:    newSendQueue(stream, nil),
+		agentID:  "agent",
+		metadata: map[string]string{"hostname": "host"},
+	}, stream
+}
+
+func newTestSession(agent *AgentConnection) (*Session, *fakeStream) {
+	stream := newFakeStream()
+	close(stream.gate)
+	ctx, cancel := context.WithCancel(context.Background())
+	now := time.Now().UTC()
+	return &Session{
+		sessionID:    "0123456789abcdef",
+		agentID:      agent.agentID,
+		agent:        agent,
+		clientQueue:  newSendQueue(stream, nil),
+		replay:       pb.NewReplayBuffer(maxReplayBufferSize),
+		ctx:          ctx,
+		cancel:       cancel,
+		startedAt:    now,
+		lastActivity: now,
+	}, stream
+}
+
+func TestSessionResume(t *testing.T) {
+	t.Run("it must send again the packets the agent didn't receive", func(t *testing.T) {
+		agent, _ := newTestAgent()
+		sess, _ := newTestSession(agent)
+		// the packets queued to a replica are dropped when its stream fails
+		agent.queue.Close(time.Second)
+		for _, pktType := range []string{"c1", "c2", "c3"} {
+			assert.NotNil(t, sess.SendToAgent(newPacket(sess.sessionID, pktType, 1)))
+		}
+		sess.detachAgent(agent, time.Minute)
+		assert.Nil(t, sess.SendToAgent(newPacket(sess.sessionID, "c4", 1)))
+
+		reconnected, stream := newTestAgent()
+		received, ok := sess.resumableOn(reconnected)
+		assert.True(t, ok)
+		assert.Equal(t, uint64(0), received)
+		// the agent has received the first packet before the stream failed
+		assert.True(t, sess.resumeOn(reconnected, 1))
+		reconnected.queue.Close(time.Second)
+		assert.Equal(t, []string{"c2", "c3", "c4"}, stream.sentTypes())
+	})
+	t.Run("it must close the session when the packets exceed the replay buffer", func(t *testing.T) {
+		agent, _ := newTestAgent()
+		sess, client := newTestSession(agent)
+		agent.queue.Close(time.Second)
+		for _, pktType := range []string{"c1", "c2", "c3"} {
+			assert.NotNil(t, sess.SendToAgent(newPacket(sess.sessionID, pktType, maxReplayBufferSize/2)))
+		}
+		sess.detachAgent(agent, time.Minute)
+
+		reconnected, stream := newTestAgent()
+		assert.False(t, sess.resumeOn(reconnected, 0))
+		reconnected.queue.Close(time.Second)
+		sess.clientQueue.Close(time.Second)
+		assert.Equal(t, codes.DataLoss, status.Code(sess.Err()))
+		assert.Equal(t, []string{"AgentSessionClose"}, stream.sentTypes())
+		assert.Equal(t, []string{pbclient.SessionClose}, client.sentTypes())
+	})
+}
+
+func TestSessionSendToClient(t *testing.T) {
+	agent, _ := newTestAgent()
+	sess, client := newTestSession(agent)
+	replay := pb.NewReplayBuffer(maxReplayBufferSize)
+	packets := map[string]*pb.Packet{}
+	for _, pktType := range []string{"a1", "a2", "a3", "a4"} {
+		packets[pktType] = newPacket(sess.sessionID, pktType, 1)
+		replay.Stamp(packets[pktType])
+	}
+	assert.Nil(t, sess.SendToClient(packets["a1"]))
+	assert.Nil(t, sess.SendToClient(packets["a2"]))
+	// the packets sent again after the agent reconnects are skipped
+	assert.Nil(t, sess.SendToClient(packets["a1"]))
+	assert.EqualError(t, sess.SendToClient(packets["a4"]), "missing the packets 3 to 3 of the session")
+	sess.clientQueue.Close(time.Second)
+	agent.queue.Close(time.Second)
+	assert.Equal(t, codes.DataLoss, status.Code(sess.Err()))
+	assert.Equal(t, []string{"a1", "a2", pbclient.SessionClose}, client.sentTypes())
+}
+
+func TestSessionSlowClient(t *testing.T) {
+	agent, agentStream := newTestAgent()
+	sess, client := newTestSession(agent)
+	// the client doesn't read the packets
+	client.gate = make(chan struct{})
+	sess.clientQueue = newSendQueue(client, nil)
+
+	assert.Nil(t, sess.SendToClient(newPacket(sess.sessionID, "a1", 1)))
+	client.waitSending(t, 1)
+	assert.Nil(t, sess.SendToClient(newPacket(sess.sessionID, "a2", maxClientQueueSize)))
+	assert.Equal(t, errSendQueueFull, sess.SendToClient(newPacket(sess.sessionID, "a3", 1)))
+
+	close(client.gate)
+	sess.clientQueue.Close(time.Second)
+	agent.queue.Close(time.Second)
+	assert.Equal(t, codes.ResourceExhausted, status.Code(sess.Err()))
+	assert.Equal(t, []string{"a1", "a2", pbclient.SessionClose}, client.sentTypes())
+	assert.Equal(t, []string{"AgentSessionClose"}, agentStream.sentTypes())
+}
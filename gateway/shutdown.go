@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	pb "github.com/bifrost/common/proto"
+	pbagent "github.com/bifrost/common/proto/agent"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// drainPollInterval is how often the active sessions are counted while draining
+	drainPollInterval = time.Second
+	// stopTimeout is the time to wait for the streams to finish after the
+	// sessions are closed, before closing the connections
+	stopTimeout = 5 * time.Second
+	// shutdownReason is sent to the clients and agents of the sessions closed by the shutdown
+	shutdownReason = "the gateway is shutting down"
+)
+
+// errShuttingDown is returned to connections received while the gateway drains
+var errShuttingDown = status.Error(codes.Unavailable, shutdownReason)
+
+// shutdown drains the gateway: new connections are rejected, the agents stop taking new
+// sessions and the active sessions can finish until the drain timeout. The remaining sessions
+// are closed and the servers are stopped. A signal received while draining ends it right away.
+func (s *gatewayServer) shutdown(grpcServer *grpc.Server, httpServer *http.Server, sigc <-chan os.Signal) {
+	s.draining.Store(true)
+	for _, agent := range s.broker.ListAgents() {
+		if err := agent.queue.Push(&pb.Packet{Type: pbagent.GatewayDraining}); err != nil {
+			log.Printf("Failed to notify agent %s about the shutdown: %v", agent.agentID, err)
+		}
+	}
+
+	drainTimeout := time.Duration(s.config.Get().ShutdownDrainTimeout)
+	log.Printf("Draining %d session(s), timeout=%v", s.broker.CountSessions(), drainTimeout)
+	s.waitSessions(drainTimeout, sigc)
+	if n := s.broker.TerminateSessions(shutdownReason); n > 0 {
+		log.Printf("Closed %d session(s) that did not finish within the drain timeout", n)
+	}
+
+	// the agent streams return when the shutdown context is done
+	s.shutdownFn()
+	ctx, cancel := context.WithTimeout(context.Background(), stopTimeout)
+	defer cancel()
+	if err := httpServer.Shutdown(ctx); err != nil {
+		log.Printf("Failed to stop the HTTP server: %v", err)
+	}
+	stopped := make(chan struct{})
+	go func() {
+		grpcServer.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		log.Printf("Closing the gRPC connections that did not finish in %v", stopTimeout)
+		grpcServer.Stop()
+	}
+}
+
+// waitSessions waits until there are no active sessions, the timeout expires or a signal is received
+func (s *gatewayServer) waitSessions(timeout time.Duration, sigc <-chan os.Signal) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+	for {
+		active := s.broker.CountSessions()
+		if active == 0 {
+			log.Println("All sessions have finished")
+			return
+		}
+		select {
+		case <-ticker.C:
+		case <-timer.C:
+			log.Printf("Drain timeout reached with %d active session(s)", active)
+			return
+		case sig := <-sigc:
+			log.Printf("Received %v while draining, closing %d active session(s)", sig, active)
+			return
+		}
+	}
+}
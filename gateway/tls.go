@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// certReloadInterval is how often the certificate files are checked for changes
+const certReloadInterval = 30 * time.Second
+
+// certReloader serves the certificate of the gateway, it's reloaded when
+// the certificate or key files change without restarting the listeners.
+type certReloader struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// reload loads the key pair if the files have changed since the last load
+func (r *certReloader) reload() (bool, error) {
+	modTime, err := r.lastModified()
+	if err != nil {
+		return false, err
+	}
+	r.mu.RLock()
+	unchanged := r.cert != nil && modTime.Equal(r.modTime)
+	r.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("failed loading certificate %s: %v", r.certFile, err)
+	}
+	r.mu.Lock()
+	r.cert, r.modTime = &cert, modTime
+	r.mu.Unlock()
+	return true, nil
+}
+
+func (r *certReloader) lastModified() (time.Time, error) {
+	var modTime time.Time
+	for _, name := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return modTime, err
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+	}
+	return modTime, nil
+}
+
+// watch reloads the certificate on changes until the context is done,
+// the current certificate is kept if the new one fails to load.
+func (r *certReloader) watch(ctx context.Context) {
+	ticker := time.NewTicker(certReloadInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		reloaded, err := r.reload()
+		switch {
+		case err != nil:
+			log.Printf("Warning: keeping the current TLS certificate: %v", err)
+		case reloaded:
+			log.Printf("Reloaded TLS certificate from %s", r.certFile)
+		}
+	}
+}
+
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// tlsSettings is the TLS configuration of the gateway listeners
+type tlsSettings struct {
+	config *tls.Config
+	certs  *certReloader
+	// agents must present a certificate signed by the client CA with
+	// the common name of their identity
+	requireAgentCert bool
+}
+
+// validate checks the combination of the TLS settings, the files are loaded by loadTLSSettings
+func (c TLSConfig) validate() error {
+	if c.Cert == "" && c.Key == "" {
+		if c.ClientCA != "" || c.RequireAgentCert {
+			return fmt.Errorf("tls.cert and tls.key are required to verify client certificates")
+		}
+		return nil
+	}
+	if c.Cert == "" || c.Key == "" {
+		return fmt.Errorf("both tls.cert and tls.key must be set")
+	}
+	if c.RequireAgentCert && c.ClientCA == "" {
+		return fmt.Errorf("tls.client_ca is required with tls.require_agent_cert")
+	}
+	return nil
+}
+
+// loadTLSSettings loads the certificates of the TLS configuration, it returns
+// nil if the certificate and key are not set and the listeners are plaintext.
+func loadTLSSettings(c TLSConfig) (*tlsSettings, error) {
+	if c.Cert == "" && c.Key == "" {
+		return nil, nil
+	}
+	certs, err := newCertReloader(c.Cert, c.Key)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: certs.GetCertificate,
+	}
+	if c.ClientCA != "" {
+		pemData, err := os.ReadFile(c.ClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed reading client CA: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pemData) {
+			return nil, fmt.Errorf("failed parsing client CA %s", c.ClientCA)
+		}
+		// clients authenticated by tokens don't have certificates,
+		// the certificate of agents is enforced when authenticating
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return &tlsSettings{config: config, certs: certs, requireAgentCert: c.RequireAgentCert}, nil
+}
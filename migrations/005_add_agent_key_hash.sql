@@ -0,0 +1,6 @@
+-- Add key_hash column to agents table
+-- The hash (sha256) of the secret key of the agent DSN, the key itself is never stored
+ALTER TABLE agents ADD COLUMN IF NOT EXISTS key_hash VARCHAR(64);
+
+-- Create unique index on key_hash for authenticating agents in the gateway
+CREATE UNIQUE INDEX IF NOT EXISTS idx_agents_key_hash ON agents(key_hash);
@@ -0,0 +1,7 @@
+-- Add connection info columns to agents table
+-- The gateway records the agents that connected with their keys
+ALTER TABLE agents ADD COLUMN IF NOT EXISTS hostname VARCHAR(255);
+ALTER TABLE agents ADD COLUMN IF NOT EXISTS version VARCHAR(255);
+ALTER TABLE agents ADD COLUMN IF NOT EXISTS platform VARCHAR(255);
+ALTER TABLE agents ADD COLUMN IF NOT EXISTS last_connected_at TIMESTAMP WITH TIME ZONE;
+ALTER TABLE agents ADD COLUMN IF NOT EXISTS last_disconnected_at TIMESTAMP WITH TIME ZONE;
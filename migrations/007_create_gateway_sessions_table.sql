@@ -0,0 +1,21 @@
+-- Migration: Create gateway sessions table
+-- Description: Traffic totals of the sessions recorded by the gateway when they close
+
+CREATE TABLE IF NOT EXISTS gateway_sessions (
+    id SERIAL PRIMARY KEY,
+    session_id VARCHAR(36) NOT NULL UNIQUE,
+    agent_id VARCHAR(255) NOT NULL,
+    hostname VARCHAR(255) NOT NULL DEFAULT '',
+    connection_type VARCHAR(50) NOT NULL DEFAULT '',
+    started_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    ended_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    packets_from_client BIGINT NOT NULL DEFAULT 0,
+    bytes_from_client BIGINT NOT NULL DEFAULT 0,
+    packets_from_agent BIGINT NOT NULL DEFAULT 0,
+    bytes_from_agent BIGINT NOT NULL DEFAULT 0,
+    -- packets and bytes by packet type of each direction
+    packets_by_type JSONB NOT NULL DEFAULT '{}'
+);
+
+CREATE INDEX idx_gateway_sessions_agent_id ON gateway_sessions(agent_id);
+CREATE INDEX idx_gateway_sessions_ended_at ON gateway_sessions(ended_at);
@@ -0,0 +1,15 @@
+-- Migration: Create query results table
+-- Description: Result sets of the queries run with the store option, they're exported later
+
+CREATE TABLE IF NOT EXISTS query_results (
+    -- random ids, the results of other queries can't be enumerated
+    id UUID PRIMARY KEY,
+    database_id INTEGER NOT NULL REFERENCES databases(id) ON DELETE CASCADE,
+    query TEXT NOT NULL,
+    result_sets JSONB NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL
+);
+
+CREATE INDEX idx_query_results_database_id ON query_results(database_id);
+CREATE INDEX idx_query_results_expires_at ON query_results(expires_at);